@@ -2,6 +2,7 @@ package main
 
 import (
 	"os"
+	"time"
 
 	"github.com/KaribuLab/titvo-installer/internal"
 	"github.com/spf13/cobra"
@@ -16,12 +17,235 @@ func NewRootCommand() *cobra.Command {
 	}
 	rootCmd.Flags().BoolP("debug", "d", false, "Enable debug mode")
 	rootCmd.Flags().StringP("config", "c", "", "Configuration file")
+	rootCmd.Flags().Bool("resume", false, "Resume a previous installation, skipping the steps it completed")
+	rootCmd.Flags().Bool("reconfigure", false, "Update the Titvo parameters without creating a user or API key")
+	rootCmd.Flags().StringP("output", "o", "text", "Output format: text or json (newline-delimited events)")
+	rootCmd.Flags().String("encryption-format", internal.EncryptionFormatECB, "Format of the encrypted values: v1 (legacy AES-ECB, read by every Titvo service), v2 (AES-GCM, only once every Titvo service reads encryption_format) or kms (a customer-managed KMS key instead of the AES secret, only once every Titvo service reads kms: values)")
+	rootCmd.Flags().String("kms-key-id", "", "ID, ARN or alias of the customer-managed KMS key of the kms format, by default the installer creates one")
+	rootCmd.Flags().StringSlice("kms-grant-role", nil, "IAM role name or ARN granted the KMS key, by default the job role of the agent's Batch job definition. Required with SCM tokens: name every Titvo role that reads encrypted parameters, the agent job role included")
+	rootCmd.Flags().String("export-aes-secret", "", "When the AES secret is generated, also write it to this file, or to the output with -")
+	rootCmd.Flags().StringToString("aws-endpoint", nil, "Override the endpoint of an AWS service used by the installer, e.g. dynamodb=http://localhost:4566 or *=http://localhost:4566 for all")
+	rootCmd.Flags().Bool("skip-ai-validation", false, "Do not check the AI API key and model with the AI provider")
+	rootCmd.Flags().StringToString("ai-endpoint", nil, "Override the API of an AI provider, e.g. openai=https://proxy.example.com")
+	rootCmd.Flags().String("system-prompt-file", "", "File replacing the default scan system prompt embedded in the installer")
+	rootCmd.Flags().String("content-template-file", "", "File replacing the default content template embedded in the installer")
+	rootCmd.Flags().Duration("step-timeout", 0, "Maximum duration of each terragrunt or npm command (e.g. 45m), 0 disables it")
+	rootCmd.AddCommand(&cobra.Command{
+		Use:   "logs [run-id|latest] [component]",
+		Short: "List past installer runs or show their logs",
+		Long:  "Without arguments, list the runs logged in ~/.titvo/logs. With a run ID, show its run log, or the log of one of its components",
+		Args:  cobra.MaximumNArgs(2),
+		Run:   internal.RunLogs,
+	})
+	rootCmd.AddCommand(newUsersCommand())
+	rootCmd.AddCommand(newAPIKeysCommand())
+	rootCmd.AddCommand(newSecretsCommand())
+	rootCmd.AddCommand(newConfigCommand())
+	rootCmd.AddCommand(newPromptCommand())
 	return rootCmd
 }
 
+// addManagementFlags adds the flags shared by the commands that manage an
+// existing installation.
+func addManagementFlags(cmd *cobra.Command) {
+	flags := cmd.PersistentFlags()
+	flags.StringP("config", "c", "", "Configuration file with the AWS credentials")
+	flags.String("profile", "", "Profile of ~/.aws/credentials to use instead of the default AWS credentials")
+	flags.String("region", "", "AWS region of the installation")
+	flags.StringP("output", "o", "text", "Output format: text (tables) or json")
+	flags.StringToString("aws-endpoint", nil, "Override the endpoint of an AWS service, e.g. dynamodb=http://localhost:4566 or *=http://localhost:4566 for all")
+}
+
+func newUsersCommand() *cobra.Command {
+	usersCmd := &cobra.Command{
+		Use:   "users",
+		Short: "Manage the Titvo users",
+	}
+	addManagementFlags(usersCmd)
+	usersCmd.AddCommand(&cobra.Command{
+		Use:   "list",
+		Short: "List the users",
+		Args:  cobra.NoArgs,
+		Run:   internal.RunUsersList,
+	})
+	createCmd := &cobra.Command{
+		Use:   "create <name>",
+		Short: "Create a user and an API key for it",
+		Args:  cobra.ExactArgs(1),
+		Run:   internal.RunUsersCreate,
+	}
+	createCmd.Flags().String("account-type", "Team", "Account type of the user")
+	usersCmd.AddCommand(createCmd)
+	usersCmd.AddCommand(&cobra.Command{
+		Use:   "disable <user-id|name>",
+		Short: "Mark a user as disabled and delete its API keys",
+		Args:  cobra.ExactArgs(1),
+		Run:   internal.RunUsersDisable,
+	})
+	deleteCmd := &cobra.Command{
+		Use:   "delete <user-id|name>",
+		Short: "Delete a user and its API keys",
+		Args:  cobra.ExactArgs(1),
+		Run:   internal.RunUsersDelete,
+	}
+	deleteCmd.Flags().BoolP("yes", "y", false, "Do not ask for confirmation")
+	usersCmd.AddCommand(deleteCmd)
+	return usersCmd
+}
+
 func main() {
 	rootCmd := NewRootCommand()
 	if err := rootCmd.Execute(); err != nil {
 		os.Exit(1)
 	}
 }
+
+func newAPIKeysCommand() *cobra.Command {
+	apiKeysCmd := &cobra.Command{
+		Use:   "apikeys",
+		Short: "Manage the API keys of the Titvo users",
+	}
+	addManagementFlags(apiKeysCmd)
+	createCmd := &cobra.Command{
+		Use:   "create <user-id|name>",
+		Short: "Create an additional API key for a user",
+		Args:  cobra.ExactArgs(1),
+		Run:   internal.RunAPIKeysCreate,
+	}
+	createCmd.Flags().String("label", "", "Label describing what the key is used for")
+	createCmd.Flags().Duration("expires-in", 0, "Mark the key expired after this duration (e.g. 720h), 0 never expires. The key keeps working until apikeys revoke --expired deletes it")
+	apiKeysCmd.AddCommand(createCmd)
+	apiKeysCmd.AddCommand(&cobra.Command{
+		Use:   "list [user-id|name]",
+		Short: "List the API keys of a user, or of every user",
+		Args:  cobra.MaximumNArgs(1),
+		Run:   internal.RunAPIKeysList,
+	})
+	rotateCmd := &cobra.Command{
+		Use:   "rotate <key-id>",
+		Short: "Replace an API key with a new one",
+		Long:  "Create a new API key for the same user and label, and mark the old key expired once the grace period has passed. Nothing stops an expired key: it keeps working until apikeys revoke --expired deletes it, so schedule that command after the grace period",
+		Args:  cobra.ExactArgs(1),
+		Run:   internal.RunAPIKeysRotate,
+	}
+	rotateCmd.Flags().Duration("grace-period", 24*time.Hour, "When the old key is marked expired, 0 revokes it immediately")
+	apiKeysCmd.AddCommand(rotateCmd)
+	revokeCmd := &cobra.Command{
+		Use:   "revoke [key-id]",
+		Short: "Revoke an API key, or every expired key with --expired",
+		Long:  "Delete an API key, which stops it authenticating. Expired keys keep working until they are revoked, so run revoke --expired regularly, e.g. from a scheduled job",
+		Args:  cobra.MaximumNArgs(1),
+		Run:   internal.RunAPIKeysRevoke,
+	}
+	revokeCmd.Flags().Bool("expired", false, "Revoke every API key past its expiry")
+	apiKeysCmd.AddCommand(revokeCmd)
+	return apiKeysCmd
+}
+
+func newSecretsCommand() *cobra.Command {
+	secretsCmd := &cobra.Command{
+		Use:   "secrets",
+		Short: "Manage the values encrypted with the AES secret",
+	}
+	addManagementFlags(secretsCmd)
+	migrateCmd := &cobra.Command{
+		Use:   "migrate",
+		Short: "Re-encrypt the legacy AES-ECB parameters with AES-GCM",
+		Long:  "Re-encrypt every parameter still in the legacy v1 (AES-ECB) format with v2 (AES-GCM) and set encryption_format to v2, so the Titvo services write v2 values too",
+		Args:  cobra.NoArgs,
+		Run:   internal.RunSecretsMigrate,
+	}
+	migrateCmd.Flags().Bool("dry-run", false, "Only list the parameters to migrate")
+	secretsCmd.AddCommand(migrateCmd)
+	rotateCmd := &cobra.Command{
+		Use:   "rotate-aes",
+		Short: "Replace the AES secret and re-encrypt the parameters with it",
+		Long:  "Generate a new AES key, re-encrypt every encrypted parameter with it and make it the current version of the AES secret, rolling everything back if a step fails",
+		Args:  cobra.NoArgs,
+		Run:   internal.RunSecretsRotateAES,
+	}
+	rotateCmd.Flags().BoolP("yes", "y", false, "Do not ask for confirmation")
+	secretsCmd.AddCommand(rotateCmd)
+	return secretsCmd
+}
+
+func newConfigCommand() *cobra.Command {
+	configCmd := &cobra.Command{
+		Use:   "config",
+		Short: "Change the configuration of an installation",
+	}
+	addManagementFlags(configCmd)
+	aiCmd := &cobra.Command{
+		Use:   "ai",
+		Short: "Change the AI provider, model and API key",
+	}
+	aiCmd.PersistentFlags().Bool("skip-validation", false, "Do not check the AI API key and model with the AI provider")
+	aiCmd.PersistentFlags().StringToString("ai-endpoint", nil, "Override the API of an AI provider, e.g. openai=https://proxy.example.com")
+	setCmd := &cobra.Command{
+		Use:   "set",
+		Short: "Set the AI provider, model and provider settings",
+		Long:  "Update the AI settings given and keep the others. A new provider replaces the stored AI API key with the one read with --api-key-stdin, or removes it when the provider needs none. Selecting bedrock also grants the agent role the use of the Bedrock models",
+		Args:  cobra.NoArgs,
+		Run:   internal.RunConfigAISet,
+	}
+	setCmd.Flags().String("provider", "", "AI provider: anthropic, openai, google, bedrock, azure-openai or openai-compatible. A new provider needs --model too")
+	setCmd.Flags().String("model", "", "AI model, or the deployment name with azure-openai")
+	setCmd.Flags().String("base-url", "", "Endpoint of the azure-openai or openai-compatible provider")
+	setCmd.Flags().String("api-version", "", "API version of azure-openai")
+	setCmd.Flags().String("bedrock-region", "", "AWS region of the bedrock models, by default the region of the installation")
+	setCmd.Flags().Bool("api-key-stdin", false, "Read the AI API key of the provider from the first line of stdin. A new provider needs it, unless it is bedrock or openai-compatible")
+	aiCmd.AddCommand(setCmd)
+	rotateKeyCmd := &cobra.Command{
+		Use:   "rotate-key",
+		Short: "Replace the AI API key",
+		Long:  "Ask for a new AI API key and store it encrypted in the installation's format, with the AES secret or the KMS key",
+		Args:  cobra.NoArgs,
+		Run:   internal.RunConfigAIRotateKey,
+	}
+	rotateKeyCmd.Flags().Bool("api-key-stdin", false, "Read the AI API key from the first line of stdin instead of asking for it")
+	aiCmd.AddCommand(rotateKeyCmd)
+	configCmd.AddCommand(aiCmd)
+	return configCmd
+}
+
+func newPromptCommand() *cobra.Command {
+	promptCmd := &cobra.Command{
+		Use:   "prompt",
+		Short: "Manage the scan system prompt and content template",
+		Long:  "Show, change and roll back the versions of the system-prompt and content-template documents the Titvo agent builds its requests from",
+	}
+	addManagementFlags(promptCmd)
+	showCmd := &cobra.Command{
+		Use:   "show <system-prompt|content-template>",
+		Short: "Show the current version of a document",
+		Args:  cobra.ExactArgs(1),
+		Run:   internal.RunPromptShow,
+	}
+	showCmd.Flags().Int("version", 0, "Show this version instead of the current one")
+	promptCmd.AddCommand(showCmd)
+	setCmd := &cobra.Command{
+		Use:   "set <system-prompt|content-template> [file]",
+		Short: "Store a new version of a document",
+		Long:  "Store the content of file, or of stdin with -, as a new version of a document, or restore a previous version with --version. The previous versions are kept. A content template may only use the {repository_url}, {commit_hash} and {args} placeholders and is previewed with sample values",
+		Args:  cobra.RangeArgs(1, 2),
+		Run:   internal.RunPromptSet,
+	}
+	setCmd.Flags().Int("version", 0, "Restore this version instead of reading a file")
+	promptCmd.AddCommand(setCmd)
+	diffCmd := &cobra.Command{
+		Use:   "diff <system-prompt|content-template> [file]",
+		Short: "Compare the current version of a document",
+		Long:  "Show the changes from the current version of a document to file, to a stored version with --version, or to the default embedded in the installer",
+		Args:  cobra.RangeArgs(1, 2),
+		Run:   internal.RunPromptDiff,
+	}
+	diffCmd.Flags().Int("version", 0, "Compare with this version instead of the default")
+	promptCmd.AddCommand(diffCmd)
+	promptCmd.AddCommand(&cobra.Command{
+		Use:   "history <system-prompt|content-template>",
+		Short: "List the versions of a document",
+		Args:  cobra.ExactArgs(1),
+		Run:   internal.RunPromptHistory,
+	})
+	return promptCmd
+}
@@ -0,0 +1,377 @@
+package internal
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// AIConfigManager updates the AI settings of an installation in the parameter
+// table, leaving the rest of the configuration untouched.
+type AIConfigManager struct {
+	Parameters ParameterStore
+	Secrets    SecretStore
+	Records    RecordStore
+	Keys       KeyManager
+	// Batch, Accounts and Roles grant the agent role the use of the bedrock
+	// models.
+	Batch    BatchRunner
+	Accounts AccountResolver
+	Roles    RolePolicies
+	// Validator checks the settings with the provider; nil skips it.
+	Validator *AIValidator
+	// Region is the default region of bedrock, the installation's region.
+	Region string
+	// Console receives the warnings and the progress of the grants.
+	Console *Console
+}
+
+func NewAIConfigManager(session *AWSSession, console *Console) *AIConfigManager {
+	return &AIConfigManager{
+		Parameters: session,
+		Secrets:    session,
+		Records:    session,
+		Keys:       session,
+		Batch:      session,
+		Accounts:   session,
+		Roles:      session,
+		Region:     session.Config.Region,
+		Console:    console,
+	}
+}
+
+// storedParameters returns the values of the parameter table by ID.
+func (m *AIConfigManager) storedParameters(ctx context.Context, tableName string) (map[string]string, error) {
+	records, err := m.Records.ListRecords(ctx, tableName)
+	if err != nil {
+		return nil, err
+	}
+	values := map[string]string{}
+	for _, record := range records {
+		parameterID, _ := record["parameter_id"].(string)
+		values[parameterID], _ = record["value"].(string)
+	}
+	return values, nil
+}
+
+func storedAISettings(values map[string]string) AISettings {
+	return AISettings{
+		Provider:   values["ai_provider"],
+		Model:      values["ai_model"],
+		BaseURL:    values["ai_base_url"],
+		APIVersion: values["ai_api_version"],
+		Region:     values["ai_region"],
+	}
+}
+
+// Set updates the AI settings given; an empty value is left unchanged. A new
+// provider starts from empty settings, as model names, endpoints and the API
+// key belong to a provider, and needs its own: the stored key is replaced by
+// changes.APIKey, or removed when the provider needs none. The settings are
+// checked with the new API key, with the stored one when the provider does
+// not change and the key is encrypted with the AES secret, and always for
+// bedrock; checked reports whether they were. The agent role is granted the
+// bedrock models when bedrock is the provider.
+func (m *AIConfigManager) Set(ctx context.Context, changes AISettings) (checked bool, err error) {
+	changes = AISettings{
+		Provider:   strings.TrimSpace(changes.Provider),
+		APIKey:     strings.TrimSpace(changes.APIKey),
+		Model:      strings.TrimSpace(changes.Model),
+		BaseURL:    strings.TrimSpace(changes.BaseURL),
+		APIVersion: strings.TrimSpace(changes.APIVersion),
+		Region:     strings.TrimSpace(changes.Region),
+	}
+	if changes == (AISettings{}) {
+		return false, fmt.Errorf("nothing to set, give a provider, a model or a setting of the provider")
+	}
+	tableName, err := m.Parameters.GetParameter(ctx, parameterTableParameter)
+	if err != nil {
+		return false, err
+	}
+	values, err := m.storedParameters(ctx, tableName)
+	if err != nil {
+		return false, err
+	}
+	settings := storedAISettings(values)
+	previousProvider := settings.Provider
+	newProvider := changes.Provider != "" && changes.Provider != settings.Provider
+	if newProvider {
+		settings = AISettings{Provider: changes.Provider}
+	}
+	p, err := findAIProvider(settings.Provider)
+	if err != nil {
+		return false, err
+	}
+	if err := p.checkUnused(changes); err != nil {
+		return false, err
+	}
+	if p.APIKey == aiKeyNone && changes.APIKey != "" {
+		return false, fmt.Errorf("the %s provider is authenticated with the IAM role of the agent, not an API key", p.Name)
+	}
+	// The stored key stays only while it belongs to the provider
+	replaceKey := changes.APIKey != "" || newProvider || p.APIKey == aiKeyNone
+	settings.APIKey = changes.APIKey
+	for _, change := range []struct {
+		value   string
+		setting *string
+	}{
+		{changes.Model, &settings.Model},
+		{changes.BaseURL, &settings.BaseURL},
+		{changes.APIVersion, &settings.APIVersion},
+		{changes.Region, &settings.Region},
+	} {
+		if change.value != "" {
+			*change.setting = change.value
+		}
+	}
+	if p.NeedsAPIVersion && settings.APIVersion == "" {
+		settings.APIVersion = p.DefaultAPIVersion
+	}
+	if p.UsesRegion && settings.Region == "" {
+		settings.Region = m.Region
+	}
+	if err := p.check(settings, false); err != nil {
+		return false, err
+	}
+	if newProvider && p.APIKey == aiKeyRequired && changes.APIKey == "" {
+		return false, fmt.Errorf("the %s provider needs its API key, the stored one belongs to the %s provider", p.Name, previousProvider)
+	}
+	if m.Validator != nil && m.Validator.canCheck(p) {
+		canCheck := true
+		if !replaceKey {
+			settings.APIKey, canCheck, err = m.storedAPIKey(ctx, values)
+			if err != nil {
+				return false, err
+			}
+			canCheck = canCheck && (settings.APIKey != "" || p.APIKey == aiKeyOptional)
+		}
+		if canCheck {
+			if err := m.Validator.Validate(ctx, settings); err != nil {
+				return false, err
+			}
+			checked = true
+		}
+	}
+	parameters := settings.parameters()
+	if replaceKey {
+		encrypted := ""
+		if settings.APIKey != "" {
+			if encrypted, _, err = m.encryptAPIKey(ctx, values, settings.APIKey); err != nil {
+				return checked, err
+			}
+		}
+		parameters = append(parameters, [2]string{"ai_api_key", encrypted})
+	}
+	for _, parameter := range parameters {
+		err = m.Records.PutRecord(ctx, tableName, map[string]interface{}{
+			"parameter_id": parameter[0],
+			"value":        parameter[1],
+		})
+		if err != nil {
+			return checked, err
+		}
+	}
+	if replaceKey && settings.APIKey == "" && values["ai_api_key"] != "" {
+		m.Console.printInfo(fmt.Sprintf("Removed the AI API key of the %s provider", previousProvider))
+	}
+	if p.Name == aiProviderBedrock {
+		granter := bedrockGranter{Parameters: m.Parameters, Batch: m.Batch, Accounts: m.Accounts, Roles: m.Roles, Console: m.Console}
+		if err := granter.grant(ctx, settings.Region); err != nil {
+			return checked, fmt.Errorf("AI settings stored but the agent role could not be granted the Bedrock models: %w", err)
+		}
+	}
+	return checked, nil
+}
+
+// storedAPIKey returns the decrypted AI API key. ok is false when the key is
+// encrypted with the KMS key, which the installer cannot decrypt.
+func (m *AIConfigManager) storedAPIKey(ctx context.Context, values map[string]string) (apiKey string, ok bool, err error) {
+	value := values["ai_api_key"]
+	if value == "" {
+		return "", true, nil
+	}
+	if valueFormat(value) == EncryptionFormatKMS {
+		return "", false, nil
+	}
+	aesSecret, err := readAESSecret(ctx, m.Secrets)
+	if err != nil {
+		return "", false, err
+	}
+	apiKey, err = decryptValue(value, aesSecret)
+	if err != nil {
+		return "", false, fmt.Errorf("failed to decrypt parameter ai_api_key: %w", err)
+	}
+	return apiKey, true, nil
+}
+
+// encryptionFormat returns the format of the installation's encrypted
+// parameters. Installations without encryption_format predate it and use v1.
+func encryptionFormat(values map[string]string) (string, error) {
+	format, ok := values[encryptionFormatParameterID]
+	if !ok {
+		return EncryptionFormatECB, nil
+	}
+	if err := validateEncryptionFormat(format); err != nil {
+		return "", err
+	}
+	return format, nil
+}
+
+// RotateKey replaces ai_api_key with apiKey, encrypted in the installation's
+// format with the stored AES secret or KMS key, once checked with the stored
+// settings. It returns that format.
+func (m *AIConfigManager) RotateKey(ctx context.Context, apiKey string) (string, error) {
+	apiKey = strings.TrimSpace(apiKey)
+	if apiKey == "" {
+		return "", fmt.Errorf("AI API Key is empty")
+	}
+	tableName, err := m.Parameters.GetParameter(ctx, parameterTableParameter)
+	if err != nil {
+		return "", err
+	}
+	values, err := m.storedParameters(ctx, tableName)
+	if err != nil {
+		return "", err
+	}
+	if _, err := encryptionFormat(values); err != nil {
+		return "", err
+	}
+	settings := storedAISettings(values)
+	settings.APIKey = apiKey
+	if p, err := findAIProvider(settings.Provider); err == nil && p.APIKey == aiKeyNone {
+		return "", fmt.Errorf("the %s provider is authenticated with the IAM role of the agent, not an API key", p.Name)
+	}
+	if m.Validator != nil {
+		if err := m.Validator.Validate(ctx, settings); err != nil {
+			return "", err
+		}
+	}
+	encrypted, format, err := m.encryptAPIKey(ctx, values, apiKey)
+	if err != nil {
+		return "", err
+	}
+	err = m.Records.PutRecord(ctx, tableName, map[string]interface{}{
+		"parameter_id": "ai_api_key",
+		"value":        encrypted,
+	})
+	if err != nil {
+		return "", err
+	}
+	return format, nil
+}
+
+// encryptAPIKey encrypts apiKey in the installation's format with the stored
+// AES secret or KMS key, and returns that format.
+func (m *AIConfigManager) encryptAPIKey(ctx context.Context, values map[string]string, apiKey string) (encrypted, format string, err error) {
+	if format, err = encryptionFormat(values); err != nil {
+		return "", "", err
+	}
+	var aesSecret, kmsKeyARN string
+	if format == EncryptionFormatKMS {
+		kmsKeyARN, err = m.Parameters.GetParameter(ctx, kmsKeyParameter)
+	} else {
+		aesSecret, err = readAESSecret(ctx, m.Secrets)
+	}
+	if err != nil {
+		return "", "", err
+	}
+	encrypted, err = encryptParameter(ctx, m.Keys, apiKey, format, aesSecret, kmsKeyARN)
+	return encrypted, format, err
+}
+
+func newAIConfigManager(cmd *cobra.Command, console *Console) *AIConfigManager {
+	skipValidation, err := cmd.Flags().GetBool("skip-validation")
+	if err != nil {
+		console.printErrorAndExit(err)
+	}
+	endpoints, err := cmd.Flags().GetStringToString("ai-endpoint")
+	if err != nil {
+		console.printErrorAndExit(err)
+	}
+	session, err := managementSession(cmd, console)
+	if err != nil {
+		console.printErrorAndExit(err)
+	}
+	manager := NewAIConfigManager(session, console)
+	if !skipValidation {
+		manager.Validator = NewAIValidator(endpoints)
+		manager.Validator.Bedrock = session
+	}
+	return manager
+}
+
+// RunConfigAISet updates the AI provider, model and provider settings of the
+// installation.
+func RunConfigAISet(cmd *cobra.Command, args []string) {
+	console, _ := managementConsole(cmd)
+	changes := AISettings{}
+	for flag, value := range map[string]*string{
+		"provider":       &changes.Provider,
+		"model":          &changes.Model,
+		"base-url":       &changes.BaseURL,
+		"api-version":    &changes.APIVersion,
+		"bedrock-region": &changes.Region,
+	} {
+		var err error
+		if *value, err = cmd.Flags().GetString(flag); err != nil {
+			console.printErrorAndExit(err)
+		}
+	}
+	fromStdin, err := cmd.Flags().GetBool("api-key-stdin")
+	if err != nil {
+		console.printErrorAndExit(err)
+	}
+	manager := newAIConfigManager(cmd, console)
+	if fromStdin {
+		if changes.APIKey, err = readAIApiKey(console, true, os.Stdin); err != nil {
+			console.printErrorAndExit(err)
+		}
+		console.Secrets.Add(changes.APIKey)
+	}
+	checked, err := manager.Set(cmd.Context(), changes)
+	if err != nil {
+		console.printErrorAndExit(err)
+	}
+	console.printInfo("AI settings updated")
+	if manager.Validator != nil && !checked {
+		console.printAskQuestion("Warning: the AI settings were not checked with the stored AI API key. Run config ai rotate-key to store and check a key of this provider")
+	}
+}
+
+// readAIApiKey reads the new AI API key from the first line of stdin, for
+// scripts, or asks for it without echo.
+func readAIApiKey(console *Console, fromStdin bool, stdin io.Reader) (string, error) {
+	if !fromStdin {
+		return console.askForPassword("Enter the new AI API Key", "AI API Key")
+	}
+	line, err := bufio.NewReader(stdin).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", fmt.Errorf("error reading AI API Key from stdin: %w", err)
+	}
+	return strings.TrimSpace(line), nil
+}
+
+// RunConfigAIRotateKey replaces the AI API key of the installation.
+func RunConfigAIRotateKey(cmd *cobra.Command, args []string) {
+	console, _ := managementConsole(cmd)
+	fromStdin, err := cmd.Flags().GetBool("api-key-stdin")
+	if err != nil {
+		console.printErrorAndExit(err)
+	}
+	manager := newAIConfigManager(cmd, console)
+	apiKey, err := readAIApiKey(console, fromStdin, os.Stdin)
+	if err != nil {
+		console.printErrorAndExit(err)
+	}
+	console.Secrets.Add(apiKey)
+	format, err := manager.RotateKey(cmd.Context(), apiKey)
+	if err != nil {
+		console.printErrorAndExit(err)
+	}
+	console.printInfo(fmt.Sprintf("AI API key replaced, encrypted in the %s format", format))
+}
@@ -0,0 +1,304 @@
+package internal
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// testAIConfigManager returns an AIConfigManager over an in-memory parameter
+// table holding records, with testAESKey as the AES secret.
+func testAIConfigManager(records map[string][]map[string]interface{}) *AIConfigManager {
+	manager, _ := testAIConfigManagerWithFake(records)
+	return manager
+}
+
+func testAIConfigManagerWithFake(records map[string][]map[string]interface{}) (*AIConfigManager, *fakeAWS) {
+	_, fake := testEncryptionManager(records, testAESKey)
+	return &AIConfigManager{
+		Parameters: fake,
+		Secrets:    fake,
+		Records:    fake,
+		Keys:       fake,
+		Batch:      fake,
+		Accounts:   fake,
+		Roles:      fake,
+		Region:     "us-east-1",
+		Console:    testConsole(),
+	}, fake
+}
+
+func TestAIConfigManagerSet(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name    string
+		changes AISettings
+		want    map[string]string
+		// key is the stored AI API key afterwards, empty when untouched and
+		// "-" when removed.
+		key     string
+		wantErr string
+	}{
+		{
+			name:    "provider, model and key",
+			changes: AISettings{Provider: "anthropic", Model: "claude-sonnet-4", APIKey: "sk-ant-new"},
+			want:    map[string]string{"ai_provider": "anthropic", "ai_model": "claude-sonnet-4"},
+			key:     "sk-ant-new",
+		},
+		{
+			name:    "model only",
+			changes: AISettings{Model: "gpt-4.1"},
+			want:    map[string]string{"ai_provider": "openai", "ai_model": "gpt-4.1"},
+		},
+		{
+			name:    "key of the same provider",
+			changes: AISettings{APIKey: " sk-openai-new\n"},
+			want:    map[string]string{"ai_provider": "openai", "ai_model": "gpt-4o"},
+			key:     "sk-openai-new",
+		},
+		{
+			name:    "bedrock in the installation's region",
+			changes: AISettings{Provider: "bedrock", Model: "anthropic.claude-3-5-sonnet-20240620-v1:0"},
+			want:    map[string]string{"ai_provider": "bedrock", "ai_model": "anthropic.claude-3-5-sonnet-20240620-v1:0", "ai_region": "us-east-1"},
+			key:     "-",
+		},
+		{
+			name:    "azure openai with the default API version",
+			changes: AISettings{Provider: "azure-openai", Model: "prod-gpt-4o", BaseURL: "https://titvo.openai.azure.com", APIKey: "azure-key"},
+			want:    map[string]string{"ai_provider": "azure-openai", "ai_model": "prod-gpt-4o", "ai_base_url": "https://titvo.openai.azure.com", "ai_api_version": "2024-10-21"},
+			key:     "azure-key",
+		},
+		{
+			name:    "openai compatible without key",
+			changes: AISettings{Provider: "openai-compatible", Model: "llama3", BaseURL: "http://llm.internal:8000/v1"},
+			want:    map[string]string{"ai_provider": "openai-compatible", "ai_model": "llama3", "ai_base_url": "http://llm.internal:8000/v1", "ai_region": ""},
+			key:     "-",
+		},
+		{name: "new provider without key", changes: AISettings{Provider: "anthropic", Model: "claude-sonnet-4"}, wantErr: "the anthropic provider needs its API key, the stored one belongs to the openai provider"},
+		{name: "key for bedrock", changes: AISettings{Provider: "bedrock", Model: "m1", APIKey: "sk-x"}, wantErr: "IAM role of the agent"},
+		{name: "nothing", wantErr: "nothing to set"},
+		{name: "unknown provider", changes: AISettings{Provider: "acme", Model: "m1"}, wantErr: `unknown AI provider "acme"`},
+		{name: "provider without model", changes: AISettings{Provider: "google"}, wantErr: "the google provider needs its AI Model"},
+		{name: "azure openai without endpoint", changes: AISettings{Provider: "azure-openai", Model: "prod"}, wantErr: "needs its base URL"},
+		{name: "invalid base URL", changes: AISettings{Provider: "openai-compatible", Model: "m1", BaseURL: "llm.internal"}, wantErr: "invalid base URL"},
+		{name: "setting of another provider", changes: AISettings{Model: "gpt-4.1", Region: "eu-west-1"}, wantErr: "the openai provider does not use a region"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			records := map[string][]map[string]interface{}{
+				"parameters": {
+					{"parameter_id": "ai_provider", "value": "openai"},
+					{"parameter_id": "ai_model", "value": "gpt-4o"},
+					{"parameter_id": "ai_api_key", "value": "v2:untouched"},
+				},
+			}
+			manager := testAIConfigManager(records)
+
+			_, err := manager.Set(context.Background(), tt.changes)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expected error containing %q, got %v", tt.wantErr, err)
+				}
+				if parameterValue(records, "ai_model") != "gpt-4o" {
+					t.Fatalf("expected nothing to be written, got %v", records["parameters"])
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			for parameterID, value := range tt.want {
+				if got := parameterValue(records, parameterID); got != value {
+					t.Fatalf("expected %s to be %q, got %q", parameterID, value, got)
+				}
+			}
+			stored := parameterValue(records, "ai_api_key")
+			switch tt.key {
+			case "":
+				if stored != "v2:untouched" {
+					t.Fatalf("expected the AI API key to be untouched, got %v", records["parameters"])
+				}
+			case "-":
+				if stored != "" {
+					t.Fatalf("expected the AI API key of the previous provider to be removed, got %q", stored)
+				}
+			default:
+				if decrypted, err := decryptValue(stored, testAESKey); err != nil || decrypted != tt.key {
+					t.Fatalf("expected the new AI API key to be stored, got %q: %v", decrypted, err)
+				}
+			}
+		})
+	}
+}
+
+func TestAIConfigManagerRotateKey(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name   string
+		format string
+		want   string
+	}{
+		{name: "gcm", format: EncryptionFormatGCM, want: EncryptionFormatGCM},
+		{name: "ecb", format: EncryptionFormatECB, want: EncryptionFormatECB},
+		{name: "no encryption format record", want: EncryptionFormatECB},
+		{name: "kms", format: EncryptionFormatKMS, want: EncryptionFormatKMS},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			parameters := []map[string]interface{}{
+				{"parameter_id": "ai_api_key", "value": "v2:old"},
+				{"parameter_id": "ai_model", "value": "gpt-4o"},
+			}
+			if tt.format != "" {
+				parameters = append(parameters, map[string]interface{}{"parameter_id": encryptionFormatParameterID, "value": tt.format})
+			}
+			records := map[string][]map[string]interface{}{"parameters": parameters}
+			manager := testAIConfigManager(records)
+
+			format, err := manager.RotateKey(context.Background(), " sk-new\n")
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			encrypted := parameterValue(records, "ai_api_key")
+			if format != tt.want || valueFormat(encrypted) != tt.want {
+				t.Fatalf("expected the key encrypted in the %s format, got %q (%s)", tt.want, encrypted, format)
+			}
+			if tt.want == EncryptionFormatKMS {
+				ciphertext, _ := base64.StdEncoding.DecodeString(strings.TrimPrefix(encrypted, kmsPrefix))
+				if string(ciphertext) != "value:"+kmsKeyParameter+":sk-new" {
+					t.Fatalf("expected the key encrypted with the stored KMS key, got %q", ciphertext)
+				}
+			} else if decrypted, err := decryptValue(encrypted, testAESKey); err != nil || decrypted != "sk-new" {
+				t.Fatalf("expected the key encrypted with the AES secret, got %q: %v", decrypted, err)
+			}
+			if parameterValue(records, "ai_model") != "gpt-4o" {
+				t.Fatalf("expected the other parameters to be untouched, got %v", records["parameters"])
+			}
+		})
+	}
+}
+
+func TestAIConfigManagerRotateKeyErrors(t *testing.T) {
+	t.Parallel()
+	records := map[string][]map[string]interface{}{
+		"parameters": {{"parameter_id": encryptionFormatParameterID, "value": "v9"}},
+	}
+	manager := testAIConfigManager(records)
+	if _, err := manager.RotateKey(context.Background(), "  "); err == nil || !strings.Contains(err.Error(), "empty") {
+		t.Fatalf("expected an empty key error, got %v", err)
+	}
+	if _, err := manager.RotateKey(context.Background(), "sk-new"); err == nil {
+		t.Fatal("expected an error for an unknown encryption format")
+	}
+	if parameterValue(records, "ai_api_key") != "" {
+		t.Fatalf("expected nothing to be written, got %v", records["parameters"])
+	}
+}
+
+func TestAIConfigManagerValidates(t *testing.T) {
+	t.Parallel()
+	server := newAIProviderStub(t, "sk-valid", "gpt-4o", "gpt-4.1")
+	storedKey, _ := encryptGCM("sk-valid", testAESKey)
+	newManager := func() (*AIConfigManager, map[string][]map[string]interface{}) {
+		records := map[string][]map[string]interface{}{
+			"parameters": {
+				{"parameter_id": "ai_provider", "value": "openai"},
+				{"parameter_id": "ai_model", "value": "gpt-4o"},
+				{"parameter_id": "ai_api_key", "value": storedKey},
+				{"parameter_id": encryptionFormatParameterID, "value": EncryptionFormatGCM},
+			},
+		}
+		manager := testAIConfigManager(records)
+		manager.Validator = stubValidator(server)
+		return manager, records
+	}
+
+	manager, records := newManager()
+	if checked, err := manager.Set(context.Background(), AISettings{Model: "gpt-4.1"}); err != nil || !checked {
+		t.Fatalf("expected the model to be checked with the stored key, got %v, %v", checked, err)
+	}
+	if _, err := manager.Set(context.Background(), AISettings{Model: "gpt-5-typo"}); err == nil || !strings.Contains(err.Error(), "not available") {
+		t.Fatalf("expected an unknown model error, got %v", err)
+	}
+	if parameterValue(records, "ai_model") != "gpt-4.1" {
+		t.Fatalf("expected the unknown model not to be stored, got %v", records["parameters"])
+	}
+	if _, err := manager.Set(context.Background(), AISettings{Provider: "google", Model: "gpt-4o", APIKey: "sk-wrong"}); !errors.Is(err, errAIKeyRejected) {
+		t.Fatalf("expected the key of the new provider to be rejected, got %v", err)
+	}
+	if checked, err := manager.Set(context.Background(), AISettings{Provider: "anthropic", Model: "gpt-4o", APIKey: "sk-valid"}); err != nil || !checked {
+		t.Fatalf("expected a new provider to be checked with its key, got %v, %v", checked, err)
+	}
+	if parameterValue(records, "ai_provider") != "anthropic" {
+		t.Fatalf("expected the new provider to be stored, got %v", records["parameters"])
+	}
+
+	manager, records = newManager()
+	if _, err := manager.RotateKey(context.Background(), "sk-wrong"); !errors.Is(err, errAIKeyRejected) {
+		t.Fatalf("expected the new key to be rejected, got %v", err)
+	}
+	if parameterValue(records, "ai_api_key") != storedKey {
+		t.Fatalf("expected the rejected key not to be stored, got %v", records["parameters"])
+	}
+}
+
+func TestAIConfigManagerSetBedrock(t *testing.T) {
+	t.Parallel()
+	records := map[string][]map[string]interface{}{
+		"parameters": {
+			{"parameter_id": "ai_provider", "value": "openai"},
+			{"parameter_id": "ai_model", "value": "gpt-4o"},
+		},
+	}
+	manager, fake := testAIConfigManagerWithFake(records)
+	manager.Validator = NewAIValidator(nil)
+	manager.Validator.Bedrock = fake
+	fake.listBedrock = func(region string) ([]string, error) {
+		if region != "eu-west-1" {
+			return nil, errors.New("unexpected region " + region)
+		}
+		return []string{"anthropic.claude-3-5-sonnet-20240620-v1:0", "eu.anthropic.claude-3-5-sonnet-20240620-v1:0"}, nil
+	}
+	var roleARN, policyName, document string
+	fake.putRolePolicy = func(role, name, doc string) error {
+		roleARN, policyName, document = role, name, doc
+		return nil
+	}
+
+	_, err := manager.Set(context.Background(), AISettings{Provider: "bedrock", Model: "mistral.large", Region: "eu-west-1"})
+	if err == nil || !strings.Contains(err.Error(), `AI model "mistral.large" is not available`) {
+		t.Fatalf("expected an unknown model error, got %v", err)
+	}
+	if policyName != "" || parameterValue(records, "ai_provider") != "openai" {
+		t.Fatalf("expected nothing to be written or granted, got %v", records["parameters"])
+	}
+
+	checked, err := manager.Set(context.Background(), AISettings{Provider: "bedrock", Model: "eu.anthropic.claude-3-5-sonnet-20240620-v1:0", Region: "eu-west-1"})
+	if err != nil || !checked {
+		t.Fatalf("expected the bedrock model to be checked, got %v, %v", checked, err)
+	}
+	if roleARN != "arn:aws:iam::123456789012:role/tvo-agent-job-role" || policyName != bedrockPolicyName {
+		t.Fatalf("expected the agent role to be granted, got %q %q", roleARN, policyName)
+	}
+	if !strings.Contains(document, "bedrock:InvokeModel") || !strings.Contains(document, "arn:aws:bedrock:eu-west-1:123456789012:inference-profile/*") {
+		t.Fatalf("unexpected policy %s", document)
+	}
+	if parameterValue(records, "ai_region") != "eu-west-1" {
+		t.Fatalf("expected the region to be stored, got %v", records["parameters"])
+	}
+	if _, err := manager.RotateKey(context.Background(), "sk-new"); err == nil || !strings.Contains(err.Error(), "IAM role") {
+		t.Fatalf("expected bedrock to have no API key, got %v", err)
+	}
+}
+
+func TestReadAIApiKeyFromStdin(t *testing.T) {
+	t.Parallel()
+	apiKey, err := readAIApiKey(testConsole(), true, strings.NewReader("sk-piped\nignored\n"))
+	if err != nil || apiKey != "sk-piped" {
+		t.Fatalf("expected the first line of stdin, got %q: %v", apiKey, err)
+	}
+}
@@ -0,0 +1,477 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	aiRequestTimeout = 30 * time.Second
+	// aiModelsShown bounds the models listed in an unknown model error.
+	aiModelsShown = 10
+)
+
+// errAIKeyRejected is returned when a provider refuses the API key.
+var errAIKeyRejected = errors.New("the AI API key was rejected")
+
+// aiProviderBedrock is authenticated with the IAM role of the agent, which the
+// installer grants the use of the models.
+const aiProviderBedrock = "bedrock"
+
+// aiKeyMode tells whether a provider authenticates with an API key.
+type aiKeyMode int
+
+const (
+	aiKeyRequired aiKeyMode = iota
+	aiKeyOptional
+	// aiKeyNone is for providers authenticated with the IAM role of the agent.
+	aiKeyNone
+)
+
+// AISettings are the AI settings of an installation, stored in the parameter
+// table as ai_provider, ai_model, ai_api_key, ai_base_url, ai_api_version and
+// ai_region.
+type AISettings struct {
+	Provider string
+	// Model is the model ID, or the deployment name with azure-openai.
+	Model  string
+	APIKey string
+	// BaseURL is the endpoint of the azure-openai and openai-compatible
+	// providers.
+	BaseURL string
+	// APIVersion is the api-version of azure-openai.
+	APIVersion string
+	// Region is the AWS region of the bedrock models.
+	Region string
+}
+
+// parameters returns the parameter ID and value of each setting but the API
+// key, which is stored encrypted.
+func (s AISettings) parameters() [][2]string {
+	return [][2]string{
+		{"ai_provider", s.Provider},
+		{"ai_model", s.Model},
+		{"ai_base_url", s.BaseURL},
+		{"ai_api_version", s.APIVersion},
+		{"ai_region", s.Region},
+	}
+}
+
+// aiProvider is an AI provider the Titvo agent can use.
+type aiProvider struct {
+	Name  string
+	Label string
+	// BaseURL is the API the models are listed from, unless the provider
+	// needs an endpoint of its own or it is overridden with --ai-endpoint.
+	BaseURL string
+	APIKey  aiKeyMode
+	// NeedsBaseURL, NeedsAPIVersion and UsesRegion are the settings the
+	// provider uses besides the model and the API key.
+	NeedsBaseURL    bool
+	NeedsAPIVersion bool
+	UsesRegion      bool
+	// ModelLabel names the model setting in prompts and errors.
+	ModelLabel string
+	// BaseURLExample and DefaultAPIVersion help answering the prompts.
+	BaseURLExample    string
+	DefaultAPIVersion string
+	// listModels returns the models settings can use, from the API at
+	// baseURL, or nil when the provider cannot list them.
+	listModels func(ctx context.Context, v *AIValidator, baseURL string, settings AISettings) ([]string, error)
+}
+
+var aiProviders = []aiProvider{
+	{Name: "anthropic", Label: "Anthropic", BaseURL: "https://api.anthropic.com", ModelLabel: "AI Model", listModels: listAnthropicModels},
+	{Name: "openai", Label: "OpenAI", BaseURL: "https://api.openai.com", ModelLabel: "AI Model", listModels: listOpenAIModels},
+	{Name: "google", Label: "Google", BaseURL: "https://generativelanguage.googleapis.com", ModelLabel: "AI Model", listModels: listGoogleModels},
+	{Name: aiProviderBedrock, Label: "Amazon Bedrock", APIKey: aiKeyNone, UsesRegion: true, ModelLabel: "Bedrock model or inference profile ID", listModels: listBedrockModels},
+	{
+		Name:              "azure-openai",
+		Label:             "Azure OpenAI",
+		NeedsBaseURL:      true,
+		NeedsAPIVersion:   true,
+		ModelLabel:        "Azure OpenAI deployment name",
+		BaseURLExample:    "https://my-resource.openai.azure.com",
+		DefaultAPIVersion: "2024-10-21",
+		listModels:        listAzureOpenAIModels,
+	},
+	{
+		Name:           "openai-compatible",
+		Label:          "OpenAI-compatible endpoint",
+		APIKey:         aiKeyOptional,
+		NeedsBaseURL:   true,
+		ModelLabel:     "AI Model",
+		BaseURLExample: "https://llm.example.com/v1",
+		listModels:     listOpenAICompatibleModels,
+	},
+}
+
+func findAIProvider(name string) (*aiProvider, error) {
+	names := make([]string, 0, len(aiProviders))
+	for i := range aiProviders {
+		if aiProviders[i].Name == name {
+			return &aiProviders[i], nil
+		}
+		names = append(names, aiProviders[i].Name)
+	}
+	return nil, fmt.Errorf("unknown AI provider %q, expected one of %s", name, strings.Join(names, ", "))
+}
+
+func validateAIProvider(name string) error {
+	_, err := findAIProvider(name)
+	return err
+}
+
+// check returns an error when settings lack a setting the provider needs.
+// The API key is not checked when withKey is false.
+func (p *aiProvider) check(settings AISettings, withKey bool) error {
+	missing := []string{}
+	if settings.Model == "" {
+		missing = append(missing, p.ModelLabel)
+	}
+	if withKey && p.APIKey == aiKeyRequired && settings.APIKey == "" {
+		missing = append(missing, "API key")
+	}
+	if p.NeedsBaseURL && settings.BaseURL == "" {
+		missing = append(missing, "base URL")
+	}
+	if p.NeedsAPIVersion && settings.APIVersion == "" {
+		missing = append(missing, "API version")
+	}
+	if p.UsesRegion && settings.Region == "" {
+		missing = append(missing, "region")
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("the %s provider needs its %s", p.Name, strings.Join(missing, ", "))
+	}
+	if p.NeedsBaseURL {
+		return p.checkBaseURL(settings.BaseURL)
+	}
+	return nil
+}
+
+// checkUnused returns an error when settings has a setting the provider does
+// not use.
+func (p *aiProvider) checkUnused(settings AISettings) error {
+	unused := []string{}
+	if !p.NeedsBaseURL && settings.BaseURL != "" {
+		unused = append(unused, "base URL")
+	}
+	if !p.NeedsAPIVersion && settings.APIVersion != "" {
+		unused = append(unused, "API version")
+	}
+	if !p.UsesRegion && settings.Region != "" {
+		unused = append(unused, "region")
+	}
+	if len(unused) > 0 {
+		return fmt.Errorf("the %s provider does not use a %s", p.Name, strings.Join(unused, ", "))
+	}
+	return nil
+}
+
+func (p *aiProvider) checkBaseURL(baseURL string) error {
+	if u, err := url.Parse(baseURL); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return fmt.Errorf("invalid base URL %q of the %s provider, expected e.g. %s", baseURL, p.Name, p.BaseURLExample)
+	}
+	return nil
+}
+
+// BedrockCatalog lists the Amazon Bedrock models of a region.
+type BedrockCatalog interface {
+	ListBedrockModels(ctx context.Context, region string) ([]string, error)
+}
+
+// AIValidator checks AI API keys and model names against the models-list
+// endpoint of their provider.
+type AIValidator struct {
+	client *http.Client
+	// endpoints overrides the base URL of a provider, by name.
+	endpoints map[string]string
+	// Bedrock lists the bedrock models, once the AWS session of the
+	// installation is set up.
+	Bedrock BedrockCatalog
+}
+
+func NewAIValidator(endpoints map[string]string) *AIValidator {
+	return &AIValidator{client: &http.Client{Timeout: aiRequestTimeout}, endpoints: endpoints}
+}
+
+// canCheck reports whether v can check the settings of p: the providers
+// authenticated with IAM need the AWS session.
+func (v *AIValidator) canCheck(p *aiProvider) bool {
+	return p.APIKey != aiKeyNone || v.Bedrock != nil
+}
+
+// Models returns the sorted models settings can use, or nil when the
+// provider cannot list them.
+func (v *AIValidator) Models(ctx context.Context, settings AISettings) ([]string, error) {
+	p, err := findAIProvider(settings.Provider)
+	if err != nil {
+		return nil, err
+	}
+	baseURL := p.BaseURL
+	if p.NeedsBaseURL {
+		baseURL = settings.BaseURL
+	}
+	if endpoint, ok := v.endpoints[p.Name]; ok {
+		baseURL = endpoint
+	}
+	models, err := p.listModels(ctx, v, strings.TrimSuffix(baseURL, "/"), settings)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the %s models: %w", p.Label, err)
+	}
+	if models == nil {
+		return nil, nil
+	}
+	slices.Sort(models)
+	return slices.Compact(models), nil
+}
+
+// Validate confirms that settings are complete, that their API key works and
+// that their model is available, as far as the provider can tell.
+func (v *AIValidator) Validate(ctx context.Context, settings AISettings) error {
+	p, err := findAIProvider(settings.Provider)
+	if err != nil {
+		return err
+	}
+	if err := p.check(settings, true); err != nil {
+		return err
+	}
+	models, err := v.Models(ctx, settings)
+	if err != nil || models == nil || slices.Contains(models, settings.Model) {
+		return err
+	}
+	shown := models
+	if len(shown) > aiModelsShown {
+		shown = append(slices.Clone(shown[:aiModelsShown]), "...")
+	}
+	if p.APIKey == aiKeyNone {
+		return fmt.Errorf("AI model %q is not available in the %s region of %s, available models: %s", settings.Model, settings.Region, p.Label, strings.Join(shown, ", "))
+	}
+	return fmt.Errorf("AI model %q is not available to this %s API key, available models: %s", settings.Model, settings.Provider, strings.Join(shown, ", "))
+}
+
+// getAIJSON decodes the JSON answer of a GET to rawURL into out.
+func getAIJSON(ctx context.Context, client *http.Client, rawURL string, header http.Header, out any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return err
+	}
+	req.Header = header
+	req.Header.Set("Accept", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		return fmt.Errorf("%w: HTTP %d %s", errAIKeyRejected, resp.StatusCode, http.StatusText(resp.StatusCode))
+	case resp.StatusCode < 200 || resp.StatusCode > 299:
+		return fmt.Errorf("unexpected HTTP status %d %s", resp.StatusCode, http.StatusText(resp.StatusCode))
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("invalid models list: %w", err)
+	}
+	return nil
+}
+
+func listAnthropicModels(ctx context.Context, v *AIValidator, baseURL string, settings AISettings) ([]string, error) {
+	header := http.Header{}
+	header.Set("x-api-key", settings.APIKey)
+	header.Set("anthropic-version", "2023-06-01")
+	models := []string{}
+	afterID := ""
+	for {
+		query := url.Values{"limit": {"1000"}}
+		if afterID != "" {
+			query.Set("after_id", afterID)
+		}
+		var page struct {
+			Data []struct {
+				ID string `json:"id"`
+			} `json:"data"`
+			HasMore bool   `json:"has_more"`
+			LastID  string `json:"last_id"`
+		}
+		if err := getAIJSON(ctx, v.client, baseURL+"/v1/models?"+query.Encode(), header.Clone(), &page); err != nil {
+			return nil, err
+		}
+		for _, model := range page.Data {
+			models = append(models, model.ID)
+		}
+		if !page.HasMore || page.LastID == "" {
+			return models, nil
+		}
+		afterID = page.LastID
+	}
+}
+
+func listOpenAIModels(ctx context.Context, v *AIValidator, baseURL string, settings AISettings) ([]string, error) {
+	return listOpenAIModelsAt(ctx, v.client, baseURL+"/v1/models", settings.APIKey)
+}
+
+// listOpenAICompatibleModels lists the models of an OpenAI-compatible API,
+// whose base URL includes the version, e.g. https://llm.example.com/v1.
+func listOpenAICompatibleModels(ctx context.Context, v *AIValidator, baseURL string, settings AISettings) ([]string, error) {
+	return listOpenAIModelsAt(ctx, v.client, baseURL+"/models", settings.APIKey)
+}
+
+func listOpenAIModelsAt(ctx context.Context, client *http.Client, modelsURL, apiKey string) ([]string, error) {
+	header := http.Header{}
+	if apiKey != "" {
+		header.Set("Authorization", "Bearer "+apiKey)
+	}
+	var list struct {
+		Data []struct {
+			ID string `json:"id"`
+		} `json:"data"`
+	}
+	if err := getAIJSON(ctx, client, modelsURL, header, &list); err != nil {
+		return nil, err
+	}
+	models := make([]string, 0, len(list.Data))
+	for _, model := range list.Data {
+		models = append(models, model.ID)
+	}
+	return models, nil
+}
+
+// listAzureOpenAIModels only checks the API key: the model of azure-openai is
+// a deployment name, which the data plane API does not list.
+func listAzureOpenAIModels(ctx context.Context, v *AIValidator, baseURL string, settings AISettings) ([]string, error) {
+	header := http.Header{}
+	header.Set("api-key", settings.APIKey)
+	query := url.Values{"api-version": {settings.APIVersion}}
+	var list struct{}
+	if err := getAIJSON(ctx, v.client, baseURL+"/openai/models?"+query.Encode(), header, &list); err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
+// listBedrockModels lists the on-demand text models and the inference
+// profiles of the region through the AWS session.
+func listBedrockModels(ctx context.Context, v *AIValidator, baseURL string, settings AISettings) ([]string, error) {
+	if v.Bedrock == nil {
+		return nil, fmt.Errorf("the AWS session is not set up")
+	}
+	return v.Bedrock.ListBedrockModels(ctx, settings.Region)
+}
+
+// listGoogleModels lists the Gemini models that generate content, without
+// their "models/" prefix.
+func listGoogleModels(ctx context.Context, v *AIValidator, baseURL string, settings AISettings) ([]string, error) {
+	header := http.Header{}
+	header.Set("x-goog-api-key", settings.APIKey)
+	models := []string{}
+	pageToken := ""
+	for {
+		query := url.Values{"pageSize": {"1000"}}
+		if pageToken != "" {
+			query.Set("pageToken", pageToken)
+		}
+		var page struct {
+			Models []struct {
+				Name                       string   `json:"name"`
+				SupportedGenerationMethods []string `json:"supportedGenerationMethods"`
+			} `json:"models"`
+			NextPageToken string `json:"nextPageToken"`
+		}
+		if err := getAIJSON(ctx, v.client, baseURL+"/v1beta/models?"+query.Encode(), header.Clone(), &page); err != nil {
+			return nil, err
+		}
+		for _, model := range page.Models {
+			if slices.Contains(model.SupportedGenerationMethods, "generateContent") {
+				models = append(models, strings.TrimPrefix(model.Name, "models/"))
+			}
+		}
+		if page.NextPageToken == "" {
+			return models, nil
+		}
+		pageToken = page.NextPageToken
+	}
+}
+
+// askForAISettings asks for the AI provider, its settings and a model. With a
+// validator the settings are checked and the models available are offered as
+// choices; otherwise, or when the provider cannot list them, the model is
+// typed. checked reports whether the settings were checked. awsRegion is the
+// default region of bedrock.
+func (c *Console) askForAISettings(ctx context.Context, validator *AIValidator, awsRegion string) (settings AISettings, checked bool, err error) {
+	settings.Provider, err = c.askForAIProvider()
+	if err != nil {
+		return settings, false, err
+	}
+	p, err := findAIProvider(settings.Provider)
+	if err != nil {
+		return settings, false, err
+	}
+	if p.NeedsBaseURL {
+		settings.BaseURL, err = c.askForInput(fmt.Sprintf("Enter the endpoint of the %s (e.g. %s)", p.Label, p.BaseURLExample), "Base URL")
+		if err == nil {
+			err = p.checkBaseURL(settings.BaseURL)
+		}
+		if err != nil {
+			return settings, false, err
+		}
+	}
+	if p.NeedsAPIVersion {
+		settings.APIVersion, err = c.askForInputWithDefault(fmt.Sprintf("Enter the %s API version", p.Label), "API version", p.DefaultAPIVersion)
+		if err != nil {
+			return settings, false, err
+		}
+	}
+	if p.UsesRegion {
+		settings.Region, err = c.askForInputWithDefault(fmt.Sprintf("Enter the AWS region of the %s models", p.Label), "Region", awsRegion)
+		if err != nil {
+			return settings, false, err
+		}
+	}
+	askKey := p.APIKey == aiKeyRequired
+	if p.APIKey == aiKeyOptional {
+		if askKey, err = c.askForYesNo("Does the endpoint need an API key? (y/N)"); err != nil {
+			return settings, false, err
+		}
+	}
+	if askKey {
+		settings.APIKey, err = c.askForPassword("Enter your AI API Key", "AI API Key")
+		if err != nil {
+			return settings, false, err
+		}
+	}
+	var models []string
+	checked = validator != nil && validator.canCheck(p)
+	if checked {
+		models, err = validator.Models(ctx, settings)
+		if errors.Is(err, errAIKeyRejected) {
+			return settings, false, err
+		}
+		if err != nil {
+			c.printAskQuestion(fmt.Sprintf("Warning: %v. The AI settings cannot be checked", err))
+			checked = false
+		}
+	}
+	if len(models) == 0 {
+		// A typed model is only checked when the provider cannot list any
+		settings.Model, err = c.askForInput("Enter your "+p.ModelLabel, p.ModelLabel)
+		return settings, checked && models == nil && err == nil, err
+	}
+	choices := make([]choice, 0, len(models))
+	for i, name := range models {
+		choices = append(choices, choice{Label: name, Value: fmt.Sprint(i + 1), Callback: func() (any, error) { return name, nil }})
+	}
+	result, err := c.askForChoices("Select "+p.ModelLabel, choices)
+	if err != nil {
+		return settings, false, err
+	}
+	settings.Model = result.(string)
+	return settings, true, nil
+}
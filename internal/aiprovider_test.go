@@ -0,0 +1,172 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// newAIProviderStub serves the models-list endpoints of every AI provider
+// with an HTTP API, in pages of one model where the provider pages, listing
+// models for apiKey and rejecting other keys.
+func newAIProviderStub(t *testing.T, apiKey string, models ...string) *httptest.Server {
+	t.Helper()
+	page := func(token string) (string, string) {
+		i := 0
+		if token != "" {
+			i = slices.Index(models, token) + 1
+		}
+		if i >= len(models) {
+			return "", ""
+		}
+		if i == len(models)-1 {
+			return models[i], ""
+		}
+		return models[i], models[i]
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body any
+		switch {
+		case r.URL.Path == "/v1/models" && r.Header.Get("x-api-key") != "":
+			if r.Header.Get("x-api-key") != apiKey || r.Header.Get("anthropic-version") == "" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			model, next := page(r.URL.Query().Get("after_id"))
+			body = map[string]any{"data": []map[string]string{{"id": model}}, "has_more": next != "", "last_id": model}
+		case r.URL.Path == "/v1/models", r.URL.Path == "/models":
+			if r.Header.Get("Authorization") != "Bearer "+apiKey {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			data := []map[string]string{}
+			for _, model := range models {
+				data = append(data, map[string]string{"id": model})
+			}
+			body = map[string]any{"data": data}
+		case r.URL.Path == "/openai/models":
+			if r.Header.Get("api-key") != apiKey || r.URL.Query().Get("api-version") == "" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			body = map[string]any{"data": []map[string]string{{"id": "gpt-4o-2024-08-06"}}}
+		case r.URL.Path == "/v1beta/models":
+			if r.Header.Get("x-goog-api-key") != apiKey {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			model, next := page(r.URL.Query().Get("pageToken"))
+			body = map[string]any{
+				"models": []map[string]any{
+					{"name": "models/" + model, "supportedGenerationMethods": []string{"generateContent"}},
+					{"name": "models/embedding-001", "supportedGenerationMethods": []string{"embedContent"}},
+				},
+				"nextPageToken": next,
+			}
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(body)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func stubValidator(server *httptest.Server) *AIValidator {
+	endpoints := map[string]string{}
+	for _, provider := range aiProviders {
+		endpoints[provider.Name] = server.URL
+	}
+	return NewAIValidator(endpoints)
+}
+
+func TestAIValidatorModels(t *testing.T) {
+	t.Parallel()
+	server := newAIProviderStub(t, "sk-valid", "model-b", "model-a", "model-c")
+	validator := stubValidator(server)
+	for _, provider := range []string{"anthropic", "openai", "google", "openai-compatible"} {
+		t.Run(provider, func(t *testing.T) {
+			t.Parallel()
+			models, err := validator.Models(context.Background(), AISettings{Provider: provider, APIKey: "sk-valid"})
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if !slices.Equal(models, []string{"model-a", "model-b", "model-c"}) {
+				t.Fatalf("expected every page of models sorted, got %v", models)
+			}
+			_, err = validator.Models(context.Background(), AISettings{Provider: provider, APIKey: "sk-wrong"})
+			if !errors.Is(err, errAIKeyRejected) {
+				t.Fatalf("expected the key to be rejected, got %v", err)
+			}
+		})
+	}
+}
+
+type stubBedrockCatalog map[string][]string
+
+func (c stubBedrockCatalog) ListBedrockModels(ctx context.Context, region string) ([]string, error) {
+	models, ok := c[region]
+	if !ok {
+		return nil, errors.New("bedrock is not available in " + region)
+	}
+	return models, nil
+}
+
+func TestAIValidatorValidate(t *testing.T) {
+	t.Parallel()
+	server := newAIProviderStub(t, "sk-valid", "gpt-4o", "gpt-4.1")
+	validator := stubValidator(server)
+	validator.Bedrock = stubBedrockCatalog{"us-east-1": {"anthropic.claude-3-5-sonnet-20240620-v1:0", "us.anthropic.claude-3-5-sonnet-20240620-v1:0"}}
+	azure := AISettings{Provider: "azure-openai", Model: "any-deployment", BaseURL: "https://titvo.openai.azure.com", APIVersion: "2024-10-21"}
+	tests := []struct {
+		name     string
+		settings AISettings
+		wantErr  string
+	}{
+		{name: "valid", settings: AISettings{Provider: "openai", Model: "gpt-4o", APIKey: "sk-valid"}},
+		{name: "unknown model", settings: AISettings{Provider: "openai", Model: "gpt-4", APIKey: "sk-valid"}, wantErr: `AI model "gpt-4" is not available to this openai API key, available models: gpt-4.1, gpt-4o`},
+		{name: "rejected key", settings: AISettings{Provider: "openai", Model: "gpt-4o", APIKey: "sk-wrong"}, wantErr: "the AI API key was rejected"},
+		{name: "missing key", settings: AISettings{Provider: "openai", Model: "gpt-4o"}, wantErr: "the openai provider needs its API key"},
+		{name: "unknown provider", settings: AISettings{Provider: "acme", Model: "m1", APIKey: "sk-valid"}, wantErr: `unknown AI provider "acme"`},
+		{name: "azure openai deployment", settings: AISettings{Provider: azure.Provider, Model: azure.Model, BaseURL: azure.BaseURL, APIVersion: azure.APIVersion, APIKey: "sk-valid"}},
+		{name: "azure openai rejected key", settings: AISettings{Provider: azure.Provider, Model: azure.Model, BaseURL: azure.BaseURL, APIVersion: azure.APIVersion, APIKey: "sk-wrong"}, wantErr: "the AI API key was rejected"},
+		{name: "openai compatible without key", settings: AISettings{Provider: "openai-compatible", Model: "gpt-4o", BaseURL: "http://llm.internal/v1"}, wantErr: "the AI API key was rejected"},
+		{name: "bedrock inference profile", settings: AISettings{Provider: "bedrock", Model: "us.anthropic.claude-3-5-sonnet-20240620-v1:0", Region: "us-east-1"}},
+		{name: "bedrock unknown model", settings: AISettings{Provider: "bedrock", Model: "mistral.large", Region: "us-east-1"}, wantErr: `AI model "mistral.large" is not available in the us-east-1 region of Amazon Bedrock`},
+		{name: "bedrock unavailable region", settings: AISettings{Provider: "bedrock", Model: "mistral.large", Region: "af-south-1"}, wantErr: "bedrock is not available in af-south-1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			err := validator.Validate(context.Background(), tt.settings)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("expected no error, got %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestAIValidatorServerError(t *testing.T) {
+	t.Parallel()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+	_, err := NewAIValidator(map[string]string{"anthropic": server.URL}).Models(context.Background(), AISettings{Provider: "anthropic", APIKey: "sk-valid"})
+	if err == nil || errors.Is(err, errAIKeyRejected) || !strings.Contains(err.Error(), "503") {
+		t.Fatalf("expected a server error, got %v", err)
+	}
+}
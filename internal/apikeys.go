@@ -0,0 +1,322 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// APIKey describes an item of the Titvo apikey table. The hash of the key is
+// never exposed. ExpiresAt is only read by the installer: the Titvo services
+// accept a key until RevokeExpiredAPIKeys deletes it.
+type APIKey struct {
+	KeyID     string `json:"key_id"`
+	UserID    string `json:"user_id"`
+	Label     string `json:"label,omitempty"`
+	CreatedAt string `json:"created_at,omitempty"`
+	ExpiresAt string `json:"expires_at,omitempty"`
+}
+
+func apiKeyFromRecord(record map[string]interface{}) APIKey {
+	key := APIKey{}
+	key.KeyID, _ = record["key_id"].(string)
+	key.UserID, _ = record["user_id"].(string)
+	key.Label, _ = record["label"].(string)
+	key.CreatedAt, _ = record["created_at"].(string)
+	key.ExpiresAt, _ = record["expires_at"].(string)
+	return key
+}
+
+// expired reports whether the key has an expiry before now.
+func (k APIKey) expired(now time.Time) bool {
+	if k.ExpiresAt == "" {
+		return false
+	}
+	expiresAt, err := time.Parse(time.RFC3339, k.ExpiresAt)
+	return err == nil && !expiresAt.After(now)
+}
+
+// CreateAPIKey creates an additional API key for a user, which must not be
+// disabled, and returns it with its plaintext key.
+func (m *UserManager) CreateAPIKey(ctx context.Context, idOrName string, options apiKeyOptions) (APIKey, string, error) {
+	userTable, err := m.userTable(ctx)
+	if err != nil {
+		return APIKey{}, "", err
+	}
+	record, err := m.find(ctx, userTable, idOrName)
+	if err != nil {
+		return APIKey{}, "", err
+	}
+	user := userFromRecord(record)
+	if user.Disabled {
+		return APIKey{}, "", fmt.Errorf("user %s is disabled", user.Name)
+	}
+	apiKeyTable, err := m.apiKeyTable(ctx)
+	if err != nil {
+		return APIKey{}, "", err
+	}
+	now := m.clock()
+	if !options.ExpiresAt.IsZero() && !options.ExpiresAt.After(now) {
+		return APIKey{}, "", fmt.Errorf("expiry %s is in the past", options.ExpiresAt.Format(time.RFC3339))
+	}
+	keyID, apiKey, err := putNewAPIKey(ctx, m.Records, apiKeyTable, user.UserID, now, options)
+	if err != nil {
+		return APIKey{}, "", err
+	}
+	key := APIKey{KeyID: keyID, UserID: user.UserID, Label: options.Label, CreatedAt: now.UTC().Format(time.RFC3339)}
+	if !options.ExpiresAt.IsZero() {
+		key.ExpiresAt = options.ExpiresAt.UTC().Format(time.RFC3339)
+	}
+	return key, apiKey, nil
+}
+
+// ListAPIKeys returns the API keys of a user, or of every user when idOrName
+// is empty, oldest first.
+func (m *UserManager) ListAPIKeys(ctx context.Context, idOrName string) ([]APIKey, error) {
+	apiKeyTable, err := m.apiKeyTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var records []map[string]interface{}
+	if idOrName == "" {
+		records, err = m.Records.ListRecords(ctx, apiKeyTable)
+	} else {
+		records, err = m.userAPIKeys(ctx, apiKeyTable, idOrName)
+	}
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]APIKey, 0, len(records))
+	for _, record := range records {
+		keys = append(keys, apiKeyFromRecord(record))
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].CreatedAt != keys[j].CreatedAt {
+			return keys[i].CreatedAt < keys[j].CreatedAt
+		}
+		return keys[i].KeyID < keys[j].KeyID
+	})
+	return keys, nil
+}
+
+func (m *UserManager) userAPIKeys(ctx context.Context, apiKeyTable, idOrName string) ([]map[string]interface{}, error) {
+	userTable, err := m.userTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+	user, err := m.find(ctx, userTable, idOrName)
+	if err != nil {
+		return nil, err
+	}
+	return m.Records.FindRecords(ctx, apiKeyTable, "user_id", userFromRecord(user).UserID)
+}
+
+func (m *UserManager) findAPIKey(ctx context.Context, apiKeyTable, keyID string) (map[string]interface{}, error) {
+	records, err := m.Records.FindRecords(ctx, apiKeyTable, "key_id", keyID)
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("API key %s not found", keyID)
+	}
+	return records[0], nil
+}
+
+// RotateAPIKey creates a new API key for the user and label of keyID. The old
+// key is revoked right away when gracePeriod is zero, and otherwise gets an
+// expiry gracePeriod later, giving clients time to switch. Nothing stops the
+// old key at its expiry: it keeps working until revoke --expired deletes it.
+func (m *UserManager) RotateAPIKey(ctx context.Context, keyID string, gracePeriod time.Duration) (APIKey, string, error) {
+	if gracePeriod < 0 {
+		return APIKey{}, "", fmt.Errorf("grace period cannot be negative")
+	}
+	apiKeyTable, err := m.apiKeyTable(ctx)
+	if err != nil {
+		return APIKey{}, "", err
+	}
+	record, err := m.findAPIKey(ctx, apiKeyTable, keyID)
+	if err != nil {
+		return APIKey{}, "", err
+	}
+	old := apiKeyFromRecord(record)
+	now := m.clock()
+	if old.expired(now) {
+		return APIKey{}, "", fmt.Errorf("API key %s already expired", keyID)
+	}
+	newKeyID, apiKey, err := putNewAPIKey(ctx, m.Records, apiKeyTable, old.UserID, now, apiKeyOptions{Label: old.Label})
+	if err != nil {
+		return APIKey{}, "", err
+	}
+	if gracePeriod == 0 {
+		err = m.Records.DeleteRecord(ctx, apiKeyTable, "key_id", keyID)
+	} else {
+		expiresAt := now.Add(gracePeriod)
+		if current, parseErr := time.Parse(time.RFC3339, old.ExpiresAt); parseErr == nil && current.Before(expiresAt) {
+			expiresAt = current
+		}
+		record["expires_at"] = expiresAt.UTC().Format(time.RFC3339)
+		err = m.Records.PutRecord(ctx, apiKeyTable, record)
+	}
+	if err != nil {
+		return APIKey{}, "", fmt.Errorf("new API key %s created but the old key %s could not be revoked: %w", newKeyID, keyID, err)
+	}
+	return APIKey{KeyID: newKeyID, UserID: old.UserID, Label: old.Label, CreatedAt: now.UTC().Format(time.RFC3339)}, apiKey, nil
+}
+
+// RevokeAPIKey deletes an API key, which stops authenticating immediately.
+func (m *UserManager) RevokeAPIKey(ctx context.Context, keyID string) (APIKey, error) {
+	apiKeyTable, err := m.apiKeyTable(ctx)
+	if err != nil {
+		return APIKey{}, err
+	}
+	record, err := m.findAPIKey(ctx, apiKeyTable, keyID)
+	if err != nil {
+		return APIKey{}, err
+	}
+	if err := m.Records.DeleteRecord(ctx, apiKeyTable, "key_id", keyID); err != nil {
+		return APIKey{}, err
+	}
+	return apiKeyFromRecord(record), nil
+}
+
+// RevokeExpiredAPIKeys deletes every API key past its expiry, such as the old
+// keys of finished rotations, and returns them.
+func (m *UserManager) RevokeExpiredAPIKeys(ctx context.Context) ([]APIKey, error) {
+	keys, err := m.ListAPIKeys(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	apiKeyTable, err := m.apiKeyTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+	now := m.clock()
+	revoked := []APIKey{}
+	for _, key := range keys {
+		if !key.expired(now) {
+			continue
+		}
+		if err := m.Records.DeleteRecord(ctx, apiKeyTable, "key_id", key.KeyID); err != nil {
+			return revoked, err
+		}
+		revoked = append(revoked, key)
+	}
+	return revoked, nil
+}
+
+func reportAPIKey(console *Console, key APIKey, apiKey string) {
+	console.reportOutput("User ID", key.UserID)
+	console.reportOutput("Key ID", key.KeyID)
+	console.reportOutput("API Key", apiKey)
+	if key.ExpiresAt != "" {
+		console.reportOutput("Expires At", key.ExpiresAt)
+	}
+	console.printInfo("* Keep the API Key in a safe place, it is not shown again")
+}
+
+// RunAPIKeysCreate creates an additional API key for a user.
+func RunAPIKeysCreate(cmd *cobra.Command, args []string) {
+	console, _ := managementConsole(cmd)
+	label, err := cmd.Flags().GetString("label")
+	if err != nil {
+		console.printErrorAndExit(err)
+	}
+	expiresIn, err := cmd.Flags().GetDuration("expires-in")
+	if err != nil {
+		console.printErrorAndExit(err)
+	}
+	if expiresIn < 0 {
+		console.printErrorAndExit(fmt.Errorf("--expires-in cannot be negative"))
+	}
+	manager := newUserManager(cmd, console)
+	options := apiKeyOptions{Label: label}
+	if expiresIn > 0 {
+		options.ExpiresAt = manager.clock().Add(expiresIn)
+	}
+	key, apiKey, err := manager.CreateAPIKey(cmd.Context(), args[0], options)
+	if err != nil {
+		console.printErrorAndExit(err)
+	}
+	reportAPIKey(console, key, apiKey)
+	if expiresIn > 0 {
+		console.printAskQuestion("Warning: the key keeps working after its expiry until `apikeys revoke --expired` deletes it, schedule that command")
+	}
+}
+
+// RunAPIKeysList lists the API keys of a user, or of every user.
+func RunAPIKeysList(cmd *cobra.Command, args []string) {
+	console, format := managementConsole(cmd)
+	manager := newUserManager(cmd, console)
+	user := ""
+	if len(args) > 0 {
+		user = args[0]
+	}
+	keys, err := manager.ListAPIKeys(cmd.Context(), user)
+	if err != nil {
+		console.printErrorAndExit(err)
+	}
+	now := manager.clock()
+	rows := make([][]string, 0, len(keys))
+	for _, key := range keys {
+		status := "active"
+		if key.expired(now) {
+			status = "expired"
+		}
+		rows = append(rows, []string{key.KeyID, key.UserID, key.Label, key.CreatedAt, key.ExpiresAt, status})
+	}
+	if err := writeListing(cmd.OutOrStdout(), format, []string{"KEY ID", "USER ID", "LABEL", "CREATED AT", "EXPIRES AT", "STATUS"}, rows, keys); err != nil {
+		console.printErrorAndExit(err)
+	}
+}
+
+// RunAPIKeysRotate replaces an API key with a new one.
+func RunAPIKeysRotate(cmd *cobra.Command, args []string) {
+	console, _ := managementConsole(cmd)
+	gracePeriod, err := cmd.Flags().GetDuration("grace-period")
+	if err != nil {
+		console.printErrorAndExit(err)
+	}
+	manager := newUserManager(cmd, console)
+	key, apiKey, err := manager.RotateAPIKey(cmd.Context(), args[0], gracePeriod)
+	if err != nil {
+		console.printErrorAndExit(err)
+	}
+	reportAPIKey(console, key, apiKey)
+	if gracePeriod == 0 {
+		console.printInfo(fmt.Sprintf("API key %s revoked", args[0]))
+	} else {
+		console.printAskQuestion(fmt.Sprintf("Warning: API key %s keeps working after its expiry in %s until `apikeys revoke --expired` deletes it, schedule that command", args[0], gracePeriod))
+	}
+}
+
+// RunAPIKeysRevoke deletes an API key, or every expired key with --expired.
+func RunAPIKeysRevoke(cmd *cobra.Command, args []string) {
+	console, _ := managementConsole(cmd)
+	expired, err := cmd.Flags().GetBool("expired")
+	if err != nil {
+		console.printErrorAndExit(err)
+	}
+	if expired == (len(args) == 1) {
+		console.printErrorAndExit(fmt.Errorf("give either a key ID or --expired"))
+	}
+	manager := newUserManager(cmd, console)
+	if expired {
+		revoked, err := manager.RevokeExpiredAPIKeys(cmd.Context())
+		for _, key := range revoked {
+			console.printInfo(fmt.Sprintf("API key %s of user %s revoked", key.KeyID, key.UserID))
+		}
+		if err != nil {
+			console.printErrorAndExit(err)
+		}
+		console.printInfo(fmt.Sprintf("%d expired API keys revoked", len(revoked)))
+		return
+	}
+	key, err := manager.RevokeAPIKey(cmd.Context(), args[0])
+	if err != nil {
+		console.printErrorAndExit(err)
+	}
+	console.printInfo(fmt.Sprintf("API key %s of user %s revoked", key.KeyID, key.UserID))
+}
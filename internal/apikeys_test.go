@@ -0,0 +1,176 @@
+package internal
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+var apiKeysNow = time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+
+func testAPIKeyManager(records map[string][]map[string]interface{}) *UserManager {
+	manager, _ := testUserManager(records)
+	manager.now = func() time.Time { return apiKeysNow }
+	return manager
+}
+
+func findKey(records []map[string]interface{}, keyID string) map[string]interface{} {
+	for _, record := range records {
+		if record["key_id"] == keyID {
+			return record
+		}
+	}
+	return nil
+}
+
+func TestCreateAPIKey(t *testing.T) {
+	t.Parallel()
+	records := testUsers()
+	manager := testAPIKeyManager(records)
+
+	key, apiKey, err := manager.CreateAPIKey(context.Background(), "admin", apiKeyOptions{Label: "ci", ExpiresAt: apiKeysNow.Add(48 * time.Hour)})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if key.UserID != "user-1" || key.Label != "ci" || key.CreatedAt != "2026-03-01T12:00:00Z" || key.ExpiresAt != "2026-03-03T12:00:00Z" {
+		t.Fatalf("unexpected key %+v", key)
+	}
+	stored := findKey(records["apikeys"], key.KeyID)
+	if stored == nil || stored["api_key"] != hashSha256([]byte(apiKey)) || stored["label"] != "ci" || stored["expires_at"] != "2026-03-03T12:00:00Z" {
+		t.Fatalf("unexpected stored key %v", stored)
+	}
+	if !strings.HasPrefix(apiKey, "tvok-") {
+		t.Fatalf("expected a Titvo API key, got %q", apiKey)
+	}
+}
+
+func TestCreateAPIKeyErrors(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name     string
+		user     string
+		options  apiKeyOptions
+		expected string
+	}{
+		{name: "disabled user", user: "security", expected: "user security is disabled"},
+		{name: "expiry in the past", user: "admin", options: apiKeyOptions{ExpiresAt: apiKeysNow.Add(-time.Hour)}, expected: "expiry 2026-03-01T11:00:00Z is in the past"},
+		{name: "unknown user", user: "nobody", expected: "user nobody not found"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			records := testUsers()
+			records["users"][0]["disabled"] = true
+			manager := testAPIKeyManager(records)
+			_, _, err := manager.CreateAPIKey(context.Background(), tc.user, tc.options)
+			if err == nil || err.Error() != tc.expected {
+				t.Fatalf("expected %q, got %v", tc.expected, err)
+			}
+			if len(records["apikeys"]) != 3 {
+				t.Fatal("expected no key to be created")
+			}
+		})
+	}
+}
+
+func TestListAPIKeys(t *testing.T) {
+	t.Parallel()
+	records := testUsers()
+	records["apikeys"][0]["created_at"] = "2026-02-01T00:00:00Z"
+	records["apikeys"][1]["created_at"] = "2026-01-01T00:00:00Z"
+	manager := testAPIKeyManager(records)
+
+	keys, err := manager.ListAPIKeys(context.Background(), "admin")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(keys) != 2 || keys[0].KeyID != "key-2" || keys[1].KeyID != "key-1" {
+		t.Fatalf("expected the keys of admin oldest first, got %+v", keys)
+	}
+
+	all, err := manager.ListAPIKeys(context.Background(), "")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(all) != 3 {
+		t.Fatalf("expected every key, got %+v", all)
+	}
+}
+
+func TestRotateAPIKey(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name        string
+		gracePeriod time.Duration
+		oldExpiry   string
+		expected    string
+	}{
+		{name: "grace period", gracePeriod: 24 * time.Hour, expected: "2026-03-02T12:00:00Z"},
+		{name: "earlier expiry is kept", gracePeriod: 24 * time.Hour, oldExpiry: "2026-03-01T18:00:00Z", expected: "2026-03-01T18:00:00Z"},
+		{name: "immediate revoke", gracePeriod: 0},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			records := testUsers()
+			records["apikeys"][0]["label"] = "ci"
+			if tc.oldExpiry != "" {
+				records["apikeys"][0]["expires_at"] = tc.oldExpiry
+			}
+			manager := testAPIKeyManager(records)
+
+			key, apiKey, err := manager.RotateAPIKey(context.Background(), "key-1", tc.gracePeriod)
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if key.UserID != "user-1" || key.Label != "ci" {
+				t.Fatalf("expected the new key to keep the user and label, got %+v", key)
+			}
+			stored := findKey(records["apikeys"], key.KeyID)
+			if stored == nil || stored["api_key"] != hashSha256([]byte(apiKey)) {
+				t.Fatalf("expected the new key to be stored, got %v", stored)
+			}
+			old := findKey(records["apikeys"], "key-1")
+			if tc.gracePeriod == 0 {
+				if old != nil {
+					t.Fatalf("expected the old key to be deleted, got %v", old)
+				}
+				return
+			}
+			if old == nil || old["expires_at"] != tc.expected {
+				t.Fatalf("expected the old key to expire at %s, got %v", tc.expected, old)
+			}
+		})
+	}
+}
+
+func TestRevokeAPIKeys(t *testing.T) {
+	t.Parallel()
+	records := testUsers()
+	records["apikeys"][1]["expires_at"] = "2026-03-01T11:00:00Z"
+	records["apikeys"][2]["expires_at"] = "2026-03-02T00:00:00Z"
+	manager := testAPIKeyManager(records)
+
+	revoked, err := manager.RevokeExpiredAPIKeys(context.Background())
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(revoked) != 1 || revoked[0].KeyID != "key-2" {
+		t.Fatalf("expected only the expired key to be revoked, got %+v", revoked)
+	}
+
+	key, err := manager.RevokeAPIKey(context.Background(), "key-1")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if key.UserID != "user-1" {
+		t.Fatalf("unexpected revoked key %+v", key)
+	}
+	if len(records["apikeys"]) != 1 || records["apikeys"][0]["key_id"] != "key-3" {
+		t.Fatalf("unexpected keys after revoke %v", records["apikeys"])
+	}
+	if _, err := manager.RevokeAPIKey(context.Background(), "key-1"); err == nil || err.Error() != "API key key-1 not found" {
+		t.Fatalf("expected revoking a missing key to fail, got %v", err)
+	}
+}
@@ -4,25 +4,108 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"net/http"
+	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
 	"github.com/aws/aws-sdk-go-v2/service/batch"
 	batchtypes "github.com/aws/aws-sdk-go-v2/service/batch/types"
+	"github.com/aws/aws-sdk-go-v2/service/bedrock"
+	bedrocktypes "github.com/aws/aws-sdk-go-v2/service/bedrock/types"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	dynamodbtypes "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	kmstypes "github.com/aws/aws-sdk-go-v2/service/kms/types"
 	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
 	secretsmanagertypes "github.com/aws/aws-sdk-go-v2/service/secretsmanager/types"
+	"github.com/aws/aws-sdk-go-v2/service/servicediscovery"
+	servicediscoverytypes "github.com/aws/aws-sdk-go-v2/service/servicediscovery/types"
 	"github.com/aws/aws-sdk-go-v2/service/ssm"
 	"github.com/aws/aws-sdk-go-v2/service/ssm/types"
 	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/google/uuid"
 )
 
-func (creds *AWSCredentials) getAWSConfig(ctx context.Context) (aws.Config, error) {
+// Version identifies the installer build; it is set with
+// -ldflags "-X github.com/KaribuLab/titvo-installer/internal.Version=<version>".
+var Version = "dev"
+
+const (
+	awsMaxAttempts       = 8
+	awsMaxBackoff        = 20 * time.Second
+	awsBatchPollInterval = 10 * time.Second
+	// awsServicePollInterval and awsServiceResolveTimeout bound the wait of
+	// ResolveService for the first instance of a Cloud Map service.
+	awsServicePollInterval   = 15 * time.Second
+	awsServiceResolveTimeout = 10 * time.Minute
+	// awsAllServices is the AWSSessionOptions.Endpoints key that applies to
+	// every service without its own override.
+	awsAllServices = "*"
+)
+
+// AWSSessionOptions tunes the clients of an AWSSession.
+type AWSSessionOptions struct {
+	// Endpoints overrides the endpoint of a service, keyed by "sts", "ssm",
+	// "secretsmanager", "batch", "dynamodb", "kms", "iam", "bedrock" or
+	// "servicediscovery", or "*" for all of them. Used to point the installer at LocalStack or moto.
+	Endpoints   map[string]string
+	MaxAttempts int
+	MaxBackoff  time.Duration
+	// HTTPClient replaces the default HTTP client, e.g. in tests.
+	HTTPClient *http.Client
+	// Console receives the progress of the Batch jobs and Cloud Map lookups.
+	Console *Console
+}
+
+// AWSSession holds the AWS clients of a run. They share one configuration,
+// loaded once, with the installer's retry policy and user agent.
+type AWSSession struct {
+	Config aws.Config
+
+	sts            *sts.Client
+	ssm            *ssm.Client
+	secretsManager *secretsmanager.Client
+	batch          *batch.Client
+	dynamodb       *dynamodb.Client
+	kms            *kms.Client
+	iam            *iam.Client
+	bedrock        *bedrock.Client
+	cloudMap       *servicediscovery.Client
+	// batchPollInterval is how often SubmitBatchJob checks the job status.
+	batchPollInterval time.Duration
+	// servicePollInterval is how often ResolveService lists the instances.
+	servicePollInterval time.Duration
+	console             *Console
+}
+
+// NewAWSSession loads the AWS configuration for creds and builds the clients.
+func NewAWSSession(ctx context.Context, creds *AWSCredentials, options AWSSessionOptions) (*AWSSession, error) {
+	maxAttempts := options.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = awsMaxAttempts
+	}
+	maxBackoff := options.MaxBackoff
+	if maxBackoff <= 0 {
+		maxBackoff = awsMaxBackoff
+	}
 	configOptions := []func(*config.LoadOptions) error{
 		config.WithRegion(creds.AWSRegion),
+		config.WithAppID("titvo-installer_" + Version),
+		config.WithRetryer(func() aws.Retryer {
+			return retry.NewStandard(func(o *retry.StandardOptions) {
+				o.MaxAttempts = maxAttempts
+				o.MaxBackoff = maxBackoff
+			})
+		}),
+	}
+	if options.HTTPClient != nil {
+		configOptions = append(configOptions, config.WithHTTPClient(options.HTTPClient))
 	}
 
 	if creds.AWSAccessKeyID != "" && creds.AWSSecretAccessKey != "" {
@@ -34,19 +117,59 @@ func (creds *AWSCredentials) getAWSConfig(ctx context.Context) (aws.Config, erro
 		configOptions = append(configOptions, config.WithCredentialsProvider(credProvider))
 	}
 
-	return config.LoadDefaultConfig(ctx, configOptions...)
-}
-
-// GetAccountID obtiene el Account ID de AWS usando las credenciales proporcionadas
-func GetAccountID(creds *AWSCredentials) (string, error) {
-	cfg, err := creds.getAWSConfig(context.TODO())
+	cfg, err := config.LoadDefaultConfig(ctx, configOptions...)
 	if err != nil {
-		return "", fmt.Errorf("error al cargar configuración de AWS: %w", err)
+		return nil, fmt.Errorf("error al cargar configuración de AWS: %w", err)
 	}
+	endpoint := func(service string) *string {
+		if url, ok := options.Endpoints[service]; ok && url != "" {
+			return aws.String(url)
+		}
+		if url, ok := options.Endpoints[awsAllServices]; ok && url != "" {
+			return aws.String(url)
+		}
+		return nil
+	}
+	return &AWSSession{
+		Config: cfg,
+		sts: sts.NewFromConfig(cfg, func(o *sts.Options) {
+			o.BaseEndpoint = endpoint("sts")
+		}),
+		ssm: ssm.NewFromConfig(cfg, func(o *ssm.Options) {
+			o.BaseEndpoint = endpoint("ssm")
+		}),
+		secretsManager: secretsmanager.NewFromConfig(cfg, func(o *secretsmanager.Options) {
+			o.BaseEndpoint = endpoint("secretsmanager")
+		}),
+		batch: batch.NewFromConfig(cfg, func(o *batch.Options) {
+			o.BaseEndpoint = endpoint("batch")
+		}),
+		dynamodb: dynamodb.NewFromConfig(cfg, func(o *dynamodb.Options) {
+			o.BaseEndpoint = endpoint("dynamodb")
+		}),
+		kms: kms.NewFromConfig(cfg, func(o *kms.Options) {
+			o.BaseEndpoint = endpoint("kms")
+		}),
+		iam: iam.NewFromConfig(cfg, func(o *iam.Options) {
+			o.BaseEndpoint = endpoint("iam")
+		}),
+		bedrock: bedrock.NewFromConfig(cfg, func(o *bedrock.Options) {
+			o.BaseEndpoint = endpoint("bedrock")
+		}),
+		cloudMap: servicediscovery.NewFromConfig(cfg, func(o *servicediscovery.Options) {
+			o.BaseEndpoint = endpoint("servicediscovery")
+		}),
+		batchPollInterval:   awsBatchPollInterval,
+		servicePollInterval: awsServicePollInterval,
+		console:             options.Console,
+	}, nil
+}
 
-	client := sts.NewFromConfig(cfg)
+// GetAccountID obtiene el Account ID de AWS usando las credenciales proporcionadas
+func (s *AWSSession) GetAccountID(ctx context.Context) (string, error) {
+	client := s.sts
 
-	result, err := client.GetCallerIdentity(context.TODO(), &sts.GetCallerIdentityInput{})
+	result, err := client.GetCallerIdentity(ctx, &sts.GetCallerIdentityInput{})
 	if err != nil {
 		return "", fmt.Errorf("error al obtener identity del caller: %w", err)
 	}
@@ -57,13 +180,12 @@ func GetAccountID(creds *AWSCredentials) (string, error) {
 
 	return *result.Account, nil
 }
-func PutParameter(creds *AWSCredentials, path, value string) error {
-	cfg, err := creds.getAWSConfig(context.TODO())
-	if err != nil {
-		return fmt.Errorf("error al cargar configuración de AWS: %w", err)
-	}
 
-	client := ssm.NewFromConfig(cfg)
+// PutParameter escribe un parámetro String del Parameter Store. Solo recibe
+// nombres, ARN y configuración de red; los valores sensibles se guardan
+// cifrados en la tabla de parámetros
+func (s *AWSSession) PutParameter(ctx context.Context, path, value string) error {
+	client := s.ssm
 
 	input := &ssm.PutParameterInput{
 		Name:      aws.String(path),
@@ -73,7 +195,7 @@ func PutParameter(creds *AWSCredentials, path, value string) error {
 		Overwrite: aws.Bool(true),              // Permitir sobrescribir si ya existe
 	}
 
-	_, err = client.PutParameter(context.TODO(), input)
+	_, err := client.PutParameter(ctx, input)
 	if err != nil {
 		return fmt.Errorf("error al insertar parámetro '%s': %w", path, err)
 	}
@@ -82,20 +204,15 @@ func PutParameter(creds *AWSCredentials, path, value string) error {
 }
 
 // GetParameter obtiene el valor de un parámetro del Parameter Store por su path
-func GetParameter(creds *AWSCredentials, path string) (string, error) {
-	cfg, err := creds.getAWSConfig(context.TODO())
-	if err != nil {
-		return "", fmt.Errorf("error al cargar configuración de AWS: %w", err)
-	}
-
-	client := ssm.NewFromConfig(cfg)
+func (s *AWSSession) GetParameter(ctx context.Context, path string) (string, error) {
+	client := s.ssm
 
 	input := &ssm.GetParameterInput{
 		Name:           aws.String(path),
 		WithDecryption: aws.Bool(true), // Permitir desencriptar parámetros SecureString
 	}
 
-	result, err := client.GetParameter(context.TODO(), input)
+	result, err := client.GetParameter(ctx, input)
 	if err != nil {
 		return "", fmt.Errorf("error al obtener parámetro '%s': %w", path, err)
 	}
@@ -107,56 +224,104 @@ func GetParameter(creds *AWSCredentials, path string) (string, error) {
 	return *result.Parameter.Value, nil
 }
 
-func CreateSecret(creds *AWSCredentials, name, secretValue string) (string, error) {
-	cfg, err := creds.getAWSConfig(context.TODO())
+// GetSecret returns the value of a Secrets Manager secret
+func (s *AWSSession) GetSecret(ctx context.Context, name string) (string, error) {
+	output, err := s.secretsManager.GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{
+		SecretId: aws.String(name),
+	})
 	if err != nil {
-		return "", fmt.Errorf("error al cargar configuración de AWS: %w", err)
+		return "", fmt.Errorf("error al obtener secreto '%s': %w", name, err)
 	}
+	if output.SecretString == nil {
+		return "", fmt.Errorf("secreto '%s' no tiene valor", name)
+	}
+	return *output.SecretString, nil
+}
 
-	client := secretsmanager.NewFromConfig(cfg)
-
-	// Intentar obtener el secreto para ver si existe
-	_, err = client.GetSecretValue(context.TODO(), &secretsmanager.GetSecretValueInput{
+// EnsureSecret crea el secreto con value si no existe, sin sobrescribir uno
+// existente. Retorna su ARN y su valor actual
+func (s *AWSSession) EnsureSecret(ctx context.Context, name, value string) (string, string, error) {
+	output, err := s.secretsManager.GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{
 		SecretId: aws.String(name),
 	})
-
 	var notFound *secretsmanagertypes.ResourceNotFoundException
 	if errors.As(err, &notFound) {
-		// El secreto no existe, crearlo
-		output, err := client.CreateSecret(context.TODO(), &secretsmanager.CreateSecretInput{
+		created, err := s.secretsManager.CreateSecret(ctx, &secretsmanager.CreateSecretInput{
 			Name:         aws.String(name),
-			SecretString: aws.String(secretValue),
+			SecretString: aws.String(value),
 			Description:  aws.String(fmt.Sprintf("Secreto creado para %s", name)),
 		})
 		if err != nil {
-			return "", fmt.Errorf("error al crear secreto '%s': %w", name, err)
+			return "", "", fmt.Errorf("error al crear secreto '%s': %w", name, err)
 		}
-		return *output.ARN, nil
+		return *created.ARN, value, nil
 	} else if err != nil {
-		// Otro tipo de error
-		return "", fmt.Errorf("error al verificar secreto '%s': %w", name, err)
-	} else {
-		// El secreto existe, actualizarlo
-		output, err := client.UpdateSecret(context.TODO(), &secretsmanager.UpdateSecretInput{
-			SecretId:     aws.String(name),
-			SecretString: aws.String(secretValue),
-		})
-		if err != nil {
-			return "", fmt.Errorf("error al actualizar secreto '%s': %w", name, err)
-		}
-		return *output.ARN, nil
+		return "", "", fmt.Errorf("error al verificar secreto '%s': %w", name, err)
+	}
+	if output.SecretString == nil {
+		return "", "", fmt.Errorf("secreto '%s' no tiene valor", name)
 	}
+	return *output.ARN, *output.SecretString, nil
 }
 
-// SubmitBatchJob envía un job de AWS Batch con variables de ambiente personalizadas y espera a que termine
-func SubmitBatchJob(creds *AWSCredentials, jobName, jobQueue, jobDefinition string, envVars map[string]string) error {
-	ctx := context.TODO()
-	cfg, err := creds.getAWSConfig(ctx)
+// Etapas de las versiones de un secreto de Secrets Manager
+const (
+	secretStageCurrent = "AWSCURRENT"
+	secretStagePending = "AWSPENDING"
+)
+
+// StageSecret guarda value como versión AWSPENDING del secreto, sin cambiar la
+// versión AWSCURRENT que leen los servicios, y retorna el ID de la versión
+func (s *AWSSession) StageSecret(ctx context.Context, name, value string) (string, error) {
+	output, err := s.secretsManager.PutSecretValue(ctx, &secretsmanager.PutSecretValueInput{
+		SecretId:           aws.String(name),
+		SecretString:       aws.String(value),
+		ClientRequestToken: aws.String(uuid.New().String()),
+		VersionStages:      []string{secretStagePending},
+	})
 	if err != nil {
-		return fmt.Errorf("error al cargar configuración de AWS: %w", err)
+		return "", fmt.Errorf("error al preparar nueva versión del secreto '%s': %w", name, err)
 	}
+	return *output.VersionId, nil
+}
 
-	client := batch.NewFromConfig(cfg)
+// PromoteSecret mueve AWSCURRENT a la versión versionID en una sola operación.
+// La versión anterior queda como AWSPREVIOUS
+func (s *AWSSession) PromoteSecret(ctx context.Context, name, versionID string) error {
+	current, err := s.secretsManager.GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{
+		SecretId: aws.String(name),
+	})
+	if err != nil {
+		return fmt.Errorf("error al obtener secreto '%s': %w", name, err)
+	}
+	_, err = s.secretsManager.UpdateSecretVersionStage(ctx, &secretsmanager.UpdateSecretVersionStageInput{
+		SecretId:            aws.String(name),
+		VersionStage:        aws.String(secretStageCurrent),
+		MoveToVersionId:     aws.String(versionID),
+		RemoveFromVersionId: current.VersionId,
+	})
+	if err != nil {
+		return fmt.Errorf("error al activar nueva versión del secreto '%s': %w", name, err)
+	}
+	return nil
+}
+
+// UnstageSecret quita la etapa AWSPENDING de la versión versionID
+func (s *AWSSession) UnstageSecret(ctx context.Context, name, versionID string) error {
+	_, err := s.secretsManager.UpdateSecretVersionStage(ctx, &secretsmanager.UpdateSecretVersionStageInput{
+		SecretId:            aws.String(name),
+		VersionStage:        aws.String(secretStagePending),
+		RemoveFromVersionId: aws.String(versionID),
+	})
+	if err != nil {
+		return fmt.Errorf("error al descartar versión pendiente del secreto '%s': %w", name, err)
+	}
+	return nil
+}
+
+// SubmitBatchJob envía un job de AWS Batch con variables de ambiente personalizadas y espera a que termine
+func (s *AWSSession) SubmitBatchJob(ctx context.Context, jobName, jobQueue, jobDefinition string, envVars map[string]string) error {
+	client := s.batch
 
 	// Convertir el mapa de variables de entorno al formato requerido por AWS Batch
 	var environment []batchtypes.KeyValuePair
@@ -188,10 +353,10 @@ func SubmitBatchJob(creds *AWSCredentials, jobName, jobQueue, jobDefinition stri
 	}
 
 	jobID := *result.JobId
-	printInfo(fmt.Sprintf("Job de Batch enviado con ID: %s", jobID))
+	s.console.printInfo(fmt.Sprintf("Job de Batch enviado con ID: %s", jobID))
 
 	// Monitorear el estado del trabajo hasta que termine
-	ticker := time.NewTicker(10 * time.Second)
+	ticker := time.NewTicker(s.batchPollInterval)
 	defer ticker.Stop()
 
 	for {
@@ -214,12 +379,12 @@ func SubmitBatchJob(creds *AWSCredentials, jobName, jobQueue, jobDefinition stri
 			}
 
 			job := describeOutput.Jobs[0]
-			printInfo(fmt.Sprintf("Current job status (%s): %s", jobID, job.Status))
+			s.console.printInfo(fmt.Sprintf("Current job status (%s): %s", jobID, job.Status))
 
 			// Verificar si el job ha terminado
 			switch job.Status {
 			case batchtypes.JobStatusSucceeded:
-				printInfo(fmt.Sprintf("Job '%s' completed successfully", jobID))
+				s.console.printInfo(fmt.Sprintf("Job '%s' completed successfully", jobID))
 				return nil
 			case batchtypes.JobStatusFailed:
 				reason := "unknown reason"
@@ -235,13 +400,8 @@ func SubmitBatchJob(creds *AWSCredentials, jobName, jobQueue, jobDefinition stri
 }
 
 // PutRecord insert a record in a DynamoDB table
-func PutRecord(creds *AWSCredentials, tableName string, item map[string]interface{}) error {
-	cfg, err := creds.getAWSConfig(context.TODO())
-	if err != nil {
-		return fmt.Errorf("error loading AWS configuration: %w", err)
-	}
-
-	client := dynamodb.NewFromConfig(cfg)
+func (s *AWSSession) PutRecord(ctx context.Context, tableName string, item map[string]interface{}) error {
+	client := s.dynamodb
 
 	// Convert the map to DynamoDB attributes
 	dynamoItem, err := attributevalue.MarshalMap(item)
@@ -256,10 +416,342 @@ func PutRecord(creds *AWSCredentials, tableName string, item map[string]interfac
 	}
 
 	// Execute the PutItem operation
-	_, err = client.PutItem(context.TODO(), input)
+	_, err = client.PutItem(ctx, input)
+	if err != nil {
+		return fmt.Errorf("error inserting item in table '%s': %w", tableName, err)
+	}
+
+	return nil
+}
+
+// ErrRecordExists is returned by PutNewRecord when the table already holds an
+// item with the same key.
+var ErrRecordExists = errors.New("record already exists")
+
+// PutNewRecord inserts a record in a DynamoDB table only if no record with the
+// same keyAttribute exists, so a rerun never overwrites it.
+func (s *AWSSession) PutNewRecord(ctx context.Context, tableName, keyAttribute string, item map[string]interface{}) error {
+	dynamoItem, err := attributevalue.MarshalMap(item)
+	if err != nil {
+		return fmt.Errorf("error converting item to DynamoDB attributes: %w", err)
+	}
+
+	_, err = s.dynamodb.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:                aws.String(tableName),
+		Item:                     dynamoItem,
+		ConditionExpression:      aws.String("attribute_not_exists(#key)"),
+		ExpressionAttributeNames: map[string]string{"#key": keyAttribute},
+	})
+	var conditionFailed *dynamodbtypes.ConditionalCheckFailedException
+	if errors.As(err, &conditionFailed) {
+		return fmt.Errorf("item with %s %v in table '%s': %w", keyAttribute, item[keyAttribute], tableName, ErrRecordExists)
+	}
 	if err != nil {
 		return fmt.Errorf("error inserting item in table '%s': %w", tableName, err)
 	}
 
 	return nil
 }
+
+// FindRecords returns the records of a DynamoDB table whose attribute equals
+// value. It scans the whole table, which is fine for the small user and key
+// tables of Titvo.
+func (s *AWSSession) FindRecords(ctx context.Context, tableName, attribute, value string) ([]map[string]interface{}, error) {
+	paginator := dynamodb.NewScanPaginator(s.dynamodb, &dynamodb.ScanInput{
+		TableName:                aws.String(tableName),
+		FilterExpression:         aws.String("#attribute = :value"),
+		ExpressionAttributeNames: map[string]string{"#attribute": attribute},
+		ExpressionAttributeValues: map[string]dynamodbtypes.AttributeValue{
+			":value": &dynamodbtypes.AttributeValueMemberS{Value: value},
+		},
+	})
+	records := []map[string]interface{}{}
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning table '%s': %w", tableName, err)
+		}
+		var items []map[string]interface{}
+		if err := attributevalue.UnmarshalListOfMaps(page.Items, &items); err != nil {
+			return nil, fmt.Errorf("error converting items of table '%s': %w", tableName, err)
+		}
+		records = append(records, items...)
+	}
+	return records, nil
+}
+
+// ListRecords returns every record of a DynamoDB table.
+func (s *AWSSession) ListRecords(ctx context.Context, tableName string) ([]map[string]interface{}, error) {
+	paginator := dynamodb.NewScanPaginator(s.dynamodb, &dynamodb.ScanInput{
+		TableName: aws.String(tableName),
+	})
+	records := []map[string]interface{}{}
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning table '%s': %w", tableName, err)
+		}
+		var items []map[string]interface{}
+		if err := attributevalue.UnmarshalListOfMaps(page.Items, &items); err != nil {
+			return nil, fmt.Errorf("error converting items of table '%s': %w", tableName, err)
+		}
+		records = append(records, items...)
+	}
+	return records, nil
+}
+
+// DeleteRecord deletes the record of a DynamoDB table whose string key
+// keyAttribute is keyValue.
+func (s *AWSSession) DeleteRecord(ctx context.Context, tableName, keyAttribute, keyValue string) error {
+	_, err := s.dynamodb.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]dynamodbtypes.AttributeValue{
+			keyAttribute: &dynamodbtypes.AttributeValueMemberS{Value: keyValue},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("error deleting item %s %s from table '%s': %w", keyAttribute, keyValue, tableName, err)
+	}
+	return nil
+}
+
+// EnsureKey retorna el ARN de la llave KMS keyID, o de la llave del alias
+// cuando keyID es vacío, creándola con rotación automática si el alias no
+// existe. La llave debe ser simétrica, estar habilitada y ser administrada por
+// el cliente. Los tags se aplican también a una llave existente
+func (s *AWSSession) EnsureKey(ctx context.Context, keyID, alias string, tags map[string]string) (string, error) {
+	client := s.kms
+	kmsTags := make([]kmstypes.Tag, 0, len(tags))
+	for key, value := range tags {
+		kmsTags = append(kmsTags, kmstypes.Tag{TagKey: aws.String(key), TagValue: aws.String(value)})
+	}
+	lookup := keyID
+	if lookup == "" {
+		lookup = alias
+	}
+	described, err := client.DescribeKey(ctx, &kms.DescribeKeyInput{KeyId: aws.String(lookup)})
+	var notFound *kmstypes.NotFoundException
+	if keyID == "" && errors.As(err, &notFound) {
+		created, err := client.CreateKey(ctx, &kms.CreateKeyInput{
+			Description: aws.String(fmt.Sprintf("Llave creada para %s", alias)),
+			KeySpec:     kmstypes.KeySpecSymmetricDefault,
+			KeyUsage:    kmstypes.KeyUsageTypeEncryptDecrypt,
+			Tags:        kmsTags,
+		})
+		if err != nil {
+			return "", fmt.Errorf("error al crear llave KMS: %w", err)
+		}
+		keyARN := *created.KeyMetadata.Arn
+		if _, err := client.CreateAlias(ctx, &kms.CreateAliasInput{AliasName: aws.String(alias), TargetKeyId: aws.String(keyARN)}); err != nil {
+			return "", fmt.Errorf("error al crear alias '%s' de la llave KMS: %w", alias, err)
+		}
+		if _, err := client.EnableKeyRotation(ctx, &kms.EnableKeyRotationInput{KeyId: aws.String(keyARN)}); err != nil {
+			return "", fmt.Errorf("error al habilitar rotación de la llave KMS: %w", err)
+		}
+		return keyARN, nil
+	} else if err != nil {
+		return "", fmt.Errorf("error al obtener llave KMS '%s': %w", lookup, err)
+	}
+	metadata := described.KeyMetadata
+	switch {
+	case metadata.KeyManager != kmstypes.KeyManagerTypeCustomer:
+		return "", fmt.Errorf("la llave KMS '%s' no es administrada por el cliente", lookup)
+	case !metadata.Enabled:
+		return "", fmt.Errorf("la llave KMS '%s' no está habilitada", lookup)
+	case metadata.KeySpec != kmstypes.KeySpecSymmetricDefault:
+		return "", fmt.Errorf("la llave KMS '%s' no es simétrica", lookup)
+	}
+	_, err = client.TagResource(ctx, &kms.TagResourceInput{KeyId: metadata.Arn, Tags: kmsTags})
+	if err != nil {
+		return "", fmt.Errorf("error al etiquetar llave KMS '%s': %w", lookup, err)
+	}
+	return *metadata.Arn, nil
+}
+
+// GrantKey otorga a roleARN el uso de la llave KMS para cifrar y descifrar.
+// Retorna false si el rol ya tenía un grant del instalador
+func (s *AWSSession) GrantKey(ctx context.Context, keyARN, grantName, roleARN string) (bool, error) {
+	client := s.kms
+	paginator := kms.NewListGrantsPaginator(client, &kms.ListGrantsInput{KeyId: aws.String(keyARN)})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return false, fmt.Errorf("error al listar grants de la llave KMS: %w", err)
+		}
+		for _, grant := range page.Grants {
+			if aws.ToString(grant.Name) == grantName && aws.ToString(grant.GranteePrincipal) == roleARN {
+				return false, nil
+			}
+		}
+	}
+	_, err := client.CreateGrant(ctx, &kms.CreateGrantInput{
+		KeyId:            aws.String(keyARN),
+		GranteePrincipal: aws.String(roleARN),
+		Name:             aws.String(grantName),
+		Operations: []kmstypes.GrantOperation{
+			kmstypes.GrantOperationDecrypt,
+			kmstypes.GrantOperationEncrypt,
+			kmstypes.GrantOperationDescribeKey,
+		},
+	})
+	if err != nil {
+		return false, fmt.Errorf("error al otorgar la llave KMS a '%s': %w", roleARN, err)
+	}
+	return true, nil
+}
+
+// EncryptWithKey cifra plaintext con la llave KMS keyARN
+func (s *AWSSession) EncryptWithKey(ctx context.Context, keyARN string, plaintext []byte) ([]byte, error) {
+	output, err := s.kms.Encrypt(ctx, &kms.EncryptInput{KeyId: aws.String(keyARN), Plaintext: plaintext})
+	if err != nil {
+		return nil, fmt.Errorf("error al cifrar con la llave KMS: %w", err)
+	}
+	return output.CiphertextBlob, nil
+}
+
+// PutRolePolicy crea o reemplaza la política inline policyName del rol roleARN
+func (s *AWSSession) PutRolePolicy(ctx context.Context, roleARN, policyName, document string) error {
+	roleName := roleARN[strings.LastIndex(roleARN, "/")+1:]
+	_, err := s.iam.PutRolePolicy(ctx, &iam.PutRolePolicyInput{
+		RoleName:       aws.String(roleName),
+		PolicyName:     aws.String(policyName),
+		PolicyDocument: aws.String(document),
+	})
+	if err != nil {
+		return fmt.Errorf("error al escribir la política '%s' del rol '%s': %w", policyName, roleName, err)
+	}
+	return nil
+}
+
+// JobRoleARN retorna el rol IAM de los jobs de la última revisión activa de la
+// definición jobDefinition
+func (s *AWSSession) JobRoleARN(ctx context.Context, jobDefinition string) (string, error) {
+	output, err := s.batch.DescribeJobDefinitions(ctx, &batch.DescribeJobDefinitionsInput{
+		JobDefinitionName: aws.String(jobDefinition),
+		Status:            aws.String("ACTIVE"),
+	})
+	if err != nil {
+		return "", fmt.Errorf("error al describir la definición de job '%s': %w", jobDefinition, err)
+	}
+	var latest *batchtypes.JobDefinition
+	for i, definition := range output.JobDefinitions {
+		if latest == nil || aws.ToInt32(definition.Revision) > aws.ToInt32(latest.Revision) {
+			latest = &output.JobDefinitions[i]
+		}
+	}
+	if latest == nil {
+		return "", fmt.Errorf("la definición de job '%s' no existe", jobDefinition)
+	}
+	if latest.ContainerProperties == nil {
+		return "", nil
+	}
+	return aws.ToString(latest.ContainerProperties.JobRoleArn), nil
+}
+
+// ListBedrockModels retorna los modelos de texto con inferencia on-demand y los
+// perfiles de inferencia de Bedrock en region
+func (s *AWSSession) ListBedrockModels(ctx context.Context, region string) ([]string, error) {
+	inRegion := func(o *bedrock.Options) {
+		if region != "" {
+			o.Region = region
+		}
+	}
+	output, err := s.bedrock.ListFoundationModels(ctx, &bedrock.ListFoundationModelsInput{
+		ByOutputModality: bedrocktypes.ModelModalityText,
+		ByInferenceType:  bedrocktypes.InferenceTypeOnDemand,
+	}, inRegion)
+	if err != nil {
+		return nil, fmt.Errorf("error al listar los modelos de Bedrock: %w", err)
+	}
+	models := []string{}
+	for _, model := range output.ModelSummaries {
+		models = append(models, aws.ToString(model.ModelId))
+	}
+	paginator := bedrock.NewListInferenceProfilesPaginator(s.bedrock, &bedrock.ListInferenceProfilesInput{})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx, inRegion)
+		if err != nil {
+			return nil, fmt.Errorf("error al listar los perfiles de inferencia de Bedrock: %w", err)
+		}
+		for _, profile := range page.InferenceProfileSummaries {
+			models = append(models, aws.ToString(profile.InferenceProfileId))
+		}
+	}
+	return models, nil
+}
+
+// ResolveService retorna las direcciones de las instancias del servicio
+// service en el namespace de Cloud Map namespace, esperando a que se registre
+// la primera
+func (s *AWSSession) ResolveService(ctx context.Context, namespace, service string) ([]string, error) {
+	var namespaceID string
+	namespaces := servicediscovery.NewListNamespacesPaginator(s.cloudMap, &servicediscovery.ListNamespacesInput{})
+	for namespaceID == "" && namespaces.HasMorePages() {
+		page, err := namespaces.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("error al listar los namespaces de Cloud Map: %w", err)
+		}
+		for _, summary := range page.Namespaces {
+			if aws.ToString(summary.Name) == namespace {
+				namespaceID = aws.ToString(summary.Id)
+				break
+			}
+		}
+	}
+	if namespaceID == "" {
+		return nil, fmt.Errorf("el namespace de Cloud Map '%s' no existe", namespace)
+	}
+	var serviceID string
+	services := servicediscovery.NewListServicesPaginator(s.cloudMap, &servicediscovery.ListServicesInput{
+		Filters: []servicediscoverytypes.ServiceFilter{{
+			Name:      servicediscoverytypes.ServiceFilterNameNamespaceId,
+			Values:    []string{namespaceID},
+			Condition: servicediscoverytypes.FilterConditionEq,
+		}},
+	})
+	for serviceID == "" && services.HasMorePages() {
+		page, err := services.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("error al listar los servicios del namespace '%s': %w", namespace, err)
+		}
+		for _, summary := range page.Services {
+			if aws.ToString(summary.Name) == service {
+				serviceID = aws.ToString(summary.Id)
+				break
+			}
+		}
+	}
+	if serviceID == "" {
+		return nil, fmt.Errorf("el servicio '%s' no existe en el namespace de Cloud Map '%s'", service, namespace)
+	}
+
+	// Las tareas de ECS registran sus instancias cuando arrancan
+	ctx, cancel := context.WithTimeout(ctx, awsServiceResolveTimeout)
+	defer cancel()
+	for {
+		instances := []string{}
+		paginator := servicediscovery.NewListInstancesPaginator(s.cloudMap, &servicediscovery.ListInstancesInput{ServiceId: aws.String(serviceID)})
+		for paginator.HasMorePages() {
+			page, err := paginator.NextPage(ctx)
+			if err != nil {
+				return nil, fmt.Errorf("error al listar las instancias del servicio '%s': %w", service, err)
+			}
+			for _, instance := range page.Instances {
+				address := instance.Attributes["AWS_INSTANCE_IPV4"]
+				if port, ok := instance.Attributes["AWS_INSTANCE_PORT"]; ok {
+					address += ":" + port
+				}
+				instances = append(instances, address)
+			}
+		}
+		if len(instances) > 0 {
+			return instances, nil
+		}
+		s.console.printInfo(fmt.Sprintf("Esperando instancias del servicio '%s' en Cloud Map", service))
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("el servicio '%s' no registró instancias: %w", service, ctx.Err())
+		case <-time.After(s.servicePollInterval):
+		}
+	}
+}
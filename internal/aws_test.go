@@ -0,0 +1,149 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func testAWSSession(t *testing.T, endpoints map[string]string) *AWSSession {
+	t.Helper()
+	session, err := NewAWSSession(context.Background(), &AWSCredentials{
+		AWSAccessKeyID:     "AKIDTEST",
+		AWSSecretAccessKey: "secret-test",
+		AWSRegion:          "us-east-1",
+	}, AWSSessionOptions{Endpoints: endpoints, MaxAttempts: 3, MaxBackoff: time.Millisecond})
+	if err != nil {
+		t.Fatalf("NewAWSSession returned error: %v", err)
+	}
+	return session
+}
+
+func TestAWSSessionUsesEndpointOverrideAndUserAgent(t *testing.T) {
+	t.Parallel()
+	var target, userAgent string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		target = r.Header.Get("X-Amz-Target")
+		userAgent = r.Header.Get("User-Agent")
+		w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+		json.NewEncoder(w).Encode(map[string]any{
+			"Parameter": map[string]any{"Name": "/tvo/security-scan/prod/infra/vpc-id", "Value": "vpc-123"},
+		})
+	}))
+	defer server.Close()
+
+	session := testAWSSession(t, map[string]string{"ssm": server.URL})
+	value, err := session.GetParameter(context.Background(), "/tvo/security-scan/prod/infra/vpc-id")
+	if err != nil {
+		t.Fatalf("GetParameter returned error: %v", err)
+	}
+	if value != "vpc-123" {
+		t.Fatalf("expected vpc-123, got %q", value)
+	}
+	if target != "AmazonSSM.GetParameter" {
+		t.Fatalf("expected GetParameter request, got %q", target)
+	}
+	if !strings.Contains(userAgent, "app/titvo-installer_"+Version) {
+		t.Fatalf("expected user agent to contain the installer app ID, got %q", userAgent)
+	}
+}
+
+func TestAWSSessionDefaultEndpointOverride(t *testing.T) {
+	t.Parallel()
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Header().Set("Content-Type", "application/x-amz-json-1.0")
+		w.Write([]byte("{}"))
+	}))
+	defer server.Close()
+
+	session := testAWSSession(t, map[string]string{awsAllServices: server.URL})
+	err := session.PutRecord(context.Background(), "tvo-user-prod", map[string]interface{}{"user_id": "user-1"})
+	if err != nil {
+		t.Fatalf("PutRecord returned error: %v", err)
+	}
+	if requests.Load() != 1 {
+		t.Fatalf("expected 1 request to the default endpoint, got %d", requests.Load())
+	}
+}
+
+func TestAWSSessionRetriesServerErrors(t *testing.T) {
+	t.Parallel()
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/x-amz-json-1.0")
+		if requests.Add(1) < 3 {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(`{"__type":"com.amazonaws.dynamodb.v20120810#InternalServerError","message":"try again"}`))
+			return
+		}
+		w.Write([]byte("{}"))
+	}))
+	defer server.Close()
+
+	session := testAWSSession(t, map[string]string{"dynamodb": server.URL})
+	err := session.PutRecord(context.Background(), "tvo-user-prod", map[string]interface{}{"user_id": "user-1"})
+	if err != nil {
+		t.Fatalf("PutRecord returned error: %v", err)
+	}
+	if requests.Load() != 3 {
+		t.Fatalf("expected 3 attempts, got %d", requests.Load())
+	}
+}
+
+func TestAWSSessionPutNewRecordReportsExistingRecord(t *testing.T) {
+	t.Parallel()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/x-amz-json-1.0")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"__type":"com.amazonaws.dynamodb.v20120810#ConditionalCheckFailedException","message":"The conditional request failed"}`))
+	}))
+	defer server.Close()
+
+	session := testAWSSession(t, map[string]string{"dynamodb": server.URL})
+	err := session.PutNewRecord(context.Background(), "tvo-user-prod", "user_id", map[string]interface{}{"user_id": "user-1"})
+	if !errors.Is(err, ErrRecordExists) {
+		t.Fatalf("expected ErrRecordExists, got %v", err)
+	}
+}
+
+func TestAWSSessionStagesAndPromotesSecret(t *testing.T) {
+	t.Parallel()
+	emulator := newAWSEmulator(t)
+	session := testAWSSession(t, map[string]string{awsAllServices: emulator.URL})
+	ctx := context.Background()
+	if _, _, err := session.EnsureSecret(ctx, aesSecretName, "old"); err != nil {
+		t.Fatal(err)
+	}
+
+	versionID, err := session.StageSecret(ctx, aesSecretName, "new")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if value, _ := emulator.Secret(aesSecretName); value != "old" {
+		t.Fatalf("expected a staged version to leave the current value, got %q", value)
+	}
+	if pending, ok := emulator.PendingSecret(aesSecretName); !ok || pending.VersionID != versionID || pending.Value != "new" {
+		t.Fatalf("expected the pending version %s, got %+v", versionID, pending)
+	}
+
+	if err := session.PromoteSecret(ctx, aesSecretName, versionID); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if value, err := session.GetSecret(ctx, aesSecretName); err != nil || value != "new" {
+		t.Fatalf("expected the promoted value, got %q: %v", value, err)
+	}
+	if err := session.UnstageSecret(ctx, aesSecretName, versionID); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if _, ok := emulator.PendingSecret(aesSecretName); ok {
+		t.Fatal("expected no pending version after unstaging")
+	}
+}
@@ -0,0 +1,639 @@
+package internal
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+const emulatorAccountID = "123456789012"
+
+// awsEmulator is an in-memory stand-in for the AWS APIs used by the installer
+// (SSM, Secrets Manager, DynamoDB, STS, Batch, KMS, IAM, Bedrock and Cloud Map), in the spirit of moto or
+// LocalStack. Point an AWSSession at it with the "*" endpoint override.
+type awsEmulator struct {
+	*httptest.Server
+
+	mu         sync.Mutex
+	parameters map[string]string
+	secrets    map[string]string
+	// secretVersions holds the AWSCURRENT version ID of each secret and
+	// pendingSecrets its AWSPENDING version, if any.
+	secretVersions map[string]string
+	pendingSecrets map[string]emulatedSecretVersion
+	// tables holds the items written to each DynamoDB table, with the string
+	// and number attributes flattened to strings.
+	tables map[string][]map[string]string
+	jobs   []emulatedBatchJob
+	// kmsKeys are keyed by key ID and kmsAliases map an alias to a key ID.
+	kmsKeys    map[string]*emulatedKMSKey
+	kmsAliases map[string]string
+	// rolePolicies holds the inline policies of each role by policy name.
+	rolePolicies map[string]map[string]string
+	// jobRoles maps a Batch job definition name to the ARN of its job role.
+	jobRoles map[string]string
+	// bedrockModels and inferenceProfiles are the IDs Bedrock lists.
+	bedrockModels     []string
+	inferenceProfiles []string
+	// cloudMap holds the instance IPs of each Cloud Map service, by
+	// namespace and service name.
+	cloudMap map[string]map[string][]string
+}
+
+type emulatedKMSKey struct {
+	ID              string
+	ARN             string
+	Tags            map[string]string
+	RotationEnabled bool
+	// Grants holds the grantee principal of each grant.
+	Grants []string
+}
+
+type emulatedBatchJob struct {
+	ID            string
+	Name          string
+	Queue         string
+	Definition    string
+	Environment   map[string]string
+	DescribeCalls int
+}
+
+type emulatedSecretVersion struct {
+	VersionID string
+	Value     string
+}
+
+type awsError struct {
+	status  int
+	kind    string
+	message string
+}
+
+func newAWSEmulator(t *testing.T) *awsEmulator {
+	t.Helper()
+	e := &awsEmulator{
+		parameters:     map[string]string{},
+		secrets:        map[string]string{},
+		secretVersions: map[string]string{},
+		pendingSecrets: map[string]emulatedSecretVersion{},
+		tables:         map[string][]map[string]string{},
+		kmsKeys:        map[string]*emulatedKMSKey{},
+		kmsAliases:     map[string]string{},
+		rolePolicies:   map[string]map[string]string{},
+		jobRoles:       map[string]string{},
+		cloudMap:       map[string]map[string][]string{},
+	}
+	e.Server = httptest.NewServer(http.HandlerFunc(e.serveHTTP))
+	t.Cleanup(e.Close)
+	return e
+}
+
+// SetParameter stores an SSM parameter, e.g. one a terragrunt module would
+// have written.
+func (e *awsEmulator) SetParameter(path, value string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.parameters[path] = value
+}
+
+func (e *awsEmulator) Parameter(path string) (string, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	value, ok := e.parameters[path]
+	return value, ok
+}
+
+func (e *awsEmulator) Secret(name string) (string, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	value, ok := e.secrets[name]
+	return value, ok
+}
+
+// PendingSecret returns the AWSPENDING version of a secret.
+func (e *awsEmulator) PendingSecret(name string) (emulatedSecretVersion, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	version, ok := e.pendingSecrets[name]
+	return version, ok
+}
+
+// AddJobDefinition registers a Batch job definition whose jobs run with the
+// role roleName.
+func (e *awsEmulator) AddJobDefinition(name, roleName string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.jobRoles[name] = fmt.Sprintf("arn:aws:iam::%s:role/%s", emulatorAccountID, roleName)
+}
+
+// RolePolicy returns the document of an inline policy of a role.
+func (e *awsEmulator) RolePolicy(roleName, policyName string) (string, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	document, ok := e.rolePolicies[roleName][policyName]
+	return document, ok
+}
+
+// AddBedrockModels makes Bedrock list the given foundation models and
+// inference profiles.
+func (e *awsEmulator) AddBedrockModels(models, inferenceProfiles []string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.bedrockModels = append(e.bedrockModels, models...)
+	e.inferenceProfiles = append(e.inferenceProfiles, inferenceProfiles...)
+}
+
+// AddCloudMapInstance registers an instance of a Cloud Map service, e.g. an
+// ECS task of a service a terragrunt module would have created.
+func (e *awsEmulator) AddCloudMapInstance(namespace, service, ip string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.cloudMap[namespace] == nil {
+		e.cloudMap[namespace] = map[string][]string{}
+	}
+	e.cloudMap[namespace][service] = append(e.cloudMap[namespace][service], ip)
+}
+
+// KMSKey returns a copy of the KMS key of an alias.
+func (e *awsEmulator) KMSKey(alias string) (emulatedKMSKey, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	key, ok := e.kmsKeys[e.kmsAliases[alias]]
+	if !ok {
+		return emulatedKMSKey{}, false
+	}
+	return *key, true
+}
+
+func (e *awsEmulator) Items(table string) []map[string]string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]map[string]string(nil), e.tables[table]...)
+}
+
+func (e *awsEmulator) Jobs() []emulatedBatchJob {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]emulatedBatchJob(nil), e.jobs...)
+}
+
+func (e *awsEmulator) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	target := r.Header.Get("X-Amz-Target")
+	service, operation, _ := strings.Cut(target, ".")
+	var response any
+	var apiErr *awsError
+	switch {
+	case strings.Contains(string(body), "Action=GetCallerIdentity"):
+		e.getCallerIdentity(w)
+		return
+	case strings.Contains(string(body), "Action=PutRolePolicy"):
+		e.putRolePolicy(w, body)
+		return
+	case r.URL.Path == "/v1/submitjob":
+		response, apiErr = e.submitJob(body)
+	case r.URL.Path == "/v1/describejobs":
+		response, apiErr = e.describeJobs(body)
+	case r.URL.Path == "/v1/describejobdefinitions":
+		response, apiErr = e.describeJobDefinitions(body)
+	case r.Method == http.MethodGet && r.URL.Path == "/foundation-models":
+		response = e.listFoundationModels()
+	case r.Method == http.MethodGet && r.URL.Path == "/inference-profiles":
+		response = e.listInferenceProfiles()
+	case service == "AmazonSSM":
+		response, apiErr = e.ssm(operation, body)
+	case service == "secretsmanager":
+		response, apiErr = e.secretsManager(operation, body)
+	case service == "TrentService":
+		response, apiErr = e.kms(operation, body)
+	case service == "DynamoDB_20120810":
+		response, apiErr = e.dynamoDB(operation, body)
+	case service == "Route53AutoNaming_v20170314":
+		response, apiErr = e.serviceDiscovery(operation, body)
+	default:
+		apiErr = &awsError{http.StatusBadRequest, "UnknownOperationException", fmt.Sprintf("unsupported request %s %s %q", r.Method, r.URL.Path, target)}
+	}
+	w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+	if apiErr != nil {
+		w.WriteHeader(apiErr.status)
+		json.NewEncoder(w).Encode(map[string]string{"__type": apiErr.kind, "message": apiErr.message})
+		return
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+func (e *awsEmulator) getCallerIdentity(w http.ResponseWriter) {
+	type result struct {
+		Account string `xml:"Account"`
+		Arn     string `xml:"Arn"`
+		UserId  string `xml:"UserId"`
+	}
+	type response struct {
+		XMLName xml.Name `xml:"GetCallerIdentityResponse"`
+		Result  result   `xml:"GetCallerIdentityResult"`
+	}
+	w.Header().Set("Content-Type", "text/xml")
+	xml.NewEncoder(w).Encode(response{Result: result{
+		Account: emulatorAccountID,
+		Arn:     fmt.Sprintf("arn:aws:iam::%s:user/installer", emulatorAccountID),
+		UserId:  "AIDAEMULATOR",
+	}})
+}
+
+func (e *awsEmulator) putRolePolicy(w http.ResponseWriter, body []byte) {
+	values, err := url.ParseQuery(string(body))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	roleName := values.Get("RoleName")
+	if e.rolePolicies[roleName] == nil {
+		e.rolePolicies[roleName] = map[string]string{}
+	}
+	e.rolePolicies[roleName][values.Get("PolicyName")] = values.Get("PolicyDocument")
+	type response struct {
+		XMLName xml.Name `xml:"PutRolePolicyResponse"`
+	}
+	w.Header().Set("Content-Type", "text/xml")
+	xml.NewEncoder(w).Encode(response{})
+}
+
+// kms supports the key lifecycle used by the kms encryption format. Encrypt
+// returns "emulated:<key ARN>:<plaintext>" as the ciphertext blob.
+func (e *awsEmulator) kms(operation string, body []byte) (any, *awsError) {
+	var input struct {
+		KeyId            string
+		AliasName        string
+		TargetKeyId      string
+		GranteePrincipal string
+		Plaintext        []byte
+		Tags             []struct{ TagKey, TagValue string }
+	}
+	if err := json.Unmarshal(body, &input); err != nil {
+		return nil, &awsError{http.StatusBadRequest, "ValidationException", err.Error()}
+	}
+	if operation == "CreateKey" {
+		id := uuid.New().String()
+		key := &emulatedKMSKey{ID: id, ARN: fmt.Sprintf("arn:aws:kms:us-east-1:%s:key/%s", emulatorAccountID, id), Tags: map[string]string{}}
+		for _, tag := range input.Tags {
+			key.Tags[tag.TagKey] = tag.TagValue
+		}
+		e.kmsKeys[id] = key
+		return map[string]any{"KeyMetadata": map[string]any{"KeyId": id, "Arn": key.ARN}}, nil
+	}
+	keyID := input.KeyId
+	if operation == "CreateAlias" {
+		keyID = input.TargetKeyId
+	}
+	if aliased, ok := e.kmsAliases[keyID]; ok {
+		keyID = aliased
+	}
+	keyID = strings.TrimPrefix(keyID, fmt.Sprintf("arn:aws:kms:us-east-1:%s:key/", emulatorAccountID))
+	key, exists := e.kmsKeys[keyID]
+	if !exists {
+		return nil, &awsError{http.StatusBadRequest, "NotFoundException", fmt.Sprintf("key %s not found", input.KeyId)}
+	}
+	switch operation {
+	case "DescribeKey":
+		return map[string]any{"KeyMetadata": map[string]any{
+			"KeyId": key.ID, "Arn": key.ARN, "Enabled": true, "KeyManager": "CUSTOMER", "KeySpec": "SYMMETRIC_DEFAULT",
+		}}, nil
+	case "CreateAlias":
+		e.kmsAliases[input.AliasName] = key.ID
+		return map[string]any{}, nil
+	case "EnableKeyRotation":
+		key.RotationEnabled = true
+		return map[string]any{}, nil
+	case "TagResource":
+		for _, tag := range input.Tags {
+			key.Tags[tag.TagKey] = tag.TagValue
+		}
+		return map[string]any{}, nil
+	case "ListGrants":
+		grants := []map[string]string{}
+		for _, grantee := range key.Grants {
+			grants = append(grants, map[string]string{"Name": kmsGrantName, "GranteePrincipal": grantee})
+		}
+		return map[string]any{"Grants": grants, "Truncated": false}, nil
+	case "CreateGrant":
+		key.Grants = append(key.Grants, input.GranteePrincipal)
+		return map[string]any{"GrantId": uuid.New().String()}, nil
+	case "Encrypt":
+		return map[string]any{"KeyId": key.ARN, "CiphertextBlob": []byte("emulated:" + key.ARN + ":" + string(input.Plaintext))}, nil
+	}
+	return nil, &awsError{http.StatusBadRequest, "UnknownOperationException", "unsupported KMS operation " + operation}
+}
+
+func (e *awsEmulator) ssm(operation string, body []byte) (any, *awsError) {
+	var input struct {
+		Name  string
+		Value string
+	}
+	if err := json.Unmarshal(body, &input); err != nil {
+		return nil, &awsError{http.StatusBadRequest, "ValidationException", err.Error()}
+	}
+	switch operation {
+	case "GetParameter":
+		value, ok := e.parameters[input.Name]
+		if !ok {
+			return nil, &awsError{http.StatusBadRequest, "ParameterNotFound", fmt.Sprintf("parameter %s not found", input.Name)}
+		}
+		return map[string]any{"Parameter": map[string]any{"Name": input.Name, "Type": "String", "Value": value}}, nil
+	case "PutParameter":
+		e.parameters[input.Name] = input.Value
+		return map[string]any{"Version": 1, "Tier": "Standard"}, nil
+	}
+	return nil, &awsError{http.StatusBadRequest, "UnknownOperationException", "unsupported SSM operation " + operation}
+}
+
+func (e *awsEmulator) secretsManager(operation string, body []byte) (any, *awsError) {
+	var input struct {
+		Name                string
+		SecretId            string
+		SecretString        string
+		ClientRequestToken  string
+		VersionStages       []string
+		VersionStage        string
+		MoveToVersionId     string
+		RemoveFromVersionId string
+	}
+	if err := json.Unmarshal(body, &input); err != nil {
+		return nil, &awsError{http.StatusBadRequest, "ValidationException", err.Error()}
+	}
+	name := input.SecretId
+	if name == "" {
+		name = input.Name
+	}
+	arn := fmt.Sprintf("arn:aws:secretsmanager:us-east-1:%s:secret:%s", emulatorAccountID, name)
+	value, exists := e.secrets[name]
+	switch operation {
+	case "GetSecretValue":
+		if !exists {
+			return nil, &awsError{http.StatusBadRequest, "ResourceNotFoundException", fmt.Sprintf("secret %s not found", name)}
+		}
+		return map[string]any{"ARN": arn, "Name": name, "SecretString": value, "VersionId": e.secretVersions[name]}, nil
+	case "CreateSecret":
+		if exists {
+			return nil, &awsError{http.StatusBadRequest, "ResourceExistsException", fmt.Sprintf("secret %s already exists", name)}
+		}
+		e.secrets[name] = input.SecretString
+		e.secretVersions[name] = uuid.New().String()
+		return map[string]any{"ARN": arn, "Name": name}, nil
+	case "UpdateSecret":
+		if !exists {
+			return nil, &awsError{http.StatusBadRequest, "ResourceNotFoundException", fmt.Sprintf("secret %s not found", name)}
+		}
+		e.secrets[name] = input.SecretString
+		e.secretVersions[name] = uuid.New().String()
+		return map[string]any{"ARN": arn, "Name": name}, nil
+	case "PutSecretValue":
+		if !exists {
+			return nil, &awsError{http.StatusBadRequest, "ResourceNotFoundException", fmt.Sprintf("secret %s not found", name)}
+		}
+		if len(input.VersionStages) != 1 || input.VersionStages[0] != secretStagePending {
+			return nil, &awsError{http.StatusBadRequest, "ValidationException", "only AWSPENDING versions are supported"}
+		}
+		e.pendingSecrets[name] = emulatedSecretVersion{VersionID: input.ClientRequestToken, Value: input.SecretString}
+		return map[string]any{"ARN": arn, "Name": name, "VersionId": input.ClientRequestToken}, nil
+	case "UpdateSecretVersionStage":
+		pending, staged := e.pendingSecrets[name]
+		switch {
+		case input.VersionStage == secretStageCurrent && staged && input.MoveToVersionId == pending.VersionID && input.RemoveFromVersionId == e.secretVersions[name]:
+			e.secrets[name] = pending.Value
+			e.secretVersions[name] = pending.VersionID
+		case input.VersionStage == secretStagePending && staged && input.RemoveFromVersionId == pending.VersionID:
+			delete(e.pendingSecrets, name)
+		default:
+			return nil, &awsError{http.StatusBadRequest, "InvalidParameterException", fmt.Sprintf("unexpected version stage update of %s", name)}
+		}
+		return map[string]any{"ARN": arn, "Name": name}, nil
+	}
+	return nil, &awsError{http.StatusBadRequest, "UnknownOperationException", "unsupported Secrets Manager operation " + operation}
+}
+
+// dynamoDB supports PutItem, optionally with an attribute_not_exists
+// condition, and Scan with a single equality filter, as written by
+// AWSSession.
+func (e *awsEmulator) dynamoDB(operation string, body []byte) (any, *awsError) {
+	var input struct {
+		TableName                 string
+		Item                      map[string]map[string]any
+		ConditionExpression       string
+		FilterExpression          string
+		ExpressionAttributeNames  map[string]string
+		ExpressionAttributeValues map[string]map[string]any
+	}
+	if err := json.Unmarshal(body, &input); err != nil {
+		return nil, &awsError{http.StatusBadRequest, "ValidationException", err.Error()}
+	}
+	switch operation {
+	case "PutItem":
+		item := map[string]string{}
+		for name, attribute := range input.Item {
+			for _, kind := range []string{"S", "N"} {
+				if value, ok := attribute[kind].(string); ok {
+					item[name] = value
+				}
+			}
+		}
+		if input.ConditionExpression != "" {
+			key := input.ExpressionAttributeNames[strings.TrimSuffix(strings.TrimPrefix(input.ConditionExpression, "attribute_not_exists("), ")")]
+			for _, existing := range e.tables[input.TableName] {
+				if existing[key] == item[key] {
+					return nil, &awsError{http.StatusBadRequest, "ConditionalCheckFailedException", "The conditional request failed"}
+				}
+			}
+		}
+		e.tables[input.TableName] = append(e.tables[input.TableName], item)
+		return map[string]any{}, nil
+	case "Scan":
+		name, placeholder, _ := strings.Cut(input.FilterExpression, " = ")
+		attribute := input.ExpressionAttributeNames[name]
+		value, _ := input.ExpressionAttributeValues[placeholder]["S"].(string)
+		items := []map[string]any{}
+		for _, item := range e.tables[input.TableName] {
+			if item[attribute] != value {
+				continue
+			}
+			attributes := map[string]any{}
+			for name, value := range item {
+				attributes[name] = map[string]string{"S": value}
+			}
+			items = append(items, attributes)
+		}
+		return map[string]any{"Items": items, "Count": len(items), "ScannedCount": len(e.tables[input.TableName])}, nil
+	}
+	return nil, &awsError{http.StatusBadRequest, "UnknownOperationException", "unsupported DynamoDB operation " + operation}
+}
+
+func (e *awsEmulator) submitJob(body []byte) (any, *awsError) {
+	var input struct {
+		JobName            string `json:"jobName"`
+		JobQueue           string `json:"jobQueue"`
+		JobDefinition      string `json:"jobDefinition"`
+		ContainerOverrides struct {
+			Environment []struct {
+				Name  string `json:"name"`
+				Value string `json:"value"`
+			} `json:"environment"`
+		} `json:"containerOverrides"`
+	}
+	if err := json.Unmarshal(body, &input); err != nil {
+		return nil, &awsError{http.StatusBadRequest, "ClientException", err.Error()}
+	}
+	job := emulatedBatchJob{
+		ID:          fmt.Sprintf("job-%d", len(e.jobs)+1),
+		Name:        input.JobName,
+		Queue:       input.JobQueue,
+		Definition:  input.JobDefinition,
+		Environment: map[string]string{},
+	}
+	for _, variable := range input.ContainerOverrides.Environment {
+		job.Environment[variable.Name] = variable.Value
+	}
+	e.jobs = append(e.jobs, job)
+	return map[string]any{"jobId": job.ID, "jobName": job.Name}, nil
+}
+
+// describeJobs reports a job as RUNNING the first time and SUCCEEDED after, so
+// the installer goes through its polling loop.
+func (e *awsEmulator) describeJobs(body []byte) (any, *awsError) {
+	var input struct {
+		Jobs []string `json:"jobs"`
+	}
+	if err := json.Unmarshal(body, &input); err != nil {
+		return nil, &awsError{http.StatusBadRequest, "ClientException", err.Error()}
+	}
+	jobs := []map[string]any{}
+	for _, id := range input.Jobs {
+		for i := range e.jobs {
+			job := &e.jobs[i]
+			if job.ID != id {
+				continue
+			}
+			job.DescribeCalls++
+			status := "SUCCEEDED"
+			if job.DescribeCalls == 1 {
+				status = "RUNNING"
+			}
+			jobs = append(jobs, map[string]any{
+				"jobId":         job.ID,
+				"jobName":       job.Name,
+				"jobQueue":      job.Queue,
+				"jobDefinition": job.Definition,
+				"status":        status,
+				"startedAt":     1,
+			})
+		}
+	}
+	return map[string]any{"jobs": jobs}, nil
+}
+
+func (e *awsEmulator) describeJobDefinitions(body []byte) (any, *awsError) {
+	var input struct {
+		JobDefinitionName string `json:"jobDefinitionName"`
+	}
+	if err := json.Unmarshal(body, &input); err != nil {
+		return nil, &awsError{http.StatusBadRequest, "ClientException", err.Error()}
+	}
+	definitions := []map[string]any{}
+	if roleARN, ok := e.jobRoles[input.JobDefinitionName]; ok {
+		definitions = append(definitions, map[string]any{
+			"jobDefinitionName":   input.JobDefinitionName,
+			"jobDefinitionArn":    fmt.Sprintf("arn:aws:batch:us-east-1:%s:job-definition/%s:1", emulatorAccountID, input.JobDefinitionName),
+			"revision":            1,
+			"status":              "ACTIVE",
+			"type":                "container",
+			"containerProperties": map[string]any{"jobRoleArn": roleARN},
+		})
+	}
+	return map[string]any{"jobDefinitions": definitions}, nil
+}
+
+func (e *awsEmulator) listFoundationModels() any {
+	models := []map[string]any{}
+	for _, id := range e.bedrockModels {
+		models = append(models, map[string]any{
+			"modelId":  id,
+			"modelArn": "arn:aws:bedrock:us-east-1::foundation-model/" + id,
+		})
+	}
+	return map[string]any{"modelSummaries": models}
+}
+
+func (e *awsEmulator) listInferenceProfiles() any {
+	profiles := []map[string]any{}
+	for _, id := range e.inferenceProfiles {
+		profiles = append(profiles, map[string]any{
+			"inferenceProfileId":  id,
+			"inferenceProfileArn": fmt.Sprintf("arn:aws:bedrock:us-east-1:%s:inference-profile/%s", emulatorAccountID, id),
+			"status":              "ACTIVE",
+			"type":                "SYSTEM_DEFINED",
+		})
+	}
+	return map[string]any{"inferenceProfileSummaries": profiles}
+}
+
+// serviceDiscovery lists the Cloud Map namespaces, services and instances.
+// The ID of a namespace is its name, and the ID of a service is
+// "<namespace>/<service>".
+func (e *awsEmulator) serviceDiscovery(operation string, body []byte) (any, *awsError) {
+	var input struct {
+		ServiceId string
+		Filters   []struct {
+			Name   string
+			Values []string
+		}
+	}
+	if err := json.Unmarshal(body, &input); err != nil {
+		return nil, &awsError{http.StatusBadRequest, "InvalidInput", err.Error()}
+	}
+	switch operation {
+	case "ListNamespaces":
+		namespaces := []map[string]string{}
+		for name := range e.cloudMap {
+			namespaces = append(namespaces, map[string]string{"Id": name, "Name": name, "Type": "DNS_PRIVATE"})
+		}
+		return map[string]any{"Namespaces": namespaces}, nil
+	case "ListServices":
+		services := []map[string]string{}
+		for _, filter := range input.Filters {
+			if filter.Name != "NAMESPACE_ID" {
+				continue
+			}
+			for _, namespace := range filter.Values {
+				for name := range e.cloudMap[namespace] {
+					services = append(services, map[string]string{"Id": namespace + "/" + name, "Name": name})
+				}
+			}
+		}
+		return map[string]any{"Services": services}, nil
+	case "ListInstances":
+		namespace, service, _ := strings.Cut(input.ServiceId, "/")
+		instances := []map[string]any{}
+		for i, ip := range e.cloudMap[namespace][service] {
+			instances = append(instances, map[string]any{
+				"Id":         fmt.Sprintf("instance-%d", i+1),
+				"Attributes": map[string]string{"AWS_INSTANCE_IPV4": ip},
+			})
+		}
+		return map[string]any{"Instances": instances}, nil
+	}
+	return nil, &awsError{http.StatusBadRequest, "UnknownOperationException", "unsupported Cloud Map operation " + operation}
+}
@@ -0,0 +1,73 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+const (
+	// agentJobDefinitionParameter names the Batch job definition of the
+	// agent, whose job role invokes the bedrock models.
+	agentJobDefinitionParameter = "/tvo/security-scan/prod/infra/batch/agent/job_definition_name"
+	bedrockPolicyName           = "titvo-bedrock"
+)
+
+// bedrockGranter grants the role of the agent's Batch jobs the use of the
+// Amazon Bedrock models with an inline policy.
+type bedrockGranter struct {
+	Parameters ParameterStore
+	Batch      BatchRunner
+	Accounts   AccountResolver
+	Roles      RolePolicies
+	Console    *Console
+}
+
+// bedrockPolicy allows invoking the foundation models of every region, as the
+// cross-region inference profiles of region route requests to other regions.
+func bedrockPolicy(region, accountID string) (string, error) {
+	document, err := json.Marshal(map[string]any{
+		"Version": "2012-10-17",
+		"Statement": []map[string]any{{
+			"Effect": "Allow",
+			"Action": []string{"bedrock:InvokeModel", "bedrock:InvokeModelWithResponseStream"},
+			"Resource": []string{
+				"arn:aws:bedrock:*::foundation-model/*",
+				fmt.Sprintf("arn:aws:bedrock:%s:%s:inference-profile/*", region, accountID),
+			},
+		}},
+	})
+	return string(document), err
+}
+
+func (g bedrockGranter) grant(ctx context.Context, region string) error {
+	jobDefinition, err := g.Parameters.GetParameter(ctx, agentJobDefinitionParameter)
+	if err != nil {
+		return err
+	}
+	roleARN, err := g.Batch.JobRoleARN(ctx, jobDefinition)
+	if err != nil {
+		return err
+	}
+	if roleARN == "" {
+		return fmt.Errorf("the agent job definition %s has no job role to grant the Bedrock models", jobDefinition)
+	}
+	accountID, err := g.Accounts.GetAccountID(ctx)
+	if err != nil {
+		return err
+	}
+	document, err := bedrockPolicy(region, accountID)
+	if err != nil {
+		return err
+	}
+	if err := g.Roles.PutRolePolicy(ctx, roleARN, bedrockPolicyName, document); err != nil {
+		return err
+	}
+	g.Console.printInfo(fmt.Sprintf("Bedrock models of %s granted to %s", region, roleARN))
+	return nil
+}
+
+// GrantBedrock grants the agent role the use of the Bedrock models of region.
+func (d *Deployer) GrantBedrock(ctx context.Context, region string) error {
+	return bedrockGranter{Parameters: d.Parameters, Batch: d.Batch, Accounts: d.Accounts, Roles: d.Roles, Console: d.Console}.grant(ctx, region)
+}
@@ -0,0 +1,83 @@
+package internal
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// contentTemplatePlaceholders are the placeholders the agent replaces in the
+// content template, with the sample values of the preview. A template
+// without one of them drops that part of the scan request.
+var contentTemplatePlaceholders = []struct {
+	Name   string
+	Sample string
+}{
+	{Name: "repository_url", Sample: "https://github.com/example/repository"},
+	{Name: "commit_hash", Sample: "4f1c2a9e7b3d5f8a0c6e2b4d9f1a3c5e7b9d0f2a"},
+	{Name: "args", Sample: `{"branch": "main"}`},
+}
+
+// placeholderPattern matches a {name} placeholder. JSON braces in the
+// template do not match, as a name is an identifier.
+var placeholderPattern = regexp.MustCompile(`\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// templatePlaceholders returns the placeholders of content in order of first
+// use.
+func templatePlaceholders(content string) []string {
+	names := []string{}
+	for _, match := range placeholderPattern.FindAllStringSubmatch(content, -1) {
+		if !slices.Contains(names, match[1]) {
+			names = append(names, match[1])
+		}
+	}
+	return names
+}
+
+// validateContentTemplate returns an error for a placeholder the agent does
+// not replace, and a warning for each placeholder content does not use.
+func validateContentTemplate(content string) (warnings []string, err error) {
+	used := templatePlaceholders(content)
+	known := []string{}
+	for _, placeholder := range contentTemplatePlaceholders {
+		known = append(known, "{"+placeholder.Name+"}")
+		if !slices.Contains(used, placeholder.Name) {
+			warnings = append(warnings, fmt.Sprintf("the content template does not use {%s}, scans will not receive it", placeholder.Name))
+		}
+	}
+	unknown := []string{}
+	for _, name := range used {
+		if !slices.Contains(known, "{"+name+"}") {
+			unknown = append(unknown, "{"+name+"}")
+		}
+	}
+	if len(unknown) > 0 {
+		return warnings, fmt.Errorf("unknown placeholders %s in the content template, use %s", strings.Join(unknown, ", "), strings.Join(known, ", "))
+	}
+	return warnings, nil
+}
+
+// renderContentTemplate replaces the placeholders of content with their
+// sample values, as the agent does with the values of a scan.
+func renderContentTemplate(content string) string {
+	replacements := []string{}
+	for _, placeholder := range contentTemplatePlaceholders {
+		replacements = append(replacements, "{"+placeholder.Name+"}", placeholder.Sample)
+	}
+	return strings.NewReplacer(replacements...).Replace(content)
+}
+
+// checkContentTemplate validates a content template about to be stored,
+// reporting its warnings and a preview rendered with sample values.
+func checkContentTemplate(console *Console, content string) error {
+	warnings, err := validateContentTemplate(content)
+	if err != nil {
+		return err
+	}
+	for _, warning := range warnings {
+		console.printAskQuestion("Warning: " + warning)
+	}
+	console.printInfo("Preview of the content template with sample values:\n" + renderContentTemplate(content))
+	return nil
+}
@@ -0,0 +1,77 @@
+package internal
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestValidateContentTemplate(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name         string
+		content      string
+		wantWarnings []string
+		wantErr      string
+	}{
+		{name: "default template", content: defaultContentTemplate},
+		{
+			name:    "json braces",
+			content: "Scan {repository_url} at {commit_hash} with {args}, answer {\"issues\": []} or {}",
+		},
+		{
+			name:    "missing placeholders",
+			content: "Scan {repository_url}",
+			wantWarnings: []string{
+				"the content template does not use {commit_hash}, scans will not receive it",
+				"the content template does not use {args}, scans will not receive it",
+			},
+		},
+		{
+			name:    "unknown placeholders",
+			content: "Scan {repo} at {commit_hash} with {args} on {branch} and {repo}",
+			wantErr: "unknown placeholders {repo}, {branch} in the content template, use {repository_url}, {commit_hash}, {args}",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			warnings, err := validateContentTemplate(tt.content)
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || err.Error() != tt.wantErr) {
+				t.Fatalf("expected error %q, got %v", tt.wantErr, err)
+			}
+			if tt.wantErr == "" && !slices.Equal(warnings, tt.wantWarnings) {
+				t.Fatalf("expected warnings %q, got %q", tt.wantWarnings, warnings)
+			}
+		})
+	}
+}
+
+func TestRenderContentTemplate(t *testing.T) {
+	t.Parallel()
+	got := renderContentTemplate("Repository: {repository_url}\nCommit: {commit_hash}\n{args}\n{unknown}")
+	want := "Repository: https://github.com/example/repository\nCommit: 4f1c2a9e7b3d5f8a0c6e2b4d9f1a3c5e7b9d0f2a\n{\"branch\": \"main\"}\n{unknown}"
+	if got != want {
+		t.Fatalf("unexpected preview %q", got)
+	}
+}
+
+func TestPromptManagerSetChecksContentTemplate(t *testing.T) {
+	t.Parallel()
+	records := map[string][]map[string]interface{}{}
+	manager := testPromptManager(records)
+	_, _, err := manager.Set(context.Background(), "content-template", "Scan {repository} at {commit_hash}", "stdin")
+	if err == nil || !strings.Contains(err.Error(), "unknown placeholders {repository}") {
+		t.Fatalf("expected an unknown placeholder error, got %v", err)
+	}
+	if len(records["parameters"]) != 0 {
+		t.Fatalf("expected nothing to be stored, got %v", records["parameters"])
+	}
+	if _, changed, err := manager.Set(context.Background(), "content-template", "Scan {repository_url}", "stdin"); err != nil || !changed {
+		t.Fatalf("expected a template missing placeholders to be stored, got %v, %v", changed, err)
+	}
+}
@@ -0,0 +1,202 @@
+package internal
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Formats of the encrypted values stored in the parameter table. The
+// encryption_format parameter tells the Titvo services which one new values
+// use; decryption always accepts both.
+const (
+	// EncryptionFormatECB is the legacy AES-ECB format: base64 without prefix.
+	EncryptionFormatECB = "v1"
+	// EncryptionFormatGCM is AES-256-GCM with a random nonce, stored as
+	// "v2:" + base64(nonce || ciphertext || tag).
+	EncryptionFormatGCM = "v2"
+	// EncryptionFormatKMS is encrypted with the customer-managed KMS key of
+	// the installation instead of the AES secret, stored as
+	// "kms:" + base64(ciphertext blob).
+	EncryptionFormatKMS = "kms"
+
+	encryptionFormatParameterID = "encryption_format"
+	// aesSecretName is the Secrets Manager secret holding the base64 AES key.
+	aesSecretName = "/tvo/security-scan/prod/aes_secret"
+	gcmPrefix     = EncryptionFormatGCM + ":"
+	kmsPrefix     = EncryptionFormatKMS + ":"
+)
+
+// encryptedParameterIDs are the parameter table records encrypted with the
+// AES secret.
+var encryptedParameterIDs = []string{"ai_api_key", "bitbucket_api_token", "github_access_token"}
+
+func validateEncryptionFormat(format string) error {
+	if format != EncryptionFormatECB && format != EncryptionFormatGCM && format != EncryptionFormatKMS {
+		return fmt.Errorf("unsupported encryption format %q, use %s, %s or %s", format, EncryptionFormatGCM, EncryptionFormatECB, EncryptionFormatKMS)
+	}
+	return nil
+}
+
+// encryptValue encrypts text with the AES key in the given format.
+func encryptValue(text, key, format string) (string, error) {
+	switch format {
+	case EncryptionFormatGCM:
+		return encryptGCM(text, key)
+	case EncryptionFormatECB:
+		return encryptECB(text, key)
+	case EncryptionFormatKMS:
+		return "", errors.New("values in the kms format are encrypted with the KMS key, not the AES secret")
+	}
+	return "", validateEncryptionFormat(format)
+}
+
+// decryptValue decrypts a value encrypted with the AES key in any format.
+func decryptValue(value, key string) (string, error) {
+	if encoded, ok := strings.CutPrefix(value, gcmPrefix); ok {
+		return decryptGCM(encoded, key)
+	}
+	if strings.HasPrefix(value, kmsPrefix) {
+		return "", errors.New("value is encrypted with the KMS key, not the AES secret")
+	}
+	return decryptECB(value, key)
+}
+
+// valueFormat returns the format a stored value is encrypted with.
+func valueFormat(value string) string {
+	switch {
+	case strings.HasPrefix(value, gcmPrefix):
+		return EncryptionFormatGCM
+	case strings.HasPrefix(value, kmsPrefix):
+		return EncryptionFormatKMS
+	}
+	return EncryptionFormatECB
+}
+
+// generateAESKey returns a random AES-256 key of 32 printable characters,
+// since the Titvo services read the secret as text. The 24 random bytes
+// encoded base64url carry 192 bits of entropy.
+func generateAESKey() (string, error) {
+	raw := make([]byte, 24)
+	if _, err := rand.Read(raw); err != nil {
+		return "", fmt.Errorf("failed to generate AES key: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+func newAESCipher(key string) (cipher.Block, error) {
+	if len(key) != 32 {
+		return nil, errors.New("AES_KEY must have 32 characters length")
+	}
+	return aes.NewCipher([]byte(key))
+}
+
+// encryptGCM encrypts a text using AES-256-GCM, which also authenticates it
+func encryptGCM(text, key string) (string, error) {
+	block, err := newAESCipher(key)
+	if err != nil {
+		return "", err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	sealed := gcm.Seal(nonce, nonce, []byte(text), nil)
+	return gcmPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func decryptGCM(encoded, key string) (string, error) {
+	block, err := newAESCipher(key)
+	if err != nil {
+		return "", err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", err
+	}
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", fmt.Errorf("invalid encrypted value: %w", err)
+	}
+	if len(sealed) < gcm.NonceSize()+gcm.Overhead() {
+		return "", errors.New("invalid encrypted value: too short")
+	}
+	nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return "", errors.New("failed to decrypt value: wrong key or tampered data")
+	}
+	return string(plaintext), nil
+}
+
+// encryptECB encrypts a text using AES in ECB mode, the legacy v1 format
+func encryptECB(text, key string) (string, error) {
+	// Create the AES cipher
+	block, err := newAESCipher(key)
+	if err != nil {
+		return "", err
+	}
+
+	// Convert text to bytes
+	plaintext := []byte(text)
+
+	// Apply padding PKCS7 to make it a multiple of the block size
+	blockSize := block.BlockSize()
+	padding := blockSize - len(plaintext)%blockSize
+	// PKCS7 padding: if text is already multiple of block size, add a full block of padding
+	if padding == 0 {
+		padding = blockSize
+	}
+	padtext := make([]byte, len(plaintext)+padding)
+	copy(padtext, plaintext)
+	for i := len(plaintext); i < len(padtext); i++ {
+		padtext[i] = byte(padding)
+	}
+
+	// Encrypt using ECB (block by block) using the AES cipher
+	encrypted := make([]byte, len(padtext))
+	for i := 0; i < len(padtext); i += blockSize {
+		block.Encrypt(encrypted[i:i+blockSize], padtext[i:i+blockSize])
+	}
+
+	// Return in base64 format
+	return base64.StdEncoding.EncodeToString(encrypted), nil
+}
+
+// decryptECB decrypts a legacy v1 value and removes its PKCS7 padding
+func decryptECB(encoded, key string) (string, error) {
+	block, err := newAESCipher(key)
+	if err != nil {
+		return "", err
+	}
+	encrypted, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", fmt.Errorf("invalid encrypted value: %w", err)
+	}
+	blockSize := block.BlockSize()
+	if len(encrypted) == 0 || len(encrypted)%blockSize != 0 {
+		return "", errors.New("invalid encrypted value: not a multiple of the block size")
+	}
+	decrypted := make([]byte, len(encrypted))
+	for i := 0; i < len(encrypted); i += blockSize {
+		block.Decrypt(decrypted[i:i+blockSize], encrypted[i:i+blockSize])
+	}
+	padding := int(decrypted[len(decrypted)-1])
+	if padding == 0 || padding > blockSize {
+		return "", errors.New("failed to decrypt value: wrong key or invalid padding")
+	}
+	for _, b := range decrypted[len(decrypted)-padding:] {
+		if int(b) != padding {
+			return "", errors.New("failed to decrypt value: wrong key or invalid padding")
+		}
+	}
+	return string(decrypted[:len(decrypted)-padding]), nil
+}
@@ -0,0 +1,96 @@
+package internal
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+const testAESKey = "12345678901234567890123456789012"
+
+func TestEncryptValueRoundTrip(t *testing.T) {
+	t.Parallel()
+	for _, format := range []string{EncryptionFormatGCM, EncryptionFormatECB} {
+		t.Run(format, func(t *testing.T) {
+			t.Parallel()
+			for _, text := range []string{"", "sk-test", "0123456789abcdef"} {
+				encrypted, err := encryptValue(text, testAESKey, format)
+				if err != nil {
+					t.Fatalf("expected no error, got %v", err)
+				}
+				if valueFormat(encrypted) != format {
+					t.Fatalf("expected a %s value, got %q", format, encrypted)
+				}
+				decrypted, err := decryptValue(encrypted, testAESKey)
+				if err != nil || decrypted != text {
+					t.Fatalf("expected %q, got %q: %v", text, decrypted, err)
+				}
+			}
+		})
+	}
+}
+
+func TestEncryptGCMUsesRandomNonce(t *testing.T) {
+	t.Parallel()
+	first, _ := encryptGCM("sk-test", testAESKey)
+	second, _ := encryptGCM("sk-test", testAESKey)
+	if first == second {
+		t.Fatal("expected two encryptions of the same text to differ")
+	}
+	if !strings.HasPrefix(first, "v2:") {
+		t.Fatalf("expected the v2 prefix, got %q", first)
+	}
+}
+
+func TestDecryptValueErrors(t *testing.T) {
+	t.Parallel()
+	gcm, _ := encryptGCM("sk-test", testAESKey)
+	sealed, _ := base64.StdEncoding.DecodeString(strings.TrimPrefix(gcm, gcmPrefix))
+	sealed[len(sealed)-1] ^= 1
+	tampered := gcmPrefix + base64.StdEncoding.EncodeToString(sealed)
+	ecb, _ := encryptECB("sk-test", testAESKey)
+	otherKey := strings.Repeat("k", 32)
+
+	tests := []struct {
+		name     string
+		value    string
+		key      string
+		expected string
+	}{
+		{name: "tampered gcm value", value: tampered, key: testAESKey, expected: "wrong key or tampered data"},
+		{name: "gcm with another key", value: gcm, key: otherKey, expected: "wrong key or tampered data"},
+		{name: "short gcm value", value: gcmPrefix + "AAAA", key: testAESKey, expected: "too short"},
+		{name: "ecb with another key", value: ecb, key: otherKey, expected: "wrong key or invalid padding"},
+		{name: "not base64", value: "not base64!", key: testAESKey, expected: "invalid encrypted value"},
+		{name: "short key", value: gcm, key: "short", expected: "AES_KEY must have 32 characters length"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			if _, err := decryptValue(tc.value, tc.key); err == nil || !strings.Contains(err.Error(), tc.expected) {
+				t.Fatalf("expected an error containing %q, got %v", tc.expected, err)
+			}
+		})
+	}
+}
+
+func TestEncryptValueRejectsUnknownFormat(t *testing.T) {
+	t.Parallel()
+	if _, err := encryptValue("sk-test", testAESKey, "v3"); err == nil || !strings.Contains(err.Error(), `unsupported encryption format "v3"`) {
+		t.Fatalf("expected an unsupported format error, got %v", err)
+	}
+}
+
+func TestGenerateAESKeyIsPrintable(t *testing.T) {
+	t.Parallel()
+	key, err := generateAESKey()
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(key) != 32 || strings.Trim(key, "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789-_") != "" {
+		t.Fatalf("expected 32 printable characters, got %q", key)
+	}
+	if other, _ := generateAESKey(); other == key {
+		t.Fatalf("expected random keys, got %q twice", key)
+	}
+}
@@ -1,5 +1,10 @@
 package internal
 
+import (
+	"context"
+	"time"
+)
+
 const titvoInfraSource = "https://github.com/KaribuLab/titvo-security-scan-infra-aws.git"
 const titvoAuthSetupSource = "https://github.com/KaribuLab/titvo-auth-setup-aws.git"
 const titvoTaskCliFilesSource = "https://github.com/KaribuLab/titvo-task-cli-files-aws.git"
@@ -18,9 +23,6 @@ type batchJobSpec struct {
 	EnvVars map[string]string
 }
 
-var downloadSourceFn = downloadSource
-var deployInfraFn = deployInfra
-
 func installerECRPublisherJobs(region string) []batchJobSpec {
 	return []batchJobSpec{
 		{
@@ -42,52 +44,52 @@ func installerECRPublisherJobs(region string) []batchJobSpec {
 	}
 }
 
-func DownloadInfraSource(dir string) error {
-	return downloadSourceFn(dir, titvoInfraSource, "infra")
+func DownloadInfraSource(ctx context.Context, sources SourceFetcher, dir string) error {
+	return sources.FetchSource(ctx, dir, titvoInfraSource, "infra")
 }
 
-func DownloadAgentAWSSource(dir string) error {
-	return downloadSourceFn(dir, titvoAgentAWS, "agent aws")
+func DownloadAgentAWSSource(ctx context.Context, sources SourceFetcher, dir string) error {
+	return sources.FetchSource(ctx, dir, titvoAgentAWS, "agent aws")
 }
 
-func DownloadMCPGatewaySource(dir string) error {
-	return downloadSourceFn(dir, titvoMCPGateway, "MCP gateway")
+func DownloadMCPGatewaySource(ctx context.Context, sources SourceFetcher, dir string) error {
+	return sources.FetchSource(ctx, dir, titvoMCPGateway, "MCP gateway")
 }
 
-func DownloadBitbucketCodeInsightsAWSSource(dir string) error {
-	return downloadSourceFn(dir, titvoBitbucketCodeInsightsAWS, "bitbucket code insights aws")
+func DownloadBitbucketCodeInsightsAWSSource(ctx context.Context, sources SourceFetcher, dir string) error {
+	return sources.FetchSource(ctx, dir, titvoBitbucketCodeInsightsAWS, "bitbucket code insights aws")
 }
 
-func DownloadGitCommitFilesAWSSource(dir string) error {
-	return downloadSourceFn(dir, titvoGitCommitFilesAWS, "git commit files aws")
+func DownloadGitCommitFilesAWSSource(ctx context.Context, sources SourceFetcher, dir string) error {
+	return sources.FetchSource(ctx, dir, titvoGitCommitFilesAWS, "git commit files aws")
 }
 
-func DownloadGithubIssueAWSSource(dir string) error {
-	return downloadSourceFn(dir, titvoGithubIssueAWS, "github issue aws")
+func DownloadGithubIssueAWSSource(ctx context.Context, sources SourceFetcher, dir string) error {
+	return sources.FetchSource(ctx, dir, titvoGithubIssueAWS, "github issue aws")
 }
 
-func DownloadIssueReportAWSSource(dir string) error {
-	return downloadSourceFn(dir, titvoIssueReportAWS, "issue report aws")
+func DownloadIssueReportAWSSource(ctx context.Context, sources SourceFetcher, dir string) error {
+	return sources.FetchSource(ctx, dir, titvoIssueReportAWS, "issue report aws")
 }
 
-func DownloadAuthSetupSource(dir string) error {
-	return downloadSourceFn(dir, titvoAuthSetupSource, "auth setup")
+func DownloadAuthSetupSource(ctx context.Context, sources SourceFetcher, dir string) error {
+	return sources.FetchSource(ctx, dir, titvoAuthSetupSource, "auth setup")
 }
 
-func DownloadTaskCliFilesSource(dir string) error {
-	return downloadSourceFn(dir, titvoTaskCliFilesSource, "task cli files")
+func DownloadTaskCliFilesSource(ctx context.Context, sources SourceFetcher, dir string) error {
+	return sources.FetchSource(ctx, dir, titvoTaskCliFilesSource, "task cli files")
 }
 
-func DownloadTaskTriggerSource(dir string) error {
-	return downloadSourceFn(dir, titvoTaskTriggerSource, "task trigger")
+func DownloadTaskTriggerSource(ctx context.Context, sources SourceFetcher, dir string) error {
+	return sources.FetchSource(ctx, dir, titvoTaskTriggerSource, "task trigger")
 }
 
-func DownloadTaskStatusSource(dir string) error {
-	return downloadSourceFn(dir, titvoTaskStatusSource, "task status")
+func DownloadTaskStatusSource(ctx context.Context, sources SourceFetcher, dir string) error {
+	return sources.FetchSource(ctx, dir, titvoTaskStatusSource, "task status")
 }
 
-func DownloadInstallerECRPublisherSource(dir string) error {
-	return downloadSourceFn(dir, titvoInstallerECRPublisherSource, "installer ecr publisher")
+func DownloadInstallerECRPublisherSource(ctx context.Context, sources SourceFetcher, dir string) error {
+	return sources.FetchSource(ctx, dir, titvoInstallerECRPublisherSource, "installer ecr publisher")
 }
 
 type DeployConfig struct {
@@ -97,12 +99,24 @@ type DeployConfig struct {
 	PrivateSubnetCIDR string
 	AvailabilityZone  string
 	NatGatewayID      string
-	AESSecret         string
+	// AESSecret is the AES key the SCM tokens are encrypted with, empty in
+	// the kms format. AESSecretARN is its Secrets Manager secret, which the
+	// base infra reads in every format.
+	AESSecret    string
+	AESSecretARN string
+	// EncryptionFormat is the format of the encrypted SCM tokens, v2, v1 or
+	// kms. The kms format uses KMSKeyARN instead of the AES secret.
+	EncryptionFormat  string
+	KMSKeyARN         string
 	BitbucketAPIToken string
 	GithubAccessToken string
-	Debug             bool
-}
-
-func DeployInfra(config DeployConfig) error {
-	return deployInfraFn(config)
+	// MCPGateway names the Cloud Map service and port of the MCP gateway.
+	MCPGateway MCPGateway
+	Debug      bool
+	// StepTimeout bounds every terragrunt and npm command; 0 means no limit.
+	StepTimeout time.Duration
+	// State records completed steps so an interrupted run can be resumed.
+	State *installState
+	// Logs receives the command output of every step; nil disables it.
+	Logs *runLogger
 }
@@ -1,11 +1,14 @@
 package internal
 
 import (
-	"encoding/base64"
+	"context"
 	"encoding/json"
 	"fmt"
+	"io"
+	"maps"
 	"os"
 	"path"
+	"time"
 )
 
 type privateSubnetConfig struct {
@@ -14,20 +17,8 @@ type privateSubnetConfig struct {
 	NatGatewayID     string `json:"nat_gateway_id"`
 }
 
-var executeWithOptionsFn = ExecuteWithOptions
-var getAccountIDFn = GetAccountID
-var putParameterFn = PutParameter
-var createSecretFn = CreateSecret
-var getParameterFn = GetParameter
-var submitBatchJobFn = SubmitBatchJob
-var putRecordFn = PutRecord
-var mkdirAllFn = os.MkdirAll
-
-func downloadSource(dir, sourceURL, component string) error {
-	err := executeWithOptionsFn("git", &ExecuteOptions{WorkingDir: dir}, "clone", sourceURL)
-	printInfo(fmt.Sprintf("Downloaded %s from %s to %s", component, sourceURL, dir))
-	return err
-}
+// sourceDownload is one of the Download*Source functions.
+type sourceDownload func(ctx context.Context, sources SourceFetcher, dir string) error
 
 func ensureDirExists(dir, errMsg string) error {
 	if _, err := os.Stat(dir); os.IsNotExist(err) {
@@ -36,79 +27,152 @@ func ensureDirExists(dir, errMsg string) error {
 	return nil
 }
 
-func runTerragrunt(dir string, env map[string]string, action string) error {
-	return executeWithOptionsFn("terragrunt", &ExecuteOptions{WorkingDir: dir, Env: env}, "run-all", action, "-input=false", "-auto-approve", "--terragrunt-non-interactive")
+// toolEnv carries what every deployment command needs: the environment and
+// the resolver that points at the titvo-managed tools.
+type toolEnv struct {
+	Runner   CommandRunner
+	Env      map[string]string
+	Resolver *ToolResolver
+	Timeout  time.Duration
+	Output   io.Writer
+	Console  *Console
+}
+
+func (t toolEnv) options(dir string) *ExecuteOptions {
+	return &ExecuteOptions{WorkingDir: dir, Env: t.Env, Resolver: t.Resolver, Timeout: t.Timeout, Output: t.Output, Console: t.Console}
+}
+
+func (t toolEnv) run(ctx context.Context, dir, command string, args ...string) error {
+	return t.Runner.Run(ctx, command, t.options(dir), args...)
+}
+
+// withComponentLog returns a copy of t that also writes the command output to
+// the component log of the run. The returned func closes the log file.
+func (t toolEnv) withComponentLog(logs *runLogger, component string) (toolEnv, func()) {
+	if logs == nil {
+		return t, func() {}
+	}
+	out, err := logs.component(component)
+	if err != nil {
+		t.Console.printError(err)
+		return t, func() {}
+	}
+	t.Output = out
+	return t, func() { out.Close() }
+}
+
+func runTerragrunt(ctx context.Context, dir string, tools toolEnv, action string) error {
+	return tools.run(ctx, dir, "terragrunt", "run-all", action, "-input=false", "-auto-approve", "--terragrunt-non-interactive")
 }
 
-func runBuild(sourceDir string, repeats int) error {
+func runBuild(ctx context.Context, sourceDir string, tools toolEnv, repeats int) error {
 	for range repeats {
-		printInfo("Executing build with npm")
-		if err := executeWithOptionsFn("npm", &ExecuteOptions{WorkingDir: sourceDir}, "ci"); err != nil {
+		tools.Console.printInfo("Executing build with npm")
+		if err := tools.run(ctx, sourceDir, "npm", "ci"); err != nil {
 			return fmt.Errorf("npm ci failed: %w", err)
 		}
-		if err := executeWithOptionsFn("npm", &ExecuteOptions{WorkingDir: sourceDir}, "run", "build"); err != nil {
+		if err := tools.run(ctx, sourceDir, "npm", "run", "build"); err != nil {
 			return fmt.Errorf("npm run build failed: %w", err)
 		}
 	}
 	return nil
 }
 
-func applyTerragruntInDir(dir, label string, env map[string]string) error {
+func applyTerragruntInDir(ctx context.Context, dir, label string, tools toolEnv) error {
 	if err := ensureDirExists(dir, "%s directory does not exist"); err != nil {
 		return err
 	}
-	printInfo(fmt.Sprintf("Executing terragrunt apply %s", label))
-	if err := runTerragrunt(dir, env, "apply"); err != nil {
+	tools.Console.printInfo(fmt.Sprintf("Executing terragrunt apply %s", label))
+	if err := runTerragrunt(ctx, dir, tools, "apply"); err != nil {
 		return fmt.Errorf("terragrunt apply %s failed: %w", label, err)
 	}
 	return nil
 }
 
-func deployTerraformComponentFromSource(sourceDir, label string, env map[string]string) error {
+func deployTerraformComponentFromSource(ctx context.Context, sourceDir, label string, tools toolEnv) error {
 	if err := ensureDirExists(sourceDir, "%s directory does not exist"); err != nil {
 		return err
 	}
 	prodDir := path.Join(sourceDir, "aws")
-	printInfo(fmt.Sprintf("Deploying %s to %s", label, prodDir))
-	return applyTerragruntInDir(prodDir, label, env)
+	tools.Console.printInfo(fmt.Sprintf("Deploying %s to %s", label, prodDir))
+	return applyTerragruntInDir(ctx, prodDir, label, tools)
 }
 
-func deployNodeComponentFromSource(sourceDir, label string, env map[string]string, buildRepeats int, needsSubmodules bool) error {
+func deployNodeComponentFromSource(ctx context.Context, sourceDir, label string, tools toolEnv, buildRepeats int, needsSubmodules bool) error {
 	if err := ensureDirExists(sourceDir, "%s directory does not exist"); err != nil {
 		return err
 	}
 
 	if needsSubmodules {
-		printInfo("Updating git submodules")
-		if err := executeWithOptionsFn("git", &ExecuteOptions{WorkingDir: sourceDir}, "submodule", "update", "--init"); err != nil {
+		tools.Console.printInfo("Updating git submodules")
+		if err := tools.run(ctx, sourceDir, "git", "submodule", "update", "--init"); err != nil {
 			return fmt.Errorf("git submodule update failed: %w", err)
 		}
 	}
 
 	if buildRepeats > 0 {
-		if err := runBuild(sourceDir, buildRepeats); err != nil {
+		if err := runBuild(ctx, sourceDir, tools, buildRepeats); err != nil {
 			return err
 		}
 	}
 
-	return deployTerraformComponentFromSource(sourceDir, label, env)
+	return deployTerraformComponentFromSource(ctx, sourceDir, label, tools)
 }
 
-func deployNodeComponent(infraDir, repoDirName, label string, downloadFn func(string) error, env map[string]string, buildRepeats int, needsSubmodules bool) error {
-	if err := downloadFn(infraDir); err != nil {
+func (d *Deployer) deployNodeComponent(ctx context.Context, infraDir, repoDirName, label string, downloadFn sourceDownload, tools toolEnv, buildRepeats int, needsSubmodules bool) error {
+	if err := downloadFn(ctx, d.Sources, infraDir); err != nil {
 		return fmt.Errorf("failed to download %s: %w", label, err)
 	}
 
 	sourceDir := path.Join(infraDir, repoDirName)
-	return deployNodeComponentFromSource(sourceDir, label, env, buildRepeats, needsSubmodules)
+	return deployNodeComponentFromSource(ctx, sourceDir, label, tools, buildRepeats, needsSubmodules)
 }
 
-func deployInfra(config DeployConfig) error {
+// publishImages deploys the temporary installer ECR publisher, runs its Batch
+// jobs to build the agent and MCP gateway images and destroys it again.
+func (d *Deployer) publishImages(ctx context.Context, config DeployConfig, infraDir string, tools toolEnv) error {
+	if err := DownloadInstallerECRPublisherSource(ctx, d.Sources, infraDir); err != nil {
+		return fmt.Errorf("failed to download installer ecr publisher: %w", err)
+	}
+	ecrPublisherSource := path.Join(infraDir, "titvo-installer-ecr-publisher")
+	if err := ensureDirExists(ecrPublisherSource, "installer ecr publisher directory %s does not exist"); err != nil {
+		return err
+	}
+	ecrPublisherAWSDir := path.Join(ecrPublisherSource, "aws")
+	d.Console.printInfo("Executing terragrunt apply installer ecr publisher")
+	if err := runTerragrunt(ctx, ecrPublisherAWSDir, tools, "apply"); err != nil {
+		return fmt.Errorf("terragrunt apply installer ecr publisher failed: %w", err)
+	}
+
+	jobDefinitionARN, err := d.Parameters.GetParameter(ctx, "/tvo/security-scan/prod/infra/ecr/publisher/job_definition_arn")
+	if err != nil {
+		return fmt.Errorf("failed to get ecr publisher job definition arn: %w", err)
+	}
+	jobQueueARN, err := d.Parameters.GetParameter(ctx, "/tvo/security-scan/prod/infra/ecr/publisher/job_queue_arn")
+	if err != nil {
+		return fmt.Errorf("failed to get ecr publisher job queue arn: %w", err)
+	}
+	for _, job := range installerECRPublisherJobs(config.AWSCredentials.AWSRegion) {
+		d.Console.printInfo(fmt.Sprintf("Submitting installer ecr publisher job: %s", job.Name))
+		if err := d.Batch.SubmitBatchJob(ctx, job.Name, jobQueueARN, jobDefinitionARN, job.EnvVars); err != nil {
+			return fmt.Errorf("failed to submit installer ecr publisher job %s: %w", job.Name, err)
+		}
+	}
+
+	d.Console.printInfo("Destroying installer ecr publisher")
+	if err := runTerragrunt(ctx, ecrPublisherAWSDir, tools, "destroy"); err != nil {
+		return fmt.Errorf("terragrunt destroy installer ecr publisher failed: %w", err)
+	}
+	return nil
+}
+
+// DeployInfra deploys the base infrastructure and every Titvo component.
+func (d *Deployer) DeployInfra(ctx context.Context, config DeployConfig) error {
 	infraDir := path.Join(config.InstallToolConfig.TitvoDir, "infra")
-	if err := mkdirAllFn(infraDir, 0755); err != nil {
+	if err := os.MkdirAll(infraDir, 0755); err != nil {
 		return err
 	}
-	if err := DownloadInfraSource(infraDir); err != nil {
+	if err := DownloadInfraSource(ctx, d.Sources, infraDir); err != nil {
 		return fmt.Errorf("failed to download infra: %w", err)
 	}
 
@@ -117,22 +181,20 @@ func deployInfra(config DeployConfig) error {
 		return err
 	}
 	baseProdDir := path.Join(baseSourceDir, "prod", "us-east-1")
-	printInfo(fmt.Sprintf("Deploying infra to %s", baseProdDir))
+	d.Console.printInfo(fmt.Sprintf("Deploying infra to %s", baseProdDir))
 
-	currentPathEnv := os.Getenv("PATH")
-	var newPathEnv string
-	if config.InstallToolConfig.OS == Windows {
-		newPathEnv = fmt.Sprintf("%s;%s;%s;%s", currentPathEnv, config.InstallToolConfig.TerraformBinDir, config.InstallToolConfig.TerragruntBinDir, config.InstallToolConfig.NodeBinDir)
-	} else {
-		newPathEnv = fmt.Sprintf("%s:%s:%s:%s", currentPathEnv, config.InstallToolConfig.TerraformBinDir, config.InstallToolConfig.TerragruntBinDir, config.InstallToolConfig.NodeBinDir)
-	}
+	// The titvo-managed tools go first so terragrunt and npm scripts pick them
+	// over any system-wide install
+	installed := config.InstallToolConfig
+	newPathEnv := joinPathList(installed.OS, installed.TerraformBinDir, installed.TerragruntBinDir, installed.NodeBinDir, os.Getenv("PATH"))
+	resolver := installed.ToolResolver()
 
 	pluginCacheDir := path.Join(config.InstallToolConfig.TitvoDir, "terraform-plugins")
-	if err := mkdirAllFn(pluginCacheDir, 0755); err != nil {
+	if err := os.MkdirAll(pluginCacheDir, 0755); err != nil {
 		return fmt.Errorf("failed to create plugin cache directory: %w", err)
 	}
 
-	accountID, err := getAccountIDFn(&config.AWSCredentials)
+	accountID, err := d.Accounts.GetAccountID(ctx)
 	if err != nil {
 		return fmt.Errorf("failed to get AWS account ID: %w", err)
 	}
@@ -153,8 +215,21 @@ func deployInfra(config DeployConfig) error {
 	if config.AWSCredentials.AWSSessionToken != "" {
 		env["AWS_SESSION_TOKEN"] = config.AWSCredentials.AWSSessionToken
 	}
+	if terraformPath, err := resolver.Resolve("terraform"); err == nil {
+		env["TERRAGRUNT_TFPATH"] = terraformPath
+	}
+	tools := toolEnv{Runner: d.Commands, Env: env, Resolver: resolver, Timeout: config.StepTimeout, Console: d.Console}
+	// runStep tracks step in the install state and logs its commands to the
+	// step's component log
+	runStep := func(step string, fn func(tools toolEnv) error) error {
+		return config.State.run(ctx, d.Console, step, func() error {
+			stepTools, closeLog := tools.withComponentLog(config.Logs, step)
+			defer closeLog()
+			return fn(stepTools)
+		})
+	}
 
-	printInfo("Setting up parameters")
+	d.Console.printInfo("Setting up parameters")
 	privateSubnets, err := json.Marshal([]privateSubnetConfig{
 		{
 			CIDRBlock:        config.PrivateSubnetCIDR,
@@ -175,32 +250,43 @@ func deployInfra(config DeployConfig) error {
 		{name: "private-subnets", path: "/tvo/security-scan/prod/infra/vpc/installer/subnets/private", value: string(privateSubnets)},
 	}
 	for _, param := range parameterWrites {
-		if err := putParameterFn(&config.AWSCredentials, param.path, param.value); err != nil {
+		if err := d.Parameters.PutParameter(ctx, param.path, param.value); err != nil {
 			return fmt.Errorf("failed to put parameter %s: %w", param.name, err)
 		}
 	}
-	base64AESSecret := base64.StdEncoding.EncodeToString([]byte(config.AESSecret))
-	secretARN, err := createSecretFn(&config.AWSCredentials, "/tvo/security-scan/prod/aes_secret", base64AESSecret)
-	if err != nil {
-		return fmt.Errorf("failed to create secret aes_secret: %w", err)
-	}
+	// The base infra modules read the AES secret parameters whatever the
+	// format. Only names and ARNs go to SSM, the sensitive values are
+	// encrypted in the parameter table.
 	secretParameters := []struct {
 		name  string
 		path  string
 		value string
 	}{
-		{name: "encryption-key-name", path: "/tvo/security-scan/prod/infra/kms/encryption-key-name", value: "/tvo/security-scan/prod/aes_secret"},
-		{name: "encryption-key-arn", path: "/tvo/security-scan/prod/infra/secret/manager/arn", value: secretARN},
+		{name: "encryption-key-name", path: "/tvo/security-scan/prod/infra/kms/encryption-key-name", value: aesSecretName},
+		{name: "encryption-key-arn", path: "/tvo/security-scan/prod/infra/secret/manager/arn", value: config.AESSecretARN},
+	}
+	if config.EncryptionFormat == EncryptionFormatKMS {
+		secretParameters = append(secretParameters, struct {
+			name  string
+			path  string
+			value string
+		}{name: "kms-key-arn", path: kmsKeyParameter, value: config.KMSKeyARN})
 	}
 	for _, param := range secretParameters {
-		if err := putParameterFn(&config.AWSCredentials, param.path, param.value); err != nil {
+		if err := d.Parameters.PutParameter(ctx, param.path, param.value); err != nil {
 			return fmt.Errorf("failed to put parameter %s: %w", param.name, err)
 		}
 	}
 
-	printInfo("Executing terragrunt apply base infra")
-	if err := runTerragrunt(baseProdDir, env, "apply"); err != nil {
-		return fmt.Errorf("terragrunt apply failed: %w", err)
+	err = runStep("base infra", func(tools toolEnv) error {
+		d.Console.printInfo("Executing terragrunt apply base infra")
+		if err := runTerragrunt(ctx, baseProdDir, tools, "apply"); err != nil {
+			return fmt.Errorf("terragrunt apply failed: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
 	}
 
 	type scmSecretResult struct {
@@ -211,9 +297,9 @@ func deployInfra(config DeployConfig) error {
 	scmSecretResults := []scmSecretResult{}
 
 	if config.BitbucketAPIToken == "" {
-		printAskQuestion("Warning: Bitbucket credentials were not provided. Bitbucket integration deployment will be skipped.")
+		d.Console.printAskQuestion("Warning: Bitbucket credentials were not provided. Bitbucket integration deployment will be skipped.")
 	} else {
-		encryptedBitbucketAPIToken, err := encrypt(config.BitbucketAPIToken, config.AESSecret)
+		encryptedBitbucketAPIToken, err := d.encryptParameter(ctx, config.BitbucketAPIToken, config.EncryptionFormat, config.AESSecret, config.KMSKeyARN)
 		if err != nil {
 			return fmt.Errorf("failed to encrypt bitbucket api token: %w", err)
 		}
@@ -224,9 +310,9 @@ func deployInfra(config DeployConfig) error {
 	}
 
 	if config.GithubAccessToken == "" {
-		printAskQuestion("Warning: GitHub access token was not provided. GitHub integration deployment will be skipped.")
+		d.Console.printAskQuestion("Warning: GitHub access token was not provided. GitHub integration deployment will be skipped.")
 	} else {
-		encryptedGithubAccessToken, err := encrypt(config.GithubAccessToken, config.AESSecret)
+		encryptedGithubAccessToken, err := d.encryptParameter(ctx, config.GithubAccessToken, config.EncryptionFormat, config.AESSecret, config.KMSKeyARN)
 		if err != nil {
 			return fmt.Errorf("failed to encrypt github access token: %w", err)
 		}
@@ -237,7 +323,7 @@ func deployInfra(config DeployConfig) error {
 	}
 
 	for _, secret := range scmSecretResults {
-		if err := putRecordFn(&config.AWSCredentials, "tvo-security-scan-parameter-prod", map[string]interface{}{
+		if err := d.Records.PutRecord(ctx, "tvo-security-scan-parameter-prod", map[string]interface{}{
 			"parameter_id": secret.parameterID,
 			"value":        secret.value,
 		}); err != nil {
@@ -248,7 +334,7 @@ func deployInfra(config DeployConfig) error {
 	firstStageComponents := []struct {
 		repoDirName    string
 		label          string
-		downloadFn     func(string) error
+		downloadFn     sourceDownload
 		buildRepeats   int
 		needsSubmodule bool
 	}{
@@ -259,12 +345,15 @@ func deployInfra(config DeployConfig) error {
 		{repoDirName: "titvo-task-status-aws", label: "task status", downloadFn: DownloadTaskStatusSource, buildRepeats: 1, needsSubmodule: true},
 	}
 	for _, component := range firstStageComponents {
-		if err := deployNodeComponent(infraDir, component.repoDirName, component.label, component.downloadFn, env, component.buildRepeats, component.needsSubmodule); err != nil {
+		err := runStep(component.label, func(tools toolEnv) error {
+			return d.deployNodeComponent(ctx, infraDir, component.repoDirName, component.label, component.downloadFn, tools, component.buildRepeats, component.needsSubmodule)
+		})
+		if err != nil {
 			return err
 		}
 	}
 
-	if err := DownloadMCPGatewaySource(infraDir); err != nil {
+	if err := DownloadMCPGatewaySource(ctx, d.Sources, infraDir); err != nil {
 		return fmt.Errorf("failed to download MCP gateway: %w", err)
 	}
 	mcpGatewaySourceDir := path.Join(infraDir, "titvo-mcp-gateway")
@@ -272,52 +361,34 @@ func deployInfra(config DeployConfig) error {
 		return err
 	}
 	mcpGatewayECRDir := path.Join(mcpGatewaySourceDir, "aws", "ecr")
-	printInfo(fmt.Sprintf("Deploying MCP gateway ECR to %s", mcpGatewayECRDir))
-	if err := applyTerragruntInDir(mcpGatewayECRDir, "MCP gateway ECR", env); err != nil {
+	d.Console.printInfo(fmt.Sprintf("Deploying MCP gateway ECR to %s", mcpGatewayECRDir))
+	err = runStep("MCP gateway ECR", func(tools toolEnv) error {
+		return applyTerragruntInDir(ctx, mcpGatewayECRDir, "MCP gateway ECR", tools)
+	})
+	if err != nil {
 		return err
 	}
 
-	if err := DownloadInstallerECRPublisherSource(infraDir); err != nil {
-		return fmt.Errorf("failed to download installer ecr publisher: %w", err)
-	}
-	ecrPublisherSource := path.Join(infraDir, "titvo-installer-ecr-publisher")
-	if err := ensureDirExists(ecrPublisherSource, "installer ecr publisher directory %s does not exist"); err != nil {
+	err = runStep("installer ecr publisher", func(tools toolEnv) error {
+		return d.publishImages(ctx, config, infraDir, tools)
+	})
+	if err != nil {
 		return err
 	}
-	ecrPublisherAWSDir := path.Join(ecrPublisherSource, "aws")
-	printInfo("Executing terragrunt apply installer ecr publisher")
-	if err := runTerragrunt(ecrPublisherAWSDir, env, "apply"); err != nil {
-		return fmt.Errorf("terragrunt apply installer ecr publisher failed: %w", err)
-	}
 
-	jobDefinitionARN, err := getParameterFn(&config.AWSCredentials, "/tvo/security-scan/prod/infra/ecr/publisher/job_definition_arn")
-	if err != nil {
-		return fmt.Errorf("failed to get ecr publisher job definition arn: %w", err)
-	}
-	jobQueueARN, err := getParameterFn(&config.AWSCredentials, "/tvo/security-scan/prod/infra/ecr/publisher/job_queue_arn")
+	err = runStep("MCP gateway", func(tools toolEnv) error {
+		tools.Env = maps.Clone(tools.Env)
+		maps.Copy(tools.Env, config.MCPGateway.env())
+		return deployTerraformComponentFromSource(ctx, mcpGatewaySourceDir, "MCP gateway", tools)
+	})
 	if err != nil {
-		return fmt.Errorf("failed to get ecr publisher job queue arn: %w", err)
-	}
-	for _, job := range installerECRPublisherJobs(config.AWSCredentials.AWSRegion) {
-		printInfo(fmt.Sprintf("Submitting installer ecr publisher job: %s", job.Name))
-		if err := submitBatchJobFn(&config.AWSCredentials, job.Name, jobQueueARN, jobDefinitionARN, job.EnvVars); err != nil {
-			return fmt.Errorf("failed to submit installer ecr publisher job %s: %w", job.Name, err)
-		}
-	}
-
-	printInfo("Destroying installer ecr publisher")
-	if err := runTerragrunt(ecrPublisherAWSDir, env, "destroy"); err != nil {
-		return fmt.Errorf("terragrunt destroy installer ecr publisher failed: %w", err)
-	}
-
-	if err := deployTerraformComponentFromSource(mcpGatewaySourceDir, "MCP gateway", env); err != nil {
 		return err
 	}
 
 	secondStageComponents := []struct {
 		repoDirName    string
 		label          string
-		downloadFn     func(string) error
+		downloadFn     sourceDownload
 		buildRepeat    int
 		needsSubmodule bool
 	}{
@@ -328,7 +399,7 @@ func deployInfra(config DeployConfig) error {
 		secondStageComponents = append(secondStageComponents, struct {
 			repoDirName    string
 			label          string
-			downloadFn     func(string) error
+			downloadFn     sourceDownload
 			buildRepeat    int
 			needsSubmodule bool
 		}{repoDirName: "titvo-bitbucket-code-insights-aws", label: "bitbucket code insights aws", downloadFn: DownloadBitbucketCodeInsightsAWSSource, buildRepeat: 1, needsSubmodule: true})
@@ -337,17 +408,20 @@ func deployInfra(config DeployConfig) error {
 		secondStageComponents = append(secondStageComponents, struct {
 			repoDirName    string
 			label          string
-			downloadFn     func(string) error
+			downloadFn     sourceDownload
 			buildRepeat    int
 			needsSubmodule bool
 		}{repoDirName: "titvo-github-issue-aws", label: "github issue aws", downloadFn: DownloadGithubIssueAWSSource, buildRepeat: 1, needsSubmodule: true})
 	}
 	for _, component := range secondStageComponents {
-		if err := deployNodeComponent(infraDir, component.repoDirName, component.label, component.downloadFn, env, component.buildRepeat, component.needsSubmodule); err != nil {
+		err := runStep(component.label, func(tools toolEnv) error {
+			return d.deployNodeComponent(ctx, infraDir, component.repoDirName, component.label, component.downloadFn, tools, component.buildRepeat, component.needsSubmodule)
+		})
+		if err != nil {
 			return err
 		}
 	}
 
-	printInfo("Deployed all services")
+	d.Console.printInfo("Deployed all services")
 	return nil
 }
@@ -1,35 +1,213 @@
 package internal
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"maps"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
-func successfulDeployStubs() {
-	mkdirAllFn = os.MkdirAll
-	downloadSourceFn = func(dir, sourceURL, component string) error { return nil }
-	executeWithOptionsFn = func(command string, options *ExecuteOptions, args ...string) error { return nil }
-	getAccountIDFn = func(creds *AWSCredentials) (string, error) { return "123456789012", nil }
-	putParameterFn = func(creds *AWSCredentials, path, value string) error { return nil }
-	createSecretFn = func(creds *AWSCredentials, name, secretValue string) (string, error) {
-		return "arn:aws:secretsmanager:secret", nil
-	}
-	getParameterFn = func(creds *AWSCredentials, path string) (string, error) {
-		if path == "/tvo/security-scan/prod/infra/ecr/publisher/job_definition_arn" {
-			return "job-def", nil
-		}
-		return "job-queue", nil
-	}
-	submitBatchJobFn = func(creds *AWSCredentials, jobName, jobQueue, jobDefinition string, envVars map[string]string) error {
-		return nil
-	}
-	putRecordFn = func(creds *AWSCredentials, tableName string, item map[string]interface{}) error {
-		return nil
+type commandFunc func(ctx context.Context, command string, options *ExecuteOptions, args ...string) error
+
+func (f commandFunc) Run(ctx context.Context, command string, options *ExecuteOptions, args ...string) error {
+	return f(ctx, command, options, args...)
+}
+
+type sourceFunc func(ctx context.Context, dir, sourceURL, component string) error
+
+func (f sourceFunc) FetchSource(ctx context.Context, dir, sourceURL, component string) error {
+	return f(ctx, dir, sourceURL, component)
+}
+
+// fakeAWS implements the AWS backed interfaces of Deployer; tests replace the
+// functions they need to observe or fail.
+type fakeAWS struct {
+	getAccountID   func() (string, error)
+	getParameter   func(path string) (string, error)
+	putParameter   func(path, value string) error
+	getSecret      func(name string) (string, error)
+	ensureSecret   func(name, value string) (string, string, error)
+	stageSecret    func(name, value string) (string, error)
+	promoteSecret  func(name, versionID string) error
+	unstageSecret  func(name, versionID string) error
+	ensureKey      func(keyID, alias string, tags map[string]string) (string, error)
+	grantKey       func(keyARN, grantName, roleARN string) (bool, error)
+	encryptWithKey func(keyARN string, plaintext []byte) ([]byte, error)
+	submitBatchJob func(jobName, jobQueue, jobDefinition string, envVars map[string]string) error
+	jobRoleARN     func(jobDefinition string) (string, error)
+	putRolePolicy  func(roleARN, policyName, document string) error
+	listBedrock    func(region string) ([]string, error)
+	resolveService func(namespace, service string) ([]string, error)
+	putRecord      func(tableName string, item map[string]interface{}) error
+	putNewRecord   func(tableName, keyAttribute string, item map[string]interface{}) error
+	findRecords    func(tableName, attribute, value string) ([]map[string]interface{}, error)
+	listRecords    func(tableName string) ([]map[string]interface{}, error)
+	deleteRecord   func(tableName, keyAttribute, keyValue string) error
+}
+
+func (f *fakeAWS) GetAccountID(ctx context.Context) (string, error) {
+	return f.getAccountID()
+}
+
+func (f *fakeAWS) GetParameter(ctx context.Context, path string) (string, error) {
+	return f.getParameter(path)
+}
+
+func (f *fakeAWS) PutParameter(ctx context.Context, path, value string) error {
+	return f.putParameter(path, value)
+}
+
+func (f *fakeAWS) GetSecret(ctx context.Context, name string) (string, error) {
+	return f.getSecret(name)
+}
+
+func (f *fakeAWS) StageSecret(ctx context.Context, name, value string) (string, error) {
+	return f.stageSecret(name, value)
+}
+
+func (f *fakeAWS) PromoteSecret(ctx context.Context, name, versionID string) error {
+	return f.promoteSecret(name, versionID)
+}
+
+func (f *fakeAWS) UnstageSecret(ctx context.Context, name, versionID string) error {
+	return f.unstageSecret(name, versionID)
+}
+
+func (f *fakeAWS) EnsureSecret(ctx context.Context, name, value string) (string, string, error) {
+	return f.ensureSecret(name, value)
+}
+
+func (f *fakeAWS) EnsureKey(ctx context.Context, keyID, alias string, tags map[string]string) (string, error) {
+	return f.ensureKey(keyID, alias, tags)
+}
+
+func (f *fakeAWS) GrantKey(ctx context.Context, keyARN, grantName, roleARN string) (bool, error) {
+	return f.grantKey(keyARN, grantName, roleARN)
+}
+
+func (f *fakeAWS) EncryptWithKey(ctx context.Context, keyARN string, plaintext []byte) ([]byte, error) {
+	return f.encryptWithKey(keyARN, plaintext)
+}
+
+func (f *fakeAWS) SubmitBatchJob(ctx context.Context, jobName, jobQueue, jobDefinition string, envVars map[string]string) error {
+	return f.submitBatchJob(jobName, jobQueue, jobDefinition, envVars)
+}
+
+func (f *fakeAWS) JobRoleARN(ctx context.Context, jobDefinition string) (string, error) {
+	return f.jobRoleARN(jobDefinition)
+}
+
+func (f *fakeAWS) PutRolePolicy(ctx context.Context, roleARN, policyName, document string) error {
+	return f.putRolePolicy(roleARN, policyName, document)
+}
+
+func (f *fakeAWS) ListBedrockModels(ctx context.Context, region string) ([]string, error) {
+	return f.listBedrock(region)
+}
+
+func (f *fakeAWS) ResolveService(ctx context.Context, namespace, service string) ([]string, error) {
+	return f.resolveService(namespace, service)
+}
+
+func (f *fakeAWS) PutRecord(ctx context.Context, tableName string, item map[string]interface{}) error {
+	return f.putRecord(tableName, item)
+}
+
+func (f *fakeAWS) PutNewRecord(ctx context.Context, tableName, keyAttribute string, item map[string]interface{}) error {
+	return f.putNewRecord(tableName, keyAttribute, item)
+}
+
+func (f *fakeAWS) FindRecords(ctx context.Context, tableName, attribute, value string) ([]map[string]interface{}, error) {
+	return f.findRecords(tableName, attribute, value)
+}
+
+func (f *fakeAWS) ListRecords(ctx context.Context, tableName string) ([]map[string]interface{}, error) {
+	return f.listRecords(tableName)
+}
+
+func (f *fakeAWS) DeleteRecord(ctx context.Context, tableName, keyAttribute, keyValue string) error {
+	return f.deleteRecord(tableName, keyAttribute, keyValue)
+}
+
+// testDeployer returns a Deployer whose commands, downloads and AWS calls all
+// succeed.
+func testDeployer() (*Deployer, *fakeAWS) {
+	fake := &fakeAWS{
+		getAccountID: func() (string, error) { return "123456789012", nil },
+		getParameter: func(path string) (string, error) {
+			if path == "/tvo/security-scan/prod/infra/ecr/publisher/job_definition_arn" {
+				return "job-def", nil
+			}
+			return "job-queue", nil
+		},
+		putParameter: func(path, value string) error { return nil },
+		getSecret: func(name string) (string, error) {
+			return "", errors.New("secret not found")
+		},
+		stageSecret:   func(name, value string) (string, error) { return "version-1", nil },
+		promoteSecret: func(name, versionID string) error { return nil },
+		unstageSecret: func(name, versionID string) error { return nil },
+		ensureSecret: func(name, value string) (string, string, error) {
+			return "arn:aws:secretsmanager:secret", value, nil
+		},
+		ensureKey: func(keyID, alias string, tags map[string]string) (string, error) {
+			return "arn:aws:kms:us-east-1:123456789012:key/key-1", nil
+		},
+		grantKey: func(keyARN, grantName, roleARN string) (bool, error) { return true, nil },
+		encryptWithKey: func(keyARN string, plaintext []byte) ([]byte, error) {
+			return append([]byte(keyARN+":"), plaintext...), nil
+		},
+		submitBatchJob: func(jobName, jobQueue, jobDefinition string, envVars map[string]string) error {
+			return nil
+		},
+		jobRoleARN: func(jobDefinition string) (string, error) {
+			return "arn:aws:iam::123456789012:role/tvo-agent-job-role", nil
+		},
+		putRolePolicy: func(roleARN, policyName, document string) error { return nil },
+		listBedrock:   func(region string) ([]string, error) { return nil, nil },
+		resolveService: func(namespace, service string) ([]string, error) {
+			return []string{"10.0.1.5:3000"}, nil
+		},
+		putRecord: func(tableName string, item map[string]interface{}) error {
+			return nil
+		},
+		putNewRecord: func(tableName, keyAttribute string, item map[string]interface{}) error {
+			return nil
+		},
+		findRecords: func(tableName, attribute, value string) ([]map[string]interface{}, error) {
+			return nil, nil
+		},
+		listRecords: func(tableName string) ([]map[string]interface{}, error) {
+			return nil, nil
+		},
+		deleteRecord: func(tableName, keyAttribute, keyValue string) error {
+			return nil
+		},
 	}
+	return &Deployer{
+		Console: testConsole(),
+		Commands: commandFunc(func(ctx context.Context, command string, options *ExecuteOptions, args ...string) error {
+			return nil
+		}),
+		Parameters: fake,
+		Secrets:    fake,
+		Keys:       fake,
+		Batch:      fake,
+		Roles:      fake,
+		Records:    fake,
+		Accounts:   fake,
+		Services:   fake,
+		Sources: sourceFunc(func(ctx context.Context, dir, sourceURL, component string) error {
+			return nil
+		}),
+	}, fake
 }
 
 func validDeployConfig(titvoDir string) DeployConfig {
@@ -52,35 +230,13 @@ func validDeployConfig(titvoDir string) DeployConfig {
 		AvailabilityZone:  "us-east-1a",
 		NatGatewayID:      "nat-00000000000000001",
 		AESSecret:         "12345678901234567890123456789012",
+		AESSecretARN:      "arn:aws:secretsmanager:secret",
+		EncryptionFormat:  EncryptionFormatGCM,
+		MCPGateway:        MCPGateway{Hostname: "mcp", Port: 8080, Namespace: "svc.example.internal"},
 		Debug:             false,
 	}
 }
 
-func withRuntimeStubs(t *testing.T) {
-	t.Helper()
-	origExec := executeWithOptionsFn
-	origGetAccount := getAccountIDFn
-	origPut := putParameterFn
-	origCreateSecret := createSecretFn
-	origGetParam := getParameterFn
-	origSubmit := submitBatchJobFn
-	origPutRecord := putRecordFn
-	origDownload := downloadSourceFn
-	origMkdirAll := mkdirAllFn
-
-	t.Cleanup(func() {
-		executeWithOptionsFn = origExec
-		getAccountIDFn = origGetAccount
-		putParameterFn = origPut
-		createSecretFn = origCreateSecret
-		getParameterFn = origGetParam
-		submitBatchJobFn = origSubmit
-		putRecordFn = origPutRecord
-		downloadSourceFn = origDownload
-		mkdirAllFn = origMkdirAll
-	})
-}
-
 func createRequiredInfraDirs(t *testing.T, titvoDir string) {
 	t.Helper()
 	paths := []string{
@@ -106,11 +262,11 @@ func createRequiredInfraDirs(t *testing.T, titvoDir string) {
 	}
 }
 
-func TestDownloadSourceRunsGitClone(t *testing.T) {
-	withRuntimeStubs(t)
+func TestGitSourceFetcherRunsGitClone(t *testing.T) {
+	t.Parallel()
 
 	called := false
-	executeWithOptionsFn = func(command string, options *ExecuteOptions, args ...string) error {
+	fetcher := gitSourceFetcher{console: testConsole(), commands: commandFunc(func(ctx context.Context, command string, options *ExecuteOptions, args ...string) error {
 		called = true
 		if command != "git" {
 			t.Fatalf("unexpected command: %s", command)
@@ -122,42 +278,70 @@ func TestDownloadSourceRunsGitClone(t *testing.T) {
 			t.Fatalf("unexpected args: %v", args)
 		}
 		return nil
-	}
+	})}
 
-	err := downloadSource("/tmp/work", "https://example.com/repo.git", "component")
+	err := fetcher.FetchSource(context.Background(), "/tmp/work", "https://example.com/repo.git", "component")
 	if err != nil {
 		t.Fatalf("expected no error, got %v", err)
 	}
 	if !called {
-		t.Fatalf("expected executeWithOptionsFn to be called")
+		t.Fatalf("expected git to be run")
 	}
 }
 
-func TestDownloadSourcePropagatesError(t *testing.T) {
-	withRuntimeStubs(t)
+func TestGitSourceFetcherPropagatesError(t *testing.T) {
+	t.Parallel()
 	expected := errors.New("clone failed")
-	executeWithOptionsFn = func(command string, options *ExecuteOptions, args ...string) error {
+	fetcher := gitSourceFetcher{console: testConsole(), commands: commandFunc(func(ctx context.Context, command string, options *ExecuteOptions, args ...string) error {
 		return expected
-	}
+	})}
 
-	err := downloadSource("/tmp/work", "https://example.com/repo.git", "component")
+	err := fetcher.FetchSource(context.Background(), "/tmp/work", "https://example.com/repo.git", "component")
 	if !errors.Is(err, expected) {
 		t.Fatalf("expected error %v, got %v", expected, err)
 	}
 }
 
+func TestGitSourceFetcherExistingCheckout(t *testing.T) {
+	t.Parallel()
+	for _, resume := range []bool{false, true} {
+		dir := t.TempDir()
+		stale := filepath.Join(dir, "repo", "stale.txt")
+		if err := os.MkdirAll(filepath.Join(dir, "repo", ".git"), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(stale, []byte("old"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		cloned := false
+		fetcher := gitSourceFetcher{console: testConsole(), resume: resume, commands: commandFunc(func(ctx context.Context, command string, options *ExecuteOptions, args ...string) error {
+			cloned = true
+			return nil
+		})}
+		if err := fetcher.FetchSource(context.Background(), dir, "https://example.com/repo.git", "component"); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		_, statErr := os.Stat(stale)
+		if resume && (cloned || statErr != nil) {
+			t.Errorf("expected a resumed run to keep the checkout, cloned %v, %v", cloned, statErr)
+		}
+		if !resume && (!cloned || !os.IsNotExist(statErr)) {
+			t.Errorf("expected a new run to clone again, cloned %v, %v", cloned, statErr)
+		}
+	}
+}
+
 func TestDeployInfraSuccess(t *testing.T) {
-	withRuntimeStubs(t)
+	t.Parallel()
+	d, fake := testDeployer()
 	titvoDir := t.TempDir()
 	createRequiredInfraDirs(t, titvoDir)
-
-	successfulDeployStubs()
 	jobsSubmitted := 0
 	writtenParams := map[string]string{}
 	terragruntApplyDirs := []string{}
 	mcpRanNpm := false
 	mcpRanSubmodule := false
-	executeWithOptionsFn = func(command string, options *ExecuteOptions, args ...string) error {
+	d.Commands = commandFunc(func(ctx context.Context, command string, options *ExecuteOptions, args ...string) error {
 		if options != nil && strings.Contains(options.WorkingDir, "titvo-mcp-gateway") {
 			if command == "npm" {
 				mcpRanNpm = true
@@ -170,12 +354,12 @@ func TestDeployInfraSuccess(t *testing.T) {
 			terragruntApplyDirs = append(terragruntApplyDirs, options.WorkingDir)
 		}
 		return nil
-	}
-	putParameterFn = func(creds *AWSCredentials, path, value string) error {
+	})
+	fake.putParameter = func(path, value string) error {
 		writtenParams[path] = value
 		return nil
 	}
-	submitBatchJobFn = func(creds *AWSCredentials, jobName, jobQueue, jobDefinition string, envVars map[string]string) error {
+	fake.submitBatchJob = func(jobName, jobQueue, jobDefinition string, envVars map[string]string) error {
 		jobsSubmitted++
 		return nil
 	}
@@ -183,7 +367,7 @@ func TestDeployInfraSuccess(t *testing.T) {
 	config := validDeployConfig(titvoDir)
 	config.InstallToolConfig.OS = Windows
 	config.Debug = true
-	err := deployInfra(config)
+	err := d.DeployInfra(context.Background(), config)
 	if err != nil {
 		t.Fatalf("expected no error, got %v", err)
 	}
@@ -262,33 +446,31 @@ func TestDeployInfraSuccess(t *testing.T) {
 }
 
 func TestDeployInfraGetAccountIDError(t *testing.T) {
-	withRuntimeStubs(t)
+	t.Parallel()
+	d, fake := testDeployer()
 	titvoDir := t.TempDir()
 	createRequiredInfraDirs(t, titvoDir)
+	fake.getAccountID = func() (string, error) { return "", errors.New("sts error") }
 
-	successfulDeployStubs()
-	getAccountIDFn = func(creds *AWSCredentials) (string, error) { return "", errors.New("sts error") }
-
-	err := deployInfra(validDeployConfig(titvoDir))
+	err := d.DeployInfra(context.Background(), validDeployConfig(titvoDir))
 	if err == nil || err.Error() != "failed to get AWS account ID: sts error" {
 		t.Fatalf("unexpected error: %v", err)
 	}
 }
 
 func TestDeployInfraSubmitBatchJobError(t *testing.T) {
-	withRuntimeStubs(t)
+	t.Parallel()
+	d, fake := testDeployer()
 	titvoDir := t.TempDir()
 	createRequiredInfraDirs(t, titvoDir)
-
-	successfulDeployStubs()
-	submitBatchJobFn = func(creds *AWSCredentials, jobName, jobQueue, jobDefinition string, envVars map[string]string) error {
+	fake.submitBatchJob = func(jobName, jobQueue, jobDefinition string, envVars map[string]string) error {
 		if jobName == "installer-ecr-publisher-mcp-gateway" {
 			return errors.New("batch failed")
 		}
 		return nil
 	}
 
-	err := deployInfra(validDeployConfig(titvoDir))
+	err := d.DeployInfra(context.Background(), validDeployConfig(titvoDir))
 	if err == nil {
 		t.Fatalf("expected error")
 	}
@@ -305,51 +487,52 @@ func TestEnsureDirExistsMissing(t *testing.T) {
 }
 
 func TestRunBuildErrors(t *testing.T) {
-	withRuntimeStubs(t)
-	executeWithOptionsFn = func(command string, options *ExecuteOptions, args ...string) error {
+	t.Parallel()
+	runner := commandFunc(func(ctx context.Context, command string, options *ExecuteOptions, args ...string) error {
 		if command == "npm" && len(args) > 0 && args[0] == "ci" {
 			return errors.New("ci failed")
 		}
 		return nil
-	}
-	err := runBuild(t.TempDir(), 1)
+	})
+	err := runBuild(context.Background(), t.TempDir(), toolEnv{Runner: runner, Console: testConsole()}, 1)
 	if err == nil || err.Error() != "npm ci failed: ci failed" {
 		t.Fatalf("unexpected error: %v", err)
 	}
 }
 
 func TestRunBuildRunBuildError(t *testing.T) {
-	withRuntimeStubs(t)
-	executeWithOptionsFn = func(command string, options *ExecuteOptions, args ...string) error {
+	t.Parallel()
+	runner := commandFunc(func(ctx context.Context, command string, options *ExecuteOptions, args ...string) error {
 		if command == "npm" && len(args) > 1 && args[0] == "run" && args[1] == "build" {
 			return errors.New("build failed")
 		}
 		return nil
-	}
-	err := runBuild(t.TempDir(), 1)
+	})
+	err := runBuild(context.Background(), t.TempDir(), toolEnv{Runner: runner, Console: testConsole()}, 1)
 	if err == nil || err.Error() != "npm run build failed: build failed" {
 		t.Fatalf("unexpected error: %v", err)
 	}
 }
 
 func TestRunBuildZeroRepeats(t *testing.T) {
-	withRuntimeStubs(t)
-	executeWithOptionsFn = func(command string, options *ExecuteOptions, args ...string) error {
+	t.Parallel()
+	runner := commandFunc(func(ctx context.Context, command string, options *ExecuteOptions, args ...string) error {
 		t.Fatalf("execute should not be called")
 		return nil
-	}
-	if err := runBuild(t.TempDir(), 0); err != nil {
+	})
+	if err := runBuild(context.Background(), t.TempDir(), toolEnv{Runner: runner, Console: testConsole()}, 0); err != nil {
 		t.Fatalf("expected nil error, got %v", err)
 	}
 }
 
 func TestDeployNodeComponentMissingDir(t *testing.T) {
-	withRuntimeStubs(t)
+	t.Parallel()
+	d, _ := testDeployer()
 	downloadCalled := false
-	err := deployNodeComponent(t.TempDir(), "missing-repo", "comp", func(dir string) error {
+	err := d.deployNodeComponent(context.Background(), t.TempDir(), "missing-repo", "comp", func(ctx context.Context, sources SourceFetcher, dir string) error {
 		downloadCalled = true
 		return nil
-	}, map[string]string{}, 0, false)
+	}, toolEnv{Console: testConsole()}, 0, false)
 	if !downloadCalled {
 		t.Fatalf("expected download to be called")
 	}
@@ -359,94 +542,98 @@ func TestDeployNodeComponentMissingDir(t *testing.T) {
 }
 
 func TestDeployNodeComponentDownloadError(t *testing.T) {
-	withRuntimeStubs(t)
-	err := deployNodeComponent(t.TempDir(), "repo", "comp", func(dir string) error {
+	t.Parallel()
+	d, _ := testDeployer()
+	err := d.deployNodeComponent(context.Background(), t.TempDir(), "repo", "comp", func(ctx context.Context, sources SourceFetcher, dir string) error {
 		return errors.New("download failed")
-	}, map[string]string{}, 0, false)
+	}, toolEnv{Console: testConsole()}, 0, false)
 	if err == nil || err.Error() != "failed to download comp: download failed" {
 		t.Fatalf("unexpected error: %v", err)
 	}
 }
 
 func TestDeployNodeComponentSubmoduleError(t *testing.T) {
-	withRuntimeStubs(t)
+	t.Parallel()
+	d, _ := testDeployer()
 	infraDir := t.TempDir()
 	if err := os.MkdirAll(filepath.Join(infraDir, "repo", "aws"), 0o755); err != nil {
 		t.Fatal(err)
 	}
-	executeWithOptionsFn = func(command string, options *ExecuteOptions, args ...string) error {
+	runner := commandFunc(func(ctx context.Context, command string, options *ExecuteOptions, args ...string) error {
 		if command == "git" {
 			return errors.New("submodule failed")
 		}
 		return nil
-	}
-	err := deployNodeComponent(infraDir, "repo", "comp", func(dir string) error { return nil }, map[string]string{}, 1, true)
+	})
+	err := d.deployNodeComponent(context.Background(), infraDir, "repo", "comp", func(ctx context.Context, sources SourceFetcher, dir string) error { return nil }, toolEnv{Runner: runner, Console: testConsole()}, 1, true)
 	if err == nil || err.Error() != "git submodule update failed: submodule failed" {
 		t.Fatalf("unexpected error: %v", err)
 	}
 }
 
 func TestDeployNodeComponentTerragruntError(t *testing.T) {
-	withRuntimeStubs(t)
+	t.Parallel()
+	d, _ := testDeployer()
 	infraDir := t.TempDir()
 	if err := os.MkdirAll(filepath.Join(infraDir, "repo", "aws"), 0o755); err != nil {
 		t.Fatal(err)
 	}
-	executeWithOptionsFn = func(command string, options *ExecuteOptions, args ...string) error {
+	runner := commandFunc(func(ctx context.Context, command string, options *ExecuteOptions, args ...string) error {
 		if command == "terragrunt" {
 			return errors.New("apply failed")
 		}
 		return nil
-	}
-	err := deployNodeComponent(infraDir, "repo", "comp", func(dir string) error { return nil }, map[string]string{}, 0, false)
+	})
+	err := d.deployNodeComponent(context.Background(), infraDir, "repo", "comp", func(ctx context.Context, sources SourceFetcher, dir string) error { return nil }, toolEnv{Runner: runner, Console: testConsole()}, 0, false)
 	if err == nil || err.Error() != "terragrunt apply comp failed: apply failed" {
 		t.Fatalf("unexpected error: %v", err)
 	}
 }
 
 func TestDeployNodeComponentBuildError(t *testing.T) {
-	withRuntimeStubs(t)
+	t.Parallel()
+	d, _ := testDeployer()
 	infraDir := t.TempDir()
 	if err := os.MkdirAll(filepath.Join(infraDir, "repo", "aws"), 0o755); err != nil {
 		t.Fatal(err)
 	}
-	executeWithOptionsFn = func(command string, options *ExecuteOptions, args ...string) error {
+	runner := commandFunc(func(ctx context.Context, command string, options *ExecuteOptions, args ...string) error {
 		if command == "npm" && len(args) > 0 && args[0] == "ci" {
 			return errors.New("ci failed")
 		}
 		return nil
-	}
-	err := deployNodeComponent(infraDir, "repo", "comp", func(dir string) error { return nil }, map[string]string{}, 1, false)
+	})
+	err := d.deployNodeComponent(context.Background(), infraDir, "repo", "comp", func(ctx context.Context, sources SourceFetcher, dir string) error { return nil }, toolEnv{Runner: runner, Console: testConsole()}, 1, false)
 	if err == nil || err.Error() != "npm ci failed: ci failed" {
 		t.Fatalf("unexpected error: %v", err)
 	}
 }
 
 func TestDeployInfraDownloadInfraError(t *testing.T) {
-	withRuntimeStubs(t)
-	successfulDeployStubs()
-	downloadSourceFn = func(dir, sourceURL, component string) error {
+	t.Parallel()
+	d, _ := testDeployer()
+	d.Sources = sourceFunc(func(ctx context.Context, dir, sourceURL, component string) error {
 		if component == "infra" {
 			return errors.New("clone failed")
 		}
 		return nil
-	}
-	err := deployInfra(DeployConfig{InstallToolConfig: InstallToolConfig{TitvoDir: t.TempDir()}})
+	})
+	err := d.DeployInfra(context.Background(), DeployConfig{InstallToolConfig: InstallToolConfig{TitvoDir: t.TempDir()}})
 	if err == nil || err.Error() != "failed to download infra: clone failed" {
 		t.Fatalf("unexpected error: %v", err)
 	}
 }
 
 func TestDeployInfraGetParameterError(t *testing.T) {
-	withRuntimeStubs(t)
+	t.Parallel()
+	d, fake := testDeployer()
 	titvoDir := t.TempDir()
 	createRequiredInfraDirs(t, titvoDir)
-	successfulDeployStubs()
-	getParameterFn = func(creds *AWSCredentials, path string) (string, error) {
+	fake.getParameter = func(path string) (string, error) {
 		return "", errors.New("ssm failed")
 	}
 
-	err := deployInfra(validDeployConfig(titvoDir))
+	err := d.DeployInfra(context.Background(), validDeployConfig(titvoDir))
 	if err == nil || err.Error() != "failed to get ecr publisher job definition arn: ssm failed" {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -497,10 +684,10 @@ func TestDeployInfraOptionalSCMIntegrations(t *testing.T) {
 
 	for _, tc := range tests {
 		t.Run(tc.name, func(t *testing.T) {
-			withRuntimeStubs(t)
+			t.Parallel()
+			d, fake := testDeployer()
 			titvoDir := t.TempDir()
 			createRequiredInfraDirs(t, titvoDir)
-			successfulDeployStubs()
 
 			scmCreateSecretCalled := false
 			dynamoValues := map[string]string{}
@@ -508,13 +695,13 @@ func TestDeployInfraOptionalSCMIntegrations(t *testing.T) {
 			events := []string{}
 			terragruntApplyDirs := []string{}
 
-			createSecretFn = func(creds *AWSCredentials, name, secretValue string) (string, error) {
+			fake.ensureSecret = func(name, value string) (string, string, error) {
 				if name != "/tvo/security-scan/prod/aes_secret" {
 					scmCreateSecretCalled = true
 				}
-				return "arn:" + name, nil
+				return "arn:" + name, value, nil
 			}
-			putRecordFn = func(creds *AWSCredentials, tableName string, item map[string]interface{}) error {
+			fake.putRecord = func(tableName string, item map[string]interface{}) error {
 				if tableName != "tvo-security-scan-parameter-prod" {
 					t.Fatalf("unexpected table name: %s", tableName)
 				}
@@ -531,7 +718,7 @@ func TestDeployInfraOptionalSCMIntegrations(t *testing.T) {
 				events = append(events, "put_record")
 				return nil
 			}
-			executeWithOptionsFn = func(command string, options *ExecuteOptions, args ...string) error {
+			d.Commands = commandFunc(func(ctx context.Context, command string, options *ExecuteOptions, args ...string) error {
 				if command == "terragrunt" && options != nil && len(args) > 1 && args[0] == "run-all" && args[1] == "apply" {
 					terragruntApplyDirs = append(terragruntApplyDirs, options.WorkingDir)
 					if strings.Contains(options.WorkingDir, filepath.Join("prod", "us-east-1")) {
@@ -539,12 +726,12 @@ func TestDeployInfraOptionalSCMIntegrations(t *testing.T) {
 					}
 				}
 				return nil
-			}
+			})
 
 			config := validDeployConfig(titvoDir)
 			config.BitbucketAPIToken = tc.bitbucketAPIToken
 			config.GithubAccessToken = tc.githubAccessToken
-			if err := deployInfra(config); err != nil {
+			if err := d.DeployInfra(context.Background(), config); err != nil {
 				t.Fatalf("expected no error, got %v", err)
 			}
 
@@ -587,24 +774,18 @@ func TestDeployInfraOptionalSCMIntegrations(t *testing.T) {
 			}
 
 			if tc.bitbucketAPIToken != "" {
-				expectedEncrypted, err := encrypt(tc.bitbucketAPIToken, config.AESSecret)
-				if err != nil {
-					t.Fatalf("failed to encrypt expected bitbucket token: %v", err)
-				}
 				actualEncrypted := dynamoValues["bitbucket_api_token"]
-				if actualEncrypted != expectedEncrypted {
-					t.Fatalf("unexpected encrypted bitbucket api token")
+				decrypted, err := decryptValue(actualEncrypted, config.AESSecret)
+				if err != nil || decrypted != tc.bitbucketAPIToken || valueFormat(actualEncrypted) != EncryptionFormatGCM {
+					t.Fatalf("unexpected encrypted bitbucket api token %q: %v", actualEncrypted, err)
 				}
 			}
 
 			if tc.githubAccessToken != "" {
-				expectedEncrypted, err := encrypt(tc.githubAccessToken, config.AESSecret)
-				if err != nil {
-					t.Fatalf("failed to encrypt expected github token: %v", err)
-				}
 				actualEncrypted := dynamoValues["github_access_token"]
-				if actualEncrypted != expectedEncrypted {
-					t.Fatalf("unexpected encrypted github access token")
+				decrypted, err := decryptValue(actualEncrypted, config.AESSecret)
+				if err != nil || decrypted != tc.githubAccessToken || valueFormat(actualEncrypted) != EncryptionFormatGCM {
+					t.Fatalf("unexpected encrypted github access token %q: %v", actualEncrypted, err)
 				}
 			}
 
@@ -629,7 +810,7 @@ func TestDeployInfraAdditionalErrorPaths(t *testing.T) {
 	tests := []struct {
 		name         string
 		prepare      func(t *testing.T, titvoDir string)
-		mutate       func()
+		mutate       func(d *Deployer, fake *fakeAWS)
 		mutateConfig func(config *DeployConfig)
 		expected     string
 	}{
@@ -640,38 +821,39 @@ func TestDeployInfraAdditionalErrorPaths(t *testing.T) {
 					t.Fatal(err)
 				}
 			},
-			mutate:       func() {},
+			mutate:       func(d *Deployer, fake *fakeAWS) {},
 			mutateConfig: func(config *DeployConfig) {},
 			expected:     "source directory",
 		},
 		{
-			name:    "mkdir infra fails",
-			prepare: func(t *testing.T, titvoDir string) {},
-			mutate: func() {
-				mkdirAllFn = func(path string, perm os.FileMode) error { return errors.New("mkdir fail") }
+			name: "mkdir infra fails",
+			prepare: func(t *testing.T, titvoDir string) {
+				// A file where the infra directory should be makes MkdirAll fail
+				if err := os.WriteFile(filepath.Join(titvoDir, "infra"), nil, 0o644); err != nil {
+					t.Fatal(err)
+				}
 			},
+			mutate:       func(d *Deployer, fake *fakeAWS) {},
 			mutateConfig: func(config *DeployConfig) {},
-			expected:     "mkdir fail",
+			expected:     "not a directory",
 		},
 		{
-			name:    "plugin cache mkdir fails",
-			prepare: func(t *testing.T, titvoDir string) { createRequiredInfraDirs(t, titvoDir) },
-			mutate: func() {
-				mkdirAllFn = func(path string, perm os.FileMode) error {
-					if strings.Contains(path, "terraform-plugins") {
-						return errors.New("plugin mkdir fail")
-					}
-					return os.MkdirAll(path, perm)
+			name: "plugin cache mkdir fails",
+			prepare: func(t *testing.T, titvoDir string) {
+				createRequiredInfraDirs(t, titvoDir)
+				if err := os.WriteFile(filepath.Join(titvoDir, "terraform-plugins"), nil, 0o644); err != nil {
+					t.Fatal(err)
 				}
 			},
+			mutate:       func(d *Deployer, fake *fakeAWS) {},
 			mutateConfig: func(config *DeployConfig) {},
-			expected:     "failed to create plugin cache directory: plugin mkdir fail",
+			expected:     "failed to create plugin cache directory",
 		},
 		{
 			name:    "put parameter fails",
 			prepare: func(t *testing.T, titvoDir string) { createRequiredInfraDirs(t, titvoDir) },
-			mutate: func() {
-				putParameterFn = func(creds *AWSCredentials, path, value string) error {
+			mutate: func(d *Deployer, fake *fakeAWS) {
+				fake.putParameter = func(path, value string) error {
 					if strings.Contains(path, "vpc_id") {
 						return errors.New("ssm put fail")
 					}
@@ -681,22 +863,11 @@ func TestDeployInfraAdditionalErrorPaths(t *testing.T) {
 			mutateConfig: func(config *DeployConfig) {},
 			expected:     "failed to put parameter vpc-id: ssm put fail",
 		},
-		{
-			name:    "create secret fails",
-			prepare: func(t *testing.T, titvoDir string) { createRequiredInfraDirs(t, titvoDir) },
-			mutate: func() {
-				createSecretFn = func(creds *AWSCredentials, name, secretValue string) (string, error) {
-					return "", errors.New("secret fail")
-				}
-			},
-			mutateConfig: func(config *DeployConfig) {},
-			expected:     "failed to create secret aes_secret: secret fail",
-		},
 		{
 			name:    "put encryption key arn fails",
 			prepare: func(t *testing.T, titvoDir string) { createRequiredInfraDirs(t, titvoDir) },
-			mutate: func() {
-				putParameterFn = func(creds *AWSCredentials, path, value string) error {
+			mutate: func(d *Deployer, fake *fakeAWS) {
+				fake.putParameter = func(path, value string) error {
 					if strings.Contains(path, "secret/manager/arn") {
 						return errors.New("arn put fail")
 					}
@@ -709,8 +880,8 @@ func TestDeployInfraAdditionalErrorPaths(t *testing.T) {
 		{
 			name:    "put bitbucket dynamo parameter fails",
 			prepare: func(t *testing.T, titvoDir string) { createRequiredInfraDirs(t, titvoDir) },
-			mutate: func() {
-				putRecordFn = func(creds *AWSCredentials, tableName string, item map[string]interface{}) error {
+			mutate: func(d *Deployer, fake *fakeAWS) {
+				fake.putRecord = func(tableName string, item map[string]interface{}) error {
 					if item["parameter_id"] == "bitbucket_api_token" {
 						return errors.New("bitbucket dynamo put fail")
 					}
@@ -725,8 +896,8 @@ func TestDeployInfraAdditionalErrorPaths(t *testing.T) {
 		{
 			name:    "put github dynamo parameter fails",
 			prepare: func(t *testing.T, titvoDir string) { createRequiredInfraDirs(t, titvoDir) },
-			mutate: func() {
-				putRecordFn = func(creds *AWSCredentials, tableName string, item map[string]interface{}) error {
+			mutate: func(d *Deployer, fake *fakeAWS) {
+				fake.putRecord = func(tableName string, item map[string]interface{}) error {
 					if item["parameter_id"] == "github_access_token" {
 						return errors.New("github dynamo put fail")
 					}
@@ -741,13 +912,13 @@ func TestDeployInfraAdditionalErrorPaths(t *testing.T) {
 		{
 			name:    "base terragrunt fails",
 			prepare: func(t *testing.T, titvoDir string) { createRequiredInfraDirs(t, titvoDir) },
-			mutate: func() {
-				executeWithOptionsFn = func(command string, options *ExecuteOptions, args ...string) error {
+			mutate: func(d *Deployer, fake *fakeAWS) {
+				d.Commands = commandFunc(func(ctx context.Context, command string, options *ExecuteOptions, args ...string) error {
 					if command == "terragrunt" && options != nil && strings.Contains(options.WorkingDir, "prod/us-east-1") {
 						return errors.New("tg fail")
 					}
 					return nil
-				}
+				})
 			},
 			mutateConfig: func(config *DeployConfig) {},
 			expected:     "terragrunt apply failed: tg fail",
@@ -755,13 +926,13 @@ func TestDeployInfraAdditionalErrorPaths(t *testing.T) {
 		{
 			name:    "download ecr publisher fails",
 			prepare: func(t *testing.T, titvoDir string) { createRequiredInfraDirs(t, titvoDir) },
-			mutate: func() {
-				downloadSourceFn = func(dir, sourceURL, component string) error {
+			mutate: func(d *Deployer, fake *fakeAWS) {
+				d.Sources = sourceFunc(func(ctx context.Context, dir, sourceURL, component string) error {
 					if component == "installer ecr publisher" {
 						return errors.New("download fail")
 					}
 					return nil
-				}
+				})
 			},
 			mutateConfig: func(config *DeployConfig) {},
 			expected:     "failed to download installer ecr publisher: download fail",
@@ -769,13 +940,13 @@ func TestDeployInfraAdditionalErrorPaths(t *testing.T) {
 		{
 			name:    "ecr apply fails",
 			prepare: func(t *testing.T, titvoDir string) { createRequiredInfraDirs(t, titvoDir) },
-			mutate: func() {
-				executeWithOptionsFn = func(command string, options *ExecuteOptions, args ...string) error {
+			mutate: func(d *Deployer, fake *fakeAWS) {
+				d.Commands = commandFunc(func(ctx context.Context, command string, options *ExecuteOptions, args ...string) error {
 					if command == "terragrunt" && options != nil && strings.Contains(options.WorkingDir, "titvo-installer-ecr-publisher/aws") && len(args) > 1 && args[1] == "apply" {
 						return errors.New("ecr apply fail")
 					}
 					return nil
-				}
+				})
 			},
 			mutateConfig: func(config *DeployConfig) {},
 			expected:     "terragrunt apply installer ecr publisher failed: ecr apply fail",
@@ -783,8 +954,8 @@ func TestDeployInfraAdditionalErrorPaths(t *testing.T) {
 		{
 			name:    "get queue arn fails",
 			prepare: func(t *testing.T, titvoDir string) { createRequiredInfraDirs(t, titvoDir) },
-			mutate: func() {
-				getParameterFn = func(creds *AWSCredentials, path string) (string, error) {
+			mutate: func(d *Deployer, fake *fakeAWS) {
+				fake.getParameter = func(path string) (string, error) {
 					if strings.Contains(path, "job_queue_arn") {
 						return "", errors.New("queue fail")
 					}
@@ -797,13 +968,13 @@ func TestDeployInfraAdditionalErrorPaths(t *testing.T) {
 		{
 			name:    "destroy ecr publisher fails",
 			prepare: func(t *testing.T, titvoDir string) { createRequiredInfraDirs(t, titvoDir) },
-			mutate: func() {
-				executeWithOptionsFn = func(command string, options *ExecuteOptions, args ...string) error {
+			mutate: func(d *Deployer, fake *fakeAWS) {
+				d.Commands = commandFunc(func(ctx context.Context, command string, options *ExecuteOptions, args ...string) error {
 					if command == "terragrunt" && options != nil && strings.Contains(options.WorkingDir, "titvo-installer-ecr-publisher/aws") && len(args) > 1 && args[1] == "destroy" {
 						return errors.New("destroy fail")
 					}
 					return nil
-				}
+				})
 			},
 			mutateConfig: func(config *DeployConfig) {},
 			expected:     "terragrunt destroy installer ecr publisher failed: destroy fail",
@@ -811,13 +982,13 @@ func TestDeployInfraAdditionalErrorPaths(t *testing.T) {
 		{
 			name:    "second stage component fails",
 			prepare: func(t *testing.T, titvoDir string) { createRequiredInfraDirs(t, titvoDir) },
-			mutate: func() {
-				downloadSourceFn = func(dir, sourceURL, component string) error {
+			mutate: func(d *Deployer, fake *fakeAWS) {
+				d.Sources = sourceFunc(func(ctx context.Context, dir, sourceURL, component string) error {
 					if component == "bitbucket code insights aws" {
 						return errors.New("bitbucket download fail")
 					}
 					return nil
-				}
+				})
 			},
 			mutateConfig: func(config *DeployConfig) {
 				config.BitbucketAPIToken = "bb-token"
@@ -827,13 +998,13 @@ func TestDeployInfraAdditionalErrorPaths(t *testing.T) {
 		{
 			name:    "first stage component fails",
 			prepare: func(t *testing.T, titvoDir string) { createRequiredInfraDirs(t, titvoDir) },
-			mutate: func() {
-				downloadSourceFn = func(dir, sourceURL, component string) error {
+			mutate: func(d *Deployer, fake *fakeAWS) {
+				d.Sources = sourceFunc(func(ctx context.Context, dir, sourceURL, component string) error {
 					if component == "auth setup" {
 						return errors.New("auth download fail")
 					}
 					return nil
-				}
+				})
 			},
 			mutateConfig: func(config *DeployConfig) {},
 			expected:     "failed to download auth setup: auth download fail",
@@ -841,13 +1012,13 @@ func TestDeployInfraAdditionalErrorPaths(t *testing.T) {
 		{
 			name:    "MCP ecr apply fails",
 			prepare: func(t *testing.T, titvoDir string) { createRequiredInfraDirs(t, titvoDir) },
-			mutate: func() {
-				executeWithOptionsFn = func(command string, options *ExecuteOptions, args ...string) error {
+			mutate: func(d *Deployer, fake *fakeAWS) {
+				d.Commands = commandFunc(func(ctx context.Context, command string, options *ExecuteOptions, args ...string) error {
 					if command == "terragrunt" && options != nil && strings.Contains(options.WorkingDir, "titvo-mcp-gateway/aws/ecr") && len(args) > 1 && args[1] == "apply" {
 						return errors.New("mcp ecr apply fail")
 					}
 					return nil
-				}
+				})
 			},
 			mutateConfig: func(config *DeployConfig) {},
 			expected:     "terragrunt apply MCP gateway ECR failed: mcp ecr apply fail",
@@ -860,7 +1031,7 @@ func TestDeployInfraAdditionalErrorPaths(t *testing.T) {
 					t.Fatal(err)
 				}
 			},
-			mutate:       func() {},
+			mutate:       func(d *Deployer, fake *fakeAWS) {},
 			mutateConfig: func(config *DeployConfig) {},
 			expected:     "installer ecr publisher directory",
 		},
@@ -868,14 +1039,14 @@ func TestDeployInfraAdditionalErrorPaths(t *testing.T) {
 
 	for _, tc := range tests {
 		t.Run(tc.name, func(t *testing.T) {
-			withRuntimeStubs(t)
+			t.Parallel()
+			d, fake := testDeployer()
 			titvoDir := t.TempDir()
-			successfulDeployStubs()
 			tc.prepare(t, titvoDir)
-			tc.mutate()
+			tc.mutate(d, fake)
 			config := validDeployConfig(titvoDir)
 			tc.mutateConfig(&config)
-			err := deployInfra(config)
+			err := d.DeployInfra(context.Background(), config)
 			if err == nil || !strings.Contains(err.Error(), tc.expected) {
 				t.Fatalf("unexpected error: %v", err)
 			}
@@ -884,13 +1055,155 @@ func TestDeployInfraAdditionalErrorPaths(t *testing.T) {
 }
 
 func TestDeployInfraWithoutSessionToken(t *testing.T) {
-	withRuntimeStubs(t)
+	t.Parallel()
+	d, _ := testDeployer()
 	titvoDir := t.TempDir()
 	createRequiredInfraDirs(t, titvoDir)
-	successfulDeployStubs()
 	config := validDeployConfig(titvoDir)
 	config.AWSCredentials.AWSSessionToken = ""
-	if err := deployInfra(config); err != nil {
+	if err := d.DeployInfra(context.Background(), config); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+}
+
+func TestDeployInfraToolEnvironment(t *testing.T) {
+	tests := []struct {
+		os        OS
+		separator string
+	}{
+		{os: Linux, separator: ":"},
+		{os: Windows, separator: ";"},
+	}
+	for _, tc := range tests {
+		t.Run(string(tc.os), func(t *testing.T) {
+			t.Parallel()
+			d, _ := testDeployer()
+			titvoDir := t.TempDir()
+			createRequiredInfraDirs(t, titvoDir)
+			var pathEnv string
+			d.Commands = commandFunc(func(ctx context.Context, command string, options *ExecuteOptions, args ...string) error {
+				if command == "terragrunt" || command == "npm" {
+					if options.Resolver == nil {
+						t.Fatalf("expected %s to run with the titvo tool resolver", command)
+					}
+					if pathEnv == "" {
+						pathEnv = options.Env["PATH"]
+					}
+				}
+				return nil
+			})
+			config := validDeployConfig(titvoDir)
+			config.InstallToolConfig.OS = tc.os
+			if err := d.DeployInfra(context.Background(), config); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			expectedPrefix := strings.Join([]string{"tf", "tg", "node"}, tc.separator)
+			if !strings.HasPrefix(pathEnv, expectedPrefix+tc.separator) {
+				t.Fatalf("expected titvo tools first in PATH, got %q", pathEnv)
+			}
+		})
+	}
+}
+
+func TestDeployInfraMCPGatewayEnvironment(t *testing.T) {
+	t.Parallel()
+	d, _ := testDeployer()
+	titvoDir := t.TempDir()
+	createRequiredInfraDirs(t, titvoDir)
+	var mu sync.Mutex
+	gatewayEnv := map[string]string{}
+	d.Commands = commandFunc(func(ctx context.Context, command string, options *ExecuteOptions, args ...string) error {
+		mu.Lock()
+		defer mu.Unlock()
+		gatewayDeploy := strings.Contains(options.WorkingDir, "titvo-mcp-gateway") && !strings.HasSuffix(options.WorkingDir, "ecr")
+		for _, name := range []string{"MCP_GATEWAY_HOSTNAME", "MCP_GATEWAY_PORT", "MCP_GATEWAY_NAMESPACE"} {
+			value, ok := options.Env[name]
+			if ok != gatewayDeploy {
+				t.Errorf("expected %s only in the MCP gateway deployment, got it in %s", name, options.WorkingDir)
+			}
+			if ok {
+				gatewayEnv[name] = value
+			}
+		}
+		return nil
+	})
+	if err := d.DeployInfra(context.Background(), validDeployConfig(titvoDir)); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	expected := map[string]string{"MCP_GATEWAY_HOSTNAME": "mcp", "MCP_GATEWAY_PORT": "8080", "MCP_GATEWAY_NAMESPACE": "svc.example.internal"}
+	if !maps.Equal(gatewayEnv, expected) {
+		t.Fatalf("expected the MCP gateway environment %v, got %v", expected, gatewayEnv)
+	}
+}
+
+func TestDeployInfraKMSFormatParameters(t *testing.T) {
+	t.Parallel()
+	d, fake := testDeployer()
+	titvoDir := t.TempDir()
+	createRequiredInfraDirs(t, titvoDir)
+	var mu sync.Mutex
+	written := map[string]string{}
+	fake.putParameter = func(path, value string) error {
+		mu.Lock()
+		defer mu.Unlock()
+		written[path] = value
+		return nil
+	}
+	config := validDeployConfig(titvoDir)
+	config.EncryptionFormat, config.KMSKeyARN, config.AESSecret = EncryptionFormatKMS, "arn:aws:kms:us-east-1:123456789012:key/key-1", ""
+	config.GithubAccessToken = "ghp_kms_access_token"
+	if err := d.DeployInfra(context.Background(), config); err != nil {
 		t.Fatalf("expected no error, got %v", err)
 	}
+	// The base infra modules read the AES secret parameters in every format
+	for path, expected := range map[string]string{
+		"/tvo/security-scan/prod/infra/kms/encryption-key-name": aesSecretName,
+		"/tvo/security-scan/prod/infra/secret/manager/arn":      "arn:aws:secretsmanager:secret",
+		kmsKeyParameter: config.KMSKeyARN,
+	} {
+		if written[path] != expected {
+			t.Errorf("expected parameter %s to be %q, got %q", path, expected, written[path])
+		}
+	}
+	for path, value := range written {
+		if strings.Contains(value, config.GithubAccessToken) {
+			t.Errorf("expected no sensitive value in the SSM String parameter %s", path)
+		}
+	}
+}
+
+func TestDeployInfraWritesComponentLogs(t *testing.T) {
+	t.Parallel()
+	d, _ := testDeployer()
+	titvoDir := t.TempDir()
+	createRequiredInfraDirs(t, titvoDir)
+	d.Commands = commandFunc(func(ctx context.Context, command string, options *ExecuteOptions, args ...string) error {
+		if command == "terragrunt" {
+			if options.Output == nil {
+				t.Fatalf("expected terragrunt output to be logged")
+			}
+			fmt.Fprintf(options.Output, "%s %s\n", command, strings.Join(args, " "))
+		}
+		return nil
+	})
+	logs, err := newRunLogger(titvoDir, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer logs.Close()
+	config := validDeployConfig(titvoDir)
+	config.Logs = logs
+	if err := d.DeployInfra(context.Background(), config); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	for _, component := range []string{"base infra", "agent aws", "MCP gateway"} {
+		content, err := os.ReadFile(logs.componentLogPath(component))
+		if err != nil {
+			t.Fatalf("expected %s log, got %v", component, err)
+		}
+		if !strings.Contains(string(content), "terragrunt run-all apply") {
+			t.Fatalf("expected %s log to contain the command output, got %q", component, content)
+		}
+	}
 }
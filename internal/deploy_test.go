@@ -1,32 +1,15 @@
 package internal
 
 import (
+	"context"
 	"errors"
 	"testing"
 )
 
-func withDownloadSourceStub(t *testing.T, stub func(dir, sourceURL, component string) error) {
-	t.Helper()
-	original := downloadSourceFn
-	downloadSourceFn = stub
-	t.Cleanup(func() {
-		downloadSourceFn = original
-	})
-}
-
-func withDeployInfraStub(t *testing.T, stub func(config DeployConfig) error) {
-	t.Helper()
-	original := deployInfraFn
-	deployInfraFn = stub
-	t.Cleanup(func() {
-		deployInfraFn = original
-	})
-}
-
-func TestDownloadFunctionsRouteToDownloadSource(t *testing.T) {
+func TestDownloadFunctionsRouteToSourceFetcher(t *testing.T) {
 	tests := []struct {
 		name           string
-		call           func(string) error
+		call           sourceDownload
 		expectedURL    string
 		expectedTarget string
 	}{
@@ -46,8 +29,9 @@ func TestDownloadFunctionsRouteToDownloadSource(t *testing.T) {
 
 	for _, tc := range tests {
 		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
 			called := false
-			withDownloadSourceStub(t, func(dir, sourceURL, component string) error {
+			sources := sourceFunc(func(ctx context.Context, dir, sourceURL, component string) error {
 				called = true
 				if dir != "/tmp/titvo" {
 					t.Fatalf("unexpected dir: %s", dir)
@@ -61,11 +45,11 @@ func TestDownloadFunctionsRouteToDownloadSource(t *testing.T) {
 				return nil
 			})
 
-			if err := tc.call("/tmp/titvo"); err != nil {
+			if err := tc.call(context.Background(), sources, "/tmp/titvo"); err != nil {
 				t.Fatalf("expected nil error, got %v", err)
 			}
 			if !called {
-				t.Fatalf("expected downloadSourceFn to be called")
+				t.Fatalf("expected the source fetcher to be called")
 			}
 		})
 	}
@@ -73,11 +57,11 @@ func TestDownloadFunctionsRouteToDownloadSource(t *testing.T) {
 
 func TestDownloadInfraSourcePropagatesError(t *testing.T) {
 	expectedErr := errors.New("clone failed")
-	withDownloadSourceStub(t, func(dir, sourceURL, component string) error {
+	sources := sourceFunc(func(ctx context.Context, dir, sourceURL, component string) error {
 		return expectedErr
 	})
 
-	err := DownloadInfraSource("/tmp/titvo")
+	err := DownloadInfraSource(context.Background(), sources, "/tmp/titvo")
 	if !errors.Is(err, expectedErr) {
 		t.Fatalf("expected wrapped error %v, got %v", expectedErr, err)
 	}
@@ -127,21 +111,3 @@ func TestInstallerECRPublisherJobsUsesProvidedRegion(t *testing.T) {
 		}
 	}
 }
-
-func TestDeployInfraDelegatesToRuntime(t *testing.T) {
-	expected := errors.New("runtime failure")
-	received := DeployConfig{}
-	withDeployInfraStub(t, func(config DeployConfig) error {
-		received = config
-		return expected
-	})
-
-	config := DeployConfig{VPCID: "vpc-123"}
-	err := DeployInfra(config)
-	if !errors.Is(err, expected) {
-		t.Fatalf("expected error %v, got %v", expected, err)
-	}
-	if received.VPCID != "vpc-123" {
-		t.Fatalf("expected config to be delegated")
-	}
-}
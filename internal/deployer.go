@@ -0,0 +1,150 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+)
+
+// CommandRunner runs the external tools (git, terragrunt, npm) of a deployment.
+type CommandRunner interface {
+	Run(ctx context.Context, command string, options *ExecuteOptions, args ...string) error
+}
+
+// ParameterStore reads and writes SSM parameters.
+type ParameterStore interface {
+	GetParameter(ctx context.Context, path string) (string, error)
+	PutParameter(ctx context.Context, path, value string) error
+}
+
+// SecretStore reads, creates and rotates Secrets Manager secrets.
+type SecretStore interface {
+	GetSecret(ctx context.Context, name string) (string, error)
+	// EnsureSecret creates a secret unless it exists and returns its ARN and
+	// current value.
+	EnsureSecret(ctx context.Context, name, value string) (string, string, error)
+	// StageSecret stores a pending version of a secret, PromoteSecret makes it
+	// the current one and UnstageSecret discards it.
+	StageSecret(ctx context.Context, name, value string) (string, error)
+	PromoteSecret(ctx context.Context, name, versionID string) error
+	UnstageSecret(ctx context.Context, name, versionID string) error
+}
+
+// KeyManager manages the customer-managed KMS key of the kms encryption
+// format and grants it to IAM roles.
+type KeyManager interface {
+	EnsureKey(ctx context.Context, keyID, alias string, tags map[string]string) (string, error)
+	// GrantKey returns false when roleARN already holds the grant.
+	GrantKey(ctx context.Context, keyARN, grantName, roleARN string) (bool, error)
+	EncryptWithKey(ctx context.Context, keyARN string, plaintext []byte) ([]byte, error)
+}
+
+// BatchRunner submits an AWS Batch job and waits for it to finish.
+type BatchRunner interface {
+	SubmitBatchJob(ctx context.Context, jobName, jobQueue, jobDefinition string, envVars map[string]string) error
+	// JobRoleARN returns the IAM role of the jobs of the latest active
+	// revision of a job definition.
+	JobRoleARN(ctx context.Context, jobDefinition string) (string, error)
+}
+
+// RolePolicies writes the inline policies of IAM roles.
+type RolePolicies interface {
+	PutRolePolicy(ctx context.Context, roleARN, policyName, document string) error
+}
+
+// RecordStore reads and writes items of DynamoDB tables.
+type RecordStore interface {
+	PutRecord(ctx context.Context, tableName string, item map[string]interface{}) error
+	// PutNewRecord fails with ErrRecordExists when an item with the same
+	// keyAttribute already exists.
+	PutNewRecord(ctx context.Context, tableName, keyAttribute string, item map[string]interface{}) error
+	FindRecords(ctx context.Context, tableName, attribute, value string) ([]map[string]interface{}, error)
+	ListRecords(ctx context.Context, tableName string) ([]map[string]interface{}, error)
+	DeleteRecord(ctx context.Context, tableName, keyAttribute, keyValue string) error
+}
+
+// AccountResolver returns the AWS account the deployment runs in.
+type AccountResolver interface {
+	GetAccountID(ctx context.Context) (string, error)
+}
+
+// SourceFetcher checks out the source repository of a component into dir.
+type SourceFetcher interface {
+	FetchSource(ctx context.Context, dir, sourceURL, component string) error
+}
+
+// ServiceResolver finds the instances of a Cloud Map service.
+type ServiceResolver interface {
+	ResolveService(ctx context.Context, namespace, service string) ([]string, error)
+}
+
+// Deployer bundles the services used to deploy and configure Titvo, so every
+// dependency can be replaced in tests.
+type Deployer struct {
+	Commands   CommandRunner
+	Parameters ParameterStore
+	Secrets    SecretStore
+	Keys       KeyManager
+	Batch      BatchRunner
+	Roles      RolePolicies
+	Records    RecordStore
+	Accounts   AccountResolver
+	Sources    SourceFetcher
+	Services   ServiceResolver
+	// Console receives the progress messages of the deployment.
+	Console *Console
+}
+
+// NewDeployer returns a Deployer that runs real commands and talks to AWS
+// through session. resume keeps the source checkouts of the previous run.
+func NewDeployer(session *AWSSession, console *Console, resume bool) *Deployer {
+	commands := execRunner{}
+	return &Deployer{
+		Commands:   commands,
+		Parameters: session,
+		Secrets:    session,
+		Keys:       session,
+		Batch:      session,
+		Roles:      session,
+		Records:    session,
+		Accounts:   session,
+		Sources:    gitSourceFetcher{commands: commands, console: console, resume: resume},
+		Services:   session,
+		Console:    console,
+	}
+}
+
+// execRunner runs commands with ExecuteWithOptions.
+type execRunner struct{}
+
+func (execRunner) Run(ctx context.Context, command string, options *ExecuteOptions, args ...string) error {
+	return ExecuteWithOptions(ctx, command, options, args...)
+}
+
+// gitSourceFetcher clones component repositories with git.
+type gitSourceFetcher struct {
+	commands CommandRunner
+	console  *Console
+	// resume reuses the checkouts of the interrupted run, which the
+	// completed steps were deployed from.
+	resume bool
+}
+
+func (g gitSourceFetcher) FetchSource(ctx context.Context, dir, sourceURL, component string) error {
+	repoDir := path.Join(dir, strings.TrimSuffix(path.Base(sourceURL), ".git"))
+	if _, err := os.Stat(path.Join(repoDir, ".git")); err == nil && g.resume {
+		g.console.printInfo(fmt.Sprintf("Using existing %s checkout in %s", component, repoDir))
+		return nil
+	}
+	// A new run deploys the current sources, not those of an earlier run
+	if err := os.RemoveAll(repoDir); err != nil {
+		return fmt.Errorf("failed to remove the previous %s checkout: %w", component, err)
+	}
+	if err := g.commands.Run(ctx, "git", &ExecuteOptions{WorkingDir: dir, Console: g.console}, "clone", sourceURL); err != nil {
+		return err
+	}
+	g.console.printInfo(fmt.Sprintf("Downloaded %s from %s to %s", component, sourceURL, dir))
+	return nil
+}
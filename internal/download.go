@@ -0,0 +1,272 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/term"
+)
+
+const (
+	downloadMaxAttempts    = 5
+	downloadInitialBackoff = 2 * time.Second
+	downloadMaxBackoff     = 30 * time.Second
+	// downloadAttemptTimeout bounds a single attempt; an interrupted attempt is
+	// resumed by the next one, so this does not cap the size of the file.
+	downloadAttemptTimeout  = 10 * time.Minute
+	downloadProgressRefresh = 200 * time.Millisecond
+	partialFileSuffix       = ".part"
+)
+
+// httpStatusError is returned when the server answers with a non-2xx status.
+type httpStatusError struct {
+	URL        string
+	StatusCode int
+}
+
+func (e *httpStatusError) Error() string {
+	return fmt.Sprintf("download %s failed: unexpected HTTP status %d %s", e.URL, e.StatusCode, http.StatusText(e.StatusCode))
+}
+
+// retryable reports whether the status is worth retrying. Client errors such as
+// 404 are permanent; server errors and throttling are not.
+func (e *httpStatusError) retryable() bool {
+	return e.StatusCode >= 500 || e.StatusCode == http.StatusTooManyRequests || e.StatusCode == http.StatusRequestTimeout
+}
+
+// downloader fetches files over HTTP with retries, resume and atomic writes.
+type downloader struct {
+	client      *http.Client
+	maxAttempts int
+	backoff     time.Duration
+	maxBackoff  time.Duration
+	// progress receives the progress bar; nil disables it.
+	progress io.Writer
+	console  *Console
+}
+
+func newDownloader(console *Console) *downloader {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = (&net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}).DialContext
+	transport.TLSHandshakeTimeout = 15 * time.Second
+	transport.ResponseHeaderTimeout = 30 * time.Second
+
+	d := &downloader{
+		client:      &http.Client{Transport: transport, Timeout: downloadAttemptTimeout},
+		maxAttempts: downloadMaxAttempts,
+		backoff:     downloadInitialBackoff,
+		maxBackoff:  downloadMaxBackoff,
+		console:     console,
+	}
+	if out, ok := console.Reporter.CommandOutput().(*os.File); ok && term.IsTerminal(int(out.Fd())) {
+		d.progress = out
+	}
+	return d
+}
+
+func downloadFile(ctx context.Context, console *Console, url string, dir string, fileName string) error {
+	return newDownloader(console).Download(ctx, url, dir, fileName)
+}
+
+// Download stores url in dir/fileName. Data is written to a ".part" file that
+// is renamed into place only once the download is complete, so a failed or
+// interrupted download never leaves a truncated file behind. A ".part" file
+// left by a previous attempt is resumed with a Range request.
+func (d *downloader) Download(ctx context.Context, url string, dir string, fileName string) error {
+	target := filepath.Join(dir, fileName)
+	partial := target + partialFileSuffix
+
+	var err error
+	for attempt := 1; attempt <= d.maxAttempts; attempt++ {
+		err = d.fetch(ctx, url, partial)
+		if err == nil {
+			break
+		}
+		if ctx.Err() != nil {
+			// Keep the partial file so the next run can resume it
+			return fmt.Errorf("download %s interrupted: %w", url, ctx.Err())
+		}
+		var statusErr *httpStatusError
+		if errors.As(err, &statusErr) && !statusErr.retryable() {
+			os.Remove(partial)
+			return err
+		}
+		if attempt == d.maxAttempts {
+			break
+		}
+		wait := d.backoffFor(attempt)
+		d.console.printInfo(fmt.Sprintf("Download attempt %d/%d failed: %v. Retrying in %s", attempt, d.maxAttempts, err, wait))
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("download %s interrupted: %w", url, ctx.Err())
+		case <-time.After(wait):
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("download %s failed after %d attempts: %w", url, d.maxAttempts, err)
+	}
+	if err := os.Rename(partial, target); err != nil {
+		return fmt.Errorf("failed to move download into place: %w", err)
+	}
+	return nil
+}
+
+func (d *downloader) backoffFor(attempt int) time.Duration {
+	wait := d.backoff << (attempt - 1)
+	if wait > d.maxBackoff || wait <= 0 {
+		wait = d.maxBackoff
+	}
+	return wait
+}
+
+// fetch performs a single attempt, appending to partial when the server
+// supports ranges and starting over otherwise.
+func (d *downloader) fetch(ctx context.Context, url string, partial string) error {
+	var offset int64
+	if info, err := os.Stat(partial); err == nil {
+		offset = info.Size()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	flags := os.O_WRONLY | os.O_CREATE
+	switch {
+	case resp.StatusCode == http.StatusPartialContent && offset > 0:
+		start, ok := contentRangeStart(resp.Header.Get("Content-Range"))
+		if !ok || start != offset {
+			// The server returned a different range than requested; start over.
+			os.Remove(partial)
+			return fmt.Errorf("unexpected Content-Range %q resuming at byte %d", resp.Header.Get("Content-Range"), offset)
+		}
+		flags |= os.O_APPEND
+	case resp.StatusCode == http.StatusRequestedRangeNotSatisfiable && offset > 0:
+		// The partial file does not match the remote file anymore.
+		os.Remove(partial)
+		return fmt.Errorf("cannot resume download of %s, restarting", url)
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		offset = 0
+		flags |= os.O_TRUNC
+	default:
+		return &httpStatusError{URL: url, StatusCode: resp.StatusCode}
+	}
+
+	out, err := os.OpenFile(partial, flags, 0644)
+	if err != nil {
+		return err
+	}
+
+	var total int64 = -1
+	if resp.ContentLength >= 0 {
+		total = offset + resp.ContentLength
+	}
+	var writer io.Writer = out
+	var bar *progressBar
+	if d.progress != nil {
+		bar = newProgressBar(d.progress, offset, total)
+		writer = io.MultiWriter(out, bar)
+	}
+	written, copyErr := io.Copy(writer, resp.Body)
+	if bar != nil {
+		bar.finish()
+	}
+	if err := out.Close(); err != nil && copyErr == nil {
+		copyErr = err
+	}
+	if copyErr != nil {
+		return copyErr
+	}
+	if resp.ContentLength >= 0 && written != resp.ContentLength {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+
+// contentRangeStart parses the first byte position of a "bytes a-b/c" header.
+func contentRangeStart(header string) (int64, bool) {
+	spec, ok := strings.CutPrefix(header, "bytes ")
+	if !ok {
+		return 0, false
+	}
+	start, _, ok := strings.Cut(spec, "-")
+	if !ok {
+		return 0, false
+	}
+	value, err := strconv.ParseInt(start, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return value, true
+}
+
+// progressBar renders download progress on a single terminal line.
+type progressBar struct {
+	out        io.Writer
+	current    int64
+	total      int64
+	lastRender time.Time
+}
+
+func newProgressBar(out io.Writer, current, total int64) *progressBar {
+	return &progressBar{out: out, current: current, total: total}
+}
+
+func (p *progressBar) Write(b []byte) (int, error) {
+	p.current += int64(len(b))
+	if time.Since(p.lastRender) >= downloadProgressRefresh {
+		p.render()
+	}
+	return len(b), nil
+}
+
+func (p *progressBar) render() {
+	p.lastRender = time.Now()
+	const width = 30
+	if p.total <= 0 {
+		fmt.Fprintf(p.out, "\r%s downloaded", formatBytes(p.current))
+		return
+	}
+	ratio := float64(p.current) / float64(p.total)
+	if ratio > 1 {
+		ratio = 1
+	}
+	filled := int(ratio * width)
+	fmt.Fprintf(p.out, "\r[%s%s] %3.0f%% %s / %s", strings.Repeat("=", filled), strings.Repeat(" ", width-filled), ratio*100, formatBytes(p.current), formatBytes(p.total))
+}
+
+func (p *progressBar) finish() {
+	p.render()
+	fmt.Fprintln(p.out)
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for value := n / unit; value >= unit; value /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
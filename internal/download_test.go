@@ -0,0 +1,153 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func testDownloader() *downloader {
+	return &downloader{
+		client:      http.DefaultClient,
+		maxAttempts: 3,
+		backoff:     time.Millisecond,
+		maxBackoff:  time.Millisecond,
+		console:     testConsole(),
+	}
+}
+
+func TestDownloadRejectsNonSuccessStatus(t *testing.T) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		http.Error(w, "<html>not found</html>", http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	err := testDownloader().Download(context.Background(), server.URL, dir, "terraform.zip")
+	var statusErr *httpStatusError
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected 404 status error, got %v", err)
+	}
+	if requests != 1 {
+		t.Fatalf("expected client errors not to be retried, got %d requests", requests)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "terraform.zip")); !os.IsNotExist(err) {
+		t.Fatalf("expected no file to be written for a failed download")
+	}
+}
+
+func TestDownloadRetriesServerErrors(t *testing.T) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1) < 3 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.Write([]byte("payload"))
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	if err := testDownloader().Download(context.Background(), server.URL, dir, "file.bin"); err != nil {
+		t.Fatalf("expected download to succeed after retries, got %v", err)
+	}
+	content, err := os.ReadFile(filepath.Join(dir, "file.bin"))
+	if err != nil || string(content) != "payload" {
+		t.Fatalf("unexpected content %q (%v)", content, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "file.bin"+partialFileSuffix)); !os.IsNotExist(err) {
+		t.Fatalf("expected partial file to be renamed")
+	}
+}
+
+func TestDownloadGivesUpAfterMaxAttempts(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	err := testDownloader().Download(context.Background(), server.URL, dir, "file.bin")
+	if err == nil || !strings.Contains(err.Error(), "after 3 attempts") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "file.bin")); !os.IsNotExist(err) {
+		t.Fatalf("expected no file to be written for a failed download")
+	}
+}
+
+func TestDownloadResumesPartialFile(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 100)
+	var rangeHeader string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rangeHeader = r.Header.Get("Range")
+		http.ServeContent(w, r, "node.tar.gz", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	partial := filepath.Join(dir, "node.tar.gz"+partialFileSuffix)
+	if err := os.WriteFile(partial, content[:400], 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := testDownloader().Download(context.Background(), server.URL, dir, "node.tar.gz"); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if rangeHeader != "bytes=400-" {
+		t.Fatalf("expected resume range request, got %q", rangeHeader)
+	}
+	got, err := os.ReadFile(filepath.Join(dir, "node.tar.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Fatalf("resumed file does not match the remote file")
+	}
+}
+
+func TestDownloadRestartsWhenRangeIsIgnored(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("fresh"))
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "file.bin"+partialFileSuffix), []byte("stale-data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := testDownloader().Download(context.Background(), server.URL, dir, "file.bin"); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	got, _ := os.ReadFile(filepath.Join(dir, "file.bin"))
+	if string(got) != "fresh" {
+		t.Fatalf("expected partial file to be replaced, got %q", got)
+	}
+}
+
+func TestDownloadRendersProgress(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(bytes.Repeat([]byte("x"), 2048))
+	}))
+	defer server.Close()
+
+	var progress bytes.Buffer
+	d := testDownloader()
+	d.progress = &progress
+	if err := d.Download(context.Background(), server.URL, t.TempDir(), "file.bin"); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if !strings.Contains(progress.String(), "100%") {
+		t.Fatalf("expected completed progress bar, got %q", progress.String())
+	}
+}
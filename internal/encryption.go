@@ -0,0 +1,297 @@
+package internal
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"maps"
+	"slices"
+
+	"github.com/spf13/cobra"
+)
+
+// AESSecret is the AES key of an installation, stored base64 encoded in
+// Secrets Manager.
+type AESSecret struct {
+	Key string
+	ARN string
+	// Generated reports that the key was generated by this run and Reused that
+	// an existing secret was kept instead of the key given.
+	Generated bool
+	Reused    bool
+}
+
+// EnsureAESSecret returns the AES secret of the installation. An existing
+// secret is reused, never overwritten, as the stored values are encrypted
+// with it; otherwise the secret is created with key, or with a random key
+// when key is empty.
+func (d *Deployer) EnsureAESSecret(ctx context.Context, key string) (*AESSecret, error) {
+	generated := key == ""
+	if generated {
+		var err error
+		if key, err = generateAESKey(); err != nil {
+			return nil, err
+		}
+	}
+	arn, current, err := d.Secrets.EnsureSecret(ctx, aesSecretName, base64.StdEncoding.EncodeToString([]byte(key)))
+	if err != nil {
+		return nil, err
+	}
+	currentKey, err := base64.StdEncoding.DecodeString(current)
+	if err != nil {
+		return nil, fmt.Errorf("AES secret %s is not base64 encoded: %w", aesSecretName, err)
+	}
+	if string(currentKey) != key {
+		return &AESSecret{Key: string(currentKey), ARN: arn, Reused: true}, nil
+	}
+	return &AESSecret{Key: key, ARN: arn, Generated: generated}, nil
+}
+
+// EncryptionManager manages the values of the parameter table encrypted with
+// the AES secret of Secrets Manager.
+type EncryptionManager struct {
+	Parameters ParameterStore
+	Secrets    SecretStore
+	Records    RecordStore
+}
+
+func NewEncryptionManager(session *AWSSession) *EncryptionManager {
+	return &EncryptionManager{Parameters: session, Secrets: session, Records: session}
+}
+
+func (m *EncryptionManager) aesKey(ctx context.Context) (string, error) {
+	return readAESSecret(ctx, m.Secrets)
+}
+
+// readAESSecret returns the AES secret, which Secrets Manager stores base64
+// encoded.
+func readAESSecret(ctx context.Context, secrets SecretStore) (string, error) {
+	encoded, err := secrets.GetSecret(ctx, aesSecretName)
+	if err != nil {
+		return "", err
+	}
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", fmt.Errorf("AES secret %s is not base64 encoded: %w", aesSecretName, err)
+	}
+	return string(key), nil
+}
+
+// encryptedRecords returns the records of the parameter table encrypted with
+// the AES secret. It fails for installations in the kms format, which have no
+// AES secret.
+func (m *EncryptionManager) encryptedRecords(ctx context.Context, tableName string) ([]map[string]interface{}, error) {
+	records, err := m.Records.ListRecords(ctx, tableName)
+	if err != nil {
+		return nil, err
+	}
+	encrypted := []map[string]interface{}{}
+	for _, record := range records {
+		parameterID, _ := record["parameter_id"].(string)
+		value, _ := record["value"].(string)
+		if parameterID == encryptionFormatParameterID && value == EncryptionFormatKMS {
+			return nil, fmt.Errorf("the installation encrypts its parameters with a KMS key, not the AES secret")
+		}
+		if value != "" && valueFormat(value) != EncryptionFormatKMS && slices.Contains(encryptedParameterIDs, parameterID) {
+			encrypted = append(encrypted, record)
+		}
+	}
+	return encrypted, nil
+}
+
+// Migrate re-encrypts every encrypted parameter still in the legacy format
+// with AES-GCM and then sets encryption_format, so the Titvo services write
+// v2 values too. Every value is decrypted before anything is written: a wrong
+// key or a corrupt value leaves the table untouched. It returns the IDs of
+// the parameters migrated, or that would be with dryRun.
+func (m *EncryptionManager) Migrate(ctx context.Context, dryRun bool) ([]string, error) {
+	tableName, err := m.Parameters.GetParameter(ctx, parameterTableParameter)
+	if err != nil {
+		return nil, err
+	}
+	records, err := m.encryptedRecords(ctx, tableName)
+	if err != nil {
+		return nil, err
+	}
+	key, err := m.aesKey(ctx)
+	if err != nil {
+		return nil, err
+	}
+	migrated := []string{}
+	pending := []map[string]interface{}{}
+	for _, record := range records {
+		parameterID := record["parameter_id"].(string)
+		value := record["value"].(string)
+		if valueFormat(value) == EncryptionFormatGCM {
+			continue
+		}
+		plaintext, err := decryptValue(value, key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt parameter %s: %w", parameterID, err)
+		}
+		encrypted, err := encryptGCM(plaintext, key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encrypt parameter %s: %w", parameterID, err)
+		}
+		updated := maps.Clone(record)
+		updated["value"] = encrypted
+		pending = append(pending, updated)
+		migrated = append(migrated, parameterID)
+	}
+	if dryRun {
+		return migrated, nil
+	}
+	for i, record := range pending {
+		if err := m.Records.PutRecord(ctx, tableName, record); err != nil {
+			return migrated[:i], fmt.Errorf("failed to store parameter %s: %w", migrated[i], err)
+		}
+	}
+	err = m.Records.PutRecord(ctx, tableName, map[string]interface{}{
+		"parameter_id": encryptionFormatParameterID,
+		"value":        EncryptionFormatGCM,
+	})
+	if err != nil {
+		return migrated, fmt.Errorf("parameters migrated but %s could not be updated: %w", encryptionFormatParameterID, err)
+	}
+	return migrated, nil
+}
+
+// RotateAESKey replaces the AES secret with a new random key and re-encrypts
+// every encrypted parameter with it, keeping the format of each value.
+//
+// The new key is first stored as the AWSPENDING version of the secret, so it
+// cannot be lost halfway, then the parameters are rewritten and finally the
+// key becomes AWSCURRENT in one call. If a step before that fails, the parameters already
+// rewritten are restored and the pending version is discarded, leaving the
+// old key in use. It returns the IDs of the parameters re-encrypted.
+func (m *EncryptionManager) RotateAESKey(ctx context.Context) ([]string, error) {
+	tableName, err := m.Parameters.GetParameter(ctx, parameterTableParameter)
+	if err != nil {
+		return nil, err
+	}
+	records, err := m.encryptedRecords(ctx, tableName)
+	if err != nil {
+		return nil, err
+	}
+	oldKey, err := m.aesKey(ctx)
+	if err != nil {
+		return nil, err
+	}
+	newKey, err := generateAESKey()
+	if err != nil {
+		return nil, err
+	}
+	rotated := make([]string, 0, len(records))
+	pending := make([]map[string]interface{}, 0, len(records))
+	for _, record := range records {
+		parameterID := record["parameter_id"].(string)
+		value := record["value"].(string)
+		plaintext, err := decryptValue(value, oldKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt parameter %s: %w", parameterID, err)
+		}
+		encrypted, err := encryptValue(plaintext, newKey, valueFormat(value))
+		if err != nil {
+			return nil, fmt.Errorf("failed to encrypt parameter %s: %w", parameterID, err)
+		}
+		updated := maps.Clone(record)
+		updated["value"] = encrypted
+		pending = append(pending, updated)
+		rotated = append(rotated, parameterID)
+	}
+
+	versionID, err := m.Secrets.StageSecret(ctx, aesSecretName, base64.StdEncoding.EncodeToString([]byte(newKey)))
+	if err != nil {
+		return nil, err
+	}
+	for i, record := range pending {
+		if err := m.Records.PutRecord(ctx, tableName, record); err != nil {
+			err = fmt.Errorf("failed to store parameter %s: %w", rotated[i], err)
+			return nil, m.rollbackRotation(ctx, tableName, records[:i], versionID, err)
+		}
+	}
+	if err := m.Secrets.PromoteSecret(ctx, aesSecretName, versionID); err != nil {
+		return nil, m.rollbackRotation(ctx, tableName, records, versionID, err)
+	}
+	if err := m.Secrets.UnstageSecret(ctx, aesSecretName, versionID); err != nil {
+		return rotated, fmt.Errorf("AES secret rotated but its version %s is still staged as %s: %w", versionID, secretStagePending, err)
+	}
+	return rotated, nil
+}
+
+// rollbackRotation restores the original records and discards the pending
+// version of the AES secret. The pending version is kept when a record cannot
+// be restored, as it holds the only key of the values already rewritten.
+func (m *EncryptionManager) rollbackRotation(ctx context.Context, tableName string, originals []map[string]interface{}, versionID string, cause error) error {
+	for _, record := range originals {
+		if err := m.Records.PutRecord(ctx, tableName, record); err != nil {
+			return fmt.Errorf("%w; rollback failed restoring parameter %s: %v; the new key is kept as version %s of %s (stage %s)", cause, record["parameter_id"], err, versionID, aesSecretName, secretStagePending)
+		}
+	}
+	if err := m.Secrets.UnstageSecret(ctx, aesSecretName, versionID); err != nil {
+		return fmt.Errorf("%w; parameters restored but the pending version %s of %s could not be discarded: %v", cause, versionID, aesSecretName, err)
+	}
+	return fmt.Errorf("%w; rotation rolled back, the old key is still in use", cause)
+}
+
+func newEncryptionManager(cmd *cobra.Command, console *Console) *EncryptionManager {
+	session, err := managementSession(cmd, console)
+	if err != nil {
+		console.printErrorAndExit(err)
+	}
+	return NewEncryptionManager(session)
+}
+
+// RunSecretsMigrate re-encrypts the legacy AES-ECB parameters with AES-GCM.
+func RunSecretsMigrate(cmd *cobra.Command, args []string) {
+	console, _ := managementConsole(cmd)
+	dryRun, err := cmd.Flags().GetBool("dry-run")
+	if err != nil {
+		console.printErrorAndExit(err)
+	}
+	manager := newEncryptionManager(cmd, console)
+	migrated, err := manager.Migrate(cmd.Context(), dryRun)
+	for _, parameterID := range migrated {
+		if dryRun {
+			console.printInfo(fmt.Sprintf("Parameter %s would be re-encrypted with AES-GCM", parameterID))
+		} else {
+			console.printInfo(fmt.Sprintf("Parameter %s re-encrypted with AES-GCM", parameterID))
+		}
+	}
+	if err != nil {
+		console.printErrorAndExit(err)
+	}
+	if dryRun {
+		console.printInfo(fmt.Sprintf("%d parameters to migrate, nothing written", len(migrated)))
+		return
+	}
+	console.printInfo(fmt.Sprintf("%d parameters migrated, %s set to %s", len(migrated), encryptionFormatParameterID, EncryptionFormatGCM))
+}
+
+// RunSecretsRotateAES replaces the AES secret after confirmation.
+func RunSecretsRotateAES(cmd *cobra.Command, args []string) {
+	console, _ := managementConsole(cmd)
+	yes, err := cmd.Flags().GetBool("yes")
+	if err != nil {
+		console.printErrorAndExit(err)
+	}
+	manager := newEncryptionManager(cmd, console)
+	if !yes {
+		confirmed, err := console.askForYesNo("Replace the AES secret and re-encrypt every encrypted parameter? (y/N)")
+		if err != nil {
+			console.printErrorAndExit(err)
+		}
+		if !confirmed {
+			console.printInfo("Nothing changed")
+			return
+		}
+	}
+	rotated, err := manager.RotateAESKey(cmd.Context())
+	for _, parameterID := range rotated {
+		console.printInfo(fmt.Sprintf("Parameter %s re-encrypted with the new key", parameterID))
+	}
+	if err != nil {
+		console.printErrorAndExit(err)
+	}
+	console.printInfo(fmt.Sprintf("AES secret rotated, %d parameters re-encrypted. The old key is kept as the AWSPREVIOUS version of %s", len(rotated), aesSecretName))
+}
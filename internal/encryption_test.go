@@ -0,0 +1,308 @@
+package internal
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// testEncryptionManager returns an EncryptionManager over an in-memory
+// parameter table holding records and the AES secret key.
+func testEncryptionManager(records map[string][]map[string]interface{}, key string) (*EncryptionManager, *fakeAWS) {
+	_, fake := testDeployer()
+	fake.getParameter = func(path string) (string, error) {
+		if path == parameterTableParameter {
+			return "parameters", nil
+		}
+		return "value:" + path, nil
+	}
+	fake.getSecret = func(name string) (string, error) {
+		if name != aesSecretName {
+			return "", errors.New("secret not found")
+		}
+		return base64.StdEncoding.EncodeToString([]byte(key)), nil
+	}
+	useMemoryRecords(fake, records)
+	return &EncryptionManager{Parameters: fake, Secrets: fake, Records: fake}, fake
+}
+
+func legacyParameters(t *testing.T) map[string][]map[string]interface{} {
+	t.Helper()
+	aiAPIKey, _ := encryptECB("sk-legacy", testAESKey)
+	githubToken, _ := encryptGCM("ghp_current", testAESKey)
+	return map[string][]map[string]interface{}{
+		"parameters": {
+			{"parameter_id": "ai_api_key", "value": aiAPIKey},
+			{"parameter_id": "github_access_token", "value": githubToken},
+			{"parameter_id": "ai_model", "value": "gpt-4o"},
+		},
+	}
+}
+
+func parameterValue(records map[string][]map[string]interface{}, parameterID string) string {
+	for _, record := range records["parameters"] {
+		if record["parameter_id"] == parameterID {
+			value, _ := record["value"].(string)
+			return value
+		}
+	}
+	return ""
+}
+
+func TestEncryptionManagerMigrate(t *testing.T) {
+	t.Parallel()
+	records := legacyParameters(t)
+	githubToken := parameterValue(records, "github_access_token")
+	manager, _ := testEncryptionManager(records, testAESKey)
+
+	migrated, err := manager.Migrate(context.Background(), false)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(migrated) != 1 || migrated[0] != "ai_api_key" {
+		t.Fatalf("expected only the legacy value to be migrated, got %v", migrated)
+	}
+	aiAPIKey := parameterValue(records, "ai_api_key")
+	if decrypted, err := decryptValue(aiAPIKey, testAESKey); err != nil || decrypted != "sk-legacy" || valueFormat(aiAPIKey) != EncryptionFormatGCM {
+		t.Fatalf("expected the AI API key to be re-encrypted with AES-GCM, got %q: %v", aiAPIKey, err)
+	}
+	if parameterValue(records, "github_access_token") != githubToken || parameterValue(records, "ai_model") != "gpt-4o" {
+		t.Fatalf("expected the other parameters to be untouched, got %v", records["parameters"])
+	}
+	if parameterValue(records, encryptionFormatParameterID) != EncryptionFormatGCM {
+		t.Fatalf("expected the encryption format to be set to v2, got %v", records["parameters"])
+	}
+}
+
+func TestEncryptionManagerMigrateDryRun(t *testing.T) {
+	t.Parallel()
+	records := legacyParameters(t)
+	aiAPIKey := parameterValue(records, "ai_api_key")
+	manager, _ := testEncryptionManager(records, testAESKey)
+
+	migrated, err := manager.Migrate(context.Background(), true)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(migrated) != 1 || migrated[0] != "ai_api_key" {
+		t.Fatalf("expected the legacy value to be listed, got %v", migrated)
+	}
+	if parameterValue(records, "ai_api_key") != aiAPIKey || parameterValue(records, encryptionFormatParameterID) != "" {
+		t.Fatalf("expected nothing to be written, got %v", records["parameters"])
+	}
+}
+
+func TestEncryptionManagerMigrateWrongKeyWritesNothing(t *testing.T) {
+	t.Parallel()
+	records := legacyParameters(t)
+	bitbucketToken, _ := encryptECB("bitbucket", testAESKey)
+	records["parameters"] = append(records["parameters"], map[string]interface{}{"parameter_id": "bitbucket_api_token", "value": bitbucketToken})
+	manager, fake := testEncryptionManager(records, strings.Repeat("k", 32))
+	fake.putRecord = func(tableName string, item map[string]interface{}) error {
+		t.Fatalf("expected nothing to be written, got %v", item)
+		return nil
+	}
+
+	if _, err := manager.Migrate(context.Background(), false); err == nil || !strings.Contains(err.Error(), "failed to decrypt parameter ai_api_key") {
+		t.Fatalf("expected a decrypt error, got %v", err)
+	}
+}
+
+// rotationSecret records the AES secret versions written by a rotation.
+type rotationSecret struct {
+	pending  string
+	current  string
+	promoted bool
+	unstaged bool
+}
+
+func testRotation(t *testing.T) (*EncryptionManager, *fakeAWS, map[string][]map[string]interface{}, *rotationSecret) {
+	t.Helper()
+	records := legacyParameters(t)
+	manager, fake := testEncryptionManager(records, testAESKey)
+	secret := &rotationSecret{current: base64.StdEncoding.EncodeToString([]byte(testAESKey))}
+	fake.stageSecret = func(name, value string) (string, error) {
+		secret.pending = value
+		return "version-2", nil
+	}
+	fake.promoteSecret = func(name, versionID string) error {
+		if versionID != "version-2" {
+			t.Fatalf("unexpected version %s", versionID)
+		}
+		secret.current, secret.promoted = secret.pending, true
+		return nil
+	}
+	fake.unstageSecret = func(name, versionID string) error {
+		secret.unstaged = true
+		return nil
+	}
+	return manager, fake, records, secret
+}
+
+func TestEncryptionManagerRotateAESKey(t *testing.T) {
+	t.Parallel()
+	manager, _, records, secret := testRotation(t)
+
+	rotated, err := manager.RotateAESKey(context.Background())
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(rotated) != 2 || !secret.promoted || !secret.unstaged {
+		t.Fatalf("expected both parameters rotated and the key promoted, got %v and %+v", rotated, secret)
+	}
+	newKey, _ := base64.StdEncoding.DecodeString(secret.current)
+	if len(newKey) != 32 || string(newKey) == testAESKey {
+		t.Fatalf("expected a new 32 character key, got %q", newKey)
+	}
+	for id, plaintext := range map[string]string{"ai_api_key": "sk-legacy", "github_access_token": "ghp_current"} {
+		value := parameterValue(records, id)
+		if decrypted, err := decryptValue(value, string(newKey)); err != nil || decrypted != plaintext {
+			t.Fatalf("expected %s to be encrypted with the new key, got %q: %v", id, value, err)
+		}
+	}
+	if valueFormat(parameterValue(records, "ai_api_key")) != EncryptionFormatECB || valueFormat(parameterValue(records, "github_access_token")) != EncryptionFormatGCM {
+		t.Fatalf("expected each value to keep its format, got %v", records["parameters"])
+	}
+}
+
+func TestEncryptionManagerRotateAESKeyRollsBack(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name         string
+		failPut      int
+		failPromote  bool
+		failRestore  bool
+		expected     string
+		keepsPending bool
+	}{
+		{name: "parameter write fails", failPut: 2, expected: "rotation rolled back, the old key is still in use"},
+		{name: "promotion fails", failPromote: true, expected: "rotation rolled back, the old key is still in use"},
+		{name: "restore fails", failPut: 2, failRestore: true, expected: "the new key is kept as version version-2", keepsPending: true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			manager, fake, records, secret := testRotation(t)
+			original := map[string]string{}
+			for _, id := range []string{"ai_api_key", "github_access_token"} {
+				original[id] = parameterValue(records, id)
+			}
+			put := fake.putRecord
+			puts := 0
+			fake.putRecord = func(tableName string, item map[string]interface{}) error {
+				puts++
+				if puts == tc.failPut || (tc.failRestore && puts > tc.failPut) {
+					return errors.New("throttled")
+				}
+				return put(tableName, item)
+			}
+			if tc.failPromote {
+				fake.promoteSecret = func(name, versionID string) error { return errors.New("access denied") }
+			}
+
+			_, err := manager.RotateAESKey(context.Background())
+			if err == nil || !strings.Contains(err.Error(), tc.expected) {
+				t.Fatalf("expected an error containing %q, got %v", tc.expected, err)
+			}
+			if secret.promoted {
+				t.Fatal("expected the new key not to become current")
+			}
+			if secret.unstaged == tc.keepsPending {
+				t.Fatalf("expected the pending version to be kept: %v, got %+v", tc.keepsPending, secret)
+			}
+			if tc.failRestore {
+				return
+			}
+			for id, value := range original {
+				if parameterValue(records, id) != value {
+					t.Fatalf("expected %s to be restored, got %q", id, parameterValue(records, id))
+				}
+			}
+		})
+	}
+}
+
+func TestEncryptionManagerRotateAESKeyWrongKeyStagesNothing(t *testing.T) {
+	t.Parallel()
+	manager, fake, _, _ := testRotation(t)
+	fake.getSecret = func(name string) (string, error) {
+		return base64.StdEncoding.EncodeToString([]byte(strings.Repeat("k", 32))), nil
+	}
+	fake.stageSecret = func(name, value string) (string, error) {
+		t.Fatal("expected no key to be staged")
+		return "", nil
+	}
+
+	if _, err := manager.RotateAESKey(context.Background()); err == nil || !strings.Contains(err.Error(), "failed to decrypt parameter") {
+		t.Fatalf("expected a decrypt error, got %v", err)
+	}
+}
+
+func TestEnsureAESSecret(t *testing.T) {
+	t.Parallel()
+	existing := base64.StdEncoding.EncodeToString([]byte(testAESKey))
+	tests := []struct {
+		name      string
+		key       string
+		stored    string
+		generated bool
+		reused    bool
+	}{
+		{name: "key given", key: testAESKey},
+		{name: "key generated", generated: true},
+		{name: "existing secret kept over the key given", key: strings.Repeat("k", 32), stored: existing, reused: true},
+		{name: "existing secret kept over a generated key", stored: existing, reused: true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			d, fake := testDeployer()
+			fake.ensureSecret = func(name, value string) (string, string, error) {
+				if tc.stored != "" {
+					return "arn:" + name, tc.stored, nil
+				}
+				return "arn:" + name, value, nil
+			}
+
+			secret, err := d.EnsureAESSecret(context.Background(), tc.key)
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if secret.Generated != tc.generated || secret.Reused != tc.reused || secret.ARN != "arn:"+aesSecretName {
+				t.Fatalf("unexpected secret %+v", secret)
+			}
+			switch {
+			case tc.stored != "":
+				if secret.Key != testAESKey {
+					t.Fatalf("expected the existing key, got %q", secret.Key)
+				}
+			case tc.generated:
+				if len(secret.Key) != 32 {
+					t.Fatalf("expected a 32 byte key, got %d bytes", len(secret.Key))
+				}
+			case secret.Key != tc.key:
+				t.Fatalf("expected the key given, got %q", secret.Key)
+			}
+		})
+	}
+}
+
+func TestEncryptionManagerRejectsKMSInstallations(t *testing.T) {
+	t.Parallel()
+	records := map[string][]map[string]interface{}{
+		"parameters": {
+			{"parameter_id": encryptionFormatParameterID, "value": EncryptionFormatKMS},
+			{"parameter_id": "ai_api_key", "value": kmsPrefix + "Y2lwaGVydGV4dA=="},
+		},
+	}
+	manager, _ := testEncryptionManager(records, testAESKey)
+	expected := "the installation encrypts its parameters with a KMS key, not the AES secret"
+	if _, err := manager.Migrate(context.Background(), false); err == nil || err.Error() != expected {
+		t.Fatalf("expected %q, got %v", expected, err)
+	}
+	if _, err := manager.RotateAESKey(context.Background()); err == nil || err.Error() != expected {
+		t.Fatalf("expected %q, got %v", expected, err)
+	}
+}
@@ -0,0 +1,26 @@
+//go:build !windows
+
+package internal
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// configureGracefulStop runs the command in its own process group so a Ctrl-C
+// in the terminal only reaches the installer, which then forwards a single
+// SIGINT. Terraform treats a second interrupt as a forced exit that may leave
+// the state lock behind.
+func configureGracefulStop(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return cmd.Process.Signal(os.Interrupt)
+	}
+}
+
+// killProcessTree kills the process group of cmd, which includes the
+// terraform processes started by terragrunt.
+func killProcessTree(cmd *exec.Cmd) error {
+	return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+}
@@ -0,0 +1,22 @@
+//go:build windows
+
+package internal
+
+import (
+	"os/exec"
+)
+
+// configureGracefulStop relies on the console delivering Ctrl-C to every
+// attached process, the child included. Interrupt signals cannot be sent to
+// another process on Windows, so the command is only killed once the grace
+// period (cmd.WaitDelay) expires.
+func configureGracefulStop(cmd *exec.Cmd) {
+	cmd.Cancel = func() error {
+		return nil
+	}
+}
+
+// killProcessTree kills cmd. Its children got the console Ctrl-C as well.
+func killProcessTree(cmd *exec.Cmd) error {
+	return cmd.Process.Kill()
+}
@@ -1,101 +1,453 @@
 package internal
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"os"
+	"time"
 
 	"github.com/spf13/cobra"
 )
 
+// InstallOptions are the command line settings of an installer run.
+type InstallOptions struct {
+	Debug       bool
+	ConfigFile  string
+	Resume      bool
+	Reconfigure bool
+	// EncryptionFormat is the format of the encrypted values: v1 (AES-ECB),
+	// the default as every Titvo service reads it, v2 (AES-GCM) once the
+	// services read encryption_format, or kms to encrypt them with a
+	// customer-managed KMS key instead of the AES secret.
+	EncryptionFormat string
+	// KMSKeyID is the KMS key of the kms format, empty to create one.
+	KMSKeyID string
+	// KMSGrantRoles are the IAM roles granted the KMS key, empty for the job
+	// role of the agent. They are required when SCM tokens are configured.
+	KMSGrantRoles []string
+	// ExportAESSecret is where a generated AES secret is written once: a file
+	// path, "-" for the output, or empty to only keep it in Secrets Manager.
+	ExportAESSecret string
+	StepTimeout     time.Duration
+	Output          string
+	AWSEndpoints    map[string]string
+	// SkipAIValidation skips checking the AI API key and model against the
+	// provider, for offline installations.
+	SkipAIValidation bool
+	// AIEndpoints overrides the API base URL of an AI provider, by name.
+	AIEndpoints map[string]string
+	// SystemPromptFile and ContentTemplateFile replace the default scan
+	// system prompt and content template embedded in the installer.
+	SystemPromptFile    string
+	ContentTemplateFile string
+}
+
+func installOptionsFromFlags(cmd *cobra.Command) (options InstallOptions, err error) {
+	flags := cmd.Flags()
+	if options.Debug, err = flags.GetBool("debug"); err != nil {
+		return options, err
+	}
+	if options.ConfigFile, err = flags.GetString("config"); err != nil {
+		return options, err
+	}
+	if options.Resume, err = flags.GetBool("resume"); err != nil {
+		return options, err
+	}
+	if options.Reconfigure, err = flags.GetBool("reconfigure"); err != nil {
+		return options, err
+	}
+	if options.EncryptionFormat, err = flags.GetString("encryption-format"); err != nil {
+		return options, err
+	}
+	if err = validateEncryptionFormat(options.EncryptionFormat); err != nil {
+		return options, err
+	}
+	if options.KMSKeyID, err = flags.GetString("kms-key-id"); err != nil {
+		return options, err
+	}
+	if options.KMSGrantRoles, err = flags.GetStringSlice("kms-grant-role"); err != nil {
+		return options, err
+	}
+	if options.EncryptionFormat != EncryptionFormatKMS && (options.KMSKeyID != "" || len(options.KMSGrantRoles) > 0) {
+		return options, fmt.Errorf("--kms-key-id and --kms-grant-role require --encryption-format %s", EncryptionFormatKMS)
+	}
+	if options.ExportAESSecret, err = flags.GetString("export-aes-secret"); err != nil {
+		return options, err
+	}
+	if options.StepTimeout, err = flags.GetDuration("step-timeout"); err != nil {
+		return options, err
+	}
+	if options.Output, err = flags.GetString("output"); err != nil {
+		return options, err
+	}
+	if options.AWSEndpoints, err = flags.GetStringToString("aws-endpoint"); err != nil {
+		return options, err
+	}
+	if options.SkipAIValidation, err = flags.GetBool("skip-ai-validation"); err != nil {
+		return options, err
+	}
+	if options.AIEndpoints, err = flags.GetStringToString("ai-endpoint"); err != nil {
+		return options, err
+	}
+	if options.SystemPromptFile, err = flags.GetString("system-prompt-file"); err != nil {
+		return options, err
+	}
+	if options.ContentTemplateFile, err = flags.GetString("content-template-file"); err != nil {
+		return options, err
+	}
+	return options, nil
+}
+
+// installer runs one installation. installTools and newDeployer default to
+// the real implementations and are replaced by the end-to-end tests.
+type installer struct {
+	options InstallOptions
+	// console writes the messages of the run in the --output format.
+	console      *Console
+	installTools func(ctx context.Context, console *Console) (*InstallToolConfig, error)
+	newDeployer  func(session *AWSSession, console *Console, resume bool) *Deployer
+	// state and console.Log are set as soon as the run loads them, so a
+	// failure can point at the log and the step to resume from.
+	state *installState
+}
+
+// newInstaller returns the installer of options, writing its messages to
+// stdout.
+func newInstaller(options InstallOptions, stdout io.Writer) (*installer, error) {
+	console, err := newConsole(options.Output, stdout)
+	if err != nil {
+		return nil, err
+	}
+	return &installer{
+		options:      options,
+		console:      console,
+		installTools: InstallTools,
+		newDeployer:  NewDeployer,
+	}, nil
+}
+
 func RunInstaller(cmd *cobra.Command, args []string) {
-	debug, err := cmd.Flags().GetBool("debug")
+	options, err := installOptionsFromFlags(cmd)
 	if err != nil {
-		printErrorAndExit(err)
+		textConsole(cmd.OutOrStdout()).printErrorAndExit(err)
 	}
-	if debug {
-		printInfo("Debug mode enabled")
+	i, err := newInstaller(options, cmd.OutOrStdout())
+	if err != nil {
+		textConsole(cmd.OutOrStdout()).printErrorAndExit(err)
 	}
-	configFile, err := cmd.Flags().GetString("config")
+	ctx, stop := withInterrupt(cmd.Context(), i.console)
+	defer stop()
+	err = i.run(ctx)
+	if err != nil {
+		if i.state != nil {
+			i.printStepErrorAndExit(ctx, err)
+		}
+		i.console.printErrorAndExit(err)
+	}
+	i.console.Log.Close()
+}
+
+// loadSetupConfigFile reads the answers of the installation from a JSON file
+// instead of asking for them.
+func loadSetupConfigFile(configFile string) (*SetupConfig, error) {
+	configFileBytes, err := os.ReadFile(configFile)
 	if err != nil {
-		printErrorAndExit(err)
+		return nil, err
+	}
+	var setupConfigFile SetupConfigFile
+	err = json.Unmarshal(configFileBytes, &setupConfigFile)
+	if err != nil {
+		return nil, err
+	}
+	if setupConfigFile.AesSecret != "" && len(setupConfigFile.AesSecret) != 32 {
+		return nil, fmt.Errorf("AES Secret in config file must have 32 characters in length, or be left empty to generate one")
+	}
+	return &SetupConfig{
+		AWSCredentialsLookup: &SetupConfigFileLookup{
+			SetupConfigFile: setupConfigFile,
+		},
+		VPCID:              setupConfigFile.VPCID,
+		PrivateSubnetCIDR:  setupConfigFile.PrivateSubnetCIDR,
+		AvailabilityZone:   setupConfigFile.AvailabilityZone,
+		NatGatewayID:       setupConfigFile.NatGatewayID,
+		AesSecret:          setupConfigFile.AesSecret,
+		UserName:           setupConfigFile.UserName,
+		AIProvider:         setupConfigFile.AIProvider,
+		AIModel:            setupConfigFile.AIModel,
+		AIApiKey:           setupConfigFile.AIApiKey,
+		AIBaseURL:          setupConfigFile.AIBaseURL,
+		AIAPIVersion:       setupConfigFile.AIAPIVersion,
+		AIRegion:           setupConfigFile.AIRegion,
+		MCPGatewayHostname: setupConfigFile.MCPGatewayHostname,
+		MCPGatewayPort:     setupConfigFile.MCPGatewayPort,
+		MCPNamespace:       setupConfigFile.MCPNamespace,
+		BitbucketAPIToken:  setupConfigFile.BitbucketAPIToken,
+		GithubAccessToken:  setupConfigFile.GithubAccessToken,
+	}, nil
+}
+
+// run performs the installation: setup, tools, infrastructure and the
+// initial configuration.
+func (i *installer) run(ctx context.Context) error {
+	options, console := i.options, i.console
+	var err error
+	if options.Debug {
+		console.printInfo("Debug mode enabled")
+	}
+	titvoDir, err := titvoHomeDir()
+	if err != nil {
+		return err
+	}
+	logs, err := newRunLogger(titvoDir, time.Now())
+	if err != nil {
+		return err
+	}
+	console.Log = logs
+	console.printInfo("Starting Titvo Installer")
+	console.printInfo(fmt.Sprintf("Logging run %s to %s", logs.ID, logs.Dir))
+	var validator *AIValidator
+	if !options.SkipAIValidation {
+		validator = NewAIValidator(options.AIEndpoints)
 	}
-	printInfo("Starting Titvo Installer")
 	var setup *SetupConfig
-	if configFile != "" {
-		printInfo(fmt.Sprintf("Using config file %s", configFile))
-		configFileBytes, err := os.ReadFile(configFile)
-		if err != nil {
-			printErrorAndExit(err)
-		}
-		var setupConfigFile SetupConfigFile
-		err = json.Unmarshal(configFileBytes, &setupConfigFile)
-		if err != nil {
-			printErrorAndExit(err)
+	if options.ConfigFile != "" {
+		console.printInfo(fmt.Sprintf("Using config file %s", options.ConfigFile))
+		setup, err = loadSetupConfigFile(options.ConfigFile)
+	} else {
+		setup, err = SetupInstallation(ctx, console, validator)
+	}
+	if err != nil {
+		return err
+	}
+	console.Secrets.Add(setup.secretValues()...)
+	awsCredentials, err := setup.AWSCredentialsLookup.GetCredentials()
+	if err != nil {
+		return err
+	}
+	console.Secrets.Add(awsCredentials.secretValues()...)
+	if setup.AIProvider == aiProviderBedrock && setup.AIRegion == "" {
+		setup.AIRegion = awsCredentials.AWSRegion
+	}
+	if err := i.checkAISettings(ctx, validator, setup); err != nil {
+		return err
+	}
+	mcpGateway := setup.mcpGateway()
+	if err := mcpGateway.validate(); err != nil {
+		return err
+	}
+	if options.EncryptionFormat == EncryptionFormatKMS {
+		if err := checkKMSGrantRoles(setup, options.KMSGrantRoles); err != nil {
+			return err
 		}
-		if len(setupConfigFile.AesSecret) != 32 {
-			printErrorAndExit(fmt.Errorf("AES Secret in config file must have 32 characters in length"))
+	}
+	// The prompt files are read and checked before deploying, so a wrong
+	// path or template fails fast
+	promptFiles := map[string]*promptFile{}
+	for name, path := range map[string]string{"system-prompt": options.SystemPromptFile, "content-template": options.ContentTemplateFile} {
+		if path == "" {
+			continue
 		}
-		setup = &SetupConfig{
-			AWSCredentialsLookup: &SetupConfigFileLookup{
-				SetupConfigFile: setupConfigFile,
-			},
-			VPCID:             setupConfigFile.VPCID,
-			PrivateSubnetCIDR: setupConfigFile.PrivateSubnetCIDR,
-			AvailabilityZone:  setupConfigFile.AvailabilityZone,
-			NatGatewayID:      setupConfigFile.NatGatewayID,
-			AesSecret:         setupConfigFile.AesSecret,
-			UserName:          setupConfigFile.UserName,
-			AIProvider:        setupConfigFile.AIProvider,
-			AIModel:           setupConfigFile.AIModel,
-			AIApiKey:          setupConfigFile.AIApiKey,
-			BitbucketAPIToken: setupConfigFile.BitbucketAPIToken,
-			GithubAccessToken: setupConfigFile.GithubAccessToken,
+		if promptFiles[name], err = readPromptFile(path, os.Stdin); err != nil {
+			return err
 		}
-	} else {
-		setup, err = SetupInstallation()
+		document, err := findPromptDocument(name)
 		if err != nil {
-			printErrorAndExit(err)
+			return err
+		}
+		if err := document.check(console, promptFiles[name].Content); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
 		}
 	}
-	printInfo("Setup successfully")
-	tool, err := InstallTools()
+	console.printInfo("Setup successfully")
+	state, err := loadInstallState(titvoDir, options.Resume)
 	if err != nil {
-		printErrorAndExit(err)
+		return err
 	}
-	printInfo("Tools installed successfully")
-	awsCredentials, err := setup.AWSCredentialsLookup.GetCredentials()
+	i.state = state
+	if stoppedAt := state.stoppedStep(); stoppedAt != "" {
+		console.printInfo(fmt.Sprintf("Resuming installation, the previous run stopped at step %s", stoppedAt))
+	}
+	tool, err := i.installTools(ctx, console)
+	if err != nil {
+		return err
+	}
+	console.printInfo("Tools installed successfully")
+	session, err := NewAWSSession(ctx, awsCredentials, AWSSessionOptions{Endpoints: options.AWSEndpoints, Console: console})
 	if err != nil {
-		printErrorAndExit(err)
+		return err
 	}
-	err = DeployInfra(DeployConfig{
+	if validator != nil {
+		validator.Bedrock = session
+		if err := i.checkAISettings(ctx, validator, setup); err != nil {
+			return err
+		}
+	}
+	deployer := i.newDeployer(session, console, state.resume)
+	aesKey, kmsKeyARN := setup.AesSecret, ""
+	if options.EncryptionFormat == EncryptionFormatKMS {
+		if aesKey != "" {
+			console.printAskQuestion("Warning: the AES Secret given is ignored, the kms encryption format uses a KMS key")
+		}
+		aesKey = ""
+		if kmsKeyARN, err = deployer.EnsureKMSKey(ctx, options.KMSKeyID); err != nil {
+			return fmt.Errorf("failed to set up the KMS key: %w", err)
+		}
+	}
+	// The base infra modules read the AES secret name and ARN in every
+	// format, so the kms format keeps a secret no value is encrypted with.
+	secret, err := deployer.EnsureAESSecret(ctx, aesKey)
+	if err != nil {
+		return fmt.Errorf("failed to create secret aes_secret: %w", err)
+	}
+	console.Secrets.Add(secret.Key)
+	if kmsKeyARN != "" && secret.Generated {
+		console.printAskQuestion(fmt.Sprintf("Warning: AES secret %s generated only because the base infra reads its name and ARN, the kms encryption format encrypts no value with it", aesSecretName))
+	}
+	if err := i.reportAESSecret(secret, aesKey != "", options.ExportAESSecret); err != nil {
+		return err
+	}
+	aesSecret := secret.Key
+	if kmsKeyARN != "" {
+		aesSecret = ""
+	}
+	err = deployer.DeployInfra(ctx, DeployConfig{
 		AWSCredentials:    *awsCredentials,
 		InstallToolConfig: *tool,
 		VPCID:             setup.VPCID,
 		PrivateSubnetCIDR: setup.PrivateSubnetCIDR,
 		AvailabilityZone:  setup.AvailabilityZone,
 		NatGatewayID:      setup.NatGatewayID,
-		AESSecret:         setup.AesSecret,
+		AESSecret:         aesSecret,
+		AESSecretARN:      secret.ARN,
+		EncryptionFormat:  options.EncryptionFormat,
+		KMSKeyARN:         kmsKeyARN,
 		BitbucketAPIToken: setup.BitbucketAPIToken,
 		GithubAccessToken: setup.GithubAccessToken,
-		Debug:             debug,
+		MCPGateway:        mcpGateway,
+		Debug:             options.Debug,
+		StepTimeout:       options.StepTimeout,
+		State:             state,
+		Logs:              logs,
 	})
 	if err != nil {
-		printErrorAndExit(err)
+		return err
+	}
+	console.printInfo("Infra deployed successfully")
+	if kmsKeyARN != "" {
+		// The roles are created by the infra modules, so they are granted last
+		err = state.run(ctx, console, "kms grants", func() error {
+			return deployer.GrantKMSKey(ctx, kmsKeyARN, options.KMSGrantRoles)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if setup.AIProvider == aiProviderBedrock {
+		err = state.run(ctx, console, "bedrock grants", func() error {
+			return deployer.GrantBedrock(ctx, setup.AIRegion)
+		})
+		if err != nil {
+			return err
+		}
 	}
-	printInfo("Infra deployed successfully")
 	startConfig := StartConfig{
-		AWSCredentials: awsCredentials,
-		UserName:       setup.UserName,
-		AIProvider:     setup.AIProvider,
-		AIModel:        setup.AIModel,
-		AIApiKey:       setup.AIApiKey,
-		AESSecret:      setup.AesSecret,
-		TitvoDir:       tool.TitvoDir,
+		UserName:         setup.UserName,
+		AI:               setup.aiSettings(),
+		AESSecret:        aesSecret,
+		EncryptionFormat: options.EncryptionFormat,
+		KMSKeyARN:        kmsKeyARN,
+		PromptFiles:      promptFiles,
+		MCPGateway:       mcpGateway,
+		Reconfigure:      options.Reconfigure,
 	}
-	err = StartConfiguration(&startConfig)
+	err = state.run(ctx, console, "initial configuration", func() error {
+		return deployer.StartConfiguration(ctx, &startConfig)
+	})
 	if err != nil {
-		printErrorAndExit(err)
+		return err
+	}
+	console.printInfo("Configuration started successfully")
+	if err := state.clear(); err != nil {
+		console.printError(fmt.Errorf("failed to remove install state: %w", err))
+	}
+	return nil
+}
+
+// checkAISettings checks that the AI settings are complete and, with a
+// validator, that the provider accepts them. Settings checked while they were
+// asked for are not checked again, and bedrock is checked once the validator
+// has the AWS session.
+func (i *installer) checkAISettings(ctx context.Context, validator *AIValidator, setup *SetupConfig) error {
+	p, err := findAIProvider(setup.AIProvider)
+	if err != nil {
+		return err
+	}
+	if err := p.check(setup.aiSettings(), true); err != nil {
+		return err
+	}
+	if validator == nil || setup.aiChecked || !validator.canCheck(p) {
+		return nil
+	}
+	if err := validator.Validate(ctx, setup.aiSettings()); err != nil {
+		return fmt.Errorf("%w. Fix the AI settings, or use --skip-ai-validation", err)
+	}
+	setup.aiChecked = true
+	i.console.printInfo(fmt.Sprintf("AI settings checked with %s", p.Label))
+	return nil
+}
+
+// reportAESSecret reports where the AES secret comes from and writes a
+// generated one to export. The key is only available in this run: later runs
+// reuse the secret without showing it.
+func (i *installer) reportAESSecret(secret *AESSecret, keyGiven bool, export string) error {
+	switch {
+	case secret.Reused && keyGiven:
+		i.console.printAskQuestion(fmt.Sprintf("Warning: the AES secret %s already exists and is reused, the AES Secret given is ignored. Use `secrets rotate-aes` to replace it", aesSecretName))
+		return nil
+	case secret.Reused:
+		i.console.printInfo(fmt.Sprintf("Reusing the existing AES secret %s", aesSecretName))
+		return nil
+	case !secret.Generated:
+		return nil
+	}
+	i.console.printInfo(fmt.Sprintf("AES secret generated and stored in Secrets Manager at %s", aesSecretName))
+	switch export {
+	case "":
+		return nil
+	case "-":
+		i.console.reportOutput("AES Secret", secret.Key)
+	default:
+		if err := os.WriteFile(export, []byte(secret.Key+"\n"), 0600); err != nil {
+			return fmt.Errorf("failed to export AES secret: %w", err)
+		}
+		i.console.printInfo(fmt.Sprintf("AES secret exported to %s, keep it in a safe place", export))
+	}
+	return nil
+}
+
+// printStepErrorAndExit reports where the installation stopped and how to
+// continue from there.
+func (i *installer) printStepErrorAndExit(ctx context.Context, err error) {
+	state, logs := i.state, i.console.Log
+	i.console.printError(err)
+	logPath := logs.runLogPath()
+	if step := state.stoppedStep(); step != "" {
+		if _, statErr := os.Stat(logs.componentLogPath(step)); statErr == nil {
+			logPath = logs.componentLogPath(step)
+		}
+	}
+	i.console.printAskQuestion(fmt.Sprintf("See the log at %s", logPath))
+	if ctx.Err() != nil {
+		if state.InterruptedStep != "" {
+			i.console.printAskQuestion(fmt.Sprintf("Installation interrupted during step %s", state.InterruptedStep))
+		}
+		i.console.printAskQuestion("Run the installer again with --resume to continue")
+		os.Exit(130)
+	}
+	if state.FailedStep != "" {
+		i.console.printAskQuestion(fmt.Sprintf("Installation failed during step %s, fix the problem and run the installer again with --resume to continue", state.FailedStep))
 	}
-	printInfo("Configuration started successfully")
+	os.Exit(1)
 }
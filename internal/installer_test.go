@@ -0,0 +1,639 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+const e2eAESSecret = "0123456789abcdef0123456789abcdef"
+
+// e2eHarness runs the whole installer against awsEmulator, with fake git,
+// terragrunt and npm executables that record their invocations instead of
+// deploying anything.
+type e2eHarness struct {
+	t          *testing.T
+	aws        *awsEmulator
+	ai         *httptest.Server
+	home       string
+	binDir     string
+	configFile string
+	// invocations is the file the fake executables append "<tool> <dir>
+	// <args>" lines to
+	invocations string
+	// options are the command line settings of the runs, completed by run
+	options InstallOptions
+}
+
+func newE2EHarness(t *testing.T) *e2eHarness {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("uses shell scripts as fake executables")
+	}
+	root := t.TempDir()
+	h := &e2eHarness{
+		t:           t,
+		aws:         newAWSEmulator(t),
+		ai:          newAIProviderStub(t, "sk-e2e-ai-api-key", "gpt-4o", "gpt-4o-mini"),
+		home:        filepath.Join(root, "home"),
+		binDir:      filepath.Join(root, "bin"),
+		configFile:  filepath.Join(root, "config.json"),
+		invocations: filepath.Join(root, "invocations.log"),
+		options:     InstallOptions{EncryptionFormat: EncryptionFormatGCM},
+	}
+	record := `echo "$(basename "$0") $(pwd) $*" >> ` + h.invocations
+	writeFakeExecutable(t, h.binDir, "git", record+`
+if [ "$1" = "clone" ]; then
+	name=$(basename "$2" .git)
+	mkdir -p "$name/.git" "$name/aws/ecr" "$name/prod/us-east-1"
+fi`)
+	writeFakeExecutable(t, h.binDir, "terragrunt", record)
+	// npm fails in the repositories with a fail-npm-<repository> file next to
+	// the bin directory, to simulate a broken build
+	writeFakeExecutable(t, h.binDir, "npm", record+`
+if [ -f "`+root+`/fail-npm-$(basename "$(pwd)")" ]; then
+	echo "npm ERR! build failed" >&2
+	exit 1
+fi`)
+	t.Setenv("HOME", h.home)
+	t.Setenv("PATH", h.binDir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	// Outputs of the terragrunt modules the installer reads back
+	for path, value := range map[string]string{
+		"/tvo/security-scan/prod/infra/dynamo/user-table-name":                        "tvo-user-prod",
+		"/tvo/security-scan/prod/infra/dynamo/apikey-table-name":                      "tvo-apikey-prod",
+		"/tvo/security-scan/prod/infra/dynamo/parameter-table-name":                   "tvo-security-scan-parameter-prod",
+		"/tvo/security-scan/prod/infra/s3/cli-files/bucket_name":                      "tvo-cli-files-prod",
+		"/tvo/security-scan/prod/infra/batch/agent/job_queue_name":                    "tvo-agent-queue-prod",
+		"/tvo/security-scan/prod/infra/batch/agent/job_definition_name":               "tvo-agent-job-prod",
+		"/tvo/security-scan/prod/infra/apigateway/task/api_gateway_api_full_endpoint": "https://api.example.com/prod",
+		"/tvo/security-scan/prod/infra/ecr/publisher/job_definition_arn":              "arn:aws:batch:us-east-1:123456789012:job-definition/publisher",
+		"/tvo/security-scan/prod/infra/ecr/publisher/job_queue_arn":                   "arn:aws:batch:us-east-1:123456789012:job-queue/publisher",
+	} {
+		h.aws.SetParameter(path, value)
+	}
+	// The task of the MCP gateway service the gateway module would create
+	h.aws.AddCloudMapInstance("internal.titvo.com", "gateway", "10.0.1.5")
+
+	h.writeConfig(e2eAESSecret)
+
+	return h
+}
+
+// writeConfig writes the config file of the runs with the given AES secret,
+// empty to have it generated.
+func (h *e2eHarness) writeConfig(aesSecret string) {
+	h.t.Helper()
+	config, err := json.Marshal(SetupConfigFile{
+		AWSAccessKeyID:     "AKIDE2E",
+		AWSSecretAccessKey: "e2e-secret-access-key",
+		AWSRegion:          "us-east-1",
+		VPCID:              "vpc-123",
+		PrivateSubnetCIDR:  "10.0.1.0/24",
+		AvailabilityZone:   "us-east-1a",
+		NatGatewayID:       "nat-123",
+		AesSecret:          aesSecret,
+		UserName:           "e2e-team",
+		AIProvider:         "openai",
+		AIModel:            "gpt-4o",
+		AIApiKey:           "sk-e2e-ai-api-key",
+		GithubAccessToken:  "ghp_e2e_access_token",
+	})
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	if err := os.WriteFile(h.configFile, config, 0o600); err != nil {
+		h.t.Fatal(err)
+	}
+}
+
+// run runs the installer like `titvo-installer --config <file> --output json`
+// and returns its events.
+func (h *e2eHarness) run(resume bool) ([]map[string]any, error) {
+	h.t.Helper()
+	var out bytes.Buffer
+	options := h.options
+	options.ConfigFile = h.configFile
+	options.Resume = resume
+	options.Output = OutputJSON
+	options.AWSEndpoints = map[string]string{awsAllServices: h.aws.URL}
+	options.AIEndpoints = stubValidator(h.ai).endpoints
+	i, err := newInstaller(options, &out)
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	i.installTools = func(ctx context.Context, console *Console) (*InstallToolConfig, error) {
+		osType, err := GetOS()
+		if err != nil {
+			return nil, err
+		}
+		return &InstallToolConfig{
+			Dir:              h.binDir,
+			OS:               osType,
+			TitvoDir:         filepath.Join(h.home, ".titvo"),
+			TerraformBinDir:  h.binDir,
+			NodeBinDir:       h.binDir,
+			TerragruntBinDir: h.binDir,
+		}, nil
+	}
+	i.newDeployer = func(session *AWSSession, console *Console, resume bool) *Deployer {
+		session.batchPollInterval = time.Millisecond
+		session.servicePollInterval = time.Millisecond
+		return NewDeployer(session, console, resume)
+	}
+	err = i.run(context.Background())
+	i.console.Log.Close()
+	return decodeEvents(h.t, &out), err
+}
+
+func (h *e2eHarness) infraDir() string {
+	return filepath.Join(h.home, ".titvo", "infra")
+}
+
+// calls returns the recorded invocations, with the directories relative to
+// the infra directory.
+func (h *e2eHarness) calls() []string {
+	h.t.Helper()
+	content, err := os.ReadFile(h.invocations)
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	infraDir, err := filepath.EvalSymlinks(h.infraDir())
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	for i, line := range lines {
+		line = strings.ReplaceAll(line, infraDir+string(filepath.Separator), "")
+		lines[i] = strings.ReplaceAll(line, infraDir, ".")
+	}
+	return lines
+}
+
+func outputValue(events []map[string]any, name string) string {
+	for _, event := range events {
+		if event["event"] == "output" && event["name"] == name {
+			value, _ := event["value"].(string)
+			return value
+		}
+	}
+	return ""
+}
+
+func parameterValues(items []map[string]string) map[string]string {
+	values := map[string]string{}
+	for _, item := range items {
+		values[item["parameter_id"]] = item["value"]
+	}
+	return values
+}
+
+func TestRunInstallerEndToEnd(t *testing.T) {
+	h := newE2EHarness(t)
+
+	events, err := h.run(false)
+	if err != nil {
+		t.Fatalf("installer failed: %v", err)
+	}
+
+	for path, expected := range map[string]string{
+		"/tvo/security-scan/prod/infra/vpc/vpc_id":                    "vpc-123",
+		"/tvo/security-scan/prod/infra/vpc/installer/subnets/private": `[{"cidr_block":"10.0.1.0/24","availability_zone":"us-east-1a","nat_gateway_id":"nat-123"}]`,
+		"/tvo/security-scan/prod/infra/kms/encryption-key-name":       "/tvo/security-scan/prod/aes_secret",
+		"/tvo/security-scan/prod/infra/secret/manager/arn":            "arn:aws:secretsmanager:us-east-1:123456789012:secret:/tvo/security-scan/prod/aes_secret",
+	} {
+		if value, _ := h.aws.Parameter(path); value != expected {
+			t.Errorf("expected parameter %s to be %q, got %q", path, expected, value)
+		}
+	}
+	secret, ok := h.aws.Secret("/tvo/security-scan/prod/aes_secret")
+	if !ok || secret != base64.StdEncoding.EncodeToString([]byte(e2eAESSecret)) {
+		t.Errorf("expected the base64 AES secret in Secrets Manager, got %q", secret)
+	}
+
+	users := h.aws.Items("tvo-user-prod")
+	if len(users) != 1 || users[0]["name"] != "e2e-team" || users[0]["account_type"] != "Team" {
+		t.Fatalf("expected one Team user named e2e-team, got %v", users)
+	}
+	if userID := outputValue(events, "User ID"); userID != users[0]["user_id"] {
+		t.Errorf("expected User ID output %q, got %q", users[0]["user_id"], userID)
+	}
+	apiKeys := h.aws.Items("tvo-apikey-prod")
+	apiKey := outputValue(events, "API Key")
+	if len(apiKeys) != 1 || apiKeys[0]["api_key"] != hashSha256([]byte(apiKey)) || apiKeys[0]["user_id"] != users[0]["user_id"] {
+		t.Fatalf("expected one API key record holding the hash of the reported key, got %v", apiKeys)
+	}
+	if endpoint := outputValue(events, "Setup Endpoint"); endpoint != "https://api.example.com/prod" {
+		t.Errorf("expected the setup endpoint output, got %q", endpoint)
+	}
+
+	parameters := parameterValues(h.aws.Items("tvo-security-scan-parameter-prod"))
+	for id, expected := range map[string]string{
+		"ai_provider":                  "openai",
+		"ai_model":                     "gpt-4o",
+		"cli_files_bucket_name":        "tvo-cli-files-prod",
+		"security-scan-job-queue":      "tvo-agent-queue-prod",
+		"security-scan-job-definition": "tvo-agent-job-prod",
+		"task_endpoint":                "https://api.example.com/prod",
+		"mcp_server_url":               "http://gateway.internal.titvo.com:3000/mcp",
+		"scan_system_prompt":           defaultSystemPrompt,
+		"scan_system_prompt#v1":        defaultSystemPrompt,
+		"content_template":             defaultContentTemplate,
+	} {
+		if parameters[id] != expected {
+			t.Errorf("expected parameter record %s to be %q, got %q", id, expected, parameters[id])
+		}
+	}
+	for id, plaintext := range map[string]string{"ai_api_key": "sk-e2e-ai-api-key", "github_access_token": "ghp_e2e_access_token"} {
+		decrypted, err := decryptValue(parameters[id], e2eAESSecret)
+		if err != nil || decrypted != plaintext || !strings.HasPrefix(parameters[id], gcmPrefix) {
+			t.Errorf("expected parameter record %s to hold the AES-GCM encrypted value, got %q: %v", id, parameters[id], err)
+		}
+	}
+	if parameters[encryptionFormatParameterID] != EncryptionFormatGCM {
+		t.Errorf("expected the encryption format record to be %s, got %q", EncryptionFormatGCM, parameters[encryptionFormatParameterID])
+	}
+	if _, ok := parameters["bitbucket_api_token"]; ok {
+		t.Error("expected no bitbucket token record without bitbucket credentials")
+	}
+
+	jobs := h.aws.Jobs()
+	if len(jobs) != 2 || jobs[0].Name != "installer-ecr-publisher-agent" || jobs[1].Name != "installer-ecr-publisher-mcp-gateway" {
+		t.Fatalf("expected the two ecr publisher jobs, got %+v", jobs)
+	}
+	if jobs[0].Queue != "arn:aws:batch:us-east-1:123456789012:job-queue/publisher" || jobs[0].Environment["IMAGE_REPO"] != "tvo-agent-ecr-prod" {
+		t.Errorf("unexpected agent publisher job %+v", jobs[0])
+	}
+
+	calls := h.calls()
+	clones := 0
+	for _, call := range calls {
+		if strings.HasPrefix(call, "git . clone ") {
+			clones++
+		}
+		if strings.Contains(call, "bitbucket") {
+			t.Errorf("expected no bitbucket component without bitbucket credentials, got %q", call)
+		}
+	}
+	if clones != 11 {
+		t.Errorf("expected 11 repositories to be cloned, got %d in %v", clones, calls)
+	}
+	terragruntFlags := "-input=false -auto-approve --terragrunt-non-interactive"
+	for _, expected := range []string{
+		"terragrunt titvo-security-scan-infra-aws/prod/us-east-1 run-all apply " + terragruntFlags,
+		"git titvo-auth-setup-aws submodule update --init",
+		"npm titvo-auth-setup-aws ci",
+		"npm titvo-auth-setup-aws run build",
+		"terragrunt titvo-auth-setup-aws/aws run-all apply " + terragruntFlags,
+		"terragrunt titvo-mcp-gateway/aws/ecr run-all apply " + terragruntFlags,
+		"terragrunt titvo-installer-ecr-publisher/aws run-all apply " + terragruntFlags,
+		"terragrunt titvo-installer-ecr-publisher/aws run-all destroy " + terragruntFlags,
+		"terragrunt titvo-mcp-gateway/aws run-all apply " + terragruntFlags,
+		"terragrunt titvo-github-issue-aws/aws run-all apply " + terragruntFlags,
+	} {
+		if !slices.Contains(calls, expected) {
+			t.Errorf("expected invocation %q, got %v", expected, calls)
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(h.home, ".titvo", installStateFileName)); !os.IsNotExist(err) {
+		t.Errorf("expected the install state to be removed after a successful run, got %v", err)
+	}
+}
+
+func TestRunInstallerResumesAfterFailedStep(t *testing.T) {
+	h := newE2EHarness(t)
+	// The run clones the task trigger repository again, so its build is
+	// broken through a marker outside of the checkout
+	marker := filepath.Join(filepath.Dir(h.binDir), "fail-npm-titvo-task-trigger-aws")
+	if err := os.WriteFile(marker, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := h.run(false)
+	if err == nil || !strings.Contains(err.Error(), "npm ci failed") {
+		t.Fatalf("expected the task trigger build to fail, got %v", err)
+	}
+	if users := h.aws.Items("tvo-user-prod"); len(users) != 0 {
+		t.Fatalf("expected no user before the installation finished, got %v", users)
+	}
+
+	if err := os.Remove(marker); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(h.invocations, 0); err != nil {
+		t.Fatal(err)
+	}
+	events, err := h.run(true)
+	if err != nil {
+		t.Fatalf("resumed installer failed: %v", err)
+	}
+
+	skipped := []string{}
+	for _, event := range events {
+		if event["event"] == "step_finished" && event["status"] == StepSkipped {
+			skipped = append(skipped, event["step"].(string))
+		}
+	}
+	if !slices.Equal(skipped, []string{"base infra", "agent aws", "auth setup", "task cli files"}) {
+		t.Errorf("expected the steps completed by the first run to be skipped, got %v", skipped)
+	}
+	calls := h.calls()
+	for _, call := range calls {
+		if strings.HasPrefix(call, "npm titvo-auth-setup-aws") || strings.HasPrefix(call, "terragrunt titvo-security-scan-infra-aws") {
+			t.Errorf("expected completed steps not to run again, got %q", call)
+		}
+	}
+	if !slices.Contains(calls, "npm titvo-task-trigger-aws ci") {
+		t.Errorf("expected the failed step to run again, got %v", calls)
+	}
+	if users := h.aws.Items("tvo-user-prod"); len(users) != 1 {
+		t.Errorf("expected one user after the resumed run, got %v", users)
+	}
+}
+
+func TestRunInstallerRerunKeepsFirstUser(t *testing.T) {
+	h := newE2EHarness(t)
+	if _, err := h.run(false); err != nil {
+		t.Fatalf("installer failed: %v", err)
+	}
+
+	events, err := h.run(false)
+	if err != nil {
+		t.Fatalf("second installer run failed: %v", err)
+	}
+
+	users := h.aws.Items("tvo-user-prod")
+	if len(users) != 1 {
+		t.Fatalf("expected the rerun to keep the single user, got %v", users)
+	}
+	if keys := h.aws.Items("tvo-apikey-prod"); len(keys) != 1 {
+		t.Fatalf("expected the rerun to keep the single API key, got %v", keys)
+	}
+	if userID := outputValue(events, "User ID"); userID != users[0]["user_id"] {
+		t.Errorf("expected the existing User ID %q, got %q", users[0]["user_id"], userID)
+	}
+	if apiKey := outputValue(events, "API Key"); apiKey != "" {
+		t.Errorf("expected no new API key on rerun, got %q", apiKey)
+	}
+}
+
+func TestRunInstallerGeneratesAndReusesAESSecret(t *testing.T) {
+	h := newE2EHarness(t)
+	h.writeConfig("")
+	h.options.ExportAESSecret = "-"
+	events, err := h.run(false)
+	if err != nil {
+		t.Fatalf("installer failed: %v", err)
+	}
+
+	stored, _ := h.aws.Secret(aesSecretName)
+	key, err := base64.StdEncoding.DecodeString(stored)
+	if err != nil || len(key) != 32 {
+		t.Fatalf("expected a random 32 character key, got %q: %v", stored, err)
+	}
+	if exported := outputValue(events, "AES Secret"); exported != string(key) {
+		t.Fatalf("expected the generated AES secret %q to be exported once, got %q", key, exported)
+	}
+	aiAPIKey := parameterValues(h.aws.Items("tvo-security-scan-parameter-prod"))["ai_api_key"]
+	if decrypted, err := decryptValue(aiAPIKey, string(key)); err != nil || decrypted != "sk-e2e-ai-api-key" {
+		t.Fatalf("expected the AI API key to be encrypted with the generated key, got %q: %v", aiAPIKey, err)
+	}
+
+	h.writeConfig(e2eAESSecret)
+	events, err = h.run(false)
+	if err != nil {
+		t.Fatalf("second installer run failed: %v", err)
+	}
+	if secret, _ := h.aws.Secret(aesSecretName); secret != stored {
+		t.Fatalf("expected the existing AES secret to be kept, got %q", secret)
+	}
+	if exported := outputValue(events, "AES Secret"); exported != "" {
+		t.Fatalf("expected a reused AES secret not to be shown again, got %q", exported)
+	}
+	warned := false
+	for _, event := range events {
+		message, _ := event["message"].(string)
+		warned = warned || (event["event"] == "warning" && strings.Contains(message, "the AES Secret given is ignored"))
+	}
+	if !warned {
+		t.Errorf("expected a warning that the AES secret given is ignored, got %v", events)
+	}
+	aiAPIKey = parameterValues(h.aws.Items("tvo-security-scan-parameter-prod"))["ai_api_key"]
+	if decrypted, err := decryptValue(aiAPIKey, string(key)); err != nil || decrypted != "sk-e2e-ai-api-key" {
+		t.Fatalf("expected the rerun to keep encrypting with the existing key, got %q: %v", aiAPIKey, err)
+	}
+}
+
+func TestRunInstallerRejectsUnknownAIModel(t *testing.T) {
+	h := newE2EHarness(t)
+	h.ai = newAIProviderStub(t, "sk-e2e-ai-api-key", "gpt-4.1")
+	_, err := h.run(false)
+	if err == nil || !strings.Contains(err.Error(), `AI model "gpt-4o" is not available`) {
+		t.Fatalf("expected the unknown model to stop the installer, got %v", err)
+	}
+	if _, err := os.Stat(h.invocations); !os.IsNotExist(err) {
+		t.Fatalf("expected nothing to be deployed, got %v", err)
+	}
+
+	h.options.SkipAIValidation = true
+	if _, err := h.run(false); err != nil {
+		t.Fatalf("expected --skip-ai-validation to install anyway, got %v", err)
+	}
+}
+
+func TestRunInstallerPromptFiles(t *testing.T) {
+	h := newE2EHarness(t)
+	promptFile := filepath.Join(t.TempDir(), "prompt.md")
+	if err := os.WriteFile(promptFile, []byte("Custom scan prompt\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	h.options.SystemPromptFile = promptFile
+	if _, err := h.run(false); err != nil {
+		t.Fatalf("installer failed: %v", err)
+	}
+
+	// A rerun without the file keeps the customized prompt
+	h.options.SystemPromptFile = ""
+	if _, err := h.run(false); err != nil {
+		t.Fatalf("second installer run failed: %v", err)
+	}
+	parameters := parameterValues(h.aws.Items("tvo-security-scan-parameter-prod"))
+	if parameters["scan_system_prompt"] != "Custom scan prompt\n" || parameters["scan_system_prompt#v1"] != "Custom scan prompt\n" {
+		t.Errorf("expected the prompt file to be kept, got %q", parameters["scan_system_prompt"])
+	}
+	if _, ok := parameters["scan_system_prompt#v2"]; ok {
+		t.Error("expected the rerun to store no new version")
+	}
+	if parameters["content_template"] != defaultContentTemplate {
+		t.Errorf("expected the default content template, got %q", parameters["content_template"])
+	}
+
+	h.options.ContentTemplateFile = filepath.Join(t.TempDir(), "missing.md")
+	if _, err := h.run(false); err == nil || !strings.Contains(err.Error(), "missing.md") {
+		t.Fatalf("expected a missing template file to stop the installer, got %v", err)
+	}
+	h.options.ContentTemplateFile = filepath.Join(t.TempDir(), "template.md")
+	if err := os.WriteFile(h.options.ContentTemplateFile, []byte("Scan {repo} at {commit_hash}"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.run(false); err == nil || !strings.Contains(err.Error(), "unknown placeholders {repo}") {
+		t.Fatalf("expected an unknown placeholder to stop the installer, got %v", err)
+	}
+	if parameterValues(h.aws.Items("tvo-security-scan-parameter-prod"))["content_template"] != defaultContentTemplate {
+		t.Error("expected the content template to be left untouched")
+	}
+}
+
+func TestRunInstallerMCPGateway(t *testing.T) {
+	h := newE2EHarness(t)
+	h.aws.AddCloudMapInstance("svc.corp.internal", "titvo-mcp", "10.0.1.9")
+	content, err := os.ReadFile(h.configFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var config SetupConfigFile
+	if err := json.Unmarshal(content, &config); err != nil {
+		t.Fatal(err)
+	}
+	config.MCPGatewayHostname, config.MCPGatewayPort, config.MCPNamespace = "titvo-mcp", 8080, "svc.corp.internal"
+	if content, err = json.Marshal(config); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(h.configFile, content, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.run(false); err != nil {
+		t.Fatalf("installer failed: %v", err)
+	}
+	parameters := parameterValues(h.aws.Items("tvo-security-scan-parameter-prod"))
+	if parameters["mcp_server_url"] != "http://titvo-mcp.svc.corp.internal:8080/mcp" {
+		t.Errorf("expected the configured MCP server URL, got %q", parameters["mcp_server_url"])
+	}
+
+	config.MCPGatewayHostname = "other-mcp"
+	if content, err = json.Marshal(config); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(h.configFile, content, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	_, err = h.run(false)
+	if err == nil || !strings.Contains(err.Error(), "failed to resolve the MCP gateway other-mcp.svc.corp.internal") {
+		t.Fatalf("expected the unregistered service to stop the installer, got %v", err)
+	}
+}
+
+func TestRunInstallerBedrock(t *testing.T) {
+	h := newE2EHarness(t)
+	h.aws.AddJobDefinition("tvo-agent-job-prod", "tvo-agent-job-role-prod")
+	h.aws.AddBedrockModels([]string{"anthropic.claude-3-5-sonnet-20240620-v1:0"}, []string{"us.anthropic.claude-3-5-sonnet-20240620-v1:0"})
+	content, err := os.ReadFile(h.configFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var config SetupConfigFile
+	if err := json.Unmarshal(content, &config); err != nil {
+		t.Fatal(err)
+	}
+	config.AIProvider, config.AIModel, config.AIApiKey = "bedrock", "us.anthropic.claude-3-5-sonnet-20240620-v1:0", ""
+	if content, err = json.Marshal(config); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(h.configFile, content, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.run(false); err != nil {
+		t.Fatalf("installer failed: %v", err)
+	}
+
+	document, ok := h.aws.RolePolicy("tvo-agent-job-role-prod", bedrockPolicyName)
+	if !ok {
+		t.Fatalf("expected the agent job role to be granted the Bedrock models")
+	}
+	if !strings.Contains(document, "bedrock:InvokeModel") || !strings.Contains(document, "arn:aws:bedrock:us-east-1:123456789012:inference-profile/*") {
+		t.Errorf("unexpected Bedrock policy %s", document)
+	}
+	parameters := parameterValues(h.aws.Items("tvo-security-scan-parameter-prod"))
+	for id, want := range map[string]string{"ai_provider": "bedrock", "ai_model": "us.anthropic.claude-3-5-sonnet-20240620-v1:0", "ai_region": "us-east-1", "ai_api_key": ""} {
+		if parameters[id] != want {
+			t.Errorf("expected parameter record %s to be %q, got %q", id, want, parameters[id])
+		}
+	}
+}
+
+func TestRunInstallerKMSFormat(t *testing.T) {
+	h := newE2EHarness(t)
+	h.options.EncryptionFormat = EncryptionFormatKMS
+	h.aws.AddJobDefinition("tvo-agent-job-prod", "tvo-agent-job-role-prod")
+	// The GitHub token is encrypted with the key, but only the agent job role
+	// is found without --kms-grant-role
+	if _, err := h.run(false); err == nil || !strings.Contains(err.Error(), "use --kms-grant-role") {
+		t.Fatalf("expected the SCM token to require --kms-grant-role, got %v", err)
+	}
+	if _, ok := h.aws.KMSKey(kmsKeyAlias); ok {
+		t.Fatal("expected the check to fail before the KMS key is created")
+	}
+
+	h.options.KMSGrantRoles = []string{"tvo-agent-job-role-prod", "tvo-github-issue-prod"}
+	events, err := h.run(false)
+	if err != nil {
+		t.Fatalf("installer failed: %v", err)
+	}
+	warned := false
+	for _, event := range events {
+		message, _ := event["message"].(string)
+		warned = warned || (event["event"] == "warning" && strings.Contains(message, "generated only because the base infra reads its name and ARN"))
+	}
+	if !warned {
+		t.Error("expected a warning that the AES secret is generated for the base infra")
+	}
+
+	key, ok := h.aws.KMSKey(kmsKeyAlias)
+	if !ok {
+		t.Fatalf("expected the KMS key %s to be created", kmsKeyAlias)
+	}
+	if !key.RotationEnabled || key.Tags["ManagedBy"] != "titvo-installer" {
+		t.Errorf("expected a tagged key with rotation, got %+v", key)
+	}
+	if !slices.Equal(key.Grants, []string{"arn:aws:iam::123456789012:role/tvo-agent-job-role-prod", "arn:aws:iam::123456789012:role/tvo-github-issue-prod"}) {
+		t.Errorf("expected the key to be granted to the roles given, got %v", key.Grants)
+	}
+	if value, _ := h.aws.Parameter(kmsKeyParameter); value != key.ARN {
+		t.Errorf("expected the key ARN parameter, got %q", value)
+	}
+	// The base infra modules still read the AES secret parameters
+	if _, ok := h.aws.Secret(aesSecretName); !ok {
+		t.Error("expected the AES secret read by the base infra in the kms format")
+	}
+	if value, _ := h.aws.Parameter("/tvo/security-scan/prod/infra/kms/encryption-key-name"); value != aesSecretName {
+		t.Errorf("expected the AES secret name parameter, got %q", value)
+	}
+
+	parameters := parameterValues(h.aws.Items("tvo-security-scan-parameter-prod"))
+	if parameters[encryptionFormatParameterID] != EncryptionFormatKMS {
+		t.Errorf("expected the encryption format record to be kms, got %q", parameters[encryptionFormatParameterID])
+	}
+	for id, plaintext := range map[string]string{"ai_api_key": "sk-e2e-ai-api-key", "github_access_token": "ghp_e2e_access_token"} {
+		ciphertext, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(parameters[id], kmsPrefix))
+		if err != nil || !strings.HasPrefix(parameters[id], kmsPrefix) || string(ciphertext) != "emulated:"+key.ARN+":"+plaintext {
+			t.Errorf("expected parameter record %s to be encrypted with the KMS key, got %q", id, parameters[id])
+		}
+	}
+
+	if _, err := h.run(false); err != nil {
+		t.Fatalf("second installer run failed: %v", err)
+	}
+	if again, _ := h.aws.KMSKey(kmsKeyAlias); again.ARN != key.ARN || len(again.Grants) != 2 {
+		t.Errorf("expected the rerun to reuse the key and its grants, got %+v", again)
+	}
+}
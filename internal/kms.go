@@ -0,0 +1,107 @@
+package internal
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"strings"
+)
+
+const (
+	// kmsKeyAlias names the KMS key the installer creates when no key is given.
+	kmsKeyAlias = "alias/titvo-security-scan-prod"
+	// kmsKeyParameter tells the Titvo services which key the kms format uses.
+	kmsKeyParameter = "/tvo/security-scan/prod/infra/kms/key-arn"
+	kmsGrantName    = "titvo-installer"
+)
+
+// kmsKeyTags are set on the KMS key, whether created or given.
+var kmsKeyTags = map[string]string{
+	"Project":   "titvo",
+	"ManagedBy": "titvo-installer",
+}
+
+// EnsureKMSKey returns the ARN of the customer-managed KMS key keyID, or of
+// the installer's key when keyID is empty, creating it on the first run.
+func (d *Deployer) EnsureKMSKey(ctx context.Context, keyID string) (string, error) {
+	keyARN, err := d.Keys.EnsureKey(ctx, keyID, kmsKeyAlias, kmsKeyTags)
+	if err != nil {
+		return "", err
+	}
+	d.Console.printInfo(fmt.Sprintf("Using KMS key %s", keyARN))
+	return keyARN, nil
+}
+
+// checkKMSGrantRoles fails when the SCM tokens are encrypted with the KMS
+// key but no role is named: the installer only finds the agent's job role,
+// not the roles of the Bitbucket code insights and GitHub issue services
+// that decrypt the tokens.
+func checkKMSGrantRoles(setup *SetupConfig, roles []string) error {
+	if len(roles) > 0 || (setup.BitbucketAPIToken == "" && setup.GithubAccessToken == "") {
+		return nil
+	}
+	return fmt.Errorf("the %s encryption format encrypts the SCM tokens, which the Bitbucket code insights and GitHub issue services read: use --kms-grant-role to name every Titvo role that reads encrypted parameters, including the agent job role", EncryptionFormatKMS)
+}
+
+// GrantKMSKey grants the Titvo roles the use of the KMS key. roles are role
+// names or ARNs; when empty, only the job role of the agent's Batch job
+// definition created by the infra modules is granted, which is enough when
+// the AI API key is the only encrypted parameter.
+func (d *Deployer) GrantKMSKey(ctx context.Context, keyARN string, roles []string) error {
+	roleARNs := []string{}
+	if len(roles) == 0 {
+		jobDefinition, err := d.Parameters.GetParameter(ctx, agentJobDefinitionParameter)
+		if err != nil {
+			return err
+		}
+		roleARN, err := d.Batch.JobRoleARN(ctx, jobDefinition)
+		if err != nil {
+			return err
+		}
+		if roleARN != "" {
+			roleARNs = append(roleARNs, roleARN)
+		}
+	} else {
+		accountID, err := d.Accounts.GetAccountID(ctx)
+		if err != nil {
+			return err
+		}
+		for _, role := range roles {
+			if !strings.HasPrefix(role, "arn:") {
+				role = fmt.Sprintf("arn:aws:iam::%s:role/%s", accountID, role)
+			}
+			roleARNs = append(roleARNs, role)
+		}
+	}
+	if len(roleARNs) == 0 {
+		d.Console.printAskQuestion("Warning: the agent job definition has no job role, the KMS key is not granted to any role. Use --kms-grant-role to name the roles")
+		return nil
+	}
+	for _, roleARN := range roleARNs {
+		created, err := d.Keys.GrantKey(ctx, keyARN, kmsGrantName, roleARN)
+		if err != nil {
+			return err
+		}
+		if created {
+			d.Console.printInfo(fmt.Sprintf("KMS key granted to %s", roleARN))
+		}
+	}
+	return nil
+}
+
+// encryptParameter encrypts a sensitive parameter value in format: with the
+// KMS key in the kms format and with the AES secret otherwise.
+func (d *Deployer) encryptParameter(ctx context.Context, text, format, aesSecret, kmsKeyARN string) (string, error) {
+	return encryptParameter(ctx, d.Keys, text, format, aesSecret, kmsKeyARN)
+}
+
+func encryptParameter(ctx context.Context, keys KeyManager, text, format, aesSecret, kmsKeyARN string) (string, error) {
+	if format != EncryptionFormatKMS {
+		return encryptValue(text, aesSecret, format)
+	}
+	ciphertext, err := keys.EncryptWithKey(ctx, kmsKeyARN, []byte(text))
+	if err != nil {
+		return "", err
+	}
+	return kmsPrefix + base64.StdEncoding.EncodeToString(ciphertext), nil
+}
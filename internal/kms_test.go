@@ -0,0 +1,138 @@
+package internal
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestGrantKMSKey(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name     string
+		roles    []string
+		existing []string
+		expected []string
+	}{
+		{
+			name:     "agent job role",
+			expected: []string{"arn:aws:iam::123456789012:role/tvo-agent-job-role-prod"},
+		},
+		{
+			name:     "roles given by name or ARN",
+			roles:    []string{"scanner", "arn:aws:iam::210987654321:role/auditor"},
+			expected: []string{"arn:aws:iam::123456789012:role/scanner", "arn:aws:iam::210987654321:role/auditor"},
+		},
+		{
+			name:     "existing grants are kept",
+			roles:    []string{"tvo-agent-job-role-prod", "tvo-task-trigger-prod"},
+			existing: []string{"arn:aws:iam::123456789012:role/tvo-agent-job-role-prod"},
+			expected: []string{"arn:aws:iam::123456789012:role/tvo-task-trigger-prod"},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			d, fake := testDeployer()
+			fake.getParameter = func(name string) (string, error) {
+				if name != agentJobDefinitionParameter {
+					t.Fatalf("unexpected parameter %s", name)
+				}
+				return "tvo-agent-job-prod", nil
+			}
+			fake.jobRoleARN = func(jobDefinition string) (string, error) {
+				if jobDefinition != "tvo-agent-job-prod" {
+					t.Fatalf("unexpected job definition %s", jobDefinition)
+				}
+				return "arn:aws:iam::123456789012:role/tvo-agent-job-role-prod", nil
+			}
+			granted := []string{}
+			fake.grantKey = func(keyARN, grantName, roleARN string) (bool, error) {
+				if keyARN != "key-arn" || grantName != kmsGrantName {
+					t.Fatalf("unexpected grant %s of %s", grantName, keyARN)
+				}
+				if slices.Contains(tc.existing, roleARN) {
+					return false, nil
+				}
+				granted = append(granted, roleARN)
+				return true, nil
+			}
+
+			if err := d.GrantKMSKey(context.Background(), "key-arn", tc.roles); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if !slices.Equal(granted, tc.expected) {
+				t.Fatalf("expected grants to %v, got %v", tc.expected, granted)
+			}
+		})
+	}
+}
+
+func TestGrantKMSKeyErrors(t *testing.T) {
+	t.Parallel()
+	d, fake := testDeployer()
+	fake.grantKey = func(keyARN, grantName, roleARN string) (bool, error) {
+		return false, errors.New("access denied")
+	}
+	if err := d.GrantKMSKey(context.Background(), "key-arn", nil); err == nil || err.Error() != "access denied" {
+		t.Fatalf("expected the grant error, got %v", err)
+	}
+}
+
+func TestCheckKMSGrantRoles(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name     string
+		setup    SetupConfig
+		roles    []string
+		expected string
+	}{
+		{name: "no SCM tokens"},
+		{name: "bitbucket token without roles", setup: SetupConfig{BitbucketAPIToken: "bb-token"}, expected: "use --kms-grant-role"},
+		{name: "github token without roles", setup: SetupConfig{GithubAccessToken: "ghp_token"}, expected: "use --kms-grant-role"},
+		{name: "github token with roles", setup: SetupConfig{GithubAccessToken: "ghp_token"}, roles: []string{"tvo-agent-job-role-prod", "tvo-github-issue-prod"}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			err := checkKMSGrantRoles(&tc.setup, tc.roles)
+			if tc.expected == "" {
+				if err != nil {
+					t.Fatalf("expected no error, got %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.expected) {
+				t.Fatalf("expected error containing %q, got %v", tc.expected, err)
+			}
+		})
+	}
+}
+
+func TestEncryptParameter(t *testing.T) {
+	t.Parallel()
+	d, _ := testDeployer()
+
+	encrypted, err := d.encryptParameter(context.Background(), "sk-test", EncryptionFormatKMS, "", "key-arn")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(encrypted, kmsPrefix))
+	if valueFormat(encrypted) != EncryptionFormatKMS || err != nil || string(ciphertext) != "key-arn:sk-test" {
+		t.Fatalf("expected the value encrypted with the KMS key, got %q", encrypted)
+	}
+	if _, err := decryptValue(encrypted, testAESKey); err == nil || !strings.Contains(err.Error(), "encrypted with the KMS key") {
+		t.Fatalf("expected kms values not to be decrypted with the AES secret, got %v", err)
+	}
+
+	encrypted, err = d.encryptParameter(context.Background(), "sk-test", EncryptionFormatGCM, testAESKey, "")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if decrypted, err := decryptValue(encrypted, testAESKey); err != nil || decrypted != "sk-test" {
+		t.Fatalf("expected the value encrypted with the AES secret, got %q: %v", encrypted, err)
+	}
+}
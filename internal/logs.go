@@ -0,0 +1,50 @@
+package internal
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// RunLogs lists the logged installer runs or, given a run ID ("latest" for the
+// most recent one) and optionally a component, prints its log.
+func RunLogs(cmd *cobra.Command, args []string) {
+	console := textConsole(cmd.OutOrStdout())
+	titvoDir, err := titvoHomeDir()
+	if err != nil {
+		console.printErrorAndExit(err)
+	}
+	if len(args) == 0 {
+		runs, err := listRuns(titvoDir)
+		if err != nil {
+			console.printErrorAndExit(err)
+		}
+		if len(runs) == 0 {
+			console.printInfo("No installer runs found")
+			return
+		}
+		for _, run := range runs {
+			fmt.Fprintf(cmd.OutOrStdout(), "%s\t%s\n", run.ID, strings.Join(run.Components, ", "))
+		}
+		return
+	}
+	component := ""
+	if len(args) > 1 {
+		component = args[1]
+	}
+	file, err := findRunLog(titvoDir, args[0], component)
+	if err != nil {
+		console.printErrorAndExit(err)
+	}
+	content, err := os.Open(file)
+	if err != nil {
+		console.printErrorAndExit(err)
+	}
+	defer content.Close()
+	if _, err := io.Copy(cmd.OutOrStdout(), content); err != nil {
+		console.printErrorAndExit(err)
+	}
+}
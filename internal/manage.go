@@ -0,0 +1,94 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+)
+
+// SSM parameters holding the names of the DynamoDB tables written by the
+// infra modules.
+const (
+	userTableParameter      = "/tvo/security-scan/prod/infra/dynamo/user-table-name"
+	apiKeyTableParameter    = "/tvo/security-scan/prod/infra/dynamo/apikey-table-name"
+	parameterTableParameter = "/tvo/security-scan/prod/infra/dynamo/parameter-table-name"
+)
+
+// managementSession returns the AWS session of a management command such as
+// `users list`. Credentials come from the --config file or the --profile of
+// ~/.aws/credentials when given, and from the default AWS chain otherwise.
+func managementSession(cmd *cobra.Command, console *Console) (*AWSSession, error) {
+	flags := cmd.Flags()
+	configFile, err := flags.GetString("config")
+	if err != nil {
+		return nil, err
+	}
+	profile, err := flags.GetString("profile")
+	if err != nil {
+		return nil, err
+	}
+	region, err := flags.GetString("region")
+	if err != nil {
+		return nil, err
+	}
+	endpoints, err := flags.GetStringToString("aws-endpoint")
+	if err != nil {
+		return nil, err
+	}
+	var lookup AWSCredentialsLookup
+	switch {
+	case configFile != "":
+		setup, err := loadSetupConfigFile(configFile)
+		if err != nil {
+			return nil, err
+		}
+		lookup = setup.AWSCredentialsLookup
+	case profile != "":
+		lookup = &AWSFileCredentials{Profile: profile, Region: region}
+	default:
+		lookup = &InputCredential{AWSCredentials: AWSCredentials{AWSRegion: region}}
+	}
+	creds, err := lookup.GetCredentials()
+	if err != nil {
+		return nil, err
+	}
+	if region != "" {
+		creds.AWSRegion = region
+	}
+	console.Secrets.Add(creds.secretValues()...)
+	return NewAWSSession(cmd.Context(), creds, AWSSessionOptions{Endpoints: endpoints, Console: console})
+}
+
+// managementConsole returns the console of a management command for its
+// --output format, and the format. An unknown format exits.
+func managementConsole(cmd *cobra.Command) (*Console, string) {
+	format, err := cmd.Flags().GetString("output")
+	if err != nil {
+		textConsole(cmd.OutOrStdout()).printErrorAndExit(err)
+	}
+	console, err := newConsole(format, cmd.OutOrStdout())
+	if err != nil {
+		textConsole(cmd.OutOrStdout()).printErrorAndExit(err)
+	}
+	return console, format
+}
+
+// writeListing writes rows as an aligned table with a header, or as a JSON
+// array of values in the json format.
+func writeListing(out io.Writer, format string, header []string, rows [][]string, values any) error {
+	if format == OutputJSON {
+		encoder := json.NewEncoder(out)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(values)
+	}
+	table := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, strings.Join(header, "\t"))
+	for _, row := range rows {
+		fmt.Fprintln(table, strings.Join(row, "\t"))
+	}
+	return table.Flush()
+}
@@ -0,0 +1,110 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// The MCP gateway registers in a Cloud Map private DNS namespace, which
+// makes it reachable by the agent at http://<hostname>.<namespace>:<port>/mcp.
+const (
+	defaultMCPGatewayHostname = "gateway"
+	defaultMCPGatewayPort     = 3000
+	defaultMCPNamespace       = "internal.titvo.com"
+)
+
+var dnsLabelPattern = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$`)
+
+// MCPGateway is the internal DNS name and port of the MCP gateway.
+type MCPGateway struct {
+	// Hostname is the name of the Cloud Map service of the gateway.
+	Hostname  string
+	Port      int
+	Namespace string
+}
+
+// withDefaults returns g with the defaults of the settings not given.
+func (g MCPGateway) withDefaults() MCPGateway {
+	if g.Hostname == "" {
+		g.Hostname = defaultMCPGatewayHostname
+	}
+	if g.Port == 0 {
+		g.Port = defaultMCPGatewayPort
+	}
+	if g.Namespace == "" {
+		g.Namespace = defaultMCPNamespace
+	}
+	return g
+}
+
+func (g MCPGateway) validate() error {
+	if !dnsLabelPattern.MatchString(g.Hostname) {
+		return fmt.Errorf("invalid MCP gateway hostname %q, expected a lowercase DNS label such as %s", g.Hostname, defaultMCPGatewayHostname)
+	}
+	if g.Port < 1 || g.Port > 65535 {
+		return fmt.Errorf("invalid MCP gateway port %d, expected 1 to 65535", g.Port)
+	}
+	labels := strings.Split(g.Namespace, ".")
+	for _, label := range labels {
+		if !dnsLabelPattern.MatchString(label) {
+			return fmt.Errorf("invalid Cloud Map namespace %q, expected a lowercase DNS name such as %s", g.Namespace, defaultMCPNamespace)
+		}
+	}
+	if len(labels) < 2 {
+		return fmt.Errorf("invalid Cloud Map namespace %q, expected a lowercase DNS name such as %s", g.Namespace, defaultMCPNamespace)
+	}
+	return nil
+}
+
+// URL is the MCP server URL the agent connects to.
+func (g MCPGateway) URL() string {
+	return fmt.Sprintf("http://%s.%s:%d/mcp", g.Hostname, g.Namespace, g.Port)
+}
+
+// env is the environment of the MCP gateway deployment, which names its
+// Cloud Map service and namespace and its listening port after it.
+func (g MCPGateway) env() map[string]string {
+	return map[string]string{
+		"MCP_GATEWAY_HOSTNAME":  g.Hostname,
+		"MCP_GATEWAY_PORT":      strconv.Itoa(g.Port),
+		"MCP_GATEWAY_NAMESPACE": g.Namespace,
+	}
+}
+
+// resolveMCPGateway checks that the Cloud Map service of the gateway has
+// registered instances and returns the MCP server URL.
+func (d *Deployer) resolveMCPGateway(ctx context.Context, gateway MCPGateway) (string, error) {
+	instances, err := d.Services.ResolveService(ctx, gateway.Namespace, gateway.Hostname)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve the MCP gateway %s.%s: %w", gateway.Hostname, gateway.Namespace, err)
+	}
+	d.Console.printInfo(fmt.Sprintf("MCP gateway %s.%s resolved to %s", gateway.Hostname, gateway.Namespace, strings.Join(instances, ", ")))
+	return gateway.URL(), nil
+}
+
+// askForMCPGateway asks for the MCP gateway name and port, keeping the
+// defaults unless the user wants to change them.
+func (c *Console) askForMCPGateway() (MCPGateway, error) {
+	gateway := MCPGateway{}.withDefaults()
+	change, err := c.askForYesNo(fmt.Sprintf("Do you want to change the internal address of the MCP gateway (%s.%s:%d)? (y/N)", gateway.Hostname, gateway.Namespace, gateway.Port))
+	if err != nil || !change {
+		return MCPGateway{}, err
+	}
+	if gateway.Hostname, err = c.askForInputWithDefault("Enter the MCP gateway hostname", "MCP Gateway Hostname", gateway.Hostname); err != nil {
+		return MCPGateway{}, err
+	}
+	if gateway.Namespace, err = c.askForInputWithDefault("Enter the Cloud Map namespace", "Cloud Map Namespace", gateway.Namespace); err != nil {
+		return MCPGateway{}, err
+	}
+	port, err := c.askForInputWithDefault("Enter the MCP gateway port", "MCP Gateway Port", strconv.Itoa(gateway.Port))
+	if err != nil {
+		return MCPGateway{}, err
+	}
+	if gateway.Port, err = strconv.Atoi(strings.TrimSpace(port)); err != nil {
+		return MCPGateway{}, fmt.Errorf("invalid MCP gateway port %q", port)
+	}
+	return gateway, gateway.validate()
+}
@@ -0,0 +1,43 @@
+package internal
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMCPGatewayValidate(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name    string
+		gateway MCPGateway
+		wantURL string
+		wantErr string
+	}{
+		{name: "defaults", gateway: MCPGateway{}, wantURL: "http://gateway.internal.titvo.com:3000/mcp"},
+		{name: "custom", gateway: MCPGateway{Hostname: "titvo-mcp", Port: 8080, Namespace: "svc.corp.internal"}, wantURL: "http://titvo-mcp.svc.corp.internal:8080/mcp"},
+		{name: "hostname with dots", gateway: MCPGateway{Hostname: "mcp.gateway"}, wantErr: `invalid MCP gateway hostname "mcp.gateway"`},
+		{name: "uppercase hostname", gateway: MCPGateway{Hostname: "Gateway"}, wantErr: "invalid MCP gateway hostname"},
+		{name: "port out of range", gateway: MCPGateway{Port: 70000}, wantErr: "invalid MCP gateway port 70000"},
+		{name: "single label namespace", gateway: MCPGateway{Namespace: "internal"}, wantErr: `invalid Cloud Map namespace "internal"`},
+		{name: "empty namespace label", gateway: MCPGateway{Namespace: "internal..titvo.com"}, wantErr: "invalid Cloud Map namespace"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			gateway := tt.gateway.withDefaults()
+			err := gateway.validate()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expected error containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if gateway.URL() != tt.wantURL {
+				t.Fatalf("expected %s, got %s", tt.wantURL, gateway.URL())
+			}
+		})
+	}
+}
@@ -1,10 +1,16 @@
 package internal
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"runtime"
+	"strings"
+	"sync"
+	"time"
 )
 
 type OS string
@@ -58,21 +64,72 @@ func IsLinux() bool {
 	return runtime.GOOS == string(Linux)
 }
 
+// processGracePeriod is how long an interrupted command gets to shut down on
+// its own (e.g. terraform releasing its DynamoDB state lock) before it is killed.
+var processGracePeriod = 2 * time.Minute
+
 type ExecuteOptions struct {
 	WorkingDir string
 	Env        map[string]string // Variables específicas para esta ejecución
+	Resolver   *ToolResolver     // Resuelve el ejecutable; por defecto se usa el PATH del sistema
+	Timeout    time.Duration     // Tiempo máximo de ejecución; 0 significa sin límite
+	Output     io.Writer         // Recibe también stdout/stderr, por ejemplo el log del componente
+	Console    *Console          // Muestra stdout según el --output y enmascara sus secretos; por defecto se usa os.Stdout
 }
 
-func Execute(command string, args ...string) error {
-	return ExecuteWithOptions(command, nil, args...)
+func Execute(ctx context.Context, command string, args ...string) error {
+	return ExecuteWithOptions(ctx, command, nil, args...)
 }
 
-func ExecuteWithOptions(command string, options *ExecuteOptions, args ...string) error {
-	cmd := exec.Command(command, args...)
+// ExecuteWithOptions runs command until it exits or ctx is done. On
+// cancellation the process is asked to stop gracefully and given
+// processGracePeriod to exit before being killed.
+func ExecuteWithOptions(ctx context.Context, command string, options *ExecuteOptions, args ...string) error {
+	resolver := systemToolResolver()
+	if options != nil && options.Resolver != nil {
+		resolver = options.Resolver
+	}
+	executable, err := resolver.Resolve(command)
+	if err != nil {
+		return fmt.Errorf("command failed: %w", err)
+	}
+	var timeout time.Duration
+	if options != nil && options.Timeout > 0 {
+		timeout = options.Timeout
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	cmd := exec.CommandContext(ctx, executable, args...)
+	configureGracefulStop(cmd)
+	cmd.WaitDelay = processGracePeriod
 
 	// Siempre redirigir a stdout/stderr para output en vivo
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+	var stdout, stderr io.Writer = os.Stdout, os.Stderr
+	var secrets *redactor
+	if options != nil && options.Console != nil {
+		stdout = options.Console.Reporter.CommandOutput()
+		secrets = options.Console.Secrets
+	}
+	if options != nil && options.Output != nil {
+		fmt.Fprintf(options.Output, "$ %s\n", secrets.Redact(strings.Join(append([]string{command}, args...), " ")))
+		// stdout y stderr se copian desde goroutines distintas
+		output := &syncWriter{out: options.Output}
+		stdout = io.MultiWriter(stdout, output)
+		stderr = io.MultiWriter(stderr, output)
+	}
+	// Los secretos se enmascaran antes de llegar a la consola o a los logs
+	flushOutput := func() {}
+	if secrets.active() {
+		redactedStdout, redactedStderr := secrets.Writer(stdout), secrets.Writer(stderr)
+		flushOutput = func() {
+			redactedStdout.Flush()
+			redactedStderr.Flush()
+		}
+		stdout, stderr = redactedStdout, redactedStderr
+	}
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
 
 	if options != nil {
 		if options.WorkingDir != "" {
@@ -89,10 +146,36 @@ func ExecuteWithOptions(command string, options *ExecuteOptions, args ...string)
 		}
 	}
 
-	err := cmd.Run()
-	if err != nil {
-		return fmt.Errorf("command failed: %v", err)
+	if err = cmd.Start(); err == nil {
+		untrack := trackCommand(cmd)
+		err = cmd.Wait()
+		untrack()
+	}
+	flushOutput()
+	if err != nil && options != nil && options.Output != nil {
+		fmt.Fprintf(options.Output, "%s exited with error: %v\n", command, secrets.Redact(err.Error()))
+	}
+	if err == nil {
+		// A command that finished as ctx was done still completed its work
+		return nil
+	}
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		if errors.Is(ctxErr, context.DeadlineExceeded) && timeout > 0 {
+			return fmt.Errorf("command timed out after %s: %w", timeout, ctxErr)
+		}
+		return fmt.Errorf("command interrupted: %w", ctxErr)
 	}
+	return fmt.Errorf("command failed: %v", err)
+}
+
+// syncWriter serializes writes to out.
+type syncWriter struct {
+	mu  sync.Mutex
+	out io.Writer
+}
 
-	return nil
+func (w *syncWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.out.Write(p)
 }
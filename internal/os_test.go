@@ -0,0 +1,130 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExecuteWithOptionsTimeout(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses shell scripts as fake executables")
+	}
+	dir := filepath.Join(t.TempDir(), "bin")
+	writeFakeExecutable(t, dir, "terragrunt", "exec sleep 10")
+
+	options := &ExecuteOptions{Resolver: NewToolResolver(Linux, dir), Timeout: 100 * time.Millisecond}
+	start := time.Now()
+	err := ExecuteWithOptions(context.Background(), "terragrunt", options)
+	if !errors.Is(err, context.DeadlineExceeded) || !strings.Contains(err.Error(), "timed out after 100ms") {
+		t.Fatalf("expected timeout error, got %v", err)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Fatalf("expected the command to stop on interrupt")
+	}
+}
+
+func TestExecuteWithOptionsInterrupted(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses shell scripts as fake executables")
+	}
+	original := processGracePeriod
+	processGracePeriod = 100 * time.Millisecond
+	t.Cleanup(func() { processGracePeriod = original })
+
+	dir := filepath.Join(t.TempDir(), "bin")
+	// Ignores SIGINT, so it has to be killed once the grace period is over
+	writeFakeExecutable(t, dir, "terragrunt", "trap '' INT\nexec sleep 10")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+	start := time.Now()
+	err := ExecuteWithOptions(ctx, "terragrunt", &ExecuteOptions{Resolver: NewToolResolver(Linux, dir)})
+	if !errors.Is(err, context.Canceled) || !strings.Contains(err.Error(), "command interrupted") {
+		t.Fatalf("expected interrupted error, got %v", err)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Fatalf("expected the command to be killed after the grace period")
+	}
+}
+
+// finishedContext reports itself as cancelled without ever signalling the
+// command, like a Ctrl-C that arrives once the command already exited.
+type finishedContext struct{ context.Context }
+
+func (finishedContext) Done() <-chan struct{} { return nil }
+
+func (finishedContext) Err() error { return context.Canceled }
+
+func TestExecuteWithOptionsCompletedBeforeCancel(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses shell scripts as fake executables")
+	}
+	dir := filepath.Join(t.TempDir(), "bin")
+	writeFakeExecutable(t, dir, "terragrunt", "exit 0")
+
+	ctx := finishedContext{context.Background()}
+	if err := ExecuteWithOptions(ctx, "terragrunt", &ExecuteOptions{Resolver: NewToolResolver(Linux, dir)}); err != nil {
+		t.Fatalf("expected a successful exit to be reported as such, got %v", err)
+	}
+}
+
+func TestKillRunningCommands(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses shell scripts as fake executables")
+	}
+	dir := filepath.Join(t.TempDir(), "bin")
+	// Like terragrunt, waits on a child that also ignores SIGINT
+	writeFakeExecutable(t, dir, "terragrunt", "trap '' INT\nsleep 10 &\nwait")
+
+	done := make(chan error, 1)
+	go func() {
+		done <- ExecuteWithOptions(context.Background(), "terragrunt", &ExecuteOptions{Resolver: NewToolResolver(Linux, dir)})
+	}()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		runningCommands.Lock()
+		running := len(runningCommands.commands)
+		runningCommands.Unlock()
+		if running > 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected the command to be tracked while running")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	killRunningCommands(testConsole())
+	select {
+	case err := <-done:
+		if err == nil || !strings.Contains(err.Error(), "killed") {
+			t.Fatalf("expected the command to be killed, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the command and its child to be killed")
+	}
+}
+
+func TestExecuteWithOptionsTeesOutput(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses shell scripts as fake executables")
+	}
+	dir := filepath.Join(t.TempDir(), "bin")
+	writeFakeExecutable(t, dir, "npm", "echo building\necho warning >&2\nexit 3")
+
+	var output bytes.Buffer
+	err := ExecuteWithOptions(context.Background(), "npm", &ExecuteOptions{Resolver: NewToolResolver(Linux, dir), Output: &output}, "run", "build")
+	if err == nil {
+		t.Fatalf("expected the command to fail")
+	}
+	for _, expected := range []string{"$ npm run build", "building", "warning", "npm exited with error: exit status 3"} {
+		if !strings.Contains(output.String(), expected) {
+			t.Fatalf("expected output to contain %q, got %q", expected, output.String())
+		}
+	}
+}
@@ -2,100 +2,102 @@ package internal
 
 import (
 	"bufio"
+	"context"
 	"fmt"
 	"os"
 	"strings"
 
-	"github.com/fatih/color"
 	"golang.org/x/term"
 )
 
-func printError(err error) {
-	fmt.Println(color.RedString(err.Error()))
+func (c *Console) printError(err error) {
+	message := c.Secrets.Redact(err.Error())
+	c.Log.logf("ERROR", "%s", message)
+	c.Reporter.Error(message)
 }
 
-func printErrorAndExit(err error) {
-	printError(err)
+func (c *Console) printErrorAndExit(err error) {
+	c.printError(err)
 	os.Exit(1)
 }
 
-func printInfo(message string) {
-	fmt.Println(color.GreenString(message))
+func (c *Console) printInfo(message string) {
+	message = c.Secrets.Redact(message)
+	c.Log.logf("INFO", "%s", message)
+	c.Reporter.Info(message)
 }
 
-func printAskQuestion(message string) {
-	fmt.Println(color.YellowString(message))
+func (c *Console) printAskQuestion(message string) {
+	message = c.Secrets.Redact(message)
+	c.Log.logf("WARN", "%s", message)
+	c.Reporter.Warning(message)
 }
 
-func askForCredentialsFile(awsRegion string) (*SetupConfig, error) {
-	profile, err := askForInput("Enter your AWS Profile", "AWS Profile")
+func (c *Console) askForCredentialsFile(ctx context.Context, awsRegion string, validator *AIValidator) (*SetupConfig, error) {
+	profile, err := c.askForInput("Enter your AWS Profile", "AWS Profile")
 	if err != nil {
-		printErrorAndExit(err)
+		c.printErrorAndExit(err)
 	}
-	vpcID, err := askForInput("Enter your VPC ID", "VPC ID")
+	vpcID, err := c.askForInput("Enter your VPC ID", "VPC ID")
 	if err != nil {
-		printErrorAndExit(err)
+		c.printErrorAndExit(err)
 	}
-	printAskQuestion("These values will be used to create an isolated private network for Titvo.")
-	privateSubnetCIDR, err := askForInput("Enter your private subnet CIDR (e.g. 172.31.64.0/20)", "Private Subnet CIDR")
+	c.printAskQuestion("These values will be used to create an isolated private network for Titvo.")
+	privateSubnetCIDR, err := c.askForInput("Enter your private subnet CIDR (e.g. 172.31.64.0/20)", "Private Subnet CIDR")
 	if err != nil {
-		printErrorAndExit(err)
+		c.printErrorAndExit(err)
 	}
-	availabilityZone, err := askForInput("Enter your Availability Zone (e.g. us-east-1a)", "Availability Zone")
+	availabilityZone, err := c.askForInput("Enter your Availability Zone (e.g. us-east-1a)", "Availability Zone")
 	if err != nil {
-		printErrorAndExit(err)
+		c.printErrorAndExit(err)
 	}
-	natGatewayID, err := askForInput("Enter your NAT Gateway ID (e.g. nat-xxxxxxxxxxxxxxxxx)", "NAT Gateway ID")
+	natGatewayID, err := c.askForInput("Enter your NAT Gateway ID (e.g. nat-xxxxxxxxxxxxxxxxx)", "NAT Gateway ID")
 	if err != nil {
-		printErrorAndExit(err)
+		c.printErrorAndExit(err)
 	}
-	aesSecret, err := askForPassword("Enter your AES Secret", "AES Secret")
+	aesSecret, err := c.askForAESSecret()
 	if err != nil {
-		printErrorAndExit(err)
+		c.printErrorAndExit(err)
 	}
-	userName, err := askForInput("Enter your first Titvo User Name", "Titvo User Name")
+	userName, err := c.askForInput("Enter your first Titvo User Name", "Titvo User Name")
 	if err != nil {
-		printErrorAndExit(err)
+		c.printErrorAndExit(err)
 	}
-	aiProvider, err := askForAIProvider()
+	ai, aiChecked, err := c.askForAISettings(ctx, validator, strings.TrimSpace(awsRegion))
 	if err != nil {
-		printErrorAndExit(err)
+		c.printErrorAndExit(err)
 	}
-	aiModel, err := askForInput("Enter your AI Model", "AI Model")
+	mcpGateway, err := c.askForMCPGateway()
 	if err != nil {
-		printErrorAndExit(err)
-	}
-	aiApiKey, err := askForPassword("Enter your AI API Key", "AI API Key")
-	if err != nil {
-		printErrorAndExit(err)
+		c.printErrorAndExit(err)
 	}
 
 	bitbucketAPIToken := ""
-	configureBitbucket, err := askForYesNo("Do you want to configure Bitbucket credentials? (y/N)")
+	configureBitbucket, err := c.askForYesNo("Do you want to configure Bitbucket credentials? (y/N)")
 	if err != nil {
-		printErrorAndExit(err)
+		c.printErrorAndExit(err)
 	}
 	if configureBitbucket {
-		bitbucketAPIToken, err = askForPassword("Enter Bitbucket API Token", "Bitbucket API Token")
+		bitbucketAPIToken, err = c.askForPassword("Enter Bitbucket API Token", "Bitbucket API Token")
 		if err != nil {
-			printErrorAndExit(err)
+			c.printErrorAndExit(err)
 		}
 	} else {
-		printAskQuestion("Warning: Bitbucket credentials were not provided. Bitbucket integration deployment will be skipped.")
+		c.printAskQuestion("Warning: Bitbucket credentials were not provided. Bitbucket integration deployment will be skipped.")
 	}
 
 	githubAccessToken := ""
-	configureGithub, err := askForYesNo("Do you want to configure GitHub credentials? (y/N)")
+	configureGithub, err := c.askForYesNo("Do you want to configure GitHub credentials? (y/N)")
 	if err != nil {
-		printErrorAndExit(err)
+		c.printErrorAndExit(err)
 	}
 	if configureGithub {
-		githubAccessToken, err = askForPassword("Enter GitHub Access Token", "GitHub Access Token")
+		githubAccessToken, err = c.askForPassword("Enter GitHub Access Token", "GitHub Access Token")
 		if err != nil {
-			printErrorAndExit(err)
+			c.printErrorAndExit(err)
 		}
 	} else {
-		printAskQuestion("Warning: GitHub access token was not provided. GitHub integration deployment will be skipped.")
+		c.printAskQuestion("Warning: GitHub access token was not provided. GitHub integration deployment will be skipped.")
 	}
 
 	return &SetupConfig{
@@ -103,30 +105,37 @@ func askForCredentialsFile(awsRegion string) (*SetupConfig, error) {
 			Profile: profile,
 			Region:  strings.TrimSpace(awsRegion),
 		},
-		VPCID:             vpcID,
-		PrivateSubnetCIDR: privateSubnetCIDR,
-		AvailabilityZone:  availabilityZone,
-		NatGatewayID:      natGatewayID,
-		AesSecret:         string(aesSecret),
-		UserName:          userName,
-		AIProvider:        aiProvider,
-		AIModel:           aiModel,
-		AIApiKey:          string(aiApiKey),
-		BitbucketAPIToken: string(bitbucketAPIToken),
-		GithubAccessToken: string(githubAccessToken),
+		VPCID:              vpcID,
+		PrivateSubnetCIDR:  privateSubnetCIDR,
+		AvailabilityZone:   availabilityZone,
+		NatGatewayID:       natGatewayID,
+		AesSecret:          string(aesSecret),
+		UserName:           userName,
+		AIProvider:         ai.Provider,
+		AIModel:            ai.Model,
+		AIApiKey:           ai.APIKey,
+		AIBaseURL:          ai.BaseURL,
+		AIAPIVersion:       ai.APIVersion,
+		AIRegion:           ai.Region,
+		MCPGatewayHostname: mcpGateway.Hostname,
+		MCPGatewayPort:     mcpGateway.Port,
+		MCPNamespace:       mcpGateway.Namespace,
+		BitbucketAPIToken:  string(bitbucketAPIToken),
+		GithubAccessToken:  string(githubAccessToken),
+		aiChecked:          aiChecked,
 	}, nil
 }
 
-func askForInputWithDefault(question string, inputName string, defaultValue string) (string, error) {
+func (c *Console) askForInputWithDefault(question string, inputName string, defaultValue string) (string, error) {
 	if defaultValue != "" {
-		printAskQuestion(fmt.Sprintf("%s: (default: %s)", question, defaultValue))
+		c.printAskQuestion(fmt.Sprintf("%s: (default: %s)", question, defaultValue))
 	} else {
-		printAskQuestion(question)
+		c.printAskQuestion(question)
 	}
 	reader := bufio.NewReader(os.Stdin)
 	answer, err := reader.ReadString('\n')
 	if err != nil {
-		printError(fmt.Errorf("error reading %s: %v", inputName, err))
+		c.printError(fmt.Errorf("error reading %s: %v", inputName, err))
 		return "", err
 	}
 	if len(strings.TrimSpace(answer)) == 0 && defaultValue == "" {
@@ -138,15 +147,15 @@ func askForInputWithDefault(question string, inputName string, defaultValue stri
 	return strings.TrimSpace(answer), nil
 }
 
-func askForInput(question string, inputName string) (string, error) {
-	return askForInputWithDefault(question, inputName, "")
+func (c *Console) askForInput(question string, inputName string) (string, error) {
+	return c.askForInputWithDefault(question, inputName, "")
 }
 
-func askForPassword(question string, inputName string) (string, error) {
-	printAskQuestion(fmt.Sprintf("%s: ", question))
+func (c *Console) askForPassword(question string, inputName string) (string, error) {
+	c.printAskQuestion(fmt.Sprintf("%s: ", question))
 	answer, err := term.ReadPassword(int(os.Stdin.Fd()))
 	if err != nil {
-		printError(fmt.Errorf("error reading %s: %v", inputName, err))
+		c.printError(fmt.Errorf("error reading %s: %v", inputName, err))
 		return "", err
 	}
 	if len(strings.TrimSpace(string(answer))) == 0 {
@@ -155,12 +164,12 @@ func askForPassword(question string, inputName string) (string, error) {
 	return strings.TrimSpace(string(answer)), nil
 }
 
-func askForYesNo(question string) (bool, error) {
-	printAskQuestion(question)
+func (c *Console) askForYesNo(question string) (bool, error) {
+	c.printAskQuestion(question)
 	reader := bufio.NewReader(os.Stdin)
 	answer, err := reader.ReadString('\n')
 	if err != nil {
-		printError(fmt.Errorf("error reading yes/no input: %v", err))
+		c.printError(fmt.Errorf("error reading yes/no input: %v", err))
 		return false, err
 	}
 	normalized := strings.ToLower(strings.TrimSpace(answer))
@@ -181,12 +190,12 @@ type choice struct {
 	Callback choiceCallback
 }
 
-func askForChoices(question string, choices []choice) (any, error) {
-	printAskQuestion(question)
+func (c *Console) askForChoices(question string, choices []choice) (any, error) {
+	c.printAskQuestion(question)
 	for _, choice := range choices {
-		printAskQuestion(fmt.Sprintf("- %s: %s", choice.Label, choice.Value))
+		c.printAskQuestion(fmt.Sprintf("- %s: %s", choice.Label, choice.Value))
 	}
-	answer, err := askForInput("Enter your choice", "Choice")
+	answer, err := c.askForInput("Enter your choice", "Choice")
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,566 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	titvoinstaller "github.com/KaribuLab/titvo-installer"
+	"github.com/spf13/cobra"
+)
+
+// The default scan system prompt and content template ship with the
+// installer, so every release installs the prompt it was tested with.
+var (
+	defaultSystemPrompt    = titvoinstaller.SystemPrompt
+	defaultContentTemplate = titvoinstaller.ContentTemplate
+)
+
+// promptSourceDefault is the source of the versions holding an embedded
+// default, which a later installer replaces with its own default.
+const promptSourceDefault = "default"
+
+// promptDocument is a text of the parameter table the agent builds its
+// requests from.
+type promptDocument struct {
+	// Name identifies the document in the prompt commands.
+	Name        string
+	ParameterID string
+	Default     string
+	// Check validates a new version before it is stored, warning on console;
+	// nil accepts any.
+	Check func(console *Console, content string) error
+}
+
+var promptDocuments = []promptDocument{
+	{Name: "system-prompt", ParameterID: "scan_system_prompt", Default: defaultSystemPrompt},
+	{Name: "content-template", ParameterID: "content_template", Default: defaultContentTemplate, Check: checkContentTemplate},
+}
+
+func findPromptDocument(name string) (promptDocument, error) {
+	names := []string{}
+	for _, document := range promptDocuments {
+		if document.Name == name {
+			return document, nil
+		}
+		names = append(names, document.Name)
+	}
+	return promptDocument{}, fmt.Errorf("unknown prompt document %q, use %s", name, strings.Join(names, " or "))
+}
+
+func (d promptDocument) check(console *Console, content string) error {
+	if d.Check == nil {
+		return nil
+	}
+	return d.Check(console, content)
+}
+
+// historyID is the parameter table record holding a version of the document.
+func (d promptDocument) historyID(version int) string {
+	return fmt.Sprintf("%s#v%d", d.ParameterID, version)
+}
+
+// PromptVersion is a version of a prompt document kept in the parameter
+// table.
+type PromptVersion struct {
+	Version   int    `json:"version"`
+	CreatedAt string `json:"created_at,omitempty"`
+	// Source is where the version came from: default, a file, stdin, a
+	// restored version, or previous for a value stored before the history.
+	Source  string `json:"source"`
+	SHA256  string `json:"sha256"`
+	Current bool   `json:"current"`
+	Content string `json:"content,omitempty"`
+}
+
+// PromptManager keeps the scan system prompt and the content template with
+// the history of their versions, so a customization can be rolled back.
+type PromptManager struct {
+	Parameters ParameterStore
+	Records    RecordStore
+	// Console shows the warnings and preview of the checks.
+	Console *Console
+	// now returns the current time; nil means time.Now.
+	now func() time.Time
+}
+
+func NewPromptManager(session *AWSSession, console *Console) *PromptManager {
+	return &PromptManager{Parameters: session, Records: session, Console: console}
+}
+
+func (m *PromptManager) clock() time.Time {
+	if m.now != nil {
+		return m.now()
+	}
+	return time.Now()
+}
+
+// load returns the current value of document, empty when it was never set,
+// and its versions sorted from the oldest, with the current one marked.
+func (m *PromptManager) load(ctx context.Context, tableName string, document promptDocument) (string, []PromptVersion, error) {
+	records, err := m.Records.ListRecords(ctx, tableName)
+	if err != nil {
+		return "", nil, err
+	}
+	current := ""
+	versions := []PromptVersion{}
+	for _, record := range records {
+		parameterID, _ := record["parameter_id"].(string)
+		value, _ := record["value"].(string)
+		if parameterID == document.ParameterID {
+			current = value
+			continue
+		}
+		number, ok := strings.CutPrefix(parameterID, document.ParameterID+"#v")
+		if !ok {
+			continue
+		}
+		version, err := strconv.Atoi(number)
+		if err != nil {
+			continue
+		}
+		createdAt, _ := record["created_at"].(string)
+		source, _ := record["source"].(string)
+		versions = append(versions, PromptVersion{
+			Version:   version,
+			CreatedAt: createdAt,
+			Source:    source,
+			SHA256:    hashSha256([]byte(value)),
+			Content:   value,
+		})
+	}
+	slices.SortFunc(versions, func(a, b PromptVersion) int { return a.Version - b.Version })
+	// The latest version with the current value is the current one, as a
+	// restored version repeats an older one
+	for i := len(versions) - 1; i >= 0; i-- {
+		if versions[i].Content == current {
+			versions[i].Current = true
+			break
+		}
+	}
+	return current, versions, nil
+}
+
+// History returns the versions of document, without their content.
+func (m *PromptManager) History(ctx context.Context, name string) ([]PromptVersion, error) {
+	document, err := findPromptDocument(name)
+	if err != nil {
+		return nil, err
+	}
+	tableName, err := m.Parameters.GetParameter(ctx, parameterTableParameter)
+	if err != nil {
+		return nil, err
+	}
+	_, versions, err := m.load(ctx, tableName, document)
+	if err != nil {
+		return nil, err
+	}
+	for i := range versions {
+		versions[i].Content = ""
+	}
+	return versions, nil
+}
+
+// Show returns a version of document, or its current value with version 0.
+// The current value is returned with version 0 too when it has no version,
+// as values stored before the history.
+func (m *PromptManager) Show(ctx context.Context, name string, version int) (PromptVersion, error) {
+	document, err := findPromptDocument(name)
+	if err != nil {
+		return PromptVersion{}, err
+	}
+	tableName, err := m.Parameters.GetParameter(ctx, parameterTableParameter)
+	if err != nil {
+		return PromptVersion{}, err
+	}
+	current, versions, err := m.load(ctx, tableName, document)
+	if err != nil {
+		return PromptVersion{}, err
+	}
+	for _, v := range versions {
+		if (version == 0 && v.Current) || (version != 0 && v.Version == version) {
+			return v, nil
+		}
+	}
+	if version != 0 {
+		return PromptVersion{}, fmt.Errorf("%s has no version %d", document.Name, version)
+	}
+	if current == "" {
+		return PromptVersion{}, fmt.Errorf("%s is not set, run the installer first", document.Name)
+	}
+	return PromptVersion{Source: "previous", SHA256: hashSha256([]byte(current)), Current: true, Content: current}, nil
+}
+
+// Set stores content as a new version of document and makes it the current
+// value. The current value is kept as a version first when it has none, so
+// it can be restored too. Content equal to the current value is not stored
+// again; changed reports whether it was.
+func (m *PromptManager) Set(ctx context.Context, name, content, source string) (version PromptVersion, changed bool, err error) {
+	document, err := findPromptDocument(name)
+	if err != nil {
+		return PromptVersion{}, false, err
+	}
+	if strings.TrimSpace(content) == "" {
+		return PromptVersion{}, false, fmt.Errorf("the %s is empty", document.Name)
+	}
+	if err := document.check(m.Console, content); err != nil {
+		return PromptVersion{}, false, err
+	}
+	tableName, err := m.Parameters.GetParameter(ctx, parameterTableParameter)
+	if err != nil {
+		return PromptVersion{}, false, err
+	}
+	return m.set(ctx, tableName, document, content, source)
+}
+
+func (m *PromptManager) set(ctx context.Context, tableName string, document promptDocument, content, source string) (PromptVersion, bool, error) {
+	current, versions, err := m.load(ctx, tableName, document)
+	if err != nil {
+		return PromptVersion{}, false, err
+	}
+	last := 0
+	var tracked *PromptVersion
+	for i, v := range versions {
+		last = v.Version
+		if v.Current {
+			tracked = &versions[i]
+		}
+	}
+	if tracked != nil && current == content {
+		return *tracked, false, nil
+	}
+	if current != "" && current != content && tracked == nil {
+		last++
+		if _, err := m.putVersion(ctx, tableName, document, last, current, "previous"); err != nil {
+			return PromptVersion{}, false, err
+		}
+	}
+	version, err := m.putVersion(ctx, tableName, document, last+1, content, source)
+	if err != nil {
+		return PromptVersion{}, false, err
+	}
+	version.Current = true
+	// A value stored before the history only needs its version
+	if current == content {
+		return version, false, nil
+	}
+	err = m.Records.PutRecord(ctx, tableName, map[string]interface{}{
+		"parameter_id": document.ParameterID,
+		"value":        content,
+	})
+	if err != nil {
+		return PromptVersion{}, false, err
+	}
+	return version, true, nil
+}
+
+func (m *PromptManager) putVersion(ctx context.Context, tableName string, document promptDocument, number int, content, source string) (PromptVersion, error) {
+	version := PromptVersion{
+		Version:   number,
+		CreatedAt: m.clock().UTC().Format(time.RFC3339),
+		Source:    source,
+		SHA256:    hashSha256([]byte(content)),
+		Content:   content,
+	}
+	err := m.Records.PutRecord(ctx, tableName, map[string]interface{}{
+		"parameter_id": document.historyID(number),
+		"value":        content,
+		"created_at":   version.CreatedAt,
+		"source":       source,
+	})
+	if err != nil {
+		return PromptVersion{}, fmt.Errorf("failed to store version %d of the %s: %w", number, document.Name, err)
+	}
+	return version, nil
+}
+
+// Restore makes a previous version of document the current value again, as
+// a new version so the history stays in order.
+func (m *PromptManager) Restore(ctx context.Context, name string, version int) (PromptVersion, bool, error) {
+	previous, err := m.Show(ctx, name, version)
+	if err != nil {
+		return PromptVersion{}, false, err
+	}
+	return m.Set(ctx, name, previous.Content, fmt.Sprintf("version %d", version))
+}
+
+// install writes the documents of an installation: override, already
+// checked, replaces the document when given, and the embedded default
+// otherwise. A document
+// customized with prompt set is kept instead of the default, while one
+// holding the default of a previous release, or a value stored before the
+// history, is updated to this release's default.
+func (m *PromptManager) install(ctx context.Context, tableName string, document promptDocument, override *promptFile) error {
+	content, source := document.Default, promptSourceDefault
+	if override != nil {
+		content, source = override.Content, override.Source
+	} else {
+		_, versions, err := m.load(ctx, tableName, document)
+		if err != nil {
+			return err
+		}
+		for _, v := range versions {
+			if v.Current && v.Source != promptSourceDefault && v.Source != "previous" {
+				m.Console.printInfo(fmt.Sprintf("Keeping the customized %s (version %d), use prompt set to change it", document.Name, v.Version))
+				return nil
+			}
+		}
+	}
+	version, changed, err := m.set(ctx, tableName, document, content, source)
+	if err != nil {
+		return err
+	}
+	if changed {
+		m.Console.printInfo(fmt.Sprintf("Stored version %d of the %s from %s", version.Version, document.Name, source))
+	}
+	return nil
+}
+
+// promptFile is the content of a prompt document read from a file.
+type promptFile struct {
+	Content string
+	Source  string
+}
+
+// readPromptFile reads a prompt document from path, or from stdin with "-".
+func readPromptFile(path string, stdin io.Reader) (*promptFile, error) {
+	var content []byte
+	var err error
+	source := "file " + path
+	if path == "-" {
+		content, err = io.ReadAll(stdin)
+		source = "stdin"
+	} else {
+		content, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	if strings.TrimSpace(string(content)) == "" {
+		return nil, fmt.Errorf("%s is empty", path)
+	}
+	return &promptFile{Content: string(content), Source: source}, nil
+}
+
+func newPromptManager(cmd *cobra.Command, console *Console) *PromptManager {
+	session, err := managementSession(cmd, console)
+	if err != nil {
+		console.printErrorAndExit(err)
+	}
+	return NewPromptManager(session, console)
+}
+
+// RunPromptShow prints the current version of a prompt document, or the one
+// given with --version.
+func RunPromptShow(cmd *cobra.Command, args []string) {
+	console, format := managementConsole(cmd)
+	version, err := cmd.Flags().GetInt("version")
+	if err != nil {
+		console.printErrorAndExit(err)
+	}
+	manager := newPromptManager(cmd, console)
+	shown, err := manager.Show(cmd.Context(), args[0], version)
+	if err != nil {
+		console.printErrorAndExit(err)
+	}
+	if format == OutputJSON {
+		if err := writeListing(cmd.OutOrStdout(), format, nil, nil, shown); err != nil {
+			console.printErrorAndExit(err)
+		}
+		return
+	}
+	fmt.Fprint(cmd.OutOrStdout(), shown.Content)
+}
+
+// RunPromptSet stores a new version of a prompt document from a file, or
+// restores a previous version with --version.
+func RunPromptSet(cmd *cobra.Command, args []string) {
+	console, _ := managementConsole(cmd)
+	version, err := cmd.Flags().GetInt("version")
+	if err != nil {
+		console.printErrorAndExit(err)
+	}
+	if (len(args) == 2) == (version != 0) {
+		console.printErrorAndExit(fmt.Errorf("give either a file or --version"))
+	}
+	manager := newPromptManager(cmd, console)
+	var stored PromptVersion
+	var changed bool
+	if version != 0 {
+		stored, changed, err = manager.Restore(cmd.Context(), args[0], version)
+	} else {
+		var file *promptFile
+		if file, err = readPromptFile(args[1], os.Stdin); err != nil {
+			console.printErrorAndExit(err)
+		}
+		stored, changed, err = manager.Set(cmd.Context(), args[0], file.Content, file.Source)
+	}
+	if err != nil {
+		console.printErrorAndExit(err)
+	}
+	if !changed {
+		console.printInfo(fmt.Sprintf("The %s is already version %d, nothing to store", args[0], stored.Version))
+		return
+	}
+	console.printInfo(fmt.Sprintf("Stored version %d of the %s", stored.Version, args[0]))
+}
+
+// RunPromptDiff prints the changes from the current version of a prompt
+// document to a file, a stored version or the embedded default.
+func RunPromptDiff(cmd *cobra.Command, args []string) {
+	console, _ := managementConsole(cmd)
+	version, err := cmd.Flags().GetInt("version")
+	if err != nil {
+		console.printErrorAndExit(err)
+	}
+	if len(args) == 2 && version != 0 {
+		console.printErrorAndExit(fmt.Errorf("give either a file or --version"))
+	}
+	document, err := findPromptDocument(args[0])
+	if err != nil {
+		console.printErrorAndExit(err)
+	}
+	manager := newPromptManager(cmd, console)
+	current, err := manager.Show(cmd.Context(), document.Name, 0)
+	if err != nil {
+		console.printErrorAndExit(err)
+	}
+	other, otherName := document.Default, "default"
+	switch {
+	case len(args) == 2:
+		file, err := readPromptFile(args[1], os.Stdin)
+		if err != nil {
+			console.printErrorAndExit(err)
+		}
+		other, otherName = file.Content, args[1]
+	case version != 0:
+		stored, err := manager.Show(cmd.Context(), document.Name, version)
+		if err != nil {
+			console.printErrorAndExit(err)
+		}
+		other, otherName = stored.Content, fmt.Sprintf("version %d", version)
+	}
+	fmt.Fprint(cmd.OutOrStdout(), unifiedDiff("current", otherName, current.Content, other))
+}
+
+// RunPromptHistory lists the versions of a prompt document.
+func RunPromptHistory(cmd *cobra.Command, args []string) {
+	console, format := managementConsole(cmd)
+	manager := newPromptManager(cmd, console)
+	versions, err := manager.History(cmd.Context(), args[0])
+	if err != nil {
+		console.printErrorAndExit(err)
+	}
+	rows := make([][]string, 0, len(versions))
+	for _, v := range versions {
+		current := ""
+		if v.Current {
+			current = "*"
+		}
+		rows = append(rows, []string{strconv.Itoa(v.Version), v.CreatedAt, v.Source, v.SHA256[:12], current})
+	}
+	if err := writeListing(cmd.OutOrStdout(), format, []string{"VERSION", "CREATED AT", "SOURCE", "SHA256", "CURRENT"}, rows, versions); err != nil {
+		console.printErrorAndExit(err)
+	}
+}
+
+// diffContext is the number of unchanged lines shown around a change.
+const diffContext = 3
+
+// unifiedDiff returns the line changes from a to b in the unified format, or
+// nothing when they are equal.
+func unifiedDiff(aName, bName, a, b string) string {
+	if a == b {
+		return ""
+	}
+	aLines := splitLines(a)
+	bLines := splitLines(b)
+	// lcs[i][j] is the length of the longest common subsequence of
+	// aLines[i:] and bLines[j:]
+	lcs := make([][]int, len(aLines)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(bLines)+1)
+	}
+	for i := len(aLines) - 1; i >= 0; i-- {
+		for j := len(bLines) - 1; j >= 0; j-- {
+			if aLines[i] == bLines[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+	type edit struct {
+		op   byte
+		line string
+		// aLine and bLine are the 0-based positions before the edit.
+		aLine, bLine int
+	}
+	edits := []edit{}
+	i, j := 0, 0
+	for i < len(aLines) || j < len(bLines) {
+		switch {
+		case i < len(aLines) && j < len(bLines) && aLines[i] == bLines[j]:
+			edits = append(edits, edit{' ', aLines[i], i, j})
+			i++
+			j++
+		case i < len(aLines) && (j == len(bLines) || lcs[i+1][j] >= lcs[i][j+1]):
+			edits = append(edits, edit{'-', aLines[i], i, j})
+			i++
+		default:
+			edits = append(edits, edit{'+', bLines[j], i, j})
+			j++
+		}
+	}
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "--- %s\n+++ %s\n", aName, bName)
+	for start := 0; start < len(edits); {
+		if edits[start].op == ' ' {
+			start++
+			continue
+		}
+		// A hunk spans the changes closer than twice the context
+		first := max(start-diffContext, 0)
+		end := start
+		for k := start; k < len(edits) && k-end <= 2*diffContext; k++ {
+			if edits[k].op != ' ' {
+				end = k
+			}
+		}
+		last := min(end+diffContext+1, len(edits))
+		aCount, bCount := 0, 0
+		for _, e := range edits[first:last] {
+			if e.op != '+' {
+				aCount++
+			}
+			if e.op != '-' {
+				bCount++
+			}
+		}
+		fmt.Fprintf(&out, "@@ -%d,%d +%d,%d @@\n", edits[first].aLine+1, aCount, edits[first].bLine+1, bCount)
+		for _, e := range edits[first:last] {
+			out.WriteByte(e.op)
+			out.WriteString(e.line)
+			if !strings.HasSuffix(e.line, "\n") {
+				out.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+		start = last
+	}
+	return out.String()
+}
+
+// splitLines splits text after each newline, without an empty last line.
+func splitLines(text string) []string {
+	lines := strings.SplitAfter(text, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
@@ -0,0 +1,182 @@
+package internal
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testPromptManager(records map[string][]map[string]interface{}) *PromptManager {
+	_, fake := testEncryptionManager(records, testAESKey)
+	now := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	return &PromptManager{Parameters: fake, Records: fake, Console: testConsole(), now: func() time.Time { return now }}
+}
+
+func TestPromptManagerSetAndRestore(t *testing.T) {
+	t.Parallel()
+	records := map[string][]map[string]interface{}{
+		"parameters": {{"parameter_id": "scan_system_prompt", "value": "legacy prompt\n"}},
+	}
+	manager := testPromptManager(records)
+	ctx := context.Background()
+
+	version, changed, err := manager.Set(ctx, "system-prompt", "custom prompt\n", "file custom.md")
+	if err != nil || !changed || version.Version != 2 {
+		t.Fatalf("expected version 2 after the imported value, got %+v, %v, %v", version, changed, err)
+	}
+	if parameterValue(records, "scan_system_prompt#v1") != "legacy prompt\n" || parameterValue(records, "scan_system_prompt") != "custom prompt\n" {
+		t.Fatalf("expected the legacy value to be kept as version 1, got %v", records["parameters"])
+	}
+	if _, changed, err := manager.Set(ctx, "system-prompt", "custom prompt\n", "stdin"); err != nil || changed {
+		t.Fatalf("expected the same content not to be stored again, got %v, %v", changed, err)
+	}
+
+	restored, changed, err := manager.Restore(ctx, "system-prompt", 1)
+	if err != nil || !changed || restored.Version != 3 || restored.Source != "version 1" {
+		t.Fatalf("expected version 1 to be restored as version 3, got %+v, %v, %v", restored, changed, err)
+	}
+	if parameterValue(records, "scan_system_prompt") != "legacy prompt\n" {
+		t.Fatalf("expected the restored value to be current, got %v", records["parameters"])
+	}
+
+	history, err := manager.History(ctx, "system-prompt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sources := []string{}
+	for _, v := range history {
+		sources = append(sources, v.Source)
+		if v.Content != "" || v.CreatedAt != "2026-10-01T12:00:00Z" || v.Current != (v.Version == 3) {
+			t.Errorf("unexpected history entry %+v", v)
+		}
+	}
+	if !slices.Equal(sources, []string{"previous", "file custom.md", "version 1"}) {
+		t.Fatalf("unexpected history %v", sources)
+	}
+	shown, err := manager.Show(ctx, "system-prompt", 2)
+	if err != nil || shown.Content != "custom prompt\n" || shown.SHA256 != hashSha256([]byte("custom prompt\n")) {
+		t.Fatalf("unexpected version 2 %+v, %v", shown, err)
+	}
+}
+
+func TestPromptManagerErrors(t *testing.T) {
+	t.Parallel()
+	manager := testPromptManager(map[string][]map[string]interface{}{})
+	ctx := context.Background()
+	if _, _, err := manager.Set(ctx, "agent-prompt", "text", "stdin"); err == nil || !strings.Contains(err.Error(), `unknown prompt document "agent-prompt"`) {
+		t.Errorf("expected an unknown document error, got %v", err)
+	}
+	if _, _, err := manager.Set(ctx, "content-template", " \n", "stdin"); err == nil || !strings.Contains(err.Error(), "the content-template is empty") {
+		t.Errorf("expected an empty content error, got %v", err)
+	}
+	if _, err := manager.Show(ctx, "system-prompt", 0); err == nil || !strings.Contains(err.Error(), "is not set") {
+		t.Errorf("expected an unset document error, got %v", err)
+	}
+	if _, _, err := manager.Restore(ctx, "system-prompt", 4); err == nil || !strings.Contains(err.Error(), "has no version 4") {
+		t.Errorf("expected a missing version error, got %v", err)
+	}
+}
+
+func TestPromptManagerInstall(t *testing.T) {
+	t.Parallel()
+	document, _ := findPromptDocument("system-prompt")
+	tests := []struct {
+		name     string
+		records  []map[string]interface{}
+		override *promptFile
+		want     string
+		versions int
+	}{
+		{name: "new installation", want: defaultSystemPrompt, versions: 1},
+		{
+			name:     "legacy value",
+			records:  []map[string]interface{}{{"parameter_id": "scan_system_prompt", "value": "downloaded prompt"}},
+			want:     defaultSystemPrompt,
+			versions: 2,
+		},
+		{
+			name: "previous default",
+			records: []map[string]interface{}{
+				{"parameter_id": "scan_system_prompt", "value": "old default"},
+				{"parameter_id": "scan_system_prompt#v1", "value": "old default", "source": promptSourceDefault},
+			},
+			want:     defaultSystemPrompt,
+			versions: 2,
+		},
+		{
+			name: "customized",
+			records: []map[string]interface{}{
+				{"parameter_id": "scan_system_prompt", "value": "custom"},
+				{"parameter_id": "scan_system_prompt#v1", "value": "custom", "source": "file custom.md"},
+			},
+			want:     "custom",
+			versions: 1,
+		},
+		{
+			name: "override",
+			records: []map[string]interface{}{
+				{"parameter_id": "scan_system_prompt", "value": "custom"},
+				{"parameter_id": "scan_system_prompt#v1", "value": "custom", "source": "file custom.md"},
+			},
+			override: &promptFile{Content: "newer custom", Source: "file newer.md"},
+			want:     "newer custom",
+			versions: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			records := map[string][]map[string]interface{}{"parameters": tt.records}
+			manager := testPromptManager(records)
+			if err := manager.install(context.Background(), "parameters", document, tt.override); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if got := parameterValue(records, "scan_system_prompt"); got != tt.want {
+				t.Errorf("expected %q, got %q", tt.want, got)
+			}
+			history, err := manager.History(context.Background(), "system-prompt")
+			if err != nil || len(history) != tt.versions || !history[len(history)-1].Current {
+				t.Errorf("expected %d versions with the last current, got %+v, %v", tt.versions, history, err)
+			}
+		})
+	}
+}
+
+func TestUnifiedDiff(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name string
+		a, b string
+		want string
+	}{
+		{name: "equal", a: "one\ntwo\n", b: "one\ntwo\n", want: ""},
+		{
+			name: "changed line",
+			a:    "1\n2\n3\n4\n5\n6\n7\n8\n9\n",
+			b:    "1\n2\n3\n4\nfive\n6\n7\n8\n9\n",
+			want: "--- a\n+++ b\n@@ -2,7 +2,7 @@\n 2\n 3\n 4\n-5\n+five\n 6\n 7\n 8\n",
+		},
+		{
+			name: "distant changes",
+			a:    "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n",
+			b:    "one\n2\n3\n4\n5\n6\n7\n8\n9\nten\n",
+			want: "--- a\n+++ b\n@@ -1,4 +1,4 @@\n-1\n+one\n 2\n 3\n 4\n@@ -7,4 +7,4 @@\n 7\n 8\n 9\n-10\n+ten\n",
+		},
+		{
+			name: "added line without newline",
+			a:    "1\n",
+			b:    "1\n2",
+			want: "--- a\n+++ b\n@@ -1,1 +1,2 @@\n 1\n+2\n\\ No newline at end of file\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			if got := unifiedDiff("a", "b", tt.a, tt.b); got != tt.want {
+				t.Errorf("unexpected diff\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
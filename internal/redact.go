@@ -0,0 +1,131 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	redactedValue = "[REDACTED]"
+	// minSecretLength keeps short values from masking unrelated output.
+	minSecretLength = 6
+	// maxRedactLineLength bounds the buffered partial line of a command that
+	// never writes a newline (e.g. a progress bar).
+	maxRedactLineLength = 64 * 1024
+)
+
+// redactor replaces known secret values, and their base64 forms, with
+// redactedValue. Each Console has its own, with the secrets of its command.
+type redactor struct {
+	mu     sync.RWMutex
+	values []string
+}
+
+// Add registers values to be masked. Empty and very short values are ignored.
+// Values are kept as given, a raw key may start or end with whitespace bytes.
+func (r *redactor) Add(values ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, value := range values {
+		if len(strings.TrimSpace(value)) < minSecretLength {
+			continue
+		}
+		raw := []byte(value)
+		for _, form := range []string{
+			value,
+			base64.StdEncoding.EncodeToString(raw),
+			base64.RawStdEncoding.EncodeToString(raw),
+			base64.URLEncoding.EncodeToString(raw),
+			base64.RawURLEncoding.EncodeToString(raw),
+		} {
+			if !slices.Contains(r.values, form) {
+				r.values = append(r.values, form)
+			}
+		}
+	}
+	// Longest first, so a secret is never partially masked by a shorter one
+	sort.Slice(r.values, func(i, j int) bool {
+		return len(r.values[i]) > len(r.values[j])
+	})
+}
+
+func (r *redactor) active() bool {
+	if r == nil {
+		return false
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return len(r.values) > 0
+}
+
+// Redact returns s with every registered secret masked. A nil redactor
+// returns s as is.
+func (r *redactor) Redact(s string) string {
+	if r == nil {
+		return s
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, value := range r.values {
+		s = strings.ReplaceAll(s, value, redactedValue)
+	}
+	return s
+}
+
+// Writer returns a writer that masks secrets before writing to out. Output is
+// buffered per line so a secret split across writes is still masked; Flush
+// writes the remaining partial line.
+func (r *redactor) Writer(out io.Writer) *redactingWriter {
+	return &redactingWriter{redactor: r, out: out}
+}
+
+type redactingWriter struct {
+	redactor *redactor
+	mu       sync.Mutex
+	out      io.Writer
+	pending  []byte
+}
+
+func (w *redactingWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.pending = append(w.pending, p...)
+	end := bytes.LastIndexByte(w.pending, '\n') + 1
+	if end == 0 && len(w.pending) > maxRedactLineLength {
+		end = len(w.pending)
+	}
+	if end > 0 {
+		if _, err := io.WriteString(w.out, w.redactor.Redact(string(w.pending[:end]))); err != nil {
+			return 0, err
+		}
+		w.pending = append(w.pending[:0], w.pending[end:]...)
+	}
+	return len(p), nil
+}
+
+func (w *redactingWriter) Flush() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.pending) == 0 {
+		return nil
+	}
+	_, err := io.WriteString(w.out, w.redactor.Redact(string(w.pending)))
+	w.pending = nil
+	return err
+}
+
+// secretValues returns the values of config that must never be printed.
+func (s *SetupConfig) secretValues() []string {
+	return []string{s.AesSecret, s.AIApiKey, s.BitbucketAPIToken, s.GithubAccessToken}
+}
+
+// secretValues returns the secret parts of the credentials. The access key ID
+// is an identifier, not a secret, and stays visible for troubleshooting.
+func (c *AWSCredentials) secretValues() []string {
+	return []string{c.AWSSecretAccessKey, c.AWSSessionToken}
+}
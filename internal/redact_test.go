@@ -0,0 +1,92 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestRedactorMasksSecretsAndBase64Forms(t *testing.T) {
+	r := &redactor{}
+	aesSecret := "0123456789abcdef0123456789abcdef"
+	r.Add(aesSecret, "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY", "", "abc")
+
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{name: "plain", input: "aes_secret=" + aesSecret},
+		{name: "base64", input: `"SecretString":"` + base64.StdEncoding.EncodeToString([]byte(aesSecret)) + `"`},
+		{name: "raw url base64", input: "token " + base64.RawURLEncoding.EncodeToString([]byte("wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"))},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := r.Redact(tc.input)
+			if !strings.Contains(got, redactedValue) || strings.Contains(got, aesSecret) || strings.Contains(got, "EXAMPLEKEY") {
+				t.Fatalf("expected secret to be masked, got %q", got)
+			}
+		})
+	}
+	if got := r.Redact("abc region us-east-1"); got != "abc region us-east-1" {
+		t.Fatalf("expected short values to be ignored, got %q", got)
+	}
+}
+
+func TestRedactorKeepsSurroundingWhitespace(t *testing.T) {
+	r := &redactor{}
+	// A raw AES key may start or end with a whitespace byte
+	key := "\n" + strings.Repeat("\x9f", 30) + "\t"
+	r.Add(key, "   ")
+	encoded := base64.StdEncoding.EncodeToString([]byte(key))
+	if got := r.Redact("key " + encoded); got != "key "+redactedValue {
+		t.Fatalf("expected the base64 form of the key to be masked, got %q", got)
+	}
+	if got := r.Redact("a   b"); got != "a   b" {
+		t.Fatalf("expected blank values to be ignored, got %q", got)
+	}
+}
+
+func TestRedactingWriterMasksSecretsSplitAcrossWrites(t *testing.T) {
+	r := &redactor{}
+	r.Add("super-secret-token")
+	var out bytes.Buffer
+	writer := r.Writer(&out)
+
+	writer.Write([]byte("Authorization: super-se"))
+	writer.Write([]byte("cret-token\nnext line with super-secret"))
+	writer.Write([]byte("-token"))
+	writer.Flush()
+
+	expected := "Authorization: [REDACTED]\nnext line with [REDACTED]"
+	if out.String() != expected {
+		t.Fatalf("expected %q, got %q", expected, out.String())
+	}
+}
+
+func TestExecuteWithOptionsRedactsOutput(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses shell scripts as fake executables")
+	}
+	console := testConsole()
+	console.Secrets.Add("FwoGZXIvYXdzEXAMPLESESSIONTOKEN")
+	dir := filepath.Join(t.TempDir(), "bin")
+	writeFakeExecutable(t, dir, "terragrunt", `echo "DEBUG: X-Amz-Security-Token: $AWS_SESSION_TOKEN"; echo "$AWS_SESSION_TOKEN" >&2`)
+
+	var output bytes.Buffer
+	err := ExecuteWithOptions(context.Background(), "terragrunt", &ExecuteOptions{
+		Resolver: NewToolResolver(Linux, dir),
+		Env:      map[string]string{"AWS_SESSION_TOKEN": "FwoGZXIvYXdzEXAMPLESESSIONTOKEN"},
+		Output:   &output,
+		Console:  console,
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if strings.Contains(output.String(), "EXAMPLESESSIONTOKEN") || strings.Count(output.String(), redactedValue) != 2 {
+		t.Fatalf("expected session token to be masked in the log, got %q", output.String())
+	}
+}
@@ -0,0 +1,211 @@
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+const (
+	OutputText = "text"
+	OutputJSON = "json"
+)
+
+// Step statuses reported in StepEvent.
+const (
+	StepStarted     = "started"
+	StepSucceeded   = "succeeded"
+	StepFailed      = "failed"
+	StepInterrupted = "interrupted"
+	StepSkipped     = "skipped"
+)
+
+// StepEvent describes a change in the status of an installation step.
+type StepEvent struct {
+	Step     string
+	Status   string
+	Duration time.Duration
+	Err      error
+}
+
+// Reporter renders the installer progress. The Console of a command sends
+// its messages to it after redacting secrets.
+type Reporter interface {
+	Info(message string)
+	Warning(message string)
+	Error(message string)
+	Step(event StepEvent)
+	// Output reports a result the user needs after the installation, such as
+	// the setup endpoint.
+	Output(name, value string)
+	// CommandOutput receives the output of the commands run by the installer.
+	CommandOutput() io.Writer
+}
+
+// newReporter returns the reporter for an --output format.
+func newReporter(format string, out io.Writer) (Reporter, error) {
+	switch format {
+	case "", OutputText:
+		return &humanReporter{out: out}, nil
+	case OutputJSON:
+		return &jsonReporter{out: out, commandOutput: os.Stderr}, nil
+	default:
+		return nil, fmt.Errorf("unsupported output format %q, use %s or %s", format, OutputText, OutputJSON)
+	}
+}
+
+// Console writes the messages of a command: it redacts the secrets, records
+// the messages in the run log and renders them with Reporter. Every
+// command builds its own, so nothing about the output is process-wide.
+type Console struct {
+	Reporter Reporter
+	// Secrets masks the secret values of the command in its messages,
+	// command output and logs.
+	Secrets *redactor
+	// Log mirrors the messages into the run log of an installation; nil
+	// when no run is being logged.
+	Log *runLogger
+}
+
+// newConsole returns the console of a command with the --output format.
+func newConsole(format string, out io.Writer) (*Console, error) {
+	reporter, err := newReporter(format, out)
+	if err != nil {
+		return nil, err
+	}
+	return &Console{Reporter: reporter, Secrets: &redactor{}}, nil
+}
+
+// textConsole returns a console with the human reporter, for the errors
+// reported before the --output format is known.
+func textConsole(out io.Writer) *Console {
+	return &Console{Reporter: &humanReporter{out: out}, Secrets: &redactor{}}
+}
+
+// reportStep logs a step event to the run log and the reporter.
+func (c *Console) reportStep(event StepEvent) {
+	if event.Err != nil {
+		// The reporters print the error as is
+		event.Err = errors.New(c.Secrets.Redact(event.Err.Error()))
+		c.Log.logf("STEP", "%s %s after %s: %s", event.Step, event.Status, event.Duration.Round(time.Millisecond), event.Err)
+	} else {
+		c.Log.logf("STEP", "%s %s", event.Step, event.Status)
+	}
+	c.Reporter.Step(event)
+}
+
+func (c *Console) reportOutput(name, value string) {
+	c.Log.logf("INFO", "%s: %s", name, c.Secrets.Redact(value))
+	c.Reporter.Output(name, value)
+}
+
+type humanReporter struct {
+	out io.Writer
+}
+
+func (r *humanReporter) Info(message string) {
+	fmt.Fprintln(r.out, color.GreenString(message))
+}
+
+func (r *humanReporter) Warning(message string) {
+	fmt.Fprintln(r.out, color.YellowString(message))
+}
+
+func (r *humanReporter) Error(message string) {
+	fmt.Fprintln(r.out, color.RedString(message))
+}
+
+func (r *humanReporter) Step(event StepEvent) {
+	switch event.Status {
+	case StepSkipped:
+		r.Info(fmt.Sprintf("Skipping %s, already completed by a previous run", event.Step))
+	case StepSucceeded:
+		r.Info(fmt.Sprintf("Finished %s in %s", event.Step, event.Duration.Round(time.Second)))
+	}
+}
+
+func (r *humanReporter) Output(name, value string) {
+	r.Info(fmt.Sprintf("- %s: %s", name, value))
+}
+
+func (r *humanReporter) CommandOutput() io.Writer {
+	return r.out
+}
+
+// jsonReporter writes one JSON event per line. Command output goes to
+// commandOutput so it does not mix with the events.
+type jsonReporter struct {
+	mu            sync.Mutex
+	out           io.Writer
+	commandOutput io.Writer
+	now           func() time.Time
+}
+
+type jsonEvent struct {
+	Time       string `json:"time"`
+	Event      string `json:"event"`
+	Message    string `json:"message,omitempty"`
+	Step       string `json:"step,omitempty"`
+	Component  string `json:"component,omitempty"`
+	Status     string `json:"status,omitempty"`
+	DurationMS *int64 `json:"duration_ms,omitempty"`
+	Error      string `json:"error,omitempty"`
+	Name       string `json:"name,omitempty"`
+	Value      string `json:"value,omitempty"`
+}
+
+func (r *jsonReporter) emit(event jsonEvent) {
+	now := time.Now
+	if r.now != nil {
+		now = r.now
+	}
+	event.Time = now().UTC().Format(time.RFC3339Nano)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	json.NewEncoder(r.out).Encode(event)
+}
+
+func (r *jsonReporter) Info(message string) {
+	r.emit(jsonEvent{Event: "info", Message: message})
+}
+
+func (r *jsonReporter) Warning(message string) {
+	r.emit(jsonEvent{Event: "warning", Message: message})
+}
+
+func (r *jsonReporter) Error(message string) {
+	r.emit(jsonEvent{Event: "error", Error: message})
+}
+
+func (r *jsonReporter) Step(event StepEvent) {
+	out := jsonEvent{
+		Event:     "step_finished",
+		Step:      event.Step,
+		Component: componentName(event.Step),
+		Status:    event.Status,
+	}
+	if event.Status == StepStarted {
+		out.Event = "step_started"
+	} else {
+		duration := event.Duration.Milliseconds()
+		out.DurationMS = &duration
+	}
+	if event.Err != nil {
+		out.Error = event.Err.Error()
+	}
+	r.emit(out)
+}
+
+func (r *jsonReporter) Output(name, value string) {
+	r.emit(jsonEvent{Event: "output", Name: name, Value: value})
+}
+
+func (r *jsonReporter) CommandOutput() io.Writer {
+	return r.commandOutput
+}
@@ -0,0 +1,116 @@
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testConsole returns a console that discards its messages.
+func testConsole() *Console {
+	return textConsole(io.Discard)
+}
+
+// jsonConsole returns a console with the json reporter and the buffer its
+// events are written to.
+func jsonConsole() (*Console, *bytes.Buffer) {
+	var out bytes.Buffer
+	return &Console{Reporter: &jsonReporter{out: &out, commandOutput: &bytes.Buffer{}}, Secrets: &redactor{}}, &out
+}
+
+func decodeEvents(t *testing.T, out *bytes.Buffer) []map[string]any {
+	t.Helper()
+	events := []map[string]any{}
+	scanner := bufio.NewScanner(out)
+	for scanner.Scan() {
+		var event map[string]any
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			t.Fatalf("expected one JSON event per line, got %q: %v", scanner.Text(), err)
+		}
+		events = append(events, event)
+	}
+	return events
+}
+
+func TestJSONReporterEmitsEvents(t *testing.T) {
+	t.Parallel()
+	console, out := jsonConsole()
+	console.Secrets.Add("sk-test-1234567890")
+
+	console.printInfo("Starting Titvo Installer")
+	console.printError(errors.New("invalid key sk-test-1234567890"))
+	console.reportOutput("Setup Endpoint", "https://api.example.com")
+
+	events := decodeEvents(t, out)
+	if len(events) != 3 {
+		t.Fatalf("expected 3 events, got %d", len(events))
+	}
+	if events[0]["event"] != "info" || events[0]["message"] != "Starting Titvo Installer" {
+		t.Fatalf("unexpected info event %v", events[0])
+	}
+	if events[1]["event"] != "error" || events[1]["error"] != "invalid key [REDACTED]" {
+		t.Fatalf("unexpected error event %v", events[1])
+	}
+	if events[2]["event"] != "output" || events[2]["name"] != "Setup Endpoint" || events[2]["value"] != "https://api.example.com" {
+		t.Fatalf("unexpected output event %v", events[2])
+	}
+	if _, err := time.Parse(time.RFC3339Nano, events[0]["time"].(string)); err != nil {
+		t.Fatalf("expected RFC3339 time, got %v", events[0]["time"])
+	}
+}
+
+func TestInstallStateRunReportsSteps(t *testing.T) {
+	t.Parallel()
+	console, out := jsonConsole()
+	console.Secrets.Add("sk-test-1234567890")
+	state, _ := loadInstallState(t.TempDir(), false)
+
+	state.run(context.Background(), console, "MCP gateway", func() error { return nil })
+	state.run(context.Background(), console, "agent aws", func() error { return errors.New("apply failed with sk-test-1234567890") })
+
+	events := decodeEvents(t, out)
+	if len(events) != 4 {
+		t.Fatalf("expected 4 events, got %d", len(events))
+	}
+	if events[0]["event"] != "step_started" || events[0]["step"] != "MCP gateway" || events[0]["component"] != "mcp-gateway" {
+		t.Fatalf("unexpected started event %v", events[0])
+	}
+	if events[1]["event"] != "step_finished" || events[1]["status"] != StepSucceeded || events[1]["duration_ms"] == nil {
+		t.Fatalf("unexpected finished event %v", events[1])
+	}
+	if events[3]["status"] != StepFailed || events[3]["error"] != "apply failed with [REDACTED]" {
+		t.Fatalf("unexpected failed event %v", events[3])
+	}
+}
+
+func TestHumanReporter(t *testing.T) {
+	t.Parallel()
+	var out bytes.Buffer
+	r, err := newReporter(OutputText, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Output("User ID", "user-123")
+	r.Step(StepEvent{Step: "base infra", Status: StepSkipped})
+	r.Step(StepEvent{Step: "base infra", Status: StepStarted})
+	for _, expected := range []string{"- User ID: user-123", "Skipping base infra, already completed by a previous run"} {
+		if !strings.Contains(out.String(), expected) {
+			t.Fatalf("expected %q in %q", expected, out.String())
+		}
+	}
+	if strings.Count(out.String(), "\n") != 2 {
+		t.Fatalf("expected started steps not to be printed, got %q", out.String())
+	}
+}
+
+func TestNewReporterRejectsUnknownFormat(t *testing.T) {
+	if _, err := newReporter("yaml", &bytes.Buffer{}); err == nil {
+		t.Fatalf("expected an error for an unknown output format")
+	}
+}
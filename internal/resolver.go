@@ -0,0 +1,57 @@
+package internal
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+// ToolResolver maps tool names such as terragrunt or npm to absolute
+// executable paths. The titvo-managed directories are searched first so the
+// binaries downloaded by the installer win over any system-wide install; tools
+// we do not manage (git) fall back to the system PATH.
+type ToolResolver struct {
+	OS   OS
+	Dirs []string
+}
+
+func NewToolResolver(osType OS, dirs ...string) *ToolResolver {
+	return &ToolResolver{OS: osType, Dirs: dirs}
+}
+
+// ToolResolver returns a resolver over the directories of the installed tools.
+func (c InstallToolConfig) ToolResolver() *ToolResolver {
+	return NewToolResolver(c.OS, c.TerraformBinDir, c.TerragruntBinDir, c.NodeBinDir)
+}
+
+// Resolve returns the absolute path of the executable for name.
+func (r *ToolResolver) Resolve(name string) (string, error) {
+	if filepath.IsAbs(name) {
+		return name, nil
+	}
+	executable := executableName(name, r.OS)
+	for _, dir := range r.Dirs {
+		if dir == "" {
+			continue
+		}
+		candidate := filepath.Join(dir, executable)
+		if info, err := os.Stat(candidate); err == nil && !info.IsDir() {
+			return filepath.Abs(candidate)
+		}
+	}
+	found, err := exec.LookPath(name)
+	if err != nil {
+		return "", fmt.Errorf("%s not found in titvo tools or PATH: %w", name, err)
+	}
+	return filepath.Abs(found)
+}
+
+// systemToolResolver resolves commands from the system PATH only.
+func systemToolResolver() *ToolResolver {
+	osType, err := GetOS()
+	if err != nil {
+		osType = OS("")
+	}
+	return NewToolResolver(osType)
+}
@@ -0,0 +1,95 @@
+package internal
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func writeFakeExecutable(t *testing.T, dir, name, script string) string {
+	t.Helper()
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(dir, name)
+	if err := os.WriteFile(file, []byte("#!/bin/sh\n"+script+"\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestToolResolverPrefersManagedDirs(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses shell scripts as fake executables")
+	}
+	root := t.TempDir()
+	managed := writeFakeExecutable(t, filepath.Join(root, "titvo"), "terragrunt", "exit 0")
+	writeFakeExecutable(t, filepath.Join(root, "system"), "terragrunt", "exit 0")
+	t.Setenv("PATH", filepath.Join(root, "system"))
+
+	resolved, err := NewToolResolver(Linux, filepath.Join(root, "missing"), filepath.Join(root, "titvo")).Resolve("terragrunt")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if resolved != managed {
+		t.Fatalf("expected managed binary %s, got %s", managed, resolved)
+	}
+}
+
+func TestToolResolverFallsBackToPath(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses shell scripts as fake executables")
+	}
+	root := t.TempDir()
+	system := writeFakeExecutable(t, filepath.Join(root, "system"), "git", "exit 0")
+	t.Setenv("PATH", filepath.Join(root, "system"))
+
+	resolved, err := NewToolResolver(Linux, filepath.Join(root, "titvo")).Resolve("git")
+	if err != nil || resolved != system {
+		t.Fatalf("expected system git %s, got %s (%v)", system, resolved, err)
+	}
+
+	_, err = NewToolResolver(Linux, filepath.Join(root, "titvo")).Resolve("terragrunt")
+	if err == nil || !strings.Contains(err.Error(), "terragrunt not found in titvo tools or PATH") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestToolResolverUsesWindowsExecutableNames(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"terraform.exe", "npm.cmd"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	resolver := NewToolResolver(Windows, dir)
+	for tool, expected := range map[string]string{"terraform": "terraform.exe", "npm": "npm.cmd"} {
+		resolved, err := resolver.Resolve(tool)
+		if err != nil || resolved != filepath.Join(dir, expected) {
+			t.Fatalf("expected %s, got %s (%v)", expected, resolved, err)
+		}
+	}
+}
+
+func TestExecuteWithOptionsRunsResolvedBinary(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses shell scripts as fake executables")
+	}
+	root := t.TempDir()
+	marker := filepath.Join(root, "marker")
+	writeFakeExecutable(t, filepath.Join(root, "titvo"), "terragrunt", "echo managed > "+marker)
+	writeFakeExecutable(t, filepath.Join(root, "system"), "terragrunt", "echo system > "+marker)
+	t.Setenv("PATH", filepath.Join(root, "system"))
+
+	err := ExecuteWithOptions(context.Background(), "terragrunt", &ExecuteOptions{Resolver: NewToolResolver(Linux, filepath.Join(root, "titvo"))}, "--version")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	content, _ := os.ReadFile(marker)
+	if strings.TrimSpace(string(content)) != "managed" {
+		t.Fatalf("expected managed terragrunt to run, got %q", content)
+	}
+}
@@ -0,0 +1,273 @@
+package internal
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	logsDirName      = "logs"
+	runLogFileName   = "run.log"
+	runIDLayout      = "20060102-150405"
+	logTimestampForm = time.RFC3339
+)
+
+// runLogger keeps the logs of one installer run in ~/.titvo/logs/<run-id>:
+// run.log with the installer messages and one <component>.log per deployment
+// step with the output of its commands.
+type runLogger struct {
+	ID  string
+	Dir string
+
+	mu  sync.Mutex
+	out io.WriteCloser
+}
+
+// newRunLogger creates the log directory of a new run under titvoDir.
+func newRunLogger(titvoDir string, now time.Time) (*runLogger, error) {
+	logsDir := filepath.Join(titvoDir, logsDirName)
+	if err := os.MkdirAll(logsDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create logs directory: %w", err)
+	}
+	id := now.UTC().Format(runIDLayout)
+	dir := filepath.Join(logsDir, id)
+	for i := 2; ; i++ {
+		err := os.Mkdir(dir, 0700)
+		if err == nil {
+			break
+		}
+		if !os.IsExist(err) {
+			return nil, fmt.Errorf("failed to create run log directory: %w", err)
+		}
+		id = fmt.Sprintf("%s-%d", now.UTC().Format(runIDLayout), i)
+		dir = filepath.Join(logsDir, id)
+	}
+	file, err := os.OpenFile(filepath.Join(dir, runLogFileName), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create run log: %w", err)
+	}
+	return &runLogger{ID: id, Dir: dir, out: newTimestampWriter(file)}, nil
+}
+
+// runLogPath returns the path of run.log.
+func (l *runLogger) runLogPath() string {
+	return filepath.Join(l.Dir, runLogFileName)
+}
+
+// componentLogPath returns the path of the log file of a deployment step.
+func (l *runLogger) componentLogPath(component string) string {
+	return filepath.Join(l.Dir, componentLogFileName(component))
+}
+
+// logf appends a line to run.log. It is a no-op on a nil logger.
+func (l *runLogger) logf(level string, format string, args ...any) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	fmt.Fprintf(l.out, "%-5s %s\n", level, fmt.Sprintf(format, args...))
+}
+
+// component opens the log file of a deployment step. Closing the returned
+// writer flushes the last partial line.
+func (l *runLogger) component(component string) (io.WriteCloser, error) {
+	file, err := os.OpenFile(l.componentLogPath(component), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s log: %w", component, err)
+	}
+	return newTimestampWriter(file), nil
+}
+
+func (l *runLogger) Close() error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.out.Close()
+}
+
+// componentName turns a step label such as "MCP gateway" into the name used
+// for its log file and in reports, e.g. "mcp-gateway".
+func componentName(step string) string {
+	name := strings.ToLower(strings.TrimSpace(step))
+	return strings.Join(strings.Fields(name), "-")
+}
+
+func componentLogFileName(component string) string {
+	return strings.TrimSuffix(componentName(component), ".log") + ".log"
+}
+
+var ansiEscape = regexp.MustCompile(`\x1b\[[0-9;?]*[A-Za-z]`)
+
+// timestampWriter prefixes every line with the time it was written and strips
+// terminal color codes. Stdout and stderr of a command share one writer, so
+// writes are serialized.
+type timestampWriter struct {
+	mu      sync.Mutex
+	out     io.WriteCloser
+	pending []byte
+	now     func() time.Time
+}
+
+func newTimestampWriter(out io.WriteCloser) *timestampWriter {
+	return &timestampWriter{out: out, now: time.Now}
+}
+
+func (w *timestampWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.pending = append(w.pending, p...)
+	for {
+		i := bytes.IndexByte(w.pending, '\n')
+		if i < 0 {
+			break
+		}
+		if err := w.writeLine(w.pending[:i]); err != nil {
+			return 0, err
+		}
+		w.pending = w.pending[i+1:]
+	}
+	return len(p), nil
+}
+
+func (w *timestampWriter) writeLine(line []byte) error {
+	line = bytes.TrimRight(ansiEscape.ReplaceAll(line, nil), "\r")
+	_, err := fmt.Fprintf(w.out, "%s %s\n", w.now().UTC().Format(logTimestampForm), line)
+	return err
+}
+
+func (w *timestampWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.pending) > 0 {
+		w.writeLine(w.pending)
+		w.pending = nil
+	}
+	return w.out.Close()
+}
+
+// runSummary describes a past run found in the logs directory.
+type runSummary struct {
+	ID         string
+	Dir        string
+	Components []string
+}
+
+// parseRunID returns the start time of a run and its sequence number among
+// the runs started in the same second, 1 for the first.
+func parseRunID(id string) (time.Time, int, bool) {
+	if len(id) < len(runIDLayout) {
+		return time.Time{}, 0, false
+	}
+	started, err := time.Parse(runIDLayout, id[:len(runIDLayout)])
+	if err != nil {
+		return time.Time{}, 0, false
+	}
+	suffix := id[len(runIDLayout):]
+	if suffix == "" {
+		return started, 1, true
+	}
+	n, err := strconv.Atoi(strings.TrimPrefix(suffix, "-"))
+	if err != nil || !strings.HasPrefix(suffix, "-") || n < 2 {
+		return time.Time{}, 0, false
+	}
+	return started, n, true
+}
+
+// runNewer orders run IDs newest first, by start time and then sequence
+// number. IDs the installer did not create go last, by name.
+func runNewer(a, b string) bool {
+	aStarted, aN, aOK := parseRunID(a)
+	bStarted, bN, bOK := parseRunID(b)
+	switch {
+	case aOK != bOK:
+		return aOK
+	case !aOK:
+		return a > b
+	case !aStarted.Equal(bStarted):
+		return aStarted.After(bStarted)
+	default:
+		return aN > bN
+	}
+}
+
+// listRuns returns the logged runs in titvoDir, newest first.
+func listRuns(titvoDir string) ([]runSummary, error) {
+	logsDir := filepath.Join(titvoDir, logsDirName)
+	entries, err := os.ReadDir(logsDir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read logs directory: %w", err)
+	}
+	runs := []runSummary{}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		run := runSummary{ID: entry.Name(), Dir: filepath.Join(logsDir, entry.Name())}
+		files, err := os.ReadDir(run.Dir)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read run %s: %w", run.ID, err)
+		}
+		for _, file := range files {
+			if file.IsDir() || file.Name() == runLogFileName || filepath.Ext(file.Name()) != ".log" {
+				continue
+			}
+			run.Components = append(run.Components, strings.TrimSuffix(file.Name(), ".log"))
+		}
+		runs = append(runs, run)
+	}
+	sort.Slice(runs, func(i, j int) bool {
+		return runNewer(runs[i].ID, runs[j].ID)
+	})
+	return runs, nil
+}
+
+// findRunLog returns the log file of a run, or of one of its components when
+// component is not empty. "latest" selects the most recent run.
+func findRunLog(titvoDir, runID, component string) (string, error) {
+	if strings.ContainsAny(component, `/\`) || strings.Contains(component, "..") {
+		return "", fmt.Errorf("invalid component %q", component)
+	}
+	runs, err := listRuns(titvoDir)
+	if err != nil {
+		return "", err
+	}
+	if len(runs) == 0 {
+		return "", fmt.Errorf("no installer runs found in %s", filepath.Join(titvoDir, logsDirName))
+	}
+	var run *runSummary
+	for i := range runs {
+		if runs[i].ID == runID || (runID == "latest" && i == 0) {
+			run = &runs[i]
+			break
+		}
+	}
+	if run == nil {
+		return "", fmt.Errorf("run %s not found", runID)
+	}
+	file := filepath.Join(run.Dir, runLogFileName)
+	if component != "" {
+		file = filepath.Join(run.Dir, componentLogFileName(component))
+	}
+	if _, err := os.Stat(file); err != nil {
+		if os.IsNotExist(err) {
+			return "", fmt.Errorf("run %s has no %s log, available: %s", run.ID, component, strings.Join(run.Components, ", "))
+		}
+		return "", err
+	}
+	return file, nil
+}
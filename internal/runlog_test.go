@@ -0,0 +1,126 @@
+package internal
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+type nopWriteCloser struct{ bytes.Buffer }
+
+func (*nopWriteCloser) Close() error { return nil }
+
+func TestTimestampWriterPrefixesLines(t *testing.T) {
+	out := &nopWriteCloser{}
+	writer := newTimestampWriter(out)
+	writer.now = func() time.Time { return time.Date(2025, 3, 1, 10, 30, 0, 0, time.UTC) }
+
+	writer.Write([]byte("\x1b[32mApply complete!\x1b[0m\r\nplan"))
+	writer.Write([]byte(" pending"))
+	if out.String() != "2025-03-01T10:30:00Z Apply complete!\n" {
+		t.Fatalf("unexpected log output %q", out.String())
+	}
+	writer.Close()
+	if !strings.HasSuffix(out.String(), "2025-03-01T10:30:00Z plan pending\n") {
+		t.Fatalf("expected partial line to be flushed on close, got %q", out.String())
+	}
+}
+
+func TestRunLoggerListsAndFindsRuns(t *testing.T) {
+	titvoDir := t.TempDir()
+	now := time.Date(2025, 3, 1, 10, 30, 0, 0, time.UTC)
+	older, err := newRunLogger(titvoDir, now.Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	older.Close()
+	latest, err := newRunLogger(titvoDir, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	console := testConsole()
+	console.Log = latest
+	console.printInfo("Starting Titvo Installer")
+	component, err := latest.component("MCP gateway")
+	if err != nil {
+		t.Fatal(err)
+	}
+	component.Write([]byte("terragrunt output\n"))
+	component.Close()
+	latest.Close()
+
+	runs, err := listRuns(titvoDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(runs) != 2 || runs[0].ID != "20250301-103000" || runs[1].ID != "20250301-093000" {
+		t.Fatalf("expected runs newest first, got %+v", runs)
+	}
+	if len(runs[0].Components) != 1 || runs[0].Components[0] != "mcp-gateway" {
+		t.Fatalf("unexpected components %v", runs[0].Components)
+	}
+
+	file, err := findRunLog(titvoDir, "latest", "")
+	if err != nil || file != filepath.Join(latest.Dir, runLogFileName) {
+		t.Fatalf("expected latest run log, got %s (%v)", file, err)
+	}
+	content, _ := os.ReadFile(file)
+	if !strings.Contains(string(content), "INFO  Starting Titvo Installer") {
+		t.Fatalf("unexpected run log %q", content)
+	}
+	file, err = findRunLog(titvoDir, "20250301-103000", "MCP gateway")
+	if err != nil || file != latest.componentLogPath("MCP gateway") {
+		t.Fatalf("expected component log, got %s (%v)", file, err)
+	}
+	if _, err := findRunLog(titvoDir, "20250301-093000", "MCP gateway"); err == nil {
+		t.Fatalf("expected an error for a missing component log")
+	}
+}
+
+func TestListRunsOrdersByStartAndSequence(t *testing.T) {
+	titvoDir := t.TempDir()
+	for _, id := range []string{"20250301-103000-2", "20250301-103000-10", "20250301-103000", "20250301-093000-3", "notes", "20250302-080000"} {
+		if err := os.MkdirAll(filepath.Join(titvoDir, logsDirName, id), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	runs, err := listRuns(titvoDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := []string{}
+	for _, run := range runs {
+		ids = append(ids, run.ID)
+	}
+	expected := []string{"20250302-080000", "20250301-103000-10", "20250301-103000-2", "20250301-103000", "20250301-093000-3", "notes"}
+	if !slices.Equal(ids, expected) {
+		t.Fatalf("expected runs %v, got %v", expected, ids)
+	}
+	for _, component := range []string{"../run", "base/../../x", `base\run`, "/etc/passwd"} {
+		if _, err := findRunLog(titvoDir, "latest", component); err == nil || !strings.Contains(err.Error(), "invalid component") {
+			t.Errorf("expected component %q to be rejected, got %v", component, err)
+		}
+	}
+}
+
+func TestNewRunLoggerAvoidsIDCollisions(t *testing.T) {
+	titvoDir := t.TempDir()
+	now := time.Now()
+	first, err := newRunLogger(titvoDir, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+	second, err := newRunLogger(titvoDir, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Close()
+	if first.ID == second.ID || second.ID != first.ID+"-2" {
+		t.Fatalf("expected a distinct run ID, got %s and %s", first.ID, second.ID)
+	}
+}
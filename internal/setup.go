@@ -1,6 +1,7 @@
 package internal
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"path"
@@ -78,6 +79,14 @@ type SetupConfigFile struct {
 	AIProvider         string `json:"ai_provider"`
 	AIModel            string `json:"ai_model"`
 	AIApiKey           string `json:"ai_api_key"`
+	AIBaseURL          string `json:"ai_base_url"`
+	AIAPIVersion       string `json:"ai_api_version"`
+	AIRegion           string `json:"ai_region"`
+	// The MCP gateway settings default to gateway, 3000 and
+	// internal.titvo.com when empty.
+	MCPGatewayHostname string `json:"mcp_gateway_hostname,omitempty"`
+	MCPGatewayPort     int    `json:"mcp_gateway_port,omitempty"`
+	MCPNamespace       string `json:"mcp_namespace,omitempty"`
 	BitbucketAPIToken  string `json:"bitbucket_api_token"`
 	GithubAccessToken  string `json:"github_access_token"`
 }
@@ -106,11 +115,37 @@ type SetupConfig struct {
 	AIProvider           string
 	AIModel              string
 	AIApiKey             string
+	AIBaseURL            string
+	AIAPIVersion         string
+	AIRegion             string
+	MCPGatewayHostname   string
+	MCPGatewayPort       int
+	MCPNamespace         string
 	BitbucketAPIToken    string
 	GithubAccessToken    string
+	// aiChecked reports that the AI settings were checked with the provider
+	// while they were asked for.
+	aiChecked bool
 }
 
-func askForPromptInput(awsRegion string) (*SetupConfig, error) {
+func (s *SetupConfig) aiSettings() AISettings {
+	return AISettings{
+		Provider:   s.AIProvider,
+		Model:      s.AIModel,
+		APIKey:     s.AIApiKey,
+		BaseURL:    s.AIBaseURL,
+		APIVersion: s.AIAPIVersion,
+		Region:     s.AIRegion,
+	}
+}
+
+// mcpGateway returns the MCP gateway settings, with the defaults of the ones
+// not given.
+func (s *SetupConfig) mcpGateway() MCPGateway {
+	return MCPGateway{Hostname: s.MCPGatewayHostname, Port: s.MCPGatewayPort, Namespace: s.MCPNamespace}.withDefaults()
+}
+
+func (c *Console) askForPromptInput(ctx context.Context, awsRegion string, validator *AIValidator) (*SetupConfig, error) {
 	var awsAccessKeyID string
 	var awsSecretAccessKey string
 	var awsSessionToken string
@@ -120,88 +155,78 @@ func askForPromptInput(awsRegion string) (*SetupConfig, error) {
 	var natGatewayID string
 	var aesSecret string
 	var userName string
-	var aiProvider string
-	var aiModel string
-	var aiApiKey string
 	var bitbucketAPIToken string
 	var githubAccessToken string
 	var err error
-	awsAccessKeyID, err = askForPassword("Enter your AWS Access Key ID", "AWS Access Key ID")
+	awsAccessKeyID, err = c.askForPassword("Enter your AWS Access Key ID", "AWS Access Key ID")
 	if err != nil {
-		printErrorAndExit(err)
+		c.printErrorAndExit(err)
 	}
-	awsSecretAccessKey, err = askForPassword("Enter your AWS Secret Access Key", "AWS Secret Access Key")
+	awsSecretAccessKey, err = c.askForPassword("Enter your AWS Secret Access Key", "AWS Secret Access Key")
 	if err != nil {
-		printErrorAndExit(err)
+		c.printErrorAndExit(err)
 	}
-	awsSessionToken, err = askForPassword("Enter your AWS Session Token", "AWS Session Token")
+	awsSessionToken, err = c.askForPassword("Enter your AWS Session Token", "AWS Session Token")
 	if err != nil {
-		printErrorAndExit(err)
+		c.printErrorAndExit(err)
 	}
-	vpcID, err = askForInput("Enter your VPC ID", "VPC ID")
+	vpcID, err = c.askForInput("Enter your VPC ID", "VPC ID")
 	if err != nil {
-		printErrorAndExit(err)
+		c.printErrorAndExit(err)
 	}
-	printAskQuestion("These values will be used to create an isolated private network for Titvo.")
-	privateSubnetCIDR, err = askForInput("Enter your private subnet CIDR (e.g. 172.31.64.0/20)", "Private Subnet CIDR")
+	c.printAskQuestion("These values will be used to create an isolated private network for Titvo.")
+	privateSubnetCIDR, err = c.askForInput("Enter your private subnet CIDR (e.g. 172.31.64.0/20)", "Private Subnet CIDR")
 	if err != nil {
-		printErrorAndExit(err)
+		c.printErrorAndExit(err)
 	}
-	availabilityZone, err = askForInput("Enter your Availability Zone (e.g. us-east-1a)", "Availability Zone")
+	availabilityZone, err = c.askForInput("Enter your Availability Zone (e.g. us-east-1a)", "Availability Zone")
 	if err != nil {
-		printErrorAndExit(err)
+		c.printErrorAndExit(err)
 	}
-	natGatewayID, err = askForInput("Enter your NAT Gateway ID (e.g. nat-xxxxxxxxxxxxxxxxx)", "NAT Gateway ID")
+	natGatewayID, err = c.askForInput("Enter your NAT Gateway ID (e.g. nat-xxxxxxxxxxxxxxxxx)", "NAT Gateway ID")
 	if err != nil {
-		printErrorAndExit(err)
+		c.printErrorAndExit(err)
 	}
-	aesSecret, err = askForPassword("Enter your AES Secret", "AES Secret")
+	aesSecret, err = c.askForAESSecret()
 	if err != nil {
-		printErrorAndExit(err)
+		c.printErrorAndExit(err)
 	}
-	if len(aesSecret) != 32 {
-		printErrorAndExit(fmt.Errorf("AES Secret must have 32 characters in length"))
-	}
-	userName, err = askForInput("Enter your first Titvo User Name", "Titvo User Name")
+	userName, err = c.askForInput("Enter your first Titvo User Name", "Titvo User Name")
 	if err != nil {
-		printErrorAndExit(err)
+		c.printErrorAndExit(err)
 	}
-	aiProvider, err = askForAIProvider()
+	ai, aiChecked, err := c.askForAISettings(ctx, validator, strings.TrimSpace(awsRegion))
 	if err != nil {
-		printErrorAndExit(err)
+		c.printErrorAndExit(err)
 	}
-	aiModel, err = askForInput("Enter your AI Model", "AI Model")
+	mcpGateway, err := c.askForMCPGateway()
 	if err != nil {
-		printErrorAndExit(err)
+		c.printErrorAndExit(err)
 	}
-	aiApiKey, err = askForPassword("Enter your AI API Key", "AI API Key")
+	configureBitbucket, err := c.askForYesNo("Do you want to configure Bitbucket credentials? (y/N)")
 	if err != nil {
-		printErrorAndExit(err)
-	}
-	configureBitbucket, err := askForYesNo("Do you want to configure Bitbucket credentials? (y/N)")
-	if err != nil {
-		printErrorAndExit(err)
+		c.printErrorAndExit(err)
 	}
 	if configureBitbucket {
-		bitbucketAPIToken, err = askForPassword("Enter Bitbucket API Token", "Bitbucket API Token")
+		bitbucketAPIToken, err = c.askForPassword("Enter Bitbucket API Token", "Bitbucket API Token")
 		if err != nil {
-			printErrorAndExit(err)
+			c.printErrorAndExit(err)
 		}
 	} else {
-		printAskQuestion("Warning: Bitbucket credentials were not provided. Bitbucket integration deployment will be skipped.")
+		c.printAskQuestion("Warning: Bitbucket credentials were not provided. Bitbucket integration deployment will be skipped.")
 	}
 
-	configureGithub, err := askForYesNo("Do you want to configure GitHub credentials? (y/N)")
+	configureGithub, err := c.askForYesNo("Do you want to configure GitHub credentials? (y/N)")
 	if err != nil {
-		printErrorAndExit(err)
+		c.printErrorAndExit(err)
 	}
 	if configureGithub {
-		githubAccessToken, err = askForPassword("Enter GitHub Access Token", "GitHub Access Token")
+		githubAccessToken, err = c.askForPassword("Enter GitHub Access Token", "GitHub Access Token")
 		if err != nil {
-			printErrorAndExit(err)
+			c.printErrorAndExit(err)
 		}
 	} else {
-		printAskQuestion("Warning: GitHub access token was not provided. GitHub integration deployment will be skipped.")
+		c.printAskQuestion("Warning: GitHub access token was not provided. GitHub integration deployment will be skipped.")
 	}
 
 	return &SetupConfig{
@@ -213,27 +238,51 @@ func askForPromptInput(awsRegion string) (*SetupConfig, error) {
 				AWSRegion:          strings.TrimSpace(awsRegion),
 			},
 		},
-		VPCID:             vpcID,
-		PrivateSubnetCIDR: privateSubnetCIDR,
-		AvailabilityZone:  availabilityZone,
-		NatGatewayID:      natGatewayID,
-		AesSecret:         string(aesSecret),
-		UserName:          userName,
-		AIProvider:        aiProvider,
-		AIModel:           aiModel,
-		AIApiKey:          string(aiApiKey),
-		BitbucketAPIToken: string(bitbucketAPIToken),
-		GithubAccessToken: string(githubAccessToken),
+		VPCID:              vpcID,
+		PrivateSubnetCIDR:  privateSubnetCIDR,
+		AvailabilityZone:   availabilityZone,
+		NatGatewayID:       natGatewayID,
+		AesSecret:          string(aesSecret),
+		UserName:           userName,
+		AIProvider:         ai.Provider,
+		AIModel:            ai.Model,
+		AIApiKey:           ai.APIKey,
+		AIBaseURL:          ai.BaseURL,
+		AIAPIVersion:       ai.APIVersion,
+		AIRegion:           ai.Region,
+		MCPGatewayHostname: mcpGateway.Hostname,
+		MCPGatewayPort:     mcpGateway.Port,
+		MCPNamespace:       mcpGateway.Namespace,
+		BitbucketAPIToken:  string(bitbucketAPIToken),
+		GithubAccessToken:  string(githubAccessToken),
+		aiChecked:          aiChecked,
 	}, nil
 }
 
-func askForAIProvider() (string, error) {
-	choices := []choice{
-		{Label: "Anthropic", Value: "anthropic", Callback: func() (any, error) { return "anthropic", nil }},
-		{Label: "OpenAI", Value: "openai", Callback: func() (any, error) { return "openai", nil }},
-		{Label: "Google", Value: "google", Callback: func() (any, error) { return "google", nil }},
+// askForAESSecret returns the AES secret typed by the user, or an empty
+// string to generate a random one.
+func (c *Console) askForAESSecret() (string, error) {
+	ownSecret, err := c.askForYesNo("Do you want to enter your own AES Secret? A random one is generated otherwise (y/N)")
+	if err != nil || !ownSecret {
+		return "", err
+	}
+	aesSecret, err := c.askForPassword("Enter your AES Secret", "AES Secret")
+	if err != nil {
+		return "", err
+	}
+	if len(aesSecret) != 32 {
+		return "", fmt.Errorf("AES Secret must have 32 characters in length")
+	}
+	return aesSecret, nil
+}
+
+func (c *Console) askForAIProvider() (string, error) {
+	choices := []choice{}
+	for _, provider := range aiProviders {
+		name := provider.Name
+		choices = append(choices, choice{Label: provider.Label, Value: name, Callback: func() (any, error) { return name, nil }})
 	}
-	result, err := askForChoices("Select AI Provider", choices)
+	result, err := c.askForChoices("Select AI Provider", choices)
 	if err != nil {
 		return "", err
 	}
@@ -244,31 +293,33 @@ func askForAIProvider() (string, error) {
 	return provider, nil
 }
 
-func SetupInstallation() (config *SetupConfig, err error) {
-	printInfo("Setting up Titvo Installer")
-	awsRegion, err := askForInput("Enter your AWS Region", "AWS Region")
+// SetupInstallation asks for the settings of the installation. A nil
+// validator skips checking the AI API key and model.
+func SetupInstallation(ctx context.Context, console *Console, validator *AIValidator) (config *SetupConfig, err error) {
+	console.printInfo("Setting up Titvo Installer")
+	awsRegion, err := console.askForInput("Enter your AWS Region", "AWS Region")
 	if err != nil {
-		printErrorAndExit(err)
+		console.printErrorAndExit(err)
 	}
 	choices := []choice{
 		{
 			Label: "Input",
 			Value: "1",
 			Callback: func() (any, error) {
-				return askForPromptInput(awsRegion)
+				return console.askForPromptInput(ctx, awsRegion, validator)
 			},
 		},
 		{
 			Label: "File",
 			Value: "2",
 			Callback: func() (any, error) {
-				return askForCredentialsFile(awsRegion)
+				return console.askForCredentialsFile(ctx, awsRegion, validator)
 			},
 		},
 	}
-	result, err := askForChoices("You want to give the credentials from input or a credentials file?", choices)
+	result, err := console.askForChoices("You want to give the credentials from input or a credentials file?", choices)
 	if err != nil {
-		printErrorAndExit(err)
+		console.printErrorAndExit(err)
 	}
 	config, ok := result.(*SetupConfig)
 	if !ok {
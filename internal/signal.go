@@ -0,0 +1,70 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+// runningCommands are the commands started by ExecuteWithOptions that have
+// not exited yet, killed on a forced exit so they do not outlive the installer.
+var runningCommands = struct {
+	sync.Mutex
+	commands map[*exec.Cmd]struct{}
+}{commands: map[*exec.Cmd]struct{}{}}
+
+// trackCommand registers a started command until the returned func is called.
+func trackCommand(cmd *exec.Cmd) func() {
+	runningCommands.Lock()
+	defer runningCommands.Unlock()
+	runningCommands.commands[cmd] = struct{}{}
+	return func() {
+		runningCommands.Lock()
+		defer runningCommands.Unlock()
+		delete(runningCommands.commands, cmd)
+	}
+}
+
+// killRunningCommands kills every running command along with its children.
+func killRunningCommands(console *Console) {
+	runningCommands.Lock()
+	defer runningCommands.Unlock()
+	for cmd := range runningCommands.commands {
+		if err := killProcessTree(cmd); err != nil {
+			console.printError(fmt.Errorf("failed to kill %s: %w", cmd.Path, err))
+		}
+	}
+}
+
+// withInterrupt returns a context that is cancelled on the first SIGINT or
+// SIGTERM. Running commands are then asked to stop gracefully; a second signal
+// kills them and exits right away. console reports both signals.
+func withInterrupt(parent context.Context, console *Console) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(parent)
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case sig := <-signals:
+			console.printAskQuestion(fmt.Sprintf("Received %s, waiting for the running command to stop and release its locks. Press Ctrl-C again to exit immediately", sig))
+			cancel()
+		case <-ctx.Done():
+			return
+		}
+		if _, ok := <-signals; ok {
+			// The commands run in their own process group, the terminal
+			// does not deliver the second Ctrl-C to them
+			killRunningCommands(console)
+			console.printError(fmt.Errorf("forced exit, terraform state locks may have to be released manually"))
+			os.Exit(130)
+		}
+	}()
+	return ctx, func() {
+		signal.Stop(signals)
+		cancel()
+	}
+}
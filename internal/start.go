@@ -1,42 +1,19 @@
 package internal
 
 import (
-	"crypto/aes"
+	"context"
 	"crypto/rand"
 	"crypto/sha256"
-	"encoding/base64"
 	"encoding/hex"
-	"errors"
 	"fmt"
-	"os"
-	"path"
 	"strings"
+	"time"
 
 	"github.com/google/uuid"
 )
 
-const promptFileUrl = "https://raw.githubusercontent.com/KaribuLab/titvo-installer/main/system_prompt.md"
-const contentTemplateFileUrl = "https://raw.githubusercontent.com/KaribuLab/titvo-installer/main/content_template.md"
 const apiKeyCharset = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
 
-func downloadPromptFile(dir string) (string, error) {
-	url := promptFileUrl
-	err := downloadFile(url, dir, "system_prompt.md")
-	if err != nil {
-		return "", err
-	}
-	return path.Join(dir, "system_prompt.md"), nil
-}
-
-func downloadContentTemplateFile(dir string) (string, error) {
-	url := contentTemplateFileUrl
-	err := downloadFile(url, dir, "content_template.md")
-	if err != nil {
-		return "", err
-	}
-	return path.Join(dir, "content_template.md"), nil
-}
-
 // hashSha256 hashes data using SHA-256
 func hashSha256(data []byte) string {
 	hash := sha256.New()
@@ -78,216 +55,204 @@ func generateAPIKey() string {
 	return prefix + suffix.String()
 }
 
-// encrypt encrypts a text using AES in ECB mode
-func encrypt(text, key string) (string, error) {
-	if len(key) != 32 {
-		return "", errors.New("AES_KEY must have 32 characters length")
-	}
-
-	// Create the AES cipher
-	block, err := aes.NewCipher([]byte(key))
-	if err != nil {
-		return "", err
-	}
-
-	// Convert text to bytes
-	plaintext := []byte(text)
-
-	// Apply padding PKCS7 to make it a multiple of the block size
-	blockSize := block.BlockSize()
-	padding := blockSize - len(plaintext)%blockSize
-	// PKCS7 padding: if text is already multiple of block size, add a full block of padding
-	if padding == 0 {
-		padding = blockSize
-	}
-	padtext := make([]byte, len(plaintext)+padding)
-	copy(padtext, plaintext)
-	for i := len(plaintext); i < len(padtext); i++ {
-		padtext[i] = byte(padding)
-	}
-
-	// Encrypt using ECB (block by block) using the AES cipher
-	encrypted := make([]byte, len(padtext))
-	for i := 0; i < len(padtext); i += blockSize {
-		block.Encrypt(encrypted[i:i+blockSize], padtext[i:i+blockSize])
-	}
-
-	// Return in base64 format
-	return base64.StdEncoding.EncodeToString(encrypted), nil
-}
-
 type StartConfig struct {
-	AWSCredentials *AWSCredentials
-	UserName       string
-	AIProvider     string
-	AIModel        string
-	AIApiKey       string
-	AESSecret      string
-	TitvoDir       string
+	UserName  string
+	AI        AISettings
+	AESSecret string
+	// EncryptionFormat is the format of the encrypted AI API key, v2, v1 or
+	// kms. The kms format uses KMSKeyARN instead of the AES secret.
+	EncryptionFormat string
+	KMSKeyARN        string
+	// PromptFiles replace the embedded default of the prompt documents, by
+	// name; the others keep a customized version or get the default.
+	PromptFiles map[string]*promptFile
+	// MCPGateway is resolved in Cloud Map to write the MCP server URL.
+	MCPGateway MCPGateway
+	// Reconfigure updates the parameters without creating or changing users.
+	Reconfigure bool
 }
 
-// StartConfiguration starts the configuration
-func StartConfiguration(config *StartConfig) error {
-	printInfo("Starting configuration")
-	dynamoUserTableName, err := GetParameter(config.AWSCredentials, "/tvo/security-scan/prod/infra/dynamo/user-table-name")
+// ensureFirstUser creates the first user and its API key. A user with the same
+// name, or an API key of it, created by a previous run is kept instead, so a
+// rerun does not leave duplicates behind; apiKey is empty in that case because
+// only its hash is stored.
+func (d *Deployer) ensureFirstUser(ctx context.Context, userName string) (userID, apiKey string, err error) {
+	dynamoUserTableName, err := d.Parameters.GetParameter(ctx, userTableParameter)
 	if err != nil {
-		return err
+		return "", "", err
 	}
-	userId := uuid.New().String()
-	err = PutRecord(config.AWSCredentials, dynamoUserTableName, map[string]interface{}{
-		"user_id":      userId,
-		"account_type": "Team",
-		"name":         config.UserName,
-	})
+	users, err := d.Records.FindRecords(ctx, dynamoUserTableName, "name", userName)
 	if err != nil {
-		return err
+		return "", "", err
+	}
+	if len(users) > 0 {
+		userID, _ = users[0]["user_id"].(string)
+		d.Console.printInfo(fmt.Sprintf("User %s already exists with ID %s, keeping it", userName, userID))
+	} else {
+		userID, err = putNewUser(ctx, d.Records, dynamoUserTableName, userName, defaultAccountType)
+		if err != nil {
+			return "", "", err
+		}
 	}
-	dynamoAPIKeyTableName, err := GetParameter(config.AWSCredentials, "/tvo/security-scan/prod/infra/dynamo/apikey-table-name")
+	dynamoAPIKeyTableName, err := d.Parameters.GetParameter(ctx, apiKeyTableParameter)
 	if err != nil {
-		return err
+		return "", "", err
 	}
-	keyId := uuid.New().String()
-	apiKey := generateAPIKey()
-	err = PutRecord(config.AWSCredentials, dynamoAPIKeyTableName, map[string]interface{}{
-		"key_id":  keyId,
-		"api_key": hashSha256([]byte(apiKey)),
-		"user_id": userId,
-	})
+	keys, err := d.Records.FindRecords(ctx, dynamoAPIKeyTableName, "user_id", userID)
 	if err != nil {
-		return err
+		return "", "", err
 	}
-	dynamoConfigurationTableName, err := GetParameter(config.AWSCredentials, "/tvo/security-scan/prod/infra/dynamo/parameter-table-name")
-	if err != nil {
-		return err
+	if len(keys) > 0 {
+		d.Console.printInfo(fmt.Sprintf("User %s already has an API key, keeping it", userName))
+		return userID, "", nil
 	}
-	err = PutRecord(config.AWSCredentials, dynamoConfigurationTableName, map[string]interface{}{
-		"parameter_id": "ai_provider",
-		"value":        config.AIProvider,
-	})
+	_, apiKey, err = putNewAPIKey(ctx, d.Records, dynamoAPIKeyTableName, userID, time.Now(), apiKeyOptions{Label: "installer"})
 	if err != nil {
-		return err
+		return "", "", err
 	}
-	err = PutRecord(config.AWSCredentials, dynamoConfigurationTableName, map[string]interface{}{
-		"parameter_id": "ai_model",
-		"value":        config.AIModel,
-	})
+	return userID, apiKey, nil
+}
+
+// StartConfiguration starts the configuration
+func (d *Deployer) StartConfiguration(ctx context.Context, config *StartConfig) error {
+	d.Console.printInfo("Starting configuration")
+	var err error
+	var userID, apiKey string
+	if config.Reconfigure {
+		d.Console.printInfo("Reconfiguring parameters, users and API keys are left untouched")
+	} else {
+		userID, apiKey, err = d.ensureFirstUser(ctx, config.UserName)
+		if err != nil {
+			return err
+		}
+	}
+	dynamoConfigurationTableName, err := d.Parameters.GetParameter(ctx, parameterTableParameter)
 	if err != nil {
 		return err
 	}
-	cliFilesBucketName, err := GetParameter(config.AWSCredentials, "/tvo/security-scan/prod/infra/s3/cli-files/bucket_name")
+	// The settings of other providers are written empty, so none is left
+	// over from a previous provider
+	for _, parameter := range config.AI.parameters() {
+		err = d.Records.PutRecord(ctx, dynamoConfigurationTableName, map[string]interface{}{
+			"parameter_id": parameter[0],
+			"value":        parameter[1],
+		})
+		if err != nil {
+			return err
+		}
+	}
+	cliFilesBucketName, err := d.Parameters.GetParameter(ctx, "/tvo/security-scan/prod/infra/s3/cli-files/bucket_name")
 	if err != nil {
 		return err
 	}
-	err = PutRecord(config.AWSCredentials, dynamoConfigurationTableName, map[string]interface{}{
+	err = d.Records.PutRecord(ctx, dynamoConfigurationTableName, map[string]interface{}{
 		"parameter_id": "cli_files_bucket_name",
 		"value":        cliFilesBucketName,
 	})
 	if err != nil {
 		return err
 	}
+	if err := validateEncryptionFormat(config.EncryptionFormat); err != nil {
+		return err
+	}
 	// Validate AES key has 32 characters
-	if len(config.AESSecret) != 32 {
+	if config.EncryptionFormat != EncryptionFormatKMS && len(config.AESSecret) != 32 {
 		return fmt.Errorf("AES_KEY must have 32 characters in length")
 	}
-	aiApiKey, err := encrypt(config.AIApiKey, config.AESSecret)
+	err = d.Records.PutRecord(ctx, dynamoConfigurationTableName, map[string]interface{}{
+		"parameter_id": encryptionFormatParameterID,
+		"value":        config.EncryptionFormat,
+	})
 	if err != nil {
 		return err
 	}
-	err = PutRecord(config.AWSCredentials, dynamoConfigurationTableName, map[string]interface{}{
+	// Providers authenticated with IAM have no key to encrypt
+	aiApiKey := ""
+	if config.AI.APIKey != "" {
+		aiApiKey, err = d.encryptParameter(ctx, config.AI.APIKey, config.EncryptionFormat, config.AESSecret, config.KMSKeyARN)
+		if err != nil {
+			return err
+		}
+	}
+	err = d.Records.PutRecord(ctx, dynamoConfigurationTableName, map[string]interface{}{
 		"parameter_id": "ai_api_key",
 		"value":        aiApiKey,
 	})
 	if err != nil {
 		return err
 	}
-	promptFilePath, err := downloadPromptFile(config.TitvoDir)
-	if err != nil {
-		return err
-	}
-	// Read prompt file
-	promptFile, err := os.ReadFile(promptFilePath)
-	if err != nil {
-		return err
-	}
-	err = PutRecord(config.AWSCredentials, dynamoConfigurationTableName, map[string]interface{}{
-		"parameter_id": "scan_system_prompt",
-		"value":        string(promptFile),
-	})
-	if err != nil {
-		return err
+	prompts := &PromptManager{Parameters: d.Parameters, Records: d.Records, Console: d.Console}
+	for _, document := range promptDocuments {
+		if err := prompts.install(ctx, dynamoConfigurationTableName, document, config.PromptFiles[document.Name]); err != nil {
+			return err
+		}
 	}
-	securityScanJobQueueName, err := GetParameter(config.AWSCredentials, "/tvo/security-scan/prod/infra/batch/agent/job_queue_name")
+	securityScanJobQueueName, err := d.Parameters.GetParameter(ctx, "/tvo/security-scan/prod/infra/batch/agent/job_queue_name")
 	if err != nil {
 		return err
 	}
-	err = PutRecord(config.AWSCredentials, dynamoConfigurationTableName, map[string]interface{}{
+	err = d.Records.PutRecord(ctx, dynamoConfigurationTableName, map[string]interface{}{
 		"parameter_id": "security-scan-job-queue",
 		"value":        securityScanJobQueueName,
 	})
 	if err != nil {
 		return err
 	}
-	// Read content template file
-	contentTemplateFilePath, err := downloadContentTemplateFile(config.TitvoDir)
-	if err != nil {
-		return err
-	}
-	contentTemplateFile, err := os.ReadFile(contentTemplateFilePath)
+	taskEndpoint, err := d.Parameters.GetParameter(ctx, "/tvo/security-scan/prod/infra/apigateway/task/api_gateway_api_full_endpoint")
 	if err != nil {
 		return err
 	}
-	err = PutRecord(config.AWSCredentials, dynamoConfigurationTableName, map[string]interface{}{
-		"parameter_id": "content_template",
-		"value":        string(contentTemplateFile),
+	err = d.Records.PutRecord(ctx, dynamoConfigurationTableName, map[string]interface{}{
+		"parameter_id": "task_endpoint",
+		"value":        taskEndpoint,
 	})
 	if err != nil {
 		return err
 	}
-	taskEndpoint, err := GetParameter(config.AWSCredentials, "/tvo/security-scan/prod/infra/apigateway/task/api_gateway_api_full_endpoint")
-	if err != nil {
-		return err
-	}
-	err = PutRecord(config.AWSCredentials, dynamoConfigurationTableName, map[string]interface{}{
-		"parameter_id": "task_endpoint",
-		"value":        taskEndpoint,
-	})
+	mcpServerURL, err := d.resolveMCPGateway(ctx, config.MCPGateway)
 	if err != nil {
 		return err
 	}
-	err = PutRecord(config.AWSCredentials, dynamoConfigurationTableName, map[string]interface{}{
+	err = d.Records.PutRecord(ctx, dynamoConfigurationTableName, map[string]interface{}{
 		"parameter_id": "mcp_server_url",
-		"value":        "http://gateway.internal.titvo.com:3000/mcp",
+		"value":        mcpServerURL,
 	})
 	if err != nil {
 		return err
 	}
-	securityScanJobDefinitionName, err := GetParameter(config.AWSCredentials, "/tvo/security-scan/prod/infra/batch/agent/job_definition_name")
+	securityScanJobDefinitionName, err := d.Parameters.GetParameter(ctx, "/tvo/security-scan/prod/infra/batch/agent/job_definition_name")
 	if err != nil {
 		return err
 	}
-	err = PutRecord(config.AWSCredentials, dynamoConfigurationTableName, map[string]interface{}{
+	err = d.Records.PutRecord(ctx, dynamoConfigurationTableName, map[string]interface{}{
 		"parameter_id": "security-scan-job-definition",
 		"value":        securityScanJobDefinitionName,
 	})
 	if err != nil {
 		return err
 	}
-	setupEndpoint, err := GetParameter(config.AWSCredentials, "/tvo/security-scan/prod/infra/apigateway/task/api_gateway_api_full_endpoint")
+	setupEndpoint, err := d.Parameters.GetParameter(ctx, "/tvo/security-scan/prod/infra/apigateway/task/api_gateway_api_full_endpoint")
 	if err != nil {
 		return err
 	}
-	printInfo("----------------------------------------------------------------")
-	printInfo(fmt.Sprintf("- Setup Endpoint: %s", setupEndpoint))
-	printInfo(fmt.Sprintf("- User ID: %s", userId))
-	printInfo(fmt.Sprintf("- API Key: %s", apiKey))
-	printInfo("----------------------------------------------------------------")
-	printInfo("* Remember to keep your API Key and User ID in a safe place")
-	printInfo("----------------------------------------------------------------")
-	printInfo("Now download the Titvo CLI from the following link:")
-	printInfo("https://github.com/KaribuLab/tli/releases")
-	printInfo("----------------------------------------------------------------")
-	printInfo("And run the following command to setup the Titvo CLI:")
-	printInfo("tli setup")
-	printInfo("----------------------------------------------------------------")
+	d.Console.printInfo("----------------------------------------------------------------")
+	d.Console.reportOutput("Setup Endpoint", setupEndpoint)
+	if userID != "" {
+		d.Console.reportOutput("User ID", userID)
+	}
+	if apiKey != "" {
+		d.Console.reportOutput("API Key", apiKey)
+		d.Console.printInfo("----------------------------------------------------------------")
+		d.Console.printInfo("* Remember to keep your API Key and User ID in a safe place")
+	} else if userID != "" {
+		d.Console.printInfo("* The API Key was created by a previous run and is not shown again")
+	}
+	d.Console.printInfo("----------------------------------------------------------------")
+	d.Console.printInfo("Now download the Titvo CLI from the following link:")
+	d.Console.printInfo("https://github.com/KaribuLab/tli/releases")
+	d.Console.printInfo("----------------------------------------------------------------")
+	d.Console.printInfo("And run the following command to setup the Titvo CLI:")
+	d.Console.printInfo("tli setup")
+	d.Console.printInfo("----------------------------------------------------------------")
 	return nil
 }
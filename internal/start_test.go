@@ -0,0 +1,252 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// recordKey returns the key attribute of an item of the Titvo tables.
+func recordKey(item map[string]interface{}) string {
+	for _, key := range []string{"parameter_id", "key_id", "user_id"} {
+		if _, ok := item[key]; ok {
+			return key
+		}
+	}
+	return ""
+}
+
+// useMemoryRecords makes fake store the DynamoDB items in records, keyed by
+// table name.
+func useMemoryRecords(fake *fakeAWS, records map[string][]map[string]interface{}) {
+	var mu sync.Mutex
+	fake.putRecord = func(tableName string, item map[string]interface{}) error {
+		mu.Lock()
+		defer mu.Unlock()
+		key := recordKey(item)
+		for i, existing := range records[tableName] {
+			if existing[key] == item[key] {
+				records[tableName][i] = item
+				return nil
+			}
+		}
+		records[tableName] = append(records[tableName], item)
+		return nil
+	}
+	fake.putNewRecord = func(tableName, keyAttribute string, item map[string]interface{}) error {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, existing := range records[tableName] {
+			if existing[keyAttribute] == item[keyAttribute] {
+				return ErrRecordExists
+			}
+		}
+		records[tableName] = append(records[tableName], item)
+		return nil
+	}
+	fake.findRecords = func(tableName, attribute, value string) ([]map[string]interface{}, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		found := []map[string]interface{}{}
+		for _, item := range records[tableName] {
+			if item[attribute] == value {
+				found = append(found, item)
+			}
+		}
+		return found, nil
+	}
+	fake.listRecords = func(tableName string) ([]map[string]interface{}, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]map[string]interface{}{}, records[tableName]...), nil
+	}
+	fake.deleteRecord = func(tableName, keyAttribute, keyValue string) error {
+		mu.Lock()
+		defer mu.Unlock()
+		records[tableName] = slices.DeleteFunc(records[tableName], func(item map[string]interface{}) bool {
+			return item[keyAttribute] == keyValue
+		})
+		return nil
+	}
+}
+
+// startDeployer returns a Deployer for StartConfiguration that serves the
+// infra parameters and records the DynamoDB items written per table.
+func startDeployer(records map[string][]map[string]interface{}) *Deployer {
+	d, fake := testDeployer()
+	fake.getParameter = func(path string) (string, error) {
+		switch path {
+		case "/tvo/security-scan/prod/infra/dynamo/user-table-name":
+			return "users", nil
+		case "/tvo/security-scan/prod/infra/dynamo/apikey-table-name":
+			return "apikeys", nil
+		case "/tvo/security-scan/prod/infra/dynamo/parameter-table-name":
+			return "parameters", nil
+		}
+		return "value:" + path, nil
+	}
+	useMemoryRecords(fake, records)
+	return d
+}
+
+func validStartConfig(t *testing.T) *StartConfig {
+	return &StartConfig{
+		UserName:         "admin",
+		AI:               AISettings{Provider: "openai", Model: "gpt-4o", APIKey: "sk-test"},
+		AESSecret:        "12345678901234567890123456789012",
+		EncryptionFormat: EncryptionFormatGCM,
+		MCPGateway:       MCPGateway{Hostname: "mcp", Port: 8080, Namespace: "svc.example.internal"},
+	}
+}
+
+func TestStartConfigurationWritesRecords(t *testing.T) {
+	t.Parallel()
+	records := map[string][]map[string]interface{}{}
+	d := startDeployer(records)
+
+	if err := d.StartConfiguration(context.Background(), validStartConfig(t)); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if len(records["users"]) != 1 || records["users"][0]["name"] != "admin" {
+		t.Fatalf("unexpected user records %v", records["users"])
+	}
+	userID := records["users"][0]["user_id"]
+	if len(records["apikeys"]) != 1 || records["apikeys"][0]["user_id"] != userID {
+		t.Fatalf("expected the api key to belong to the user, got %v", records["apikeys"])
+	}
+	parameters := map[string]interface{}{}
+	for _, item := range records["parameters"] {
+		parameters[item["parameter_id"].(string)] = item["value"]
+	}
+	if parameters["ai_model"] != "gpt-4o" || parameters["scan_system_prompt"] != defaultSystemPrompt || parameters["mcp_server_url"] != "http://mcp.svc.example.internal:8080/mcp" {
+		t.Fatalf("unexpected parameters %v", parameters)
+	}
+	encrypted, _ := parameters["ai_api_key"].(string)
+	if decrypted, err := decryptValue(encrypted, "12345678901234567890123456789012"); err != nil || decrypted != "sk-test" {
+		t.Fatalf("expected the AI api key to be stored encrypted, got %q: %v", encrypted, err)
+	}
+	if parameters[encryptionFormatParameterID] != EncryptionFormatGCM {
+		t.Fatalf("expected the encryption format to be recorded, got %v", parameters[encryptionFormatParameterID])
+	}
+}
+
+func TestStartConfigurationKeepsExistingUser(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name        string
+		apiKeys     []map[string]interface{}
+		reconfigure bool
+		newAPIKeys  int
+	}{
+		{
+			name:       "user and api key exist",
+			apiKeys:    []map[string]interface{}{{"key_id": "key-1", "user_id": "user-1"}},
+			newAPIKeys: 0,
+		},
+		{
+			name:       "api key missing after a failed run",
+			newAPIKeys: 1,
+		},
+		{
+			name:        "reconfigure",
+			reconfigure: true,
+			newAPIKeys:  0,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			records := map[string][]map[string]interface{}{
+				"users":   {{"user_id": "user-1", "account_type": "Team", "name": "admin"}},
+				"apikeys": tc.apiKeys,
+			}
+			d := startDeployer(records)
+			config := validStartConfig(t)
+			config.Reconfigure = tc.reconfigure
+
+			if err := d.StartConfiguration(context.Background(), config); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+
+			if len(records["users"]) != 1 {
+				t.Fatalf("expected the existing user to be kept, got %v", records["users"])
+			}
+			if added := len(records["apikeys"]) - len(tc.apiKeys); added != tc.newAPIKeys {
+				t.Fatalf("expected %d new api keys, got %d", tc.newAPIKeys, added)
+			}
+			for _, key := range records["apikeys"] {
+				if key["user_id"] != "user-1" {
+					t.Fatalf("expected api keys of the existing user, got %v", key)
+				}
+			}
+			if len(records["parameters"]) == 0 {
+				t.Fatal("expected the parameters to be written")
+			}
+		})
+	}
+}
+
+func TestStartConfigurationErrors(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name     string
+		mutate   func(d *Deployer, config *StartConfig)
+		expected string
+	}{
+		{
+			name: "invalid aes secret",
+			mutate: func(d *Deployer, config *StartConfig) {
+				config.AESSecret = "short"
+			},
+			expected: "AES_KEY must have 32 characters in length",
+		},
+		{
+			name: "prompt version not stored",
+			mutate: func(d *Deployer, config *StartConfig) {
+				fake := d.Records.(*fakeAWS)
+				putRecord := fake.putRecord
+				fake.putRecord = func(tableName string, item map[string]interface{}) error {
+					if strings.Contains(item["parameter_id"].(string), "#v") {
+						return errors.New("throttled")
+					}
+					return putRecord(tableName, item)
+				}
+			},
+			expected: "failed to store version 1 of the system-prompt: throttled",
+		},
+		{
+			name: "MCP gateway not registered",
+			mutate: func(d *Deployer, config *StartConfig) {
+				d.Services.(*fakeAWS).resolveService = func(namespace, service string) ([]string, error) {
+					return nil, errors.New("the service 'mcp' does not exist")
+				}
+			},
+			expected: "failed to resolve the MCP gateway mcp.svc.example.internal: the service 'mcp' does not exist",
+		},
+		{
+			name: "user created concurrently",
+			mutate: func(d *Deployer, config *StartConfig) {
+				d.Records.(*fakeAWS).putNewRecord = func(tableName, keyAttribute string, item map[string]interface{}) error {
+					return ErrRecordExists
+				}
+			},
+			expected: "record already exists",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			d := startDeployer(map[string][]map[string]interface{}{})
+			config := validStartConfig(t)
+			tc.mutate(d, config)
+			err := d.StartConfiguration(context.Background(), config)
+			if err == nil || !strings.Contains(err.Error(), tc.expected) {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,128 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+const installStateFileName = "state.json"
+
+// installState is persisted in ~/.titvo/state.json while the installer runs.
+// It records the steps that finished and the step that was interrupted or
+// failed, so a later run started with --resume can skip completed work.
+type installState struct {
+	path string
+	// resume makes run skip the steps already completed by a previous run.
+	resume bool
+
+	CompletedSteps  []string  `json:"completed_steps"`
+	InterruptedStep string    `json:"interrupted_step,omitempty"`
+	FailedStep      string    `json:"failed_step,omitempty"`
+	LastError       string    `json:"last_error,omitempty"`
+	UpdatedAt       time.Time `json:"updated_at"`
+}
+
+// loadInstallState reads the state file in titvoDir. Without resume, or when
+// no state exists, an empty state is returned.
+func loadInstallState(titvoDir string, resume bool) (*installState, error) {
+	state := &installState{path: filepath.Join(titvoDir, installStateFileName), resume: resume}
+	if !resume {
+		return state, nil
+	}
+	content, err := os.ReadFile(state.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read install state: %w", err)
+	}
+	if err := json.Unmarshal(content, state); err != nil {
+		return nil, fmt.Errorf("failed to parse install state %s: %w", state.path, err)
+	}
+	return state, nil
+}
+
+// stoppedStep returns the step a previous run was interrupted in or failed at.
+func (s *installState) stoppedStep() string {
+	if s.InterruptedStep != "" {
+		return s.InterruptedStep
+	}
+	return s.FailedStep
+}
+
+func (s *installState) isCompleted(step string) bool {
+	return slices.Contains(s.CompletedSteps, step)
+}
+
+// run executes fn as the named step, reports its progress to console and
+// records its outcome. A nil state only reports the step, which keeps the
+// deploy functions usable without tracking.
+func (s *installState) run(ctx context.Context, console *Console, step string, fn func() error) error {
+	if s != nil && s.resume && s.isCompleted(step) {
+		console.reportStep(StepEvent{Step: step, Status: StepSkipped})
+		return nil
+	}
+	console.reportStep(StepEvent{Step: step, Status: StepStarted})
+	started := time.Now()
+	err := fn()
+	event := StepEvent{Step: step, Status: StepSucceeded, Duration: time.Since(started), Err: err}
+	if err != nil {
+		event.Status = StepFailed
+		if ctx.Err() != nil {
+			event.Status = StepInterrupted
+		}
+	}
+	console.reportStep(event)
+	if s == nil {
+		return err
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			s.InterruptedStep = step
+		} else {
+			s.FailedStep = step
+		}
+		s.LastError = console.Secrets.Redact(err.Error())
+	} else {
+		if !s.isCompleted(step) {
+			s.CompletedSteps = append(s.CompletedSteps, step)
+		}
+		if s.InterruptedStep == step {
+			s.InterruptedStep = ""
+		}
+		if s.FailedStep == step {
+			s.FailedStep = ""
+			s.LastError = ""
+		}
+	}
+	if saveErr := s.save(); saveErr != nil {
+		console.printError(fmt.Errorf("failed to save install state: %w", saveErr))
+	}
+	return err
+}
+
+func (s *installState) save() error {
+	s.UpdatedAt = time.Now().UTC()
+	content, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(s.path, content, 0600)
+}
+
+// clear removes the state file once the installation has finished.
+func (s *installState) clear() error {
+	if err := os.Remove(s.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestInstallStateRecordsFailedAndInterruptedSteps(t *testing.T) {
+	dir := t.TempDir()
+	state, err := loadInstallState(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := state.run(context.Background(), testConsole(), "base infra", func() error { return nil }); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	failure := errors.New("apply failed")
+	if err := state.run(context.Background(), testConsole(), "agent aws", func() error { return failure }); !errors.Is(err, failure) {
+		t.Fatalf("expected step error, got %v", err)
+	}
+	if state.FailedStep != "agent aws" || state.InterruptedStep != "" {
+		t.Fatalf("expected failed step to be recorded, got %+v", state)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	state.run(ctx, testConsole(), "MCP gateway", func() error { return ctx.Err() })
+	if state.InterruptedStep != "MCP gateway" {
+		t.Fatalf("expected interrupted step to be recorded, got %+v", state)
+	}
+
+	resumed, err := loadInstallState(dir, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resumed.stoppedStep() != "MCP gateway" || !resumed.isCompleted("base infra") {
+		t.Fatalf("unexpected resumed state: %+v", resumed)
+	}
+}
+
+func TestInstallStateResumeSkipsCompletedSteps(t *testing.T) {
+	dir := t.TempDir()
+	first, _ := loadInstallState(dir, false)
+	first.run(context.Background(), testConsole(), "base infra", func() error { return nil })
+
+	resumed, err := loadInstallState(dir, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	calls := 0
+	resumed.run(context.Background(), testConsole(), "base infra", func() error { calls++; return nil })
+	resumed.run(context.Background(), testConsole(), "agent aws", func() error { calls++; return nil })
+	if calls != 1 {
+		t.Fatalf("expected only the pending step to run, got %d calls", calls)
+	}
+
+	fresh, _ := loadInstallState(dir, false)
+	if fresh.isCompleted("base infra") {
+		t.Fatalf("expected a run without resume to start from scratch")
+	}
+}
+
+func TestInstallStateNilRunsStep(t *testing.T) {
+	var state *installState
+	called := false
+	if err := state.run(context.Background(), testConsole(), "base infra", func() error { called = true; return nil }); err != nil || !called {
+		t.Fatalf("expected step to run without state, got %v", err)
+	}
+}
@@ -1,51 +1,173 @@
 package internal
 
 import (
+	"context"
 	"fmt"
 	"os"
-	"path"
+	"path/filepath"
+	"strings"
 )
 
 // https://github.com/gruntwork-io/terragrunt/releases/download/v0.69.1/terragrunt_darwin_amd64
-// https://github.com/gruntwork-io/terragrunt/releases/download/v0.69.1/terragrunt_darwin_arm64
+// https://github.com/gruntwork-io/terragrunt/releases/download/v0.69.1/terragrunt_linux_arm64
 // https://github.com/gruntwork-io/terragrunt/releases/download/v0.69.1/terragrunt_windows_amd64.exe
-// https://github.com/gruntwork-io/terragrunt/releases/download/v0.69.1/terragrunt_linux_amd64
-const terragruntUrl = "https://github.com/gruntwork-io/terragrunt/releases/download/v%s/terragrunt_%s_%s"
+const terragruntUrl = "https://github.com/gruntwork-io/terragrunt/releases/download/v%s/%s"
 
 // https://releases.hashicorp.com/terraform/1.13.0/terraform_1.13.0_darwin_amd64.zip
-// https://releases.hashicorp.com/terraform/1.13.0/terraform_1.13.0_darwin_arm64.zip
+// https://releases.hashicorp.com/terraform/1.13.0/terraform_1.13.0_linux_arm64.zip
 // https://releases.hashicorp.com/terraform/1.13.0/terraform_1.13.0_windows_amd64.zip
-// https://releases.hashicorp.com/terraform/1.13.0/terraform_1.13.0_linux_amd64.zip
-const terraformUrl = "https://releases.hashicorp.com/terraform/%s/terraform_%s_%s_%s.%s"
+const terraformUrl = "https://releases.hashicorp.com/terraform/%s/%s"
 
 // https://nodejs.org/download/release/v20.19.4/node-v20.19.4-darwin-x64.tar.gz
-// https://nodejs.org/download/release/v20.19.4/node-v20.19.4-darwin-arm64.tar.gz
-// https://nodejs.org/download/release/v20.19.4/node-v20.19.4-linux-x64.tar.gz
-// https://nodejs.org/download/release/v20.19.4/node-v20.19.4-win-x64.zip
-const nodeUrl = "https://nodejs.org/download/release/v%s/node-v%s-%s-%s.%s"
-
-func DownloadTerragrunt(dir string, version string, osType OS, arch Arch) (string, error) {
-	url := fmt.Sprintf(terragruntUrl, version, osType, arch)
-	printInfo("Downloading Terragrunt")
-	printInfo(url)
-	fileExtension := ""
+// https://nodejs.org/download/release/v20.19.4/node-v20.19.4-linux-arm64.tar.gz
+// https://nodejs.org/download/release/v20.19.4/node-v20.19.4-win-arm64.zip
+const nodeUrl = "https://nodejs.org/download/release/v%s/%s"
+
+type platform struct {
+	OS   OS
+	Arch Arch
+}
+
+// terragruntPlatforms maps a host to the suffix of the terragrunt release asset.
+var terragruntPlatforms = map[platform]string{
+	{Linux, AMD64}:   "linux_amd64",
+	{Linux, ARM64}:   "linux_arm64",
+	{Darwin, AMD64}:  "darwin_amd64",
+	{Darwin, ARM64}:  "darwin_arm64",
+	{Windows, AMD64}: "windows_amd64.exe",
+	// No native windows/arm64 build is published; Windows on ARM runs the
+	// amd64 binary under emulation.
+	{Windows, ARM64}: "windows_amd64.exe",
+}
+
+// terraformPlatforms maps a host to the suffix of the terraform release asset.
+var terraformPlatforms = map[platform]string{
+	{Linux, AMD64}:   "linux_amd64.zip",
+	{Linux, ARM64}:   "linux_arm64.zip",
+	{Darwin, AMD64}:  "darwin_amd64.zip",
+	{Darwin, ARM64}:  "darwin_arm64.zip",
+	{Windows, AMD64}: "windows_amd64.zip",
+	// Same as terragrunt: use the amd64 build on Windows on ARM.
+	{Windows, ARM64}: "windows_amd64.zip",
+}
+
+// nodePlatforms maps a host to the suffix of the node release asset.
+var nodePlatforms = map[platform]string{
+	{Linux, AMD64}:   "linux-x64.tar.gz",
+	{Linux, ARM64}:   "linux-arm64.tar.gz",
+	{Darwin, AMD64}:  "darwin-x64.tar.gz",
+	{Darwin, ARM64}:  "darwin-arm64.tar.gz",
+	{Windows, AMD64}: "win-x64.zip",
+	{Windows, ARM64}: "win-arm64.zip",
+}
+
+func lookupArtifact(tool string, version string, platforms map[platform]string, osType OS, arch Arch) (string, error) {
+	suffix, ok := platforms[platform{OS: osType, Arch: arch}]
+	if !ok {
+		return "", fmt.Errorf("%s %s is not available for %s/%s", tool, version, osType, arch)
+	}
+	return suffix, nil
+}
+
+// terragruntArtifact returns the release asset name, e.g. terragrunt_linux_arm64.
+func terragruntArtifact(version string, osType OS, arch Arch) (string, error) {
+	suffix, err := lookupArtifact("terragrunt", version, terragruntPlatforms, osType, arch)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("terragrunt_%s", suffix), nil
+}
+
+// terraformArtifact returns the release asset name, e.g. terraform_1.9.8_linux_arm64.zip.
+func terraformArtifact(version string, osType OS, arch Arch) (string, error) {
+	suffix, err := lookupArtifact("terraform", version, terraformPlatforms, osType, arch)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("terraform_%s_%s", version, suffix), nil
+}
+
+// nodeArtifact returns the release asset name, e.g. node-v20.19.4-linux-arm64.tar.gz.
+func nodeArtifact(version string, osType OS, arch Arch) (string, error) {
+	suffix, err := lookupArtifact("node", version, nodePlatforms, osType, arch)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("node-v%s-%s", version, suffix), nil
+}
+
+// nodeArtifactDir returns the top-level directory contained in a node archive.
+func nodeArtifactDir(artifact string) string {
+	return strings.TrimSuffix(strings.TrimSuffix(artifact, ".tar.gz"), ".zip")
+}
+
+// executableName returns the file name of a tool binary on the given OS.
+func executableName(name string, osType OS) string {
+	if osType != Windows {
+		return name
+	}
+	if name == "npm" || name == "npx" {
+		return name + ".cmd"
+	}
+	return name + ".exe"
+}
+
+// nodeBinDir returns the directory holding node and npm inside an extracted
+// node distribution. Windows archives ship them at the top level.
+func nodeBinDir(nodeHome string, osType OS) string {
+	if osType == Windows {
+		return nodeHome
+	}
+	return filepath.Join(nodeHome, "bin")
+}
+
+// joinPathList joins directories with the PATH list separator of the given OS.
+func joinPathList(osType OS, entries ...string) string {
+	separator := ":"
 	if osType == Windows {
-		fileExtension = ".exe"
+		separator = ";"
+	}
+	nonEmpty := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if entry != "" {
+			nonEmpty = append(nonEmpty, entry)
+		}
+	}
+	return strings.Join(nonEmpty, separator)
+}
+
+// extractArchive extracts a .zip or .tar.gz archive based on its extension.
+func extractArchive(console *Console, src, dest string) error {
+	if strings.HasSuffix(src, ".zip") {
+		return extractZip(console, src, dest)
+	}
+	return extractTarGz(console, src, dest)
+}
+
+func DownloadTerragrunt(ctx context.Context, console *Console, dir string, version string, osType OS, arch Arch) (string, error) {
+	artifact, err := terragruntArtifact(version, osType, arch)
+	if err != nil {
+		return "", err
 	}
-	fileName := fmt.Sprintf("terragrunt%s", fileExtension)
-	err := downloadFile(url, dir, fileName)
+	url := fmt.Sprintf(terragruntUrl, version, artifact)
+	console.printInfo("Downloading Terragrunt")
+	console.printInfo(url)
+	fileName := executableName("terragrunt", osType)
+	err = downloadFile(ctx, console, url, dir, fileName)
 	if err != nil {
 		return "", err
 	}
+	binary := filepath.Join(dir, fileName)
 	if osType != Windows {
 		// Give execute permission to the file
-		err = os.Chmod(path.Join(dir, fileName), 0755)
+		err = os.Chmod(binary, 0755)
 		if err != nil {
 			return "", err
 		}
 	}
-	err = ExecuteWithOptions("terragrunt", &ExecuteOptions{
+	err = ExecuteWithOptions(ctx, binary, &ExecuteOptions{
 		WorkingDir: dir,
+		Console:    console,
 	}, "--version")
 	if err != nil {
 		return "", err
@@ -53,25 +175,30 @@ func DownloadTerragrunt(dir string, version string, osType OS, arch Arch) (strin
 	return dir, nil
 }
 
-func DownloadTerraform(dir string, version string, osType OS, arch Arch) (string, error) {
-	url := fmt.Sprintf(terraformUrl, version, version, osType, arch, "zip")
-	printInfo("Downloading Terraform")
-	printInfo(url)
+func DownloadTerraform(ctx context.Context, console *Console, dir string, version string, osType OS, arch Arch) (string, error) {
+	artifact, err := terraformArtifact(version, osType, arch)
+	if err != nil {
+		return "", err
+	}
+	url := fmt.Sprintf(terraformUrl, version, artifact)
+	console.printInfo("Downloading Terraform")
+	console.printInfo(url)
 	zipFileName := "terraform.zip"
-	err := downloadFile(url, dir, zipFileName)
+	err = downloadFile(ctx, console, url, dir, zipFileName)
 	if err != nil {
 		return "", err
 	}
 
 	// Extraer el ZIP
-	zipPath := path.Join(dir, zipFileName)
-	err = extractZip(zipPath, dir)
+	zipPath := filepath.Join(dir, zipFileName)
+	err = extractZip(console, zipPath, dir)
 	if err != nil {
 		return "", err
 	}
 
-	err = ExecuteWithOptions("terraform", &ExecuteOptions{
+	err = ExecuteWithOptions(ctx, filepath.Join(dir, executableName("terraform", osType)), &ExecuteOptions{
 		WorkingDir: dir,
+		Console:    console,
 	}, "--version")
 	if err != nil {
 		return "", err
@@ -80,46 +207,47 @@ func DownloadTerraform(dir string, version string, osType OS, arch Arch) (string
 	return dir, os.Remove(zipPath)
 }
 
-func DownloadNode(dir string, version string, osType OS, arch Arch) (string, error) {
-	var url string
-	var nodeDir string
-	switch osType {
-	case Windows:
-		url = fmt.Sprintf(nodeUrl, version, version, "win", "x64", "zip")
-		nodeDir = fmt.Sprintf("node-v%s-%s-%s", version, "win", "x64")
-	default:
-		archDownload := "x64"
-		if osType == Darwin && arch == ARM64 {
-			archDownload = "arm64"
-		}
-		url = fmt.Sprintf(nodeUrl, version, version, osType, archDownload, "tar.gz")
-		nodeDir = fmt.Sprintf("node-v%s-%s-%s", version, osType, archDownload)
+// DownloadNode installs node under dir and returns the directory that holds
+// the node and npm executables.
+func DownloadNode(ctx context.Context, console *Console, dir string, version string, osType OS, arch Arch) (string, error) {
+	artifact, err := nodeArtifact(version, osType, arch)
+	if err != nil {
+		return "", err
+	}
+	url := fmt.Sprintf(nodeUrl, version, artifact)
+	binDir := nodeBinDir(filepath.Join(dir, nodeArtifactDir(artifact)), osType)
+	console.printInfo("Downloading Node")
+	console.printInfo(url)
+	archiveFileName := "node.tar.gz"
+	if strings.HasSuffix(artifact, ".zip") {
+		archiveFileName = "node.zip"
 	}
-	printInfo("Downloading Node")
-	printInfo(url)
-	tarFileName := "node.tar.gz"
-	err := downloadFile(url, dir, tarFileName)
+	err = downloadFile(ctx, console, url, dir, archiveFileName)
 	if err != nil {
 		return "", err
 	}
-	tarPath := path.Join(dir, tarFileName)
-	err = extractTarGz(tarPath, dir)
+	archivePath := filepath.Join(dir, archiveFileName)
+	err = extractArchive(console, archivePath, dir)
 	if err != nil {
 		return "", err
 	}
-	err = ExecuteWithOptions("node", &ExecuteOptions{
-		WorkingDir: path.Join(dir, nodeDir, "bin"),
+	err = ExecuteWithOptions(ctx, filepath.Join(binDir, executableName("node", osType)), &ExecuteOptions{
+		WorkingDir: binDir,
+		Console:    console,
 	}, "--version")
 	if err != nil {
 		return "", err
 	}
-	err = ExecuteWithOptions("npm", &ExecuteOptions{
-		WorkingDir: path.Join(dir, nodeDir, "bin"),
+	// npm is a script run by node, so node must be found first on PATH
+	err = ExecuteWithOptions(ctx, filepath.Join(binDir, executableName("npm", osType)), &ExecuteOptions{
+		WorkingDir: binDir,
+		Env:        map[string]string{"PATH": joinPathList(osType, binDir, os.Getenv("PATH"))},
+		Console:    console,
 	}, "--version")
 	if err != nil {
 		return "", err
 	}
-	return nodeDir, os.Remove(tarPath)
+	return binDir, os.Remove(archivePath)
 }
 
 type InstallToolConfig struct {
@@ -132,15 +260,22 @@ type InstallToolConfig struct {
 	TerragruntBinDir string
 }
 
-func InstallTools() (config *InstallToolConfig, err error) {
-	// Get home directory
+// titvoHomeDir returns ~/.titvo, where tools, sources and state are kept.
+func titvoHomeDir() (string, error) {
 	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".titvo"), nil
+}
+
+func InstallTools(ctx context.Context, console *Console) (config *InstallToolConfig, err error) {
+	titvoDir, err := titvoHomeDir()
 	if err != nil {
 		return nil, err
 	}
-	titvoDir := path.Join(home, ".titvo")
-	binDir := path.Join(titvoDir, "bin")
-	printInfo(fmt.Sprintf("Installing Tools in %s", binDir))
+	binDir := filepath.Join(titvoDir, "bin")
+	console.printInfo(fmt.Sprintf("Installing Tools in %s", binDir))
 	if err := os.MkdirAll(binDir, 0755); err != nil {
 		return nil, err
 	}
@@ -152,28 +287,28 @@ func InstallTools() (config *InstallToolConfig, err error) {
 	if err != nil {
 		return nil, err
 	}
-	terragruntDir, err := DownloadTerragrunt(binDir, "0.69.1", os, arch)
+	terragruntDir, err := DownloadTerragrunt(ctx, console, binDir, "0.69.1", os, arch)
 	if err != nil {
 		return nil, err
 	}
-	printInfo(fmt.Sprintf("Terragrunt downloaded to %s", terragruntDir))
-	terraformDir, err := DownloadTerraform(binDir, "1.9.8", os, arch)
+	console.printInfo(fmt.Sprintf("Terragrunt downloaded to %s", terragruntDir))
+	terraformDir, err := DownloadTerraform(ctx, console, binDir, "1.9.8", os, arch)
 	if err != nil {
 		return nil, err
 	}
-	printInfo(fmt.Sprintf("Terraform downloaded to %s", terraformDir))
-	nodeDir, err := DownloadNode(titvoDir, "20.19.4", os, arch)
+	console.printInfo(fmt.Sprintf("Terraform downloaded to %s", terraformDir))
+	nodeBinDir, err := DownloadNode(ctx, console, titvoDir, "20.19.4", os, arch)
 	if err != nil {
 		return nil, err
 	}
-	printInfo(fmt.Sprintf("Node downloaded to %s", nodeDir))
+	console.printInfo(fmt.Sprintf("Node downloaded to %s", nodeBinDir))
 	return &InstallToolConfig{
 		Dir:              binDir,
 		OS:               os,
 		Arch:             arch,
 		TitvoDir:         titvoDir,
 		TerraformBinDir:  terraformDir,
-		NodeBinDir:       path.Join(nodeDir, "bin"),
+		NodeBinDir:       nodeBinDir,
 		TerragruntBinDir: terragruntDir,
 	}, nil
 }
@@ -0,0 +1,162 @@
+package internal
+
+import (
+	"archive/zip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestToolArtifacts(t *testing.T) {
+	tests := []struct {
+		os         OS
+		arch       Arch
+		terragrunt string
+		terraform  string
+		node       string
+	}{
+		{os: Linux, arch: AMD64, terragrunt: "terragrunt_linux_amd64", terraform: "terraform_1.9.8_linux_amd64.zip", node: "node-v20.19.4-linux-x64.tar.gz"},
+		{os: Linux, arch: ARM64, terragrunt: "terragrunt_linux_arm64", terraform: "terraform_1.9.8_linux_arm64.zip", node: "node-v20.19.4-linux-arm64.tar.gz"},
+		{os: Darwin, arch: AMD64, terragrunt: "terragrunt_darwin_amd64", terraform: "terraform_1.9.8_darwin_amd64.zip", node: "node-v20.19.4-darwin-x64.tar.gz"},
+		{os: Darwin, arch: ARM64, terragrunt: "terragrunt_darwin_arm64", terraform: "terraform_1.9.8_darwin_arm64.zip", node: "node-v20.19.4-darwin-arm64.tar.gz"},
+		{os: Windows, arch: AMD64, terragrunt: "terragrunt_windows_amd64.exe", terraform: "terraform_1.9.8_windows_amd64.zip", node: "node-v20.19.4-win-x64.zip"},
+		{os: Windows, arch: ARM64, terragrunt: "terragrunt_windows_amd64.exe", terraform: "terraform_1.9.8_windows_amd64.zip", node: "node-v20.19.4-win-arm64.zip"},
+	}
+
+	for _, tc := range tests {
+		t.Run(string(tc.os)+"/"+string(tc.arch), func(t *testing.T) {
+			terragrunt, err := terragruntArtifact("0.69.1", tc.os, tc.arch)
+			if err != nil || terragrunt != tc.terragrunt {
+				t.Fatalf("unexpected terragrunt artifact %q (%v)", terragrunt, err)
+			}
+			terraform, err := terraformArtifact("1.9.8", tc.os, tc.arch)
+			if err != nil || terraform != tc.terraform {
+				t.Fatalf("unexpected terraform artifact %q (%v)", terraform, err)
+			}
+			node, err := nodeArtifact("20.19.4", tc.os, tc.arch)
+			if err != nil || node != tc.node {
+				t.Fatalf("unexpected node artifact %q (%v)", node, err)
+			}
+		})
+	}
+}
+
+func TestToolArtifactsUnsupportedPlatform(t *testing.T) {
+	tests := []struct {
+		name     string
+		artifact func(version string, osType OS, arch Arch) (string, error)
+		os       OS
+		arch     Arch
+		expected string
+	}{
+		{name: "terragrunt freebsd", artifact: terragruntArtifact, os: "freebsd", arch: AMD64, expected: "terragrunt 0.69.1 is not available for freebsd/amd64"},
+		{name: "terraform 386", artifact: terraformArtifact, os: Linux, arch: "386", expected: "terraform 0.69.1 is not available for linux/386"},
+		{name: "node riscv64", artifact: nodeArtifact, os: Linux, arch: "riscv64", expected: "node 0.69.1 is not available for linux/riscv64"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			artifact, err := tc.artifact("0.69.1", tc.os, tc.arch)
+			if err == nil || !strings.Contains(err.Error(), tc.expected) {
+				t.Fatalf("expected error %q, got %q (%v)", tc.expected, artifact, err)
+			}
+		})
+	}
+}
+
+func TestNodeArtifactDir(t *testing.T) {
+	if dir := nodeArtifactDir("node-v20.19.4-linux-arm64.tar.gz"); dir != "node-v20.19.4-linux-arm64" {
+		t.Fatalf("unexpected dir %s", dir)
+	}
+	if dir := nodeArtifactDir("node-v20.19.4-win-arm64.zip"); dir != "node-v20.19.4-win-arm64" {
+		t.Fatalf("unexpected dir %s", dir)
+	}
+}
+
+func TestExecutableName(t *testing.T) {
+	tests := []struct {
+		name     string
+		os       OS
+		expected string
+	}{
+		{name: "terragrunt", os: Linux, expected: "terragrunt"},
+		{name: "terragrunt", os: Windows, expected: "terragrunt.exe"},
+		{name: "terraform", os: Windows, expected: "terraform.exe"},
+		{name: "node", os: Darwin, expected: "node"},
+		{name: "node", os: Windows, expected: "node.exe"},
+		{name: "npm", os: Linux, expected: "npm"},
+		{name: "npm", os: Windows, expected: "npm.cmd"},
+	}
+	for _, tc := range tests {
+		if got := executableName(tc.name, tc.os); got != tc.expected {
+			t.Fatalf("executableName(%s, %s) = %s, expected %s", tc.name, tc.os, got, tc.expected)
+		}
+	}
+}
+
+func TestNodeBinDir(t *testing.T) {
+	home := filepath.Join("titvo", "node-v20.19.4-win-x64")
+	if dir := nodeBinDir(home, Windows); dir != home {
+		t.Fatalf("expected windows node binaries at the archive root, got %s", dir)
+	}
+	if dir := nodeBinDir(home, Linux); dir != filepath.Join(home, "bin") {
+		t.Fatalf("expected unix node binaries in bin, got %s", dir)
+	}
+}
+
+func TestJoinPathList(t *testing.T) {
+	if got := joinPathList(Windows, `C:\Windows`, "", `C:\titvo\bin`); got != `C:\Windows;C:\titvo\bin` {
+		t.Fatalf("unexpected windows PATH: %s", got)
+	}
+	if got := joinPathList(Linux, "/usr/bin", "/titvo/bin"); got != "/usr/bin:/titvo/bin" {
+		t.Fatalf("unexpected unix PATH: %s", got)
+	}
+}
+
+func TestExtractArchiveWindowsNodeLayout(t *testing.T) {
+	dir := t.TempDir()
+	archive := filepath.Join(dir, "node.zip")
+	writeTestZip(t, archive, []testZipEntry{
+		{name: "node-v20.19.4-win-x64/"},
+		{name: "node-v20.19.4-win-x64/node.exe", content: "node"},
+		{name: "node-v20.19.4-win-x64/npm.cmd", content: "npm"},
+	})
+
+	if err := extractArchive(testConsole(), archive, dir); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	binDir := nodeBinDir(filepath.Join(dir, nodeArtifactDir("node-v20.19.4-win-x64.zip")), Windows)
+	for _, name := range []string{executableName("node", Windows), executableName("npm", Windows)} {
+		if _, err := os.Stat(filepath.Join(binDir, name)); err != nil {
+			t.Fatalf("expected %s in %s: %v", name, binDir, err)
+		}
+	}
+}
+
+type testZipEntry struct {
+	name    string
+	content string
+}
+
+func writeTestZip(t *testing.T, archive string, entries []testZipEntry) {
+	t.Helper()
+	out, err := os.Create(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	writer := zip.NewWriter(out)
+	for _, file := range entries {
+		entry, err := writer.Create(file.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := entry.Write([]byte(file.content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
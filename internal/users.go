@@ -0,0 +1,321 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/spf13/cobra"
+)
+
+const defaultAccountType = "Team"
+
+// User is an item of the Titvo user table.
+type User struct {
+	UserID      string `json:"user_id"`
+	Name        string `json:"name"`
+	AccountType string `json:"account_type"`
+	Disabled    bool   `json:"disabled"`
+}
+
+func userFromRecord(record map[string]interface{}) User {
+	user := User{}
+	user.UserID, _ = record["user_id"].(string)
+	user.Name, _ = record["name"].(string)
+	user.AccountType, _ = record["account_type"].(string)
+	user.Disabled, _ = record["disabled"].(bool)
+	return user
+}
+
+// UserManager manages the Titvo users and their API keys in the tables
+// created by the infra modules.
+type UserManager struct {
+	Parameters ParameterStore
+	Records    RecordStore
+	// now returns the current time; nil means time.Now.
+	now func() time.Time
+}
+
+func NewUserManager(session *AWSSession) *UserManager {
+	return &UserManager{Parameters: session, Records: session}
+}
+
+func (m *UserManager) clock() time.Time {
+	if m.now != nil {
+		return m.now()
+	}
+	return time.Now()
+}
+
+func (m *UserManager) userTable(ctx context.Context) (string, error) {
+	return m.Parameters.GetParameter(ctx, userTableParameter)
+}
+
+func (m *UserManager) apiKeyTable(ctx context.Context) (string, error) {
+	return m.Parameters.GetParameter(ctx, apiKeyTableParameter)
+}
+
+// putNewUser creates a user record and returns its ID.
+func putNewUser(ctx context.Context, records RecordStore, tableName, name, accountType string) (string, error) {
+	userID := uuid.New().String()
+	err := records.PutNewRecord(ctx, tableName, "user_id", map[string]interface{}{
+		"user_id":      userID,
+		"account_type": accountType,
+		"name":         name,
+	})
+	if err != nil {
+		return "", err
+	}
+	return userID, nil
+}
+
+// apiKeyOptions are the optional attributes of a new API key.
+type apiKeyOptions struct {
+	Label     string
+	ExpiresAt time.Time
+}
+
+// putNewAPIKey stores the hash of a new API key of userID and returns its ID
+// and the key, which cannot be recovered afterwards.
+func putNewAPIKey(ctx context.Context, records RecordStore, tableName, userID string, createdAt time.Time, options apiKeyOptions) (string, string, error) {
+	keyID := uuid.New().String()
+	apiKey := generateAPIKey()
+	item := map[string]interface{}{
+		"key_id":     keyID,
+		"api_key":    hashSha256([]byte(apiKey)),
+		"user_id":    userID,
+		"created_at": createdAt.UTC().Format(time.RFC3339),
+	}
+	if options.Label != "" {
+		item["label"] = options.Label
+	}
+	if !options.ExpiresAt.IsZero() {
+		item["expires_at"] = options.ExpiresAt.UTC().Format(time.RFC3339)
+	}
+	if err := records.PutNewRecord(ctx, tableName, "key_id", item); err != nil {
+		return "", "", err
+	}
+	return keyID, apiKey, nil
+}
+
+// List returns the users sorted by name.
+func (m *UserManager) List(ctx context.Context) ([]User, error) {
+	tableName, err := m.userTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+	records, err := m.Records.ListRecords(ctx, tableName)
+	if err != nil {
+		return nil, err
+	}
+	users := make([]User, 0, len(records))
+	for _, record := range records {
+		users = append(users, userFromRecord(record))
+	}
+	sort.Slice(users, func(i, j int) bool {
+		if users[i].Name != users[j].Name {
+			return users[i].Name < users[j].Name
+		}
+		return users[i].UserID < users[j].UserID
+	})
+	return users, nil
+}
+
+// Create creates a user with an API key. Names are unique, as the installer
+// looks users up by name.
+func (m *UserManager) Create(ctx context.Context, name, accountType string) (User, string, error) {
+	if name == "" {
+		return User{}, "", fmt.Errorf("user name is required")
+	}
+	if accountType == "" {
+		return User{}, "", fmt.Errorf("account type is required")
+	}
+	tableName, err := m.userTable(ctx)
+	if err != nil {
+		return User{}, "", err
+	}
+	existing, err := m.Records.FindRecords(ctx, tableName, "name", name)
+	if err != nil {
+		return User{}, "", err
+	}
+	if len(existing) > 0 {
+		return User{}, "", fmt.Errorf("user %s already exists with ID %s", name, userFromRecord(existing[0]).UserID)
+	}
+	userID, err := putNewUser(ctx, m.Records, tableName, name, accountType)
+	if err != nil {
+		return User{}, "", err
+	}
+	apiKeyTable, err := m.apiKeyTable(ctx)
+	if err != nil {
+		return User{}, "", err
+	}
+	_, apiKey, err := putNewAPIKey(ctx, m.Records, apiKeyTable, userID, m.clock(), apiKeyOptions{})
+	if err != nil {
+		return User{}, "", fmt.Errorf("user %s created with ID %s but its API key could not be stored: %w", name, userID, err)
+	}
+	return User{UserID: userID, Name: name, AccountType: accountType}, apiKey, nil
+}
+
+// find returns the user record with the given ID or, failing that, the only
+// user with that name.
+func (m *UserManager) find(ctx context.Context, tableName, idOrName string) (map[string]interface{}, error) {
+	for _, attribute := range []string{"user_id", "name"} {
+		records, err := m.Records.FindRecords(ctx, tableName, attribute, idOrName)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case len(records) == 1:
+			return records[0], nil
+		case len(records) > 1:
+			return nil, fmt.Errorf("%d users are named %s, use the user ID instead", len(records), idOrName)
+		}
+	}
+	return nil, fmt.Errorf("user %s not found", idOrName)
+}
+
+// Disable marks a user as disabled, so no API key can be created for it, and
+// deletes its API keys, as the Titvo services only check the keys. The user is
+// marked first: a failure halfway is retried by disabling it again.
+func (m *UserManager) Disable(ctx context.Context, idOrName string) (User, int, error) {
+	tableName, err := m.userTable(ctx)
+	if err != nil {
+		return User{}, 0, err
+	}
+	record, err := m.find(ctx, tableName, idOrName)
+	if err != nil {
+		return User{}, 0, err
+	}
+	record["disabled"] = true
+	if err := m.Records.PutRecord(ctx, tableName, record); err != nil {
+		return User{}, 0, err
+	}
+	user := userFromRecord(record)
+	deleted, err := m.deleteAPIKeys(ctx, user.UserID)
+	return user, deleted, err
+}
+
+// deleteAPIKeys deletes the API keys of a user and returns how many were.
+func (m *UserManager) deleteAPIKeys(ctx context.Context, userID string) (int, error) {
+	apiKeyTable, err := m.apiKeyTable(ctx)
+	if err != nil {
+		return 0, err
+	}
+	keys, err := m.Records.FindRecords(ctx, apiKeyTable, "user_id", userID)
+	if err != nil {
+		return 0, err
+	}
+	for i, key := range keys {
+		keyID, _ := key["key_id"].(string)
+		if err := m.Records.DeleteRecord(ctx, apiKeyTable, "key_id", keyID); err != nil {
+			return i, err
+		}
+	}
+	return len(keys), nil
+}
+
+// Delete deletes a user and its API keys, so they stop authenticating. The
+// keys go first: a failure halfway leaves the user in place to retry.
+func (m *UserManager) Delete(ctx context.Context, idOrName string) (User, int, error) {
+	tableName, err := m.userTable(ctx)
+	if err != nil {
+		return User{}, 0, err
+	}
+	record, err := m.find(ctx, tableName, idOrName)
+	if err != nil {
+		return User{}, 0, err
+	}
+	user := userFromRecord(record)
+	deleted, err := m.deleteAPIKeys(ctx, user.UserID)
+	if err != nil {
+		return User{}, deleted, err
+	}
+	if err := m.Records.DeleteRecord(ctx, tableName, "user_id", user.UserID); err != nil {
+		return User{}, deleted, err
+	}
+	return user, deleted, nil
+}
+
+func newUserManager(cmd *cobra.Command, console *Console) *UserManager {
+	session, err := managementSession(cmd, console)
+	if err != nil {
+		console.printErrorAndExit(err)
+	}
+	return NewUserManager(session)
+}
+
+// RunUsersList lists the Titvo users.
+func RunUsersList(cmd *cobra.Command, args []string) {
+	console, format := managementConsole(cmd)
+	manager := newUserManager(cmd, console)
+	users, err := manager.List(cmd.Context())
+	if err != nil {
+		console.printErrorAndExit(err)
+	}
+	rows := make([][]string, 0, len(users))
+	for _, user := range users {
+		status := "active"
+		if user.Disabled {
+			status = "disabled"
+		}
+		rows = append(rows, []string{user.UserID, user.Name, user.AccountType, status})
+	}
+	if err := writeListing(cmd.OutOrStdout(), format, []string{"USER ID", "NAME", "ACCOUNT TYPE", "STATUS"}, rows, users); err != nil {
+		console.printErrorAndExit(err)
+	}
+}
+
+// RunUsersCreate creates a user and prints its API key once.
+func RunUsersCreate(cmd *cobra.Command, args []string) {
+	console, _ := managementConsole(cmd)
+	accountType, err := cmd.Flags().GetString("account-type")
+	if err != nil {
+		console.printErrorAndExit(err)
+	}
+	manager := newUserManager(cmd, console)
+	user, apiKey, err := manager.Create(cmd.Context(), args[0], accountType)
+	if err != nil {
+		console.printErrorAndExit(err)
+	}
+	console.reportOutput("User ID", user.UserID)
+	console.reportOutput("API Key", apiKey)
+	console.printInfo("* Keep the API Key in a safe place, it is not shown again")
+}
+
+// RunUsersDisable marks a user as disabled and deletes its API keys.
+func RunUsersDisable(cmd *cobra.Command, args []string) {
+	console, _ := managementConsole(cmd)
+	manager := newUserManager(cmd, console)
+	user, keys, err := manager.Disable(cmd.Context(), args[0])
+	if err != nil {
+		console.printErrorAndExit(fmt.Errorf("%w (%d API keys deleted, disable the user again to retry)", err, keys))
+	}
+	console.printInfo(fmt.Sprintf("User %s (%s) disabled, %d API keys deleted", user.Name, user.UserID, keys))
+}
+
+// RunUsersDelete deletes a user and its API keys after confirmation.
+func RunUsersDelete(cmd *cobra.Command, args []string) {
+	console, _ := managementConsole(cmd)
+	yes, err := cmd.Flags().GetBool("yes")
+	if err != nil {
+		console.printErrorAndExit(err)
+	}
+	manager := newUserManager(cmd, console)
+	if !yes {
+		confirmed, err := console.askForYesNo(fmt.Sprintf("Delete user %s and all its API keys? (y/N)", args[0]))
+		if err != nil {
+			console.printErrorAndExit(err)
+		}
+		if !confirmed {
+			console.printInfo("Nothing deleted")
+			return
+		}
+	}
+	user, keys, err := manager.Delete(cmd.Context(), args[0])
+	if err != nil {
+		console.printErrorAndExit(err)
+	}
+	console.printInfo(fmt.Sprintf("User %s (%s) deleted with %d API keys", user.Name, user.UserID, keys))
+}
@@ -0,0 +1,175 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// testUserManager returns a UserManager over in-memory users and apikeys
+// tables holding records.
+func testUserManager(records map[string][]map[string]interface{}) (*UserManager, *fakeAWS) {
+	_, fake := testDeployer()
+	fake.getParameter = func(path string) (string, error) {
+		switch path {
+		case userTableParameter:
+			return "users", nil
+		case apiKeyTableParameter:
+			return "apikeys", nil
+		}
+		return "value:" + path, nil
+	}
+	useMemoryRecords(fake, records)
+	return &UserManager{Parameters: fake, Records: fake}, fake
+}
+
+func testUsers() map[string][]map[string]interface{} {
+	return map[string][]map[string]interface{}{
+		"users": {
+			{"user_id": "user-2", "name": "security", "account_type": "Team"},
+			{"user_id": "user-1", "name": "admin", "account_type": "Team"},
+		},
+		"apikeys": {
+			{"key_id": "key-1", "user_id": "user-1", "api_key": "hash-1"},
+			{"key_id": "key-2", "user_id": "user-1", "api_key": "hash-2"},
+			{"key_id": "key-3", "user_id": "user-2", "api_key": "hash-3"},
+		},
+	}
+}
+
+func TestUserManagerList(t *testing.T) {
+	t.Parallel()
+	manager, _ := testUserManager(testUsers())
+
+	users, err := manager.List(context.Background())
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(users) != 2 || users[0].Name != "admin" || users[1].Name != "security" {
+		t.Fatalf("expected users sorted by name, got %+v", users)
+	}
+}
+
+func TestUserManagerCreate(t *testing.T) {
+	t.Parallel()
+	records := testUsers()
+	manager, _ := testUserManager(records)
+
+	user, apiKey, err := manager.Create(context.Background(), "ci", "Team")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if user.Name != "ci" || user.AccountType != "Team" || user.UserID == "" {
+		t.Fatalf("unexpected user %+v", user)
+	}
+	if len(records["users"]) != 3 {
+		t.Fatalf("expected the user to be stored, got %v", records["users"])
+	}
+	last := records["apikeys"][len(records["apikeys"])-1]
+	if last["user_id"] != user.UserID || last["api_key"] != hashSha256([]byte(apiKey)) {
+		t.Fatalf("expected the hash of the new API key to be stored for the user, got %v", last)
+	}
+
+	if _, _, err := manager.Create(context.Background(), "admin", "Team"); err == nil || !strings.Contains(err.Error(), "user admin already exists with ID user-1") {
+		t.Fatalf("expected duplicate names to be rejected, got %v", err)
+	}
+}
+
+func TestUserManagerDisable(t *testing.T) {
+	t.Parallel()
+	records := testUsers()
+	manager, _ := testUserManager(records)
+
+	user, keys, err := manager.Disable(context.Background(), "security")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if user.UserID != "user-2" || !user.Disabled || keys != 1 {
+		t.Fatalf("expected user-2 to be disabled with 1 key deleted, got %+v and %d", user, keys)
+	}
+	if len(records["users"]) != 2 || records["users"][0]["disabled"] != true || records["users"][0]["name"] != "security" {
+		t.Fatalf("expected the user record to be updated in place, got %v", records["users"])
+	}
+	// The Titvo services authenticate the API keys, not the disabled flag
+	if len(records["apikeys"]) != 2 || slices.ContainsFunc(records["apikeys"], func(key map[string]interface{}) bool { return key["user_id"] == "user-2" }) {
+		t.Fatalf("expected the API keys of the user to be deleted, got %v", records["apikeys"])
+	}
+}
+
+func TestUserManagerDelete(t *testing.T) {
+	t.Parallel()
+	records := testUsers()
+	manager, _ := testUserManager(records)
+
+	user, keys, err := manager.Delete(context.Background(), "user-1")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if user.Name != "admin" || keys != 2 {
+		t.Fatalf("expected admin to be deleted with 2 keys, got %+v and %d", user, keys)
+	}
+	if len(records["users"]) != 1 || records["users"][0]["user_id"] != "user-2" {
+		t.Fatalf("unexpected users after delete %v", records["users"])
+	}
+	if len(records["apikeys"]) != 1 || records["apikeys"][0]["key_id"] != "key-3" {
+		t.Fatalf("expected only the keys of the deleted user to be removed, got %v", records["apikeys"])
+	}
+}
+
+func TestUserManagerFindErrors(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name     string
+		user     string
+		expected string
+	}{
+		{name: "unknown user", user: "nobody", expected: "user nobody not found"},
+		{name: "ambiguous name", user: "admin", expected: "2 users are named admin, use the user ID instead"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			records := testUsers()
+			records["users"] = append(records["users"], map[string]interface{}{"user_id": "user-3", "name": "admin"})
+			manager, _ := testUserManager(records)
+			_, _, err := manager.Delete(context.Background(), tc.user)
+			if err == nil || err.Error() != tc.expected {
+				t.Fatalf("expected %q, got %v", tc.expected, err)
+			}
+			if len(records["users"]) != 3 || len(records["apikeys"]) != 3 {
+				t.Fatal("expected nothing to be deleted")
+			}
+		})
+	}
+}
+
+func TestWriteListing(t *testing.T) {
+	t.Parallel()
+	users := []User{{UserID: "user-1", Name: "admin", AccountType: "Team"}}
+	rows := [][]string{{"user-1", "admin", "Team", "active"}}
+	header := []string{"USER ID", "NAME", "ACCOUNT TYPE", "STATUS"}
+
+	var table bytes.Buffer
+	if err := writeListing(&table, OutputText, header, rows, users); err != nil {
+		t.Fatal(err)
+	}
+	expected := "USER ID  NAME   ACCOUNT TYPE  STATUS\nuser-1   admin  Team          active\n"
+	if table.String() != expected {
+		t.Fatalf("expected table %q, got %q", expected, table.String())
+	}
+
+	var out bytes.Buffer
+	if err := writeListing(&out, OutputJSON, header, rows, users); err != nil {
+		t.Fatal(err)
+	}
+	var decoded []User
+	if err := json.Unmarshal(out.Bytes(), &decoded); err != nil {
+		t.Fatalf("expected a JSON array, got %q: %v", out.String(), err)
+	}
+	if len(decoded) != 1 || decoded[0] != users[0] {
+		t.Fatalf("unexpected JSON listing %+v", decoded)
+	}
+}
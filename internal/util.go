@@ -4,39 +4,189 @@ import (
 	"archive/tar"
 	"archive/zip"
 	"compress/gzip"
+	"errors"
 	"fmt"
 	"io"
-	"net/http"
 	"os"
-	"path"
 	"path/filepath"
 	"strings"
 )
 
-func downloadFile(url string, dir string, fileName string) error {
-	resp, err := http.Get(url)
+// Limits applied while extracting archives to protect against decompression
+// bombs. The largest archive we extract (node) is well below them.
+var (
+	maxExtractedFileSize  int64 = 1 << 30 // 1 GiB
+	maxExtractedTotalSize int64 = 4 << 30 // 4 GiB
+)
+
+const maxSymlinkTargetSize = 4096
+
+var errExtractLimitExceeded = errors.New("archive exceeds the maximum extracted size")
+
+// safeExtractPath joins name to dest and rejects entries that would end up
+// outside of dest (zip-slip / path traversal).
+func safeExtractPath(dest, name string) (string, error) {
+	if filepath.IsAbs(name) || strings.HasPrefix(name, "/") || strings.HasPrefix(name, `\`) || filepath.VolumeName(name) != "" {
+		return "", fmt.Errorf("invalid file path: %s", name)
+	}
+	cleanDest := filepath.Clean(dest)
+	target := filepath.Join(cleanDest, name)
+	rel, err := filepath.Rel(cleanDest, target)
+	if err != nil || filepath.IsAbs(rel) || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("invalid file path: %s", name)
+	}
+	return target, nil
+}
+
+// isWithin reports whether path is dest or lies under it.
+func isWithin(dest, path string) bool {
+	rel, err := filepath.Rel(dest, path)
+	return err == nil && !filepath.IsAbs(rel) && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// resolveInDest resolves the symlinks of the directories leading to target,
+// which a previous entry of the archive may have planted, and rejects the
+// entry unless they still lead inside dest. dest must already be resolved.
+func resolveInDest(dest, target string) (string, error) {
+	existing, missing := filepath.Dir(target), []string{filepath.Base(target)}
+	for {
+		if _, err := os.Lstat(existing); err == nil {
+			break
+		} else if !os.IsNotExist(err) {
+			return "", err
+		}
+		parent := filepath.Dir(existing)
+		if parent == existing {
+			break
+		}
+		missing = append([]string{filepath.Base(existing)}, missing...)
+		existing = parent
+	}
+	resolved, err := filepath.EvalSymlinks(existing)
 	if err != nil {
-		return err
+		return "", err
 	}
-	defer resp.Body.Close()
+	if !isWithin(dest, resolved) {
+		return "", fmt.Errorf("invalid file path: %s goes through a symlink outside of the destination", target)
+	}
+	return filepath.Join(append([]string{resolved}, missing...)...), nil
+}
+
+// prepareExtractDest creates dest and returns it with its symlinks resolved,
+// the base every entry is checked against.
+func prepareExtractDest(dest string) (string, error) {
+	if err := os.MkdirAll(dest, 0755); err != nil {
+		return "", err
+	}
+	return filepath.EvalSymlinks(dest)
+}
+
+// validateSymlink only allows relative links whose target stays inside dest.
+func validateSymlink(dest, target, linkName string) error {
+	if filepath.IsAbs(linkName) || strings.HasPrefix(linkName, "/") || filepath.VolumeName(linkName) != "" {
+		return fmt.Errorf("invalid symlink %s -> %s: absolute targets are not allowed", target, linkName)
+	}
+	rel, err := filepath.Rel(dest, filepath.Join(filepath.Dir(target), linkName))
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("invalid symlink %s -> %s: target is outside of the destination", target, linkName)
+	}
+	return nil
+}
 
-	filePath := path.Join(dir, fileName)
+// extractBudget tracks how many bytes may still be written by an extraction.
+type extractBudget struct {
+	remaining int64
+}
 
-	out, err := os.Create(filePath)
+func newExtractBudget() *extractBudget {
+	return &extractBudget{remaining: maxExtractedTotalSize}
+}
+
+// copy writes src into dst enforcing both the per-file and total limits.
+func (b *extractBudget) copy(dst io.Writer, src io.Reader) error {
+	limit := min(maxExtractedFileSize, b.remaining)
+	written, err := io.Copy(dst, io.LimitReader(src, limit+1))
 	if err != nil {
 		return err
 	}
-	defer out.Close()
+	if written > limit {
+		return errExtractLimitExceeded
+	}
+	b.remaining -= written
+	return nil
+}
 
-	_, err = io.Copy(out, resp.Body)
+// removeExisting deletes a previous file or link at target so it can be replaced.
+func removeExisting(target string) error {
+	if _, err := os.Lstat(target); err == nil {
+		return os.Remove(target)
+	}
+	return nil
+}
+
+func writeExtractedFile(dest, target string, mode os.FileMode, src io.Reader, budget *extractBudget) error {
+	target, err := resolveInDest(dest, target)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	// Never write through a symlink planted by an earlier entry
+	if err := removeExisting(target); err != nil {
+		return err
+	}
+	outFile, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
 	if err != nil {
 		return err
 	}
+	if err := budget.copy(outFile, src); err != nil {
+		outFile.Close()
+		return err
+	}
+	if err := outFile.Close(); err != nil {
+		return err
+	}
+	// OpenFile applies the umask, set the exact permissions from the archive
+	return os.Chmod(target, mode)
+}
 
+func createSymlink(dest, target, linkName string) error {
+	target, err := resolveInDest(dest, target)
+	if err != nil {
+		return err
+	}
+	if err := validateSymlink(dest, target, linkName); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	if err := removeExisting(target); err != nil {
+		return err
+	}
+	if err := os.Symlink(linkName, target); err != nil {
+		return err
+	}
+	// The check above is lexical, a target such as "link/.." may still
+	// leave dest once the links it goes through are followed
+	if resolved, err := filepath.EvalSymlinks(target); err == nil && !isWithin(dest, resolved) {
+		os.Remove(target)
+		return fmt.Errorf("invalid symlink %s -> %s: target is outside of the destination", target, linkName)
+	}
 	return nil
 }
 
-func extractTarGz(src, dest string) error {
+// createDir creates an archive directory entry inside dest.
+func createDir(dest, target string, perm os.FileMode) error {
+	target, err := resolveInDest(dest, target)
+	if err != nil {
+		return err
+	}
+	return os.MkdirAll(target, perm|0700)
+}
+
+func extractTarGz(console *Console, src, dest string) error {
 	// Abrir el archivo
 	f, err := os.Open(src)
 	if err != nil {
@@ -53,6 +203,11 @@ func extractTarGz(src, dest string) error {
 
 	// Crear lector tar
 	tr := tar.NewReader(gzr)
+	cleanDest, err := prepareExtractDest(dest)
+	if err != nil {
+		return err
+	}
+	budget := newExtractBudget()
 
 	// Iterar sobre los archivos en el tar
 	for {
@@ -64,113 +219,124 @@ func extractTarGz(src, dest string) error {
 			return err
 		}
 
-		target := filepath.Join(dest, header.Name)
-
 		// Validar que el path esté dentro del directorio de destino (prevenir path traversal)
-		cleanDest := filepath.Clean(dest)
-		cleanTarget := filepath.Clean(target)
-		rel, err := filepath.Rel(cleanDest, cleanTarget)
-		if err != nil || filepath.IsAbs(rel) || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
-			return fmt.Errorf("invalid file path: %s", header.Name)
+		target, err := safeExtractPath(cleanDest, header.Name)
+		if err != nil {
+			return err
 		}
 
 		switch header.Typeflag {
 		case tar.TypeDir:
 			// Crear directorio
-			if err := os.MkdirAll(target, os.FileMode(header.Mode)); err != nil {
+			if err := createDir(cleanDest, target, os.FileMode(header.Mode).Perm()); err != nil {
 				return err
 			}
 		case tar.TypeReg:
 			// Crear archivo regular
-			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
-				return err
+			if header.Size > maxExtractedFileSize {
+				return fmt.Errorf("%w: %s", errExtractLimitExceeded, header.Name)
 			}
-			outFile, err := os.Create(target)
-			if err != nil {
-				return err
+			if err := writeExtractedFile(cleanDest, target, os.FileMode(header.Mode).Perm(), tr, budget); err != nil {
+				return fmt.Errorf("failed to extract %s: %w", header.Name, err)
 			}
-			if _, err := io.Copy(outFile, tr); err != nil {
-				outFile.Close()
+		case tar.TypeSymlink:
+			// Crear link simbólico
+			if err := createSymlink(cleanDest, target, header.Linkname); err != nil {
 				return err
 			}
-			outFile.Close()
-			// Establecer permisos del archivo
-			if err := os.Chmod(target, os.FileMode(header.Mode)); err != nil {
+		case tar.TypeLink:
+			// Crear hard link
+			linkTarget, err := safeExtractPath(cleanDest, header.Linkname)
+			if err != nil {
 				return err
 			}
-		case tar.TypeSymlink:
-			// Crear link simbólico
-			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			if linkTarget, err = resolveInDest(cleanDest, linkTarget); err != nil {
 				return err
 			}
-			// Remover archivo existente si existe
-			if _, err := os.Lstat(target); err == nil {
-				if err := os.Remove(target); err != nil {
-					return err
-				}
-			}
-			// Crear el link simbólico
-			if err := os.Symlink(header.Linkname, target); err != nil {
+			if target, err = resolveInDest(cleanDest, target); err != nil {
 				return err
 			}
-		case tar.TypeLink:
-			// Crear hard link
 			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
 				return err
 			}
-			linkTarget := filepath.Join(dest, header.Linkname)
-			// Remover archivo existente si existe
-			if _, err := os.Lstat(target); err == nil {
-				if err := os.Remove(target); err != nil {
-					return err
-				}
+			if err := removeExisting(target); err != nil {
+				return err
 			}
-			// Crear el hard link
 			if err := os.Link(linkTarget, target); err != nil {
 				return err
 			}
 		default:
 			// Ignorar tipos no soportados en lugar de fallar
-			printInfo(fmt.Sprintf("Warning: unsupported file type ignored: %c in %s", header.Typeflag, header.Name))
+			console.printInfo(fmt.Sprintf("Warning: unsupported file type ignored: %c in %s", header.Typeflag, header.Name))
 			continue
 		}
 	}
 	return nil
 }
 
-func extractZip(src, dest string) error {
+func extractZip(console *Console, src, dest string) error {
 	reader, err := zip.OpenReader(src)
 	if err != nil {
 		return err
 	}
 	defer reader.Close()
 
+	cleanDest, err := prepareExtractDest(dest)
+	if err != nil {
+		return err
+	}
+	budget := newExtractBudget()
 	for _, file := range reader.File {
-		path := filepath.Join(dest, file.Name)
-
-		// Crear directorio si es necesario
-		if file.FileInfo().IsDir() {
-			os.MkdirAll(path, file.FileInfo().Mode())
-			continue
-		}
-
-		// Crear el archivo
-		fileReader, err := file.Open()
-		if err != nil {
+		if err := extractZipEntry(console, file, cleanDest, budget); err != nil {
 			return err
 		}
-		defer fileReader.Close()
+	}
+	return nil
+}
 
-		targetFile, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, file.FileInfo().Mode())
-		if err != nil {
-			return err
-		}
-		defer targetFile.Close()
+// extractZipEntry extracts a single entry, closing its reader before the next
+// entry is opened.
+func extractZipEntry(console *Console, file *zip.File, dest string, budget *extractBudget) error {
+	target, err := safeExtractPath(dest, file.Name)
+	if err != nil {
+		return err
+	}
+	mode := file.Mode()
+
+	// Crear directorio si es necesario
+	if mode.IsDir() {
+		return createDir(dest, target, mode.Perm())
+	}
+	if file.UncompressedSize64 > uint64(maxExtractedFileSize) {
+		return fmt.Errorf("%w: %s", errExtractLimitExceeded, file.Name)
+	}
+
+	fileReader, err := file.Open()
+	if err != nil {
+		return err
+	}
+	defer fileReader.Close()
 
-		_, err = io.Copy(targetFile, fileReader)
+	if mode&os.ModeSymlink != 0 {
+		// The entry content is the link target
+		linkName, err := io.ReadAll(io.LimitReader(fileReader, maxSymlinkTargetSize))
 		if err != nil {
 			return err
 		}
+		return createSymlink(dest, target, string(linkName))
+	}
+	if !mode.IsRegular() {
+		console.printInfo(fmt.Sprintf("Warning: unsupported file type ignored: %s in %s", mode.Type(), file.Name))
+		return nil
+	}
+
+	// Archives created on Windows usually carry no unix permissions
+	perm := mode.Perm()
+	if perm == 0 {
+		perm = 0644
+	}
+	if err := writeExtractedFile(dest, target, perm, fileReader, budget); err != nil {
+		return fmt.Errorf("failed to extract %s: %w", file.Name, err)
 	}
 	return nil
 }
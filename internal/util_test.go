@@ -0,0 +1,156 @@
+package internal
+
+import (
+	"archive/zip"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+type testZipFile struct {
+	name    string
+	content string
+	mode    os.FileMode
+}
+
+func writeZipWithModes(t *testing.T, archive string, files []testZipFile) {
+	t.Helper()
+	out, err := os.Create(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	writer := zip.NewWriter(out)
+	for _, file := range files {
+		header := &zip.FileHeader{Name: file.name, Method: zip.Deflate}
+		if file.mode != 0 {
+			header.SetMode(file.mode)
+		}
+		entry, err := writer.CreateHeader(header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := entry.Write([]byte(file.content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func withExtractLimits(t *testing.T, fileLimit, totalLimit int64) {
+	t.Helper()
+	origFile, origTotal := maxExtractedFileSize, maxExtractedTotalSize
+	maxExtractedFileSize, maxExtractedTotalSize = fileLimit, totalLimit
+	t.Cleanup(func() {
+		maxExtractedFileSize, maxExtractedTotalSize = origFile, origTotal
+	})
+}
+
+func TestExtractZipRejectsMaliciousArchives(t *testing.T) {
+	tests := []struct {
+		name     string
+		files    []testZipFile
+		expected string
+	}{
+		{name: "parent traversal", files: []testZipFile{{name: "../evil.sh", content: "x"}}, expected: "invalid file path"},
+		{name: "nested traversal", files: []testZipFile{{name: "bin/../../evil.sh", content: "x"}}, expected: "invalid file path"},
+		{name: "absolute path", files: []testZipFile{{name: "/tmp/evil.sh", content: "x"}}, expected: "invalid file path"},
+		{name: "absolute symlink", files: []testZipFile{{name: "passwd", content: "/etc/passwd", mode: os.ModeSymlink | 0777}}, expected: "absolute targets are not allowed"},
+		{name: "escaping symlink", files: []testZipFile{{name: "bin/up", content: "../../..", mode: os.ModeSymlink | 0777}}, expected: "outside of the destination"},
+		{
+			name: "chained symlinks",
+			files: []testZipFile{
+				{name: "l1", content: ".", mode: os.ModeSymlink | 0777},
+				{name: "l1/l2", content: "..", mode: os.ModeSymlink | 0777},
+				{name: "l2/evil.sh", content: "x"},
+			},
+			expected: "outside of the destination",
+		},
+		{
+			name: "symlink through a symlink",
+			files: []testZipFile{
+				{name: "here", content: ".", mode: os.ModeSymlink | 0777},
+				{name: "up", content: "here/..", mode: os.ModeSymlink | 0777},
+				{name: "up/evil.sh", content: "x"},
+			},
+			expected: "outside of the destination",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			root := t.TempDir()
+			archive := filepath.Join(root, "archive.zip")
+			writeZipWithModes(t, archive, tc.files)
+			dest := filepath.Join(root, "dest")
+
+			err := extractZip(testConsole(), archive, dest)
+			if err == nil || !strings.Contains(err.Error(), tc.expected) {
+				t.Fatalf("expected error containing %q, got %v", tc.expected, err)
+			}
+			if _, err := os.Stat(filepath.Join(root, "evil.sh")); !os.IsNotExist(err) {
+				t.Fatalf("expected nothing to be written outside of the destination")
+			}
+		})
+	}
+}
+
+func TestExtractZipEnforcesSizeLimits(t *testing.T) {
+	t.Run("single file", func(t *testing.T) {
+		withExtractLimits(t, 10, 100)
+		archive := filepath.Join(t.TempDir(), "bomb.zip")
+		writeZipWithModes(t, archive, []testZipFile{{name: "big", content: strings.Repeat("0", 1000)}})
+		err := extractZip(testConsole(), archive, t.TempDir())
+		if !errors.Is(err, errExtractLimitExceeded) {
+			t.Fatalf("expected size limit error, got %v", err)
+		}
+	})
+	t.Run("total size", func(t *testing.T) {
+		withExtractLimits(t, 10, 15)
+		archive := filepath.Join(t.TempDir(), "bomb.zip")
+		writeZipWithModes(t, archive, []testZipFile{
+			{name: "a", content: strings.Repeat("0", 8)},
+			{name: "b", content: strings.Repeat("0", 8)},
+		})
+		err := extractZip(testConsole(), archive, t.TempDir())
+		if !errors.Is(err, errExtractLimitExceeded) {
+			t.Fatalf("expected size limit error, got %v", err)
+		}
+	})
+}
+
+func TestExtractZipCreatesParentsAndPreservesPermissions(t *testing.T) {
+	root := t.TempDir()
+	archive := filepath.Join(root, "terraform.zip")
+	writeZipWithModes(t, archive, []testZipFile{
+		{name: "nested/dir/terraform", content: "binary", mode: 0755},
+		{name: "nested/dir/LICENSE", content: "license", mode: 0640},
+		{name: "nested/current", content: "dir", mode: os.ModeSymlink | 0777},
+	})
+	dest := filepath.Join(root, "dest")
+
+	if err := extractZip(testConsole(), archive, dest); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	content, err := os.ReadFile(filepath.Join(dest, "nested", "current", "terraform"))
+	if err != nil || string(content) != "binary" {
+		t.Fatalf("expected file reachable through internal symlink, got %q (%v)", content, err)
+	}
+	if runtime.GOOS == "windows" {
+		return
+	}
+	for name, expected := range map[string]os.FileMode{"terraform": 0755, "LICENSE": 0640} {
+		info, err := os.Stat(filepath.Join(dest, "nested", "dir", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Perm() != expected {
+			t.Fatalf("expected %s to have mode %v, got %v", name, expected, info.Mode().Perm())
+		}
+	}
+}
@@ -0,0 +1,14 @@
+// Package titvoinstaller embeds the default scan system prompt and content
+// template at the root of the repository. They stay at the root because
+// older installers download them from the main branch, and the installer
+// embeds these same files so the two never drift.
+package titvoinstaller
+
+import _ "embed"
+
+var (
+	//go:embed system_prompt.md
+	SystemPrompt string
+	//go:embed content_template.md
+	ContentTemplate string
+)